@@ -0,0 +1,157 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// list of networks, given as comma-separated CIDRs or IP addresses; repeated options add to the list
+type cidrList []*net.IPNet
+
+func (l *cidrList) String() string {
+	list := make([]string, len(*l))
+
+	for i, n := range *l {
+		list[i] = n.String()
+	}
+
+	return strings.Join(list, ",")
+}
+
+func (l *cidrList) Set(s string) error {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) == 0 {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(item)
+
+		if err != nil {
+			return err
+		}
+
+		*l = append(*l, n)
+	}
+
+	return nil
+}
+
+// contains checks if the address is in any of the networks
+func (l cidrList) contains(ip net.IP) bool {
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// client address restrictions
+var allowNets, denyNets cidrList
+
+func init() {
+	gnuflag.Var(&allowNets, "allow",
+		"Comma-separated list of networks (CIDR) or addresses allowed to access the server, even when inside --deny; may be repeated.")
+	gnuflag.Var(&denyNets, "deny",
+		"Comma-separated list of networks (CIDR) or addresses denied access to the server; may be repeated.")
+}
+
+// allowedIP checks the client address against the lists. An address in --allow networks is always
+// allowed, and an address in --deny networks is denied otherwise. The rest are allowed, unless
+// only --allow is given, in which case the list is exclusive.
+func allowedIP(ip net.IP) bool {
+	if len(allowNets) == 0 && len(denyNets) == 0 {
+		return true
+	}
+
+	return ip != nil && (allowNets.contains(ip) || (len(denyNets) > 0 && !denyNets.contains(ip)))
+}
+
+// accessListener closes the connections from the addresses not allowed, before any request is read
+type accessListener struct {
+	net.Listener
+}
+
+func (l accessListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && allowedIP(addr.IP) {
+			return conn, nil
+		}
+
+		log.Println(conn.RemoteAddr(), "Connection rejected: address not allowed")
+		conn.Close()
+	}
+}
+
+// withAccessList applies the lists to the relayed requests, so that a denied client gets an answer
+// over the relay instead of a dropped tunnel. Direct connections are checked by accessListener.
+func withAccessList(next http.Handler) http.Handler {
+	if len(allowNets) == 0 && len(denyNets) == 0 {
+		return next
+	}
+
+	if len(allowNets) > 0 {
+		log.Println("Allowed networks:", allowNets.String())
+	}
+
+	if len(denyNets) > 0 {
+		log.Println("Denied networks:", denyNets.String())
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if _, relayed := req.Context().Value(http.LocalAddrContextKey).(relayAddr); relayed && !allowedIP(remoteIP(req)) {
+			log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: address not allowed")
+			http.Error(resp, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
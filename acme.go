@@ -0,0 +1,137 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/gnuflag"
+	"github.com/maxim2266/mvr"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACME options
+var (
+	acmeDomains, acmeCache, acmeEmail string
+)
+
+func init() {
+	gnuflag.StringVar(&acmeDomains, "acme", "",
+		"Obtain and renew the TLS certificate for the given domain names (comma-separated) from Let's Encrypt, via HTTP challenge on port 80; implies --tls.")
+	gnuflag.StringVar(&acmeCache, "acme-cache", "",
+		"Directory to keep the ACME certificates and account key in (default: web-share/acme in the user cache directory).")
+	gnuflag.StringVar(&acmeEmail, "acme-email", "", "Contact email address for the ACME account.")
+}
+
+// setupACME returns the manager of the certificates obtained via ACME, and starts
+// the server answering the HTTP challenges, or returns nil if ACME is not used
+func setupACME() *autocert.Manager {
+	if len(acmeDomains) == 0 {
+		return nil
+	}
+
+	if len(certFile) > 0 {
+		die("Option --acme cannot be used with --cert and --key", nil)
+	}
+
+	domains := strings.Split(acmeDomains, ",")
+
+	for i, d := range domains {
+		if domains[i] = strings.TrimSpace(d); len(domains[i]) == 0 {
+			die("Invalid ACME domain list: "+acmeDomains, nil)
+		}
+	}
+
+	if len(acmeCache) == 0 {
+		dir, err := os.UserCacheDir()
+
+		if err != nil {
+			die("Cannot find the cache directory for ACME, use --acme-cache", err)
+		}
+
+		acmeCache = filepath.Join(dir, "web-share", "acme")
+	}
+
+	if err := os.MkdirAll(acmeCache, 0700); err != nil {
+		die("Cannot create ACME cache directory", err)
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(acmeCache),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      acmeEmail,
+	}
+
+	// the URLs use the domain name rather than the IP address
+	if len(displayHost) == 0 {
+		displayHost = domains[0]
+	}
+
+	log.Println("Using ACME certificates for", strings.Join(domains, ", ")+", cached in", acmeCache)
+
+	startChallengeServer(m)
+	return m
+}
+
+// startChallengeServer answers the ACME HTTP challenges on port 80 of the listening addresses,
+// redirecting all the other requests to HTTPS
+func startChallengeServer(m *autocert.Manager) {
+	srv := &http.Server{Handler: m.HTTPHandler(nil)}
+
+	mvr.OnCancel(0, func(ctx context.Context) {
+		srv.Shutdown(ctx)
+	})
+
+	for _, addr := range serverAddrs {
+		host, _, err := net.SplitHostPort(addr)
+
+		if err != nil {
+			die("", err)
+		}
+
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, "80"))
+
+		if err != nil {
+			die("Cannot listen for ACME challenges", err)
+		}
+
+		go func() {
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Println("ACME challenge server:", err)
+			}
+		}()
+	}
+}
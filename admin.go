@@ -0,0 +1,83 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// administrative endpoints
+var enableAdmin bool
+
+func init() {
+	gnuflag.BoolVar(&enableAdmin, "admin", false, "Enable administrative endpoints under /admin/.")
+}
+
+// handlers for the administrative endpoints, registered by the corresponding modules
+var adminMux = http.NewServeMux()
+
+func withAdmin(next http.Handler) http.Handler {
+	if !enableAdmin {
+		return next
+	}
+
+	log.Println("Administrative endpoints enabled under /admin/")
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !isAdminPath(req.URL.Path) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		log.Println(logTag(req), req.Method, shortenURI(req.RequestURI))
+		resp.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		adminMux.ServeHTTP(resp, req)
+	})
+}
+
+func isAdminPath(path string) bool {
+	return path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+// writeJSON sends the value as a JSON response
+func writeJSON(resp http.ResponseWriter, val interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(resp)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(val); err != nil {
+		log.Println("JSON encoding error:", err)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// handlers for the /api/ and /_api/ endpoints, registered by the corresponding modules
+var apiMux = http.NewServeMux()
+
+func init() {
+	notFound := func(resp http.ResponseWriter, req *http.Request) {
+		apiError(resp, req, http.StatusNotFound, "Not found")
+	}
+
+	apiMux.HandleFunc("/api/", notFound)
+	apiMux.HandleFunc("/_api/", notFound)
+}
+
+// isAPIPath checks if the URL path is one of the API endpoints
+func isAPIPath(p string) bool {
+	return strings.HasPrefix(p, "/api/") || strings.HasPrefix(p, "/_api/")
+}
+
+func withAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !isAPIPath(req.URL.Path) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		log.Println(logTag(req), req.Method, shortenURI(req.RequestURI))
+		resp.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		apiMux.ServeHTTP(resp, req)
+	})
+}
+
+// apiTarget returns the file system path for the part of the URL path after the given prefix
+func apiTarget(req *http.Request, prefix string) string {
+	return resolvePath(strings.TrimPrefix(req.URL.Path, prefix))
+}
+
+// error response of the API and /admin/ endpoints
+type apiErrorBody struct {
+	Code      string `json:"code"`    // like "not_found", derived from the status
+	Message   string `json:"message"` // human readable
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// apiError sends the error as a JSON object with the given status
+func apiError(resp http.ResponseWriter, req *http.Request, status int, msg string) {
+	body := apiErrorBody{
+		Code:      strings.ToLower(strings.Replace(http.StatusText(status), " ", "_", -1)),
+		Message:   msg,
+		RequestID: requestID(req),
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	resp.WriteHeader(status)
+
+	if err := json.NewEncoder(resp).Encode(&body); err != nil {
+		log.Println("JSON encoding error:", err)
+	}
+}
+
+// foreignOrigin returns the origin of the request when it comes from a page of another site:
+// its Origin header, or its Referer header if there is no Origin, as older browsers do
+func foreignOrigin(req *http.Request) string {
+	origin := req.Header.Get("Origin")
+
+	if len(origin) == 0 {
+		origin = req.Header.Get("Referer")
+	}
+
+	if len(origin) > 0 {
+		if u, err := url.Parse(origin); err != nil || u.Host != req.Host {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// jsonRequest checks that the request modifying the share carries JSON body, and does not come
+// from a page of another site; the browsers cannot send JSON cross-site without a preflight,
+// but can send a JSON-looking body from a plain HTML form
+func jsonRequest(resp http.ResponseWriter, req *http.Request) bool {
+	if origin := foreignOrigin(req); len(origin) > 0 {
+		log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: cross-origin request from", origin)
+		apiError(resp, req, http.StatusForbidden, "Forbidden")
+		return false
+	}
+
+	if t, _, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err != nil || t != "application/json" {
+		apiError(resp, req, http.StatusUnsupportedMediaType, "Request body must be application/json")
+		return false
+	}
+
+	return true
+}
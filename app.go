@@ -0,0 +1,245 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// paths of the web app files; like the login form, these shadow the files with the same names
+const (
+	appManifestPath = "/_manifest.webmanifest"
+	appWorkerPath   = "/_sw.js"
+	appOfflinePath  = "/_offline.html"
+	appIconPath     = "/_icon-" // followed by the size and ".png"
+)
+
+// icon sizes, as required by the phones to install the app
+var appIconSizes = []int{192, 512}
+
+// withApp makes the share installable as a web app ("Add to Home Screen") on phones: it serves
+// the web app manifest, icons, and a service worker caching the app shell (not the files) for offline
+// start, and adds the references to them to the directory listings
+func withApp(next http.Handler) http.Handler {
+	files := map[string][]byte{
+		appWorkerPath:  []byte(appWorker),
+		appOfflinePath: []byte(appOffline),
+	}
+
+	types := map[string]string{
+		appManifestPath: "application/manifest+json",
+		appWorkerPath:   "text/javascript; charset=utf-8",
+		appOfflinePath:  "text/html; charset=utf-8",
+	}
+
+	manifest := appManifest{
+		Name:            "web-share: " + filepath.Base(rootDir),
+		ShortName:       filepath.Base(rootDir),
+		StartURL:        "/",
+		Scope:           "/",
+		Display:         "standalone",
+		BackgroundColor: "#ffffff",
+		ThemeColor:      appColorHex,
+	}
+
+	for _, size := range appIconSizes {
+		name := appIconPath + uintToString(uint(size)) + ".png"
+		files[name] = appIcon(size)
+		types[name] = "image/png"
+		manifest.Icons = append(manifest.Icons, appManifestIcon{
+			Src:   name,
+			Sizes: uintToString(uint(size)) + "x" + uintToString(uint(size)),
+			Type:  "image/png",
+		})
+	}
+
+	data, err := json.MarshalIndent(&manifest, "", "  ")
+
+	if err != nil {
+		die("Cannot build web app manifest", err)
+	}
+
+	files[appManifestPath] = data
+
+	tags := []byte(`<link rel="manifest" href="` + appManifestPath + `" crossorigin="use-credentials">
+<link rel="apple-touch-icon" href="` + appIconPath + `192.png">
+<meta name="theme-color" content="` + appColorHex + `">
+<meta name="apple-mobile-web-app-capable" content="yes">
+<meta name="apple-mobile-web-app-title" content="` + html.EscapeString(manifest.ShortName) + `">
+<script>if ("serviceWorker" in navigator) navigator.serviceWorker.register("` + appWorkerPath + `");</script>
+`)
+
+	start := time.Now()
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if data, ok := files[req.URL.Path]; ok && (req.Method == http.MethodGet || req.Method == http.MethodHead) {
+			resp.Header().Set("Content-Type", types[req.URL.Path])
+
+			if req.URL.Path == appWorkerPath {
+				resp.Header().Set("Service-Worker-Allowed", "/")
+			}
+
+			http.ServeContent(resp, req, req.URL.Path, start, bytes.NewReader(data))
+			return
+		}
+
+		if req.Method != http.MethodGet || !strings.HasSuffix(req.URL.Path, "/") {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if hasIndex(resolvePath(req.URL.Path)) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		w := &listingWriter{ResponseWriter: resp, status: http.StatusOK}
+
+		next.ServeHTTP(w, req)
+
+		body := w.buf.Bytes()
+
+		if w.status == http.StatusOK && strings.HasPrefix(resp.Header().Get("Content-Type"), "text/html") {
+			// the tags go to the head, so after the doctype, if any
+			i := 0
+
+			if bytes.HasPrefix(bytes.ToLower(body), []byte("<!doctype html>\n")) {
+				i = len("<!doctype html>\n")
+			}
+
+			body = append(append(body[:i:i], tags...), body[i:]...)
+			resp.Header().Del("Content-Length")
+		}
+
+		resp.WriteHeader(w.status)
+
+		if _, err := resp.Write(body); err != nil {
+			log.Println(logTag(req), "Listing error:", err)
+		}
+	})
+}
+
+type appManifest struct {
+	Name            string            `json:"name"`
+	ShortName       string            `json:"short_name"`
+	StartURL        string            `json:"start_url"`
+	Scope           string            `json:"scope"`
+	Display         string            `json:"display"`
+	BackgroundColor string            `json:"background_color"`
+	ThemeColor      string            `json:"theme_color"`
+	Icons           []appManifestIcon `json:"icons"`
+}
+
+type appManifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+// colour of the app icon and the title bar
+const appColorHex = "#2d6cdf"
+
+var appColor = color.RGBA{0x2d, 0x6c, 0xdf, 0xff}
+
+// appIcon draws a white folder on the coloured background, as a PNG image of the given size
+func appIcon(size int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	draw.Draw(img, img.Bounds(), &image.Uniform{appColor}, image.Point{}, draw.Src)
+
+	rect := func(x0, y0, x1, y1 int) image.Rectangle {
+		return image.Rect(x0*size/100, y0*size/100, x1*size/100, y1*size/100)
+	}
+
+	white := &image.Uniform{color.White}
+
+	draw.Draw(img, rect(22, 28, 46, 36), white, image.Point{}, draw.Src) // tab
+	draw.Draw(img, rect(22, 34, 78, 72), white, image.Point{}, draw.Src) // body
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, img); err != nil {
+		die("Cannot build web app icon", err)
+	}
+
+	return buf.Bytes()
+}
+
+// service worker caching the app shell, so the app starts, and explains the problem, when offline;
+// the shared files are never cached
+const appWorker = `var CACHE = "web-share-1";
+var SHELL = ["` + appOfflinePath + `", "` + appManifestPath + `", "` + appIconPath + `192.png", "` + appIconPath + `512.png"];
+
+self.addEventListener("install", function(e) {
+	e.waitUntil(caches.open(CACHE)
+		.then(function(c) { return c.addAll(SHELL); })
+		.then(function() { return self.skipWaiting(); }));
+});
+
+self.addEventListener("activate", function(e) {
+	e.waitUntil(caches.keys()
+		.then(function(keys) {
+			return Promise.all(keys.filter(function(k) { return k !== CACHE; }).map(function(k) { return caches.delete(k); }));
+		})
+		.then(function() { return self.clients.claim(); }));
+});
+
+self.addEventListener("fetch", function(e) {
+	var req = e.request, url = new URL(req.url);
+
+	if (req.method !== "GET" || url.origin !== location.origin) return;
+
+	if (SHELL.indexOf(url.pathname) >= 0) {
+		e.respondWith(caches.match(req).then(function(r) { return r || fetch(req); }));
+	} else if (req.mode === "navigate") {
+		e.respondWith(fetch(req).catch(function() { return caches.match("` + appOfflinePath + `"); }));
+	}
+});
+`
+
+// page shown by the app when the server cannot be reached
+const appOffline = `<!doctype html>
+<meta name="viewport" content="width=device-width">
+<title>Offline</title>
+<h1>Offline</h1>
+<p>The share cannot be reached at the moment: the server may be stopped, or this device may be
+on another network.</p>
+<p><button onclick="location.reload()">Try again</button></p>
+`
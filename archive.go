@@ -0,0 +1,302 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archive formats; the size of uncompressed archives is known in advance
+var archiveTypes = map[string]string{
+	"zip":    "application/zip",
+	"tar":    "application/x-tar",
+	"tar.gz": "application/gzip",
+}
+
+// file to put into an archive
+type archiveFile struct {
+	name string // full path
+	rel  string // path inside the archive
+	info os.FileInfo
+}
+
+// withArchive serves "<dir>/?archive=zip|tar|tar.gz[&since=<time>]" requests with an archive of all
+// the files under the directory, streamed without temporary files. The exact size of an uncompressed
+// archive is computed in advance from the file sizes and sent in Content-Length header. The listings
+// get "Download all" button.
+func withArchive(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		format := query.Get("archive")
+
+		if len(format) == 0 && req.Method == http.MethodGet && len(query) == 0 &&
+			strings.HasSuffix(req.URL.Path, "/") {
+			if !hasIndex(resolvePath(req.URL.Path)) {
+				serveWithForm(next, resp, req, downloadForm)
+				return
+			}
+		}
+
+		if len(format) == 0 || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		dir := resolvePath(req.URL.Path)
+
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() || isIgnored(dir, true) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		ctype, ok := archiveTypes[format]
+
+		if !ok {
+			http.Error(resp, "Unsupported archive format", http.StatusBadRequest)
+			return
+		}
+
+		since, err := parseSince(query)
+
+		if err != nil {
+			http.Error(resp, "Invalid since parameter", http.StatusBadRequest)
+			return
+		}
+
+		files, err := archiveFiles(req, dir, since)
+
+		if err != nil {
+			log.Println(logTag(req), "Archive error:", err)
+			http.Error(resp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// top level directory in the archive
+		base := filepath.Base(dir)
+
+		if dir == rootDir {
+			base = "share"
+		}
+
+		size := int64(-1)
+
+		switch format {
+		case "zip":
+			size = zipSize(zipEntries(base, files))
+		case "tar":
+			size = tarSize(base, files)
+		}
+
+		resp.Header().Set("Content-Type", ctype)
+
+		if size >= 0 {
+			resp.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+
+		resp.Header().Set("Content-Disposition", `attachment; filename="`+strings.Replace(base, `"`, "'", -1)+
+			"."+format+`"`)
+
+		if req.Method == http.MethodHead {
+			return
+		}
+
+		if size >= 0 {
+			log.Println(logTag(req), "Archiving", len(files), "file(s) from", shortenURI(path.Clean(req.URL.Path)),
+				"("+sizeString(size)+")")
+		} else {
+			log.Println(logTag(req), "Archiving", len(files), "file(s) from", shortenURI(path.Clean(req.URL.Path)),
+				"(compressed)")
+		}
+
+		switch format {
+		case "zip":
+			err = writeZip(resp, base, files)
+		case "tar":
+			err = writeTar(resp, base, files)
+		case "tar.gz":
+			err = writeTarGz(resp, base, files)
+		}
+
+		// the response cannot be changed by now
+		if err != nil {
+			log.Println(logTag(req), "Archive transfer error:", err)
+		}
+	})
+}
+
+// archiveFiles returns the files under the directory modified since the given time, sorted by path
+func archiveFiles(req *http.Request, dir string, since time.Time) ([]archiveFile, error) {
+	var files []archiveFile
+	var lock sync.Mutex
+
+	err := walkFiles(req.Context(), dir, func(name, rel string, info os.FileInfo) error {
+		if modifiedSince(info.ModTime(), since) {
+			lock.Lock()
+			files = append(files, archiveFile{name: name, rel: rel, info: info})
+			lock.Unlock()
+		}
+
+		return nil
+	})
+
+	sort.Slice(files, func(i, j int) bool { return files[i].rel < files[j].rel })
+	return files, err
+}
+
+func zipEntries(base string, files []archiveFile) []zipEntry {
+	entries := make([]zipEntry, len(files))
+
+	for i, f := range files {
+		entries[i] = zipEntry{
+			name:  base + "/" + f.rel,
+			size:  f.info.Size(),
+			mtime: f.info.ModTime(),
+			mode:  f.info.Mode(),
+		}
+	}
+
+	return entries
+}
+
+func writeZip(w io.Writer, base string, files []archiveFile) error {
+	z := &zipWriter{w: w}
+
+	for i, e := range zipEntries(base, files) {
+		if err := addFile(files[i].name, func(src io.Reader) error { return z.add(e, src) }); err != nil {
+			return err
+		}
+	}
+
+	return z.close()
+}
+
+func tarHeader(base string, f archiveFile) *tar.Header {
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     base + "/" + f.rel,
+		Size:     f.info.Size(),
+		Mode:     int64(f.info.Mode().Perm()),
+		ModTime:  f.info.ModTime().Truncate(time.Second),
+		Format:   tar.FormatPAX,
+	}
+}
+
+// tarSize returns the exact size of the tar archive: the size of each header, as written by
+// the tar writer, plus the content padded to 512 byte blocks, plus two zero blocks at the end
+func tarSize(base string, files []archiveFile) int64 {
+	var size int64
+
+	for _, f := range files {
+		c := &sizeCounter{}
+
+		if err := tar.NewWriter(c).WriteHeader(tarHeader(base, f)); err != nil {
+			return -1
+		}
+
+		size += c.n + (f.info.Size()+511)/512*512
+	}
+
+	return size + 1024
+}
+
+func writeTar(w io.Writer, base string, files []archiveFile) error {
+	tw := tar.NewWriter(w)
+
+	for _, f := range files {
+		hdr := tarHeader(base, f)
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		err := addFile(f.name, func(src io.Reader) error {
+			n, err := io.Copy(tw, io.LimitReader(src, hdr.Size))
+
+			if err == nil && n != hdr.Size {
+				err = errFileChanged
+			}
+
+			return err
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// addFile opens the file and passes it to the function
+func addFile(name string, fn func(src io.Reader) error) error {
+	file, err := os.Open(name)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return fn(file)
+}
+
+// sizeCounter counts the bytes written
+type sizeCounter struct{ n int64 }
+
+func (c *sizeCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func writeTarGz(w io.Writer, base string, files []archiveFile) error {
+	gz := gzip.NewWriter(w)
+
+	if err := writeTar(gz, base, files); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+const downloadForm = `<form method="get">
+<select name="archive"><option value="zip">ZIP</option><option value="tar">TAR</option><option value="tar.gz">TAR.GZ</option></select> <button type="submit">Download all</button>
+</form>
+`
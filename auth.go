@@ -0,0 +1,222 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// authentication realm
+var authRealm string
+
+func init() {
+	gnuflag.StringVar(&authRealm, "realm", "web-share", "Authentication realm.")
+}
+
+// HTTP authentication scheme
+type authScheme struct {
+	name string // like "Digest"
+
+	// verify checks the credentials from Authorization header, returning the user name
+	verify func(req *http.Request, credentials string) (string, error)
+
+	// challenge returns WWW-Authenticate header value, given the error from the last verification, if any
+	challenge func(err error) string
+}
+
+// enabled authentication schemes, in order of preference
+var authSchemes []authScheme
+
+// password verification, returning errAuthUser for the users it does not know
+type passwordChecker func(user, password string) error
+
+// sources of user passwords for the login form
+var passwordCheckers []passwordChecker
+
+// checkPassword verifies the user's password against all password sources
+func checkPassword(user, password string) error {
+	for _, check := range passwordCheckers {
+		if err := check(user, password); err != errAuthUser {
+			return err
+		}
+	}
+
+	return errAuthUser
+}
+
+var (
+	errAuthUser     = errors.New("unknown user")
+	errAuthPassword = errors.New("invalid password")
+	errAuthStale    = errors.New("stale credentials") // to be retried by the client
+	errAuthInvalid  = errors.New("invalid credentials")
+)
+
+// withAuth requires every request to be authenticated by one of the enabled schemes, or by a session
+// from the login form; unauthenticated browsers are redirected to the login form, if enabled.
+func withAuth(next http.Handler) http.Handler {
+	setupLogin()
+
+	if len(authSchemes) == 0 && !loginForm {
+		return next
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if loginForm {
+			switch req.URL.Path {
+			case loginPath:
+				serveLogin(resp, req)
+				return
+			case logoutPath:
+				serveLogout(resp, req)
+				return
+			}
+
+			if user := sessionUser(req); len(user) > 0 {
+				next.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), authUserKey{}, user)))
+				return
+			}
+		}
+
+		var failure error
+
+		if scheme, credentials := splitAuth(req.Header.Get("Authorization")); len(scheme) > 0 {
+			for _, s := range authSchemes {
+				if !strings.EqualFold(s.name, scheme) {
+					continue
+				}
+
+				user, err := s.verify(req, credentials)
+
+				if err == nil {
+					next.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), authUserKey{}, user)))
+					return
+				}
+
+				if err != errAuthStale {
+					log.Println(logTag(req), "Authentication failed:", err)
+				}
+
+				failure = err
+				break
+			}
+		}
+
+		if loginForm && failure == nil && req.Method == http.MethodGet &&
+			strings.Contains(req.Header.Get("Accept"), "text/html") {
+			http.Redirect(resp, req, loginPath+"?next="+url.QueryEscape(req.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+
+		for _, s := range authSchemes {
+			resp.Header().Add("WWW-Authenticate", s.challenge(failure))
+		}
+
+		http.Error(resp, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// context key for the authenticated user name
+type authUserKey struct{}
+
+// authUser returns the name of the authenticated user, if any
+func authUser(req *http.Request) string {
+	user, _ := req.Context().Value(authUserKey{}).(string)
+	return user
+}
+
+// splitAuth splits Authorization header value into the scheme and the credentials
+func splitAuth(s string) (scheme, credentials string) {
+	s = strings.TrimSpace(s)
+
+	if i := strings.IndexByte(s, ' '); i > 0 {
+		return s[:i], strings.TrimSpace(s[i+1:])
+	}
+
+	return s, ""
+}
+
+// parseAuthParams parses comma-separated list of "name=value" or "name=\"quoted value\"" pairs,
+// returning the values by lower-case names
+func parseAuthParams(s string) (map[string]string, error) {
+	params := make(map[string]string)
+
+	for s = strings.TrimSpace(s); len(s) > 0; s = strings.TrimLeft(s, ", \t") {
+		i := strings.IndexByte(s, '=')
+
+		if i <= 0 {
+			return nil, errAuthInvalid
+		}
+
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " \t")
+
+		var value strings.Builder
+
+		if strings.HasPrefix(s, `"`) {
+			i = 1
+
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+
+				value.WriteByte(s[i])
+			}
+
+			if i == len(s) {
+				return nil, errAuthInvalid
+			}
+
+			s = s[i+1:]
+		} else {
+			if i = strings.IndexByte(s, ','); i < 0 {
+				i = len(s)
+			}
+
+			value.WriteString(strings.TrimSpace(s[:i]))
+			s = s[i:]
+		}
+
+		params[name] = value.String()
+	}
+
+	return params, nil
+}
+
+// quoteAuth returns the string as quoted-string for WWW-Authenticate header
+func quoteAuth(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
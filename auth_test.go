@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitAuth(t *testing.T) {
+	cases := []struct {
+		src, scheme, credentials string
+	}{
+		{"Basic am9lOnNlY3JldA==", "Basic", "am9lOnNlY3JldA=="},
+		{"  Digest  username=\"joe\", realm=\"x\" ", "Digest", "username=\"joe\", realm=\"x\""},
+		{"Bearer", "Bearer", ""},
+		{"", "", ""},
+	}
+
+	for _, c := range cases {
+		if scheme, credentials := splitAuth(c.src); scheme != c.scheme || credentials != c.credentials {
+			t.Errorf("%q: got %q, %q", c.src, scheme, credentials)
+		}
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	cases := []struct {
+		src    string
+		params map[string]string
+	}{
+		{``, map[string]string{}},
+		{`username="joe", Realm="web-share", nc=00000001`,
+			map[string]string{"username": "joe", "realm": "web-share", "nc": "00000001"}},
+		{`a="x, y",b= z ,c=""`, map[string]string{"a": "x, y", "b": "z", "c": ""}},
+		{`a="say \"hi\" \\ there"`, map[string]string{"a": `say "hi" \ there`}},
+		{`a="unterminated`, nil},
+		{`=x`, nil},
+		{`novalue`, nil},
+	}
+
+	for _, c := range cases {
+		params, err := parseAuthParams(c.src)
+
+		if c.params == nil {
+			if err == nil {
+				t.Errorf("%q: no error", c.src)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", c.src, err)
+		} else if !reflect.DeepEqual(params, c.params) {
+			t.Errorf("%q: got %v", c.src, params)
+		}
+	}
+}
+
+func TestQuoteAuth(t *testing.T) {
+	for _, s := range []string{"", "web-share", `a "quoted" \ value`} {
+		params, err := parseAuthParams("x=" + quoteAuth(s))
+
+		if err != nil || params["x"] != s {
+			t.Errorf("%q: got %q, %v", s, params["x"], err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// bandwidth limits, like "5MB/s"
+var (
+	bandwidthLimit string // total, shared by all the connections
+	connLimit      string // per connection
+)
+
+func init() {
+	gnuflag.StringVar(&bandwidthLimit, "limit-bandwidth", "",
+		"Limit the total download rate, e.g. \"5MB/s\" or \"20Mbit/s\".")
+	gnuflag.StringVar(&connLimit, "limit-conn", "",
+		"Limit the download rate of each connection, e.g. \"1MB/s\".")
+}
+
+// withBandwidth throttles the responses with token buckets, one shared by all the connections,
+// and one for each connection, and logs the throughput of each throttled request
+func withBandwidth(next http.Handler) http.Handler {
+	total, err := bandwidthRate(bandwidthLimit)
+
+	if err != nil {
+		die("Invalid bandwidth limit", err)
+	}
+
+	perConn, err := bandwidthRate(connLimit)
+
+	if err != nil {
+		die("Invalid connection rate limit", err)
+	}
+
+	if total == 0 && perConn == 0 {
+		return next
+	}
+
+	var shared *rateLimiter
+
+	if total > 0 {
+		log.Println("Bandwidth limit:", sizeString(total)+"/s")
+
+		if len(rateLimitSpec) > 0 {
+			log.Println("Warning: both --rate-limit and --limit-bandwidth are given, the lower rate applies")
+		}
+
+		shared = &rateLimiter{rates: rateSchedule{{rate: total}}}
+	}
+
+	if perConn > 0 {
+		log.Println("Connection rate limit:", sizeString(perConn)+"/s")
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		w := resp
+
+		if shared != nil {
+			w = &limitedWriter{ResponseWriter: w, limiter: shared, ctx: req.Context()}
+		}
+
+		if c, ok := req.Context().Value(connKey{}).(*countingConn); ok && perConn > 0 {
+			w = &limitedWriter{ResponseWriter: w, limiter: c.rateLimiter(perConn), ctx: req.Context()}
+		}
+
+		tw := &trackingWriter{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(tw, req)
+
+		if sent := tw.sent; sent > 0 && !isQuiet(req) {
+			elapsed := time.Since(start)
+
+			log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "sent", sizeString(sent),
+				"in", elapsed.Round(time.Millisecond).String(),
+				"("+sizeString(int64(float64(sent)/elapsed.Seconds()))+"/s)")
+		}
+	})
+}
+
+// bandwidthRate parses the rate limit given by the option, 0 for unlimited
+func bandwidthRate(s string) (int64, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+
+	return parseRate(s)
+}
+
+// rateLimiter returns the limiter of the connection, creating it on the first call
+func (c *countingConn) rateLimiter(rate int64) *rateLimiter {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.limiter == nil {
+		c.limiter = &rateLimiter{rates: rateSchedule{{rate: rate}}}
+	}
+
+	return c.limiter
+}
@@ -0,0 +1,322 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/juju/gnuflag"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Basic authentication credentials
+var (
+	basicUser    string
+	htpasswdFile string
+)
+
+func init() {
+	gnuflag.StringVar(&basicUser, "auth", "",
+		"Require HTTP Basic authentication with the given \"user:password\".")
+	gnuflag.StringVar(&htpasswdFile, "htpasswd", "",
+		"Require HTTP Basic authentication with the users from the given htpasswd(1) file (bcrypt, MD5, or SHA-1 hashes).")
+}
+
+// Basic authentication state
+var basic struct {
+	users map[string]string // user name -> password hash, in htpasswd format
+
+	lock     sync.Mutex
+	verified map[string][sha256.Size]byte // user name -> hash of the last accepted password
+}
+
+// setupBasic enables Basic authentication (RFC 7617)
+func setupBasic() {
+	if len(basicUser) == 0 && len(htpasswdFile) == 0 {
+		return
+	}
+
+	users := make(map[string]string)
+
+	if len(htpasswdFile) > 0 {
+		var err error
+
+		if users, err = readHtpasswd(htpasswdFile); err != nil {
+			die("Cannot read htpasswd file", err)
+		}
+	}
+
+	if len(basicUser) > 0 {
+		i := strings.IndexByte(basicUser, ':')
+
+		if i <= 0 || i == len(basicUser)-1 {
+			die("Invalid user:password pair for --auth option", nil)
+		}
+
+		sum := sha1.Sum([]byte(basicUser[i+1:]))
+		users[basicUser[:i]] = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	if len(users) == 0 {
+		die("No users in "+htpasswdFile, nil)
+	}
+
+	basic.users = users
+	basic.verified = make(map[string][sha256.Size]byte)
+
+	authSchemes = append(authSchemes, authScheme{
+		name:      "Basic",
+		verify:    verifyBasic,
+		challenge: basicChallenge,
+	})
+
+	passwordCheckers = append(passwordCheckers, checkBasicPassword)
+
+	log.Println("Basic authentication enabled for", len(users), "user(s)")
+
+	if tlsConfig == nil {
+		log.Println("Warning: without --tls the passwords are sent over the network in clear text")
+	}
+}
+
+var errHtpasswdHash = errors.New("unsupported password hash, expected bcrypt, MD5 ($apr1$), or SHA-1 ({SHA})")
+
+// readHtpasswd reads "user:hash" lines
+func readHtpasswd(name string) (map[string]string, error) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	users := make(map[string]string)
+	src := bufio.NewScanner(file)
+
+	for src.Scan() {
+		line := strings.TrimSpace(src.Text())
+
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		i := strings.IndexByte(line, ':')
+
+		if i <= 0 {
+			return nil, &os.PathError{Op: "parse", Path: name, Err: errAuthInvalid}
+		}
+
+		hash := line[i+1:]
+
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "$apr1$") && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, &os.PathError{Op: "parse", Path: name, Err: errHtpasswdHash}
+		}
+
+		users[line[:i]] = hash
+	}
+
+	return users, src.Err()
+}
+
+func basicChallenge(error) string {
+	return "Basic realm=" + quoteAuth(authRealm) + `, charset="UTF-8"`
+}
+
+func verifyBasic(req *http.Request, credentials string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(credentials)
+
+	if err != nil {
+		return "", errAuthInvalid
+	}
+
+	i := strings.IndexByte(string(data), ':')
+
+	if i < 0 {
+		return "", errAuthInvalid
+	}
+
+	user := string(data[:i])
+
+	if _, ok := basic.users[user]; ok && hasTOTP(user) {
+		// the one-time code can only be given in the login form
+		return "", errTOTPRequired
+	}
+
+	if err = checkBasicPassword(user, string(data[i+1:])); err != nil {
+		return "", err
+	}
+
+	return user, nil
+}
+
+// checkBasicPassword verifies the password against the hash from htpasswd file; the last accepted
+// password of each user is remembered, because bcrypt is deliberately slow, and the browsers
+// send the credentials with every request.
+func checkBasicPassword(user, password string) error {
+	hash, ok := basic.users[user]
+
+	if !ok {
+		return errAuthUser
+	}
+
+	sum := sha256.Sum256([]byte(password))
+
+	basic.lock.Lock()
+	last, ok := basic.verified[user]
+	basic.lock.Unlock()
+
+	if ok && subtle.ConstantTimeCompare(last[:], sum[:]) == 1 {
+		return nil
+	}
+
+	if !checkHtpasswdHash(hash, password) {
+		return errAuthPassword
+	}
+
+	basic.lock.Lock()
+	basic.verified[user] = sum
+	basic.lock.Unlock()
+
+	return nil
+}
+
+// checkHtpasswdHash verifies the password against the hash in one of the formats of htpasswd(1)
+func checkHtpasswdHash(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt := strings.TrimPrefix(hash, "$apr1$")
+
+		if i := strings.IndexByte(salt, '$'); i >= 0 {
+			salt = salt[:i]
+		}
+
+		return subtle.ConstantTimeCompare([]byte(apr1Hash(password, salt)), []byte(hash)) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(sum[:])), []byte(hash[5:])) == 1
+	default:
+		return false
+	}
+}
+
+// apr1Hash returns Apache's variant of MD5-based crypt(3) hash of the password
+func apr1Hash(password, salt string) string {
+	const magic = "$apr1$"
+
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	pw := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+
+	d := md5.New()
+	d.Write([]byte(password + magic + salt))
+
+	for i := len(pw); i > 0; i -= md5.Size {
+		if i > md5.Size {
+			d.Write(alt[:])
+		} else {
+			d.Write(alt[:i])
+		}
+	}
+
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+
+	sum := d.Sum(nil)
+
+	// stretching
+	for i := 0; i < 1000; i++ {
+		d.Reset()
+
+		if i&1 != 0 {
+			d.Write(pw)
+		} else {
+			d.Write(sum)
+		}
+
+		if i%3 != 0 {
+			d.Write([]byte(salt))
+		}
+
+		if i%7 != 0 {
+			d.Write(pw)
+		}
+
+		if i&1 != 0 {
+			d.Write(sum)
+		} else {
+			d.Write(pw)
+		}
+
+		sum = d.Sum(sum[:0])
+	}
+
+	// encoding
+	const alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	res := []byte(magic + salt + "$")
+
+	encode := func(a, b, c byte, n int) {
+		for v := uint(a)<<16 | uint(b)<<8 | uint(c); n > 0; n-- {
+			res = append(res, alphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+
+	encode(sum[0], sum[6], sum[12], 4)
+	encode(sum[1], sum[7], sum[13], 4)
+	encode(sum[2], sum[8], sum[14], 4)
+	encode(sum[3], sum[9], sum[15], 4)
+	encode(sum[4], sum[10], sum[5], 4)
+	encode(0, 0, sum[11], 2)
+
+	return string(res)
+}
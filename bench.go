@@ -0,0 +1,253 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// "bench" subcommand: generate concurrent download load and report throughput and latencies.
+func benchCommand(args []string) int {
+	var conns uint
+	var duration time.Duration
+	var rangeSpec string
+
+	flags := gnuflag.NewFlagSet("bench", gnuflag.ExitOnError)
+
+	flags.UintVar(&conns, "connections", 4, "Number of concurrent connections.")
+	flags.UintVar(&conns, "c", 4, "Number of concurrent connections.")
+
+	flags.DurationVar(&duration, "duration", 10*time.Second, "Duration of the test.")
+	flags.DurationVar(&duration, "d", 10*time.Second, "Duration of the test.")
+
+	flags.StringVar(&rangeSpec, "range", "", "Request random byte ranges of the given size (e.g. 64K) instead of whole files.")
+	flags.StringVar(&rangeSpec, "r", "", "Request random byte ranges of the given size (e.g. 64K) instead of whole files.")
+
+	flags.Parse(true, args)
+
+	if flags.NArg() != 1 {
+		die("Usage: "+os.Args[0]+" bench [options] <url>", nil)
+	}
+
+	if conns == 0 {
+		die("Invalid number of connections: 0", nil)
+	}
+
+	if duration <= 0 {
+		die("Invalid test duration: "+duration.String(), nil)
+	}
+
+	var rangeSize int64
+
+	if len(rangeSpec) > 0 {
+		var err error
+
+		if rangeSize, err = parseByteSize(rangeSpec); err != nil || rangeSize == 0 {
+			die("Invalid range size: "+rangeSpec, err)
+		}
+	}
+
+	b := &bench{
+		url:       flags.Arg(0),
+		rangeSize: rangeSize,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost: int(conns),
+				DisableCompression:  true,
+			},
+		},
+	}
+
+	// with ranges we need to know the size of the target
+	if rangeSize > 0 {
+		if err := b.getSize(); err != nil {
+			die("Cannot get the size of "+b.url, err)
+		}
+	}
+
+	// run
+	fmt.Printf("Running %s test with %d connection(s) against %s\n", duration, conns, b.url)
+
+	deadline := time.Now().Add(duration)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+
+	for i := uint(0); i < conns; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for time.Now().Before(deadline) {
+				b.request()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	b.report(time.Since(start))
+
+	if b.failed > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+type bench struct {
+	url       string
+	rangeSize int64
+	size      int64
+	client    *http.Client
+
+	lock      sync.Mutex
+	bytes     int64
+	failed    int
+	lastError error
+	latencies []time.Duration // full request times
+	ttfbs     []time.Duration // times to the first byte
+}
+
+func (b *bench) getSize() error {
+	resp, err := b.client.Head(b.url)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+
+	if b.size = resp.ContentLength; b.size <= 0 {
+		return errors.New("unknown content length")
+	}
+
+	if b.rangeSize > b.size {
+		b.rangeSize = b.size
+	}
+
+	return nil
+}
+
+func (b *bench) request() {
+	req, err := http.NewRequest(http.MethodGet, b.url, nil)
+
+	if err != nil {
+		die("Invalid URL", err)
+	}
+
+	if b.rangeSize > 0 {
+		from := rand.Int63n(b.size - b.rangeSize + 1)
+
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(from, 10)+"-"+strconv.FormatInt(from+b.rangeSize-1, 10))
+	}
+
+	start := time.Now()
+
+	var ttfb time.Duration
+	var n int64
+
+	resp, err := b.client.Do(req)
+
+	if err == nil {
+		ttfb = time.Since(start)
+
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+			n, err = io.Copy(ioutil.Discard, resp.Body)
+		} else {
+			err = errors.New(resp.Status)
+		}
+
+		resp.Body.Close()
+	}
+
+	latency := time.Since(start)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.bytes += n
+
+	if err != nil {
+		b.failed++
+		b.lastError = err
+		return
+	}
+
+	b.latencies = append(b.latencies, latency)
+	b.ttfbs = append(b.ttfbs, ttfb)
+}
+
+func (b *bench) report(elapsed time.Duration) {
+	total := len(b.latencies) + b.failed
+
+	fmt.Printf("Requests:   %d total, %d failed\n", total, b.failed)
+	fmt.Printf("Transfer:   %s in %s\n", sizeString(b.bytes), elapsed.Round(time.Millisecond))
+	fmt.Printf("Throughput: %s, %.1f requests/s\n",
+		sizeString(int64(float64(b.bytes)/elapsed.Seconds()))+"/s", float64(total)/elapsed.Seconds())
+
+	printPercentiles("Latency:   ", b.latencies)
+	printPercentiles("First byte:", b.ttfbs)
+
+	if b.lastError != nil {
+		fmt.Println("Last error:", b.lastError)
+	}
+}
+
+func printPercentiles(title string, ds []time.Duration) {
+	if len(ds) == 0 {
+		return
+	}
+
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+
+	pc := func(p int) time.Duration {
+		return ds[(len(ds)-1)*p/100].Round(time.Microsecond)
+	}
+
+	fmt.Println(title, "p50", pc(50), "p90", pc(90), "p99", pc(99), "max", pc(100))
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// handling of non-canonical request paths
+var canonicalMode string
+
+func init() {
+	gnuflag.StringVar(&canonicalMode, "canonical-paths", "redirect",
+		"Handling of request paths with duplicate slashes, \".\" or \"..\" segments, or wrong trailing slash: \"redirect\", \"reject\", or \"off\".")
+}
+
+// withCanonical makes every resource reachable under one path only: duplicate slashes and dot segments
+// are removed, directories end with a slash, and files do not. Requests for other spellings are
+// redirected to the canonical path (301 for GET and HEAD, 308 for other methods), or rejected.
+func withCanonical(next http.Handler) http.Handler {
+	switch canonicalMode {
+	case "off":
+		return next
+	case "redirect", "reject":
+		// ok
+	default:
+		die("Invalid --canonical-paths mode: "+canonicalMode, nil)
+	}
+
+	if canonicalMode == "reject" {
+		log.Println("Rejecting non-canonical paths")
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		p := canonicalPath(req.URL.Path)
+
+		if p == req.URL.Path {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if canonicalMode == "reject" {
+			http.NotFound(resp, req)
+			return
+		}
+
+		status := http.StatusMovedPermanently
+
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+
+		resp.Header().Set("Location", (&url.URL{Path: p, RawQuery: req.URL.RawQuery}).String())
+		resp.WriteHeader(status)
+	})
+}
+
+// canonicalPath returns the canonical form of the URL path; the trailing slash is kept as is
+// for the paths not found in the shared directory.
+func canonicalPath(p string) string {
+	res := path.Clean("/" + p)
+
+	if res == "/" {
+		return res
+	}
+
+	full := resolvePath(res)
+	info, err := os.Stat(full)
+
+	switch {
+	case err == nil && !isIgnored(full, info.IsDir()):
+		if info.IsDir() || (strings.HasSuffix(p, "/") && len(archiveKind(res)) > 0) {
+			res += "/" // directory, or the listing of an archive
+		}
+	case strings.HasSuffix(p, "/"):
+		res += "/"
+	}
+
+	return res
+}
@@ -0,0 +1,85 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// limit of the bytes sent in the session
+var totalCapSpec string
+
+func init() {
+	gnuflag.StringVar(&totalCapSpec, "total-cap", "0",
+		"Stop accepting new requests once the given amount of data (e.g. 50G) has been sent in this session; 0 for no limit.")
+}
+
+// makes sure the cap is logged once
+var capReached sync.Once
+
+// withTotalCap rejects new requests once the total amount of data sent reaches the cap,
+// while the transfers in progress continue
+func withTotalCap(next http.Handler) http.Handler {
+	limit, err := parseByteSize(totalCapSpec)
+
+	if err != nil {
+		die("Invalid total cap: "+totalCapSpec, err)
+	}
+
+	if limit == 0 {
+		return next
+	}
+
+	log.Println("Total cap:", sizeString(limit))
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		sent := atomic.LoadInt64(&totalSent)
+
+		if sent < limit {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		capReached.Do(func() {
+			log.Println("Total cap of", sizeString(limit), "reached, rejecting new requests")
+		})
+
+		log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: total cap reached")
+
+		servePage(resp, http.StatusServiceUnavailable, "Limit reached",
+			"This share has sent "+sizeString(sent)+" of data, which is all it is allowed to send "+
+				"in this session. Please ask the owner to restart it.", time.Time{})
+	})
+}
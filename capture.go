@@ -0,0 +1,417 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// request capture parameters
+var (
+	captureFile    string
+	captureBodyMax string
+)
+
+func init() {
+	gnuflag.StringVar(&captureFile, "capture", "",
+		"Record request/response metadata to the given file, for later analysis or replay.")
+	gnuflag.StringVar(&captureBodyMax, "capture-body", "0",
+		"Also record up to the given number of bytes (e.g. 4K) of request and response bodies.")
+}
+
+// captured request/response, one JSON object per line in the capture file
+type captureRecord struct {
+	Time       time.Time   `json:"time"`
+	Remote     string      `json:"remote"`
+	Method     string      `json:"method"`
+	Scheme     string      `json:"scheme,omitempty"` // "http" if not given
+	URI        string      `json:"uri"`
+	Proto      string      `json:"proto"`
+	Host       string      `json:"host"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body,omitempty"`
+	Partial    bool        `json:"partial,omitempty"` // the request body is not captured in full
+	Status     int         `json:"status"`
+	RespHeader http.Header `json:"resp_header"`
+	RespBody   []byte      `json:"resp_body,omitempty"`
+	Sent       int64       `json:"sent"`
+	Duration   float64     `json:"duration"` // seconds
+}
+
+func withCapture(next http.Handler) http.Handler {
+	if len(captureFile) == 0 {
+		return next
+	}
+
+	bodyMax, err := parseByteSize(captureBodyMax)
+
+	if err != nil {
+		die("Invalid capture body size", err)
+	}
+
+	// the captured requests are nobody else's business, even with the credentials redacted
+	file, err := os.OpenFile(captureFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+
+	if err == nil {
+		err = file.Chmod(0600)
+	}
+
+	if err != nil {
+		die("Cannot open capture file", err)
+	}
+
+	log.Println("Capturing requests to", captureFile)
+
+	var lock sync.Mutex
+
+	enc := json.NewEncoder(file)
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		rec := &captureRecord{
+			Time:   time.Now(),
+			Remote: req.RemoteAddr,
+			Method: req.Method,
+			Scheme: "http",
+			URI:    redactURI(req.RequestURI),
+			Proto:  req.Proto,
+			Host:   req.Host,
+			Header: redactHeader(req.Header),
+		}
+
+		reqBody := &limitedBuffer{max: int(bodyMax)}
+		cw := &captureWriter{ResponseWriter: resp, body: limitedBuffer{max: int(bodyMax)}}
+
+		if req.Body != nil && bodyMax > 0 {
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(req.Body, reqBody), req.Body}
+		}
+
+		if req.TLS != nil {
+			rec.Scheme = "https"
+		}
+
+		next.ServeHTTP(cw, req)
+
+		// the rest of the body the handler has not read, if it still fits
+		if req.Body != nil && req.ContentLength != 0 && bodyMax > 0 && !reqBody.dropped {
+			io.CopyN(reqBody, req.Body, int64(reqBody.max-reqBody.Len())+1)
+		}
+
+		rec.Duration = time.Since(rec.Time).Seconds()
+		rec.Status = cw.status
+		rec.RespHeader = redactHeader(resp.Header())
+		rec.Sent = cw.sent
+		rec.Body = reqBody.Bytes()
+		rec.Partial = req.ContentLength != 0 && (bodyMax == 0 || reqBody.dropped)
+		rec.RespBody = cw.body.Bytes()
+
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		if err := enc.Encode(rec); err != nil {
+			log.Println("Capture error:", err)
+		}
+	})
+}
+
+// headers and query parameters with the credentials, which are not captured
+var (
+	captureSecretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+	captureSecretParams  = []string{"sig"}
+)
+
+// redactHeader returns a copy of the header without the credentials
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+
+	for _, key := range captureSecretHeaders {
+		h.Del(key)
+	}
+
+	return h
+}
+
+// redactURI replaces the values of the query parameters with the credentials, keeping the rest as is
+func redactURI(uri string) string {
+	i := strings.IndexByte(uri, '?')
+
+	if i < 0 {
+		return uri
+	}
+
+	params := strings.Split(uri[i+1:], "&")
+
+	for k, param := range params {
+		key := param
+
+		if j := strings.IndexByte(param, '='); j >= 0 {
+			key = param[:j]
+		}
+
+		if key, err := url.QueryUnescape(key); err == nil {
+			for _, secret := range captureSecretParams {
+				if key == secret {
+					params[k] = secret + "=redacted"
+				}
+			}
+		}
+	}
+
+	return uri[:i+1] + strings.Join(params, "&")
+}
+
+// buffer that silently drops everything beyond the limit
+type limitedBuffer struct {
+	bytes.Buffer
+	max     int
+	dropped bool // some data did not fit
+}
+
+func (b *limitedBuffer) Write(data []byte) (int, error) {
+	n := b.max - b.Len()
+
+	if n < 0 {
+		n = 0
+	}
+
+	if n > len(data) {
+		n = len(data)
+	}
+
+	b.Buffer.Write(data[:n])
+	b.dropped = b.dropped || n < len(data)
+
+	return len(data), nil
+}
+
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	sent   int64
+	body   limitedBuffer
+}
+
+func (w *captureWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+
+	w.sent += int64(n)
+	w.body.Write(data[:n])
+
+	return n, err
+}
+
+func (w *captureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// "replay" subcommand: resend captured requests
+func replayCommand(args []string) int {
+	var target string
+	var keepTiming, insecure bool
+
+	flags := gnuflag.NewFlagSet("replay", gnuflag.ExitOnError)
+
+	flags.StringVar(&target, "target", "", "Server to send the requests to, like \"http://127.0.0.1:8080\" (default: the original host).")
+	flags.StringVar(&target, "t", "", "Server to send the requests to, like \"http://127.0.0.1:8080\" (default: the original host).")
+
+	flags.BoolVar(&keepTiming, "keep-timing", false, "Preserve the original intervals between requests.")
+	flags.BoolVar(&insecure, "insecure", false, "Do not verify the server's TLS certificate at all.")
+
+	flags.Parse(true, args)
+
+	if flags.NArg() != 1 {
+		die("Usage: "+os.Args[0]+" replay [options] <capture file>", nil)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+
+	if err != nil {
+		die("", err)
+	}
+
+	defer file.Close()
+
+	// the capture file may still be growing, possibly even from our own requests
+	info, err := file.Stat()
+
+	if err != nil {
+		die("", err)
+	}
+
+	client := &http.Client{
+		// we want to see the redirects as they are
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+		},
+	}
+
+	target = strings.TrimSuffix(target, "/")
+	diffs := 0
+
+	var prev time.Time
+
+	scanner := bufio.NewScanner(io.LimitReader(file, info.Size()))
+	scanner.Buffer(nil, 64<<20)
+
+	for scanner.Scan() {
+		var rec captureRecord
+
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			die("Invalid capture record", err)
+		}
+
+		if keepTiming && !prev.IsZero() {
+			time.Sleep(rec.Time.Sub(prev))
+		}
+
+		prev = rec.Time
+
+		// sending a cut down body would overwrite the uploaded file with a part of it
+		if !completeBody(&rec) {
+			fmt.Println(rec.Method, shortenURI(rec.URI), "-> skipped: request body not captured in full")
+			continue
+		}
+
+		status, err := replay(client, target, &rec)
+
+		switch {
+		case err != nil:
+			fmt.Println(rec.Method, shortenURI(rec.URI), "->", err)
+			diffs++
+		case status != rec.Status:
+			fmt.Printf("%s %s -> %d (was %d)\n", rec.Method, shortenURI(rec.URI), status, rec.Status)
+			diffs++
+		default:
+			fmt.Println(rec.Method, shortenURI(rec.URI), "->", status)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		die("Cannot read capture file", err)
+	}
+
+	if diffs > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// completeBody checks if the request body has been captured in full; the older records
+// without the flag are checked against Content-Length
+func completeBody(rec *captureRecord) bool {
+	if rec.Partial {
+		return false
+	}
+
+	if s := rec.Header.Get("Content-Length"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+
+		return err == nil && n == len(rec.Body)
+	}
+
+	return len(rec.Body) > 0 || len(rec.Header.Get("Transfer-Encoding")) == 0
+}
+
+func replay(client *http.Client, target string, rec *captureRecord) (int, error) {
+	if len(target) == 0 {
+		scheme := rec.Scheme
+
+		if len(scheme) == 0 {
+			scheme = "http"
+		}
+
+		target = scheme + "://" + rec.Host
+	}
+
+	req, err := http.NewRequest(rec.Method, target+rec.URI, bytes.NewReader(rec.Body))
+
+	if err != nil {
+		return 0, err
+	}
+
+	for key, vals := range rec.Header {
+		switch key {
+		case "Content-Length", "Connection", "Keep-Alive", "Transfer-Encoding", "Te", "Upgrade":
+		default:
+			req.Header[key] = vals
+		}
+	}
+
+	req.Host = rec.Host
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
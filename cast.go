@@ -0,0 +1,281 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// file extensions recognised as video
+var videoExts = map[string]bool{
+	".mp4":  true,
+	".m4v":  true,
+	".webm": true,
+	".mkv":  true,
+	".mov":  true,
+	".ogv":  true,
+}
+
+func isVideo(name string) bool {
+	return videoExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// isCastable checks if the file can be sent to a media receiver
+func isCastable(name string) bool {
+	return isVideo(name) || isAudio(name)
+}
+
+// withCast makes media files usable by Chromecast and AirPlay receivers: they are served with
+// CORS headers (including subtitles), "<file>?cast" shows a player page with "Cast" button,
+// and the directory listings get "cast" links next to the media files.
+func withCast(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/") {
+			if req.Method == http.MethodGet {
+				decorateListing(next, resp, req)
+			} else {
+				next.ServeHTTP(resp, req)
+			}
+
+			return
+		}
+
+		name := resolvePath(req.URL.Path)
+		subtitles := strings.ToLower(filepath.Ext(name)) == ".vtt"
+
+		if !isCastable(name) && !subtitles {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		// receivers fetch the media from their own origin
+		switch req.Method {
+		case http.MethodOptions:
+			resp.Header().Set("Access-Control-Allow-Origin", "*")
+			resp.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+			resp.Header().Set("Access-Control-Allow-Headers", "Range, Content-Type")
+			resp.Header().Set("Access-Control-Max-Age", "86400")
+			resp.WriteHeader(http.StatusNoContent)
+			return
+		case http.MethodGet, http.MethodHead:
+			resp.Header().Set("Access-Control-Allow-Origin", "*")
+			resp.Header().Set("Access-Control-Expose-Headers", "Accept-Ranges, Content-Length, Content-Range, Content-Type")
+		default:
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		// receivers only accept subtitles of the proper type
+		if subtitles {
+			resp.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+		}
+
+		if _, ok := req.URL.Query()["cast"]; !ok || subtitles {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if info, err := os.Stat(name); err != nil || !info.Mode().IsRegular() || isIgnored(name, false) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		base := path.Base(req.URL.Path)
+
+		data := struct {
+			Name, Src, Subtitles string
+			Video                bool
+		}{
+			Name:  base,
+			Src:   (&url.URL{Path: base}).String(),
+			Video: isVideo(name),
+		}
+
+		// subtitles with the same base name
+		vtt := strings.TrimSuffix(name, filepath.Ext(name)) + ".vtt"
+
+		if info, err := os.Stat(vtt); err == nil && info.Mode().IsRegular() && !isIgnored(vtt, false) {
+			data.Subtitles = (&url.URL{Path: filepath.Base(vtt)}).String()
+		}
+
+		resp.Header().Del("Access-Control-Allow-Origin")
+		resp.Header().Del("Access-Control-Expose-Headers")
+		resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		if req.Method == http.MethodHead {
+			return
+		}
+
+		if err := castTemplate.Execute(resp, &data); err != nil {
+			log.Println("Template error:", err)
+		}
+	})
+}
+
+// decorateListing adds "cast" links to the directory listing
+func decorateListing(next http.Handler, resp http.ResponseWriter, req *http.Request) {
+	if hasIndex(resolvePath(req.URL.Path)) {
+		next.ServeHTTP(resp, req)
+		return
+	}
+
+	w := &listingWriter{ResponseWriter: resp, status: http.StatusOK}
+
+	next.ServeHTTP(w, req)
+
+	body := w.buf.Bytes()
+
+	if w.status == http.StatusOK && strings.HasPrefix(resp.Header().Get("Content-Type"), "text/html") &&
+		bytes.Contains(body, []byte("<pre")) {
+		var buf bytes.Buffer
+
+		if err := castScript.Execute(&buf, castPattern); err != nil {
+			log.Println("Template error:", err)
+		}
+
+		body = append(body, buf.Bytes()...)
+		resp.Header().Del("Content-Length")
+	}
+
+	resp.WriteHeader(w.status)
+	resp.Write(body)
+}
+
+// castPattern is a regular expression matching names of the media files
+var castPattern = func() string {
+	var exts []string
+
+	for ext := range videoExts {
+		exts = append(exts, ext[1:])
+	}
+
+	for ext := range audioExts {
+		exts = append(exts, ext[1:])
+	}
+
+	sort.Strings(exts)
+	return `\.(` + strings.Join(exts, "|") + `)$`
+}()
+
+// adds the links to the listing, including the entries loaded later
+var castScript = template.Must(template.New("cast-links").Parse(`<script>
+(function() {
+	var media = new RegExp({{.}}, "i"), lists = document.getElementsByTagName("pre");
+
+	if (lists.length === 0) return;
+
+	var listing = lists[lists.length - 1];
+
+	function decorate() {
+		var links = listing.getElementsByTagName("a");
+
+		for (var i = links.length - 1; i >= 0; i--) {
+			var a = links[i], href = a.getAttribute("href");
+
+			if (a.className === "cast" || a.hasAttribute("data-cast") || !media.test(decodeURIComponent(href))) continue;
+
+			var c = document.createElement("a");
+
+			c.className = "cast";
+			c.href = href + "?cast";
+			c.title = "Play or cast to a TV";
+			c.textContent = "[cast]";
+			a.setAttribute("data-cast", "");
+			a.parentNode.insertBefore(c, a.nextSibling);
+			a.parentNode.insertBefore(document.createTextNode(" "), c);
+		}
+	}
+
+	decorate();
+
+	if (window.MutationObserver) new MutationObserver(decorate).observe(listing, {childList: true});
+})();
+</script>
+`))
+
+var castTemplate = template.Must(template.New("cast").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}}</title>
+<style>
+body{margin:0;background:#111;color:#ccc;font-family:sans-serif;text-align:center}
+video{display:block;width:100%;max-height:85vh;background:#000}
+audio{margin-top:15%;width:80%}
+button{margin:1em;padding:.4em 1.5em;font-size:1.1em}
+a{color:#9cf}
+</style>
+</head>
+<body>
+{{if .Video}}<video id="media" controls preload="metadata" src="{{.Src}}">{{with .Subtitles}}
+<track kind="subtitles" src="{{.}}" default>{{end}}
+</video>{{else}}<h2>{{.Name}}</h2>
+<audio id="media" controls preload="metadata" src="{{.Src}}"></audio>{{end}}
+<p><button id="cast" hidden>Cast</button> <a href="{{.Src}}" download>Download</a></p>
+<p id="status"></p>
+<script>
+(function() {
+	var media = document.getElementById("media"), button = document.getElementById("cast"),
+		status = document.getElementById("status");
+
+	if (media.remote) {
+		// Remote Playback API: Chromecast in Chrome, AirPlay in Safari
+		media.remote.watchAvailability(function(available) { button.hidden = !available; })
+			.catch(function() { button.hidden = false; });
+
+		button.onclick = function() {
+			media.remote.prompt().catch(function(e) { status.textContent = e.message; });
+		};
+
+		media.remote.onconnect = function() { status.textContent = "Casting"; };
+		media.remote.ondisconnect = function() { status.textContent = ""; };
+	} else if (window.WebKitPlaybackTargetAvailabilityEvent) {
+		media.addEventListener("webkitplaybacktargetavailabilitychanged", function(e) {
+			button.hidden = e.availability !== "available";
+		});
+
+		button.onclick = function() { media.webkitShowPlaybackTargetPicker(); };
+	} else {
+		status.textContent = "Casting is not supported by this browser.";
+	}
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,139 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/cgi"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// URL path of the directory with CGI scripts
+var cgiDir string
+
+func init() {
+	gnuflag.StringVar(&cgiDir, "cgi-dir", "",
+		"Execute files from the given directory of the share as CGI scripts, like \"/cgi-bin\"; cannot be used with uploads or file management.")
+}
+
+// withCGI executes the scripts from the CGI directory, with the rest of the URL path after the script name
+// passed as PATH_INFO. Only executable files are run; the content of the directory is never served,
+// and POST requests to it are passed to the scripts as well. The share must be read-only.
+func withCGI(next http.Handler) http.Handler {
+	if len(cgiDir) == 0 {
+		return next
+	}
+
+	prefix := path.Clean("/" + cgiDir)
+
+	if prefix == "/" {
+		die("CGI directory cannot be the root of the share", nil)
+	}
+
+	if info, err := os.Stat(resolvePath(prefix)); err != nil || !info.IsDir() {
+		die("Invalid CGI directory: "+cgiDir, err)
+	}
+
+	// an upload, a rename, or a mode change could put a new script there
+	if allowUpload || uploadOnly || allowManage {
+		die("CGI scripts cannot be enabled together with uploads or file management", nil)
+	}
+
+	log.Println("CGI scripts enabled under", prefix)
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		p := path.Clean("/" + req.URL.Path)
+
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		script, root := findScript(prefix, p)
+
+		if len(script) == 0 {
+			http.Error(resp, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// PATH_INFO is taken from the cleaned path
+		r := *req
+		u := *req.URL
+		u.Path = p
+		r.URL = &u
+
+		h := cgi.Handler{
+			Path:   script,
+			Root:   root,
+			Dir:    filepath.Dir(script),
+			Env:    []string{"REMOTE_USER=" + authUser(req), "DOCUMENT_ROOT=" + rootDir},
+			Stderr: log.Writer(),
+		}
+
+		h.ServeHTTP(resp, &r)
+	})
+}
+
+// findScript returns the file name and the URL path of the executable file within the path,
+// or empty strings if there is none
+func findScript(prefix, p string) (script, root string) {
+	root = prefix
+
+	for _, seg := range strings.Split(strings.TrimPrefix(p, prefix), "/") {
+		if len(seg) == 0 {
+			continue
+		}
+
+		root = path.Join(root, seg)
+		name := resolvePath(root)
+		info, err := os.Stat(name)
+
+		if err != nil || isIgnored(name, info.IsDir()) {
+			return "", ""
+		}
+
+		if info.IsDir() {
+			continue
+		}
+
+		if !info.Mode().IsRegular() || info.Mode()&0111 == 0 || checkInsideRoot(filepath.Dir(name)) != nil {
+			return "", ""
+		}
+
+		return name, root
+	}
+
+	return "", ""
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindScript(t *testing.T) {
+	defer func(dir string) { rootDir = dir }(rootDir)
+
+	base := t.TempDir()
+	rootDir = filepath.Join(base, "root")
+
+	files := map[string]os.FileMode{
+		"root/cgi/run.sh":                    0755,
+		"root/cgi/data.txt":                  0644,
+		"root/cgi/sub/tool":                  0755,
+		"root/cgi/" + uploadTempPrefix + "x": 0755,
+		"root/lib/inside":                    0755,
+		"outside/evil":                       0755,
+	}
+
+	for name, mode := range files {
+		name = filepath.Join(base, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(name, []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		"root/cgi/out": filepath.Join(base, "outside"),
+		"root/cgi/lib": filepath.Join(rootDir, "lib"),
+	}
+
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(base, filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		p, script, root string
+	}{
+		{"/cgi", "", ""},
+		{"/cgi/run.sh", "cgi/run.sh", "/cgi/run.sh"},
+		{"/cgi/run.sh/extra/path", "cgi/run.sh", "/cgi/run.sh"},
+		{"/cgi/sub/tool/x", "cgi/sub/tool", "/cgi/sub/tool"},
+		{"/cgi/sub", "", ""},
+		{"/cgi/data.txt", "", ""},
+		{"/cgi/missing/run.sh", "", ""},
+		{"/cgi/" + uploadTempPrefix + "x", "", ""},
+		{"/cgi/out/evil", "", ""},
+		{"/cgi/lib/inside", "cgi/lib/inside", "/cgi/lib/inside"},
+	}
+
+	for _, c := range cases {
+		script, root := findScript("/cgi", c.p)
+
+		if len(c.script) > 0 {
+			c.script = filepath.Join(rootDir, filepath.FromSlash(c.script))
+		}
+
+		if script != c.script || root != c.root {
+			t.Errorf("%q: got %q, %q", c.p, script, root)
+		}
+	}
+}
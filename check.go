@@ -0,0 +1,281 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// "check" subcommand: bind to the advertised addresses, then connect back to them
+// from every local interface, reporting what is likely to be wrong.
+func checkCommand(args []string) int {
+	var itf string
+	var port uint
+
+	flags := gnuflag.NewFlagSet("check", gnuflag.ExitOnError)
+
+	flags.StringVar(&itf, "interface", "", "(required) Network interface, IP address, or host name to check.")
+	flags.StringVar(&itf, "i", "", "(required) Network interface, IP address, or host name to check.")
+
+	flags.UintVar(&port, "port", defaultPort, "Network port number to check.")
+	flags.UintVar(&port, "p", defaultPort, "Network port number to check.")
+	ipFamilyFlags(flags)
+
+	flags.Parse(true, args)
+
+	if port == 0 || port > 0xFFFF {
+		die("Invalid port number: "+uintToString(port), nil)
+	}
+
+	if len(itf) == 0 {
+		die("Network interface is not specified", nil)
+	}
+
+	// the same addresses as the server would bind to
+	ips := bindAddresses(itf)
+
+	if len(ips) == 0 {
+		die("Cannot find IP address of "+itf, nil)
+	}
+
+	// every address the server would bind to and advertise
+	ok := true
+
+	for _, ip := range ips {
+		ok = checkAddress(ip, port) && ok
+	}
+
+	if !ok {
+		return 1
+	}
+
+	return 0
+}
+
+// checkAddress binds to the address, then connects back to it, reporting the results
+func checkAddress(ip string, port uint) bool {
+	addr := net.JoinHostPort(ip, uintToString(port))
+
+	// bind
+	ln, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		report(false, "bind "+addr, bindHint(err))
+		return false
+	}
+
+	report(true, "bind "+addr, "")
+
+	// unique response, to make sure it is us who answers
+	token := randomToken()
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(resp http.ResponseWriter, _ *http.Request) {
+			resp.Write([]byte(token))
+		}),
+	}
+
+	go srv.Serve(ln)
+
+	defer srv.Close()
+
+	// connect back
+	ok := true
+
+	for _, src := range probeSources(ip) {
+		name := "connect " + addr
+
+		if src != nil {
+			name += " from " + src.String()
+		}
+
+		msg := probe(addr, src, token)
+		ok = ok && len(msg) == 0
+
+		report(len(msg) == 0, name, msg)
+	}
+
+	// address scope
+	if parsed := net.ParseIP(ip); parsed.IsLoopback() {
+		fmt.Println("NOTE", ip, "is a loopback address, only this machine can connect to it")
+	} else if parsed.IsLinkLocalUnicast() {
+		fmt.Println("NOTE", ip, "is a link-local address, it is only reachable from the same network segment")
+	} else if isPrivateIPv4(parsed) {
+		fmt.Println("NOTE", ip, "is a private address, it is not reachable from the Internet without port forwarding")
+	}
+
+	return ok
+}
+
+func report(ok bool, what, msg string) {
+	if ok {
+		fmt.Println("OK  ", what)
+	} else {
+		fmt.Println("FAIL", what+":", msg)
+	}
+}
+
+// probeSources returns the local addresses to connect from: nil for the default route,
+// plus the first address of every other active interface, of the same family as the target.
+func probeSources(ip string) []net.IP {
+	srcs := []net.IP{nil}
+	v4 := net.ParseIP(ip).To4() != nil
+	itfs, err := net.Interfaces()
+
+	if err != nil {
+		return srcs
+	}
+
+	for _, it := range itfs {
+		if it.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		addrs, err := it.Addrs()
+
+		if err != nil {
+			continue
+		}
+
+		for _, a := range addrs {
+			ipn, ok := a.(*net.IPNet)
+
+			// link-local IPv6 addresses need the zone, and are not much use anyway
+			if !ok || (ipn.IP.To4() != nil) != v4 || ipn.IP.IsLinkLocalUnicast() {
+				continue
+			}
+
+			if ipn.IP.String() != ip {
+				srcs = append(srcs, ipn.IP)
+			}
+
+			break
+		}
+	}
+
+	return srcs
+}
+
+// probe connects to the address from the given source and returns a diagnostic message on failure
+func probe(addr string, src net.IP, token string) string {
+	dialer := &net.Dialer{Timeout: 3 * time.Second}
+
+	if src != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: src}
+	}
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Proxy: nil, // we want a direct connection
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	resp, err := client.Get("http://" + addr + "/")
+
+	if err != nil {
+		return connectHint(err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return err.Error()
+	}
+
+	if string(body) != token {
+		return "a different server has answered; is there a proxy or a port forwarding rule in the way?"
+	}
+
+	return ""
+}
+
+func bindHint(err error) string {
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		return "the port is already in use, is another instance running?"
+	case errors.Is(err, syscall.EACCES):
+		return "permission denied, ports below 1024 usually require root privileges"
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		return "the address is not available on this machine"
+	default:
+		return err.Error()
+	}
+}
+
+func connectHint(err error) string {
+	var nerr net.Error
+
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused, probably rejected by a firewall"
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return "no route to the address"
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		return "cannot use this source address"
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return "timed out, packets are probably dropped by a firewall"
+	default:
+		return err.Error()
+	}
+}
+
+func isPrivateIPv4(ip net.IP) bool {
+	ip4 := ip.To4()
+
+	return ip4 != nil && (ip4[0] == 10 ||
+		(ip4[0] == 172 && ip4[1]&0xF0 == 16) ||
+		(ip4[0] == 192 && ip4[1] == 168))
+}
+
+func randomToken() string {
+	var buff [16]byte
+
+	if _, err := rand.Read(buff[:]); err != nil {
+		die("Cannot generate random token", err)
+	}
+
+	return hex.EncodeToString(buff[:])
+}
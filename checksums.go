@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// withChecksums serves "<dir>/?sha256sums|b3sums|xxh3sums[&recursive=1][&sig]" requests with a SHA256SUMS,
+// B3SUMS, or XXH3SUMS file for the directory, or its signature.
+func withChecksums(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		algo := checksumQuery(query)
+
+		if algo == nil || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		dir := resolvePath(req.URL.Path)
+
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() || isIgnored(dir, true) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		var files []manifestEntry
+		var err error
+
+		if query.Get("recursive") == "1" {
+			files, err = buildManifest(req.Context(), dir, algo)
+		} else {
+			files, err = dirManifest(dir, algo)
+		}
+
+		if err != nil {
+			log.Println(logTag(req), "Checksum error:", err)
+			http.Error(resp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data, name := checksumFile(files, algo), algo.sums
+
+		if _, ok := query["sig"]; ok {
+			if signer == nil {
+				http.NotFound(resp, req)
+				return
+			}
+
+			if data, err = signer.sign(data); err != nil {
+				log.Println(logTag(req), "Signing error:", err)
+				http.Error(resp, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			name += signer.ext()
+		}
+
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		resp.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		resp.Write(data)
+	})
+}
+
+// checksumQuery returns the algorithm of the checksum file requested, or nil
+func checksumQuery(query url.Values) *hashAlgo {
+	for _, algo := range hashList {
+		if _, ok := query[strings.ToLower(algo.sums)]; ok {
+			return algo
+		}
+	}
+
+	return nil
+}
+
+// dirManifest returns hashed entries for the files directly in the directory, sorted by name
+func dirManifest(dir string, algo *hashAlgo) ([]manifestEntry, error) {
+	file, err := os.Open(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := file.Readdirnames(-1)
+
+	file.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	var files []manifestEntry
+
+	for _, name := range names {
+		full := filepath.Join(dir, name)
+		info, err := os.Stat(full)
+
+		if err != nil || !info.Mode().IsRegular() || isIgnored(full, false) {
+			continue
+		}
+
+		sum, err := fileHash(algo, full, info)
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry := manifestEntry{Path: name, Size: info.Size(), Mtime: info.ModTime()}
+		*entry.sum(algo) = sum
+		files = append(files, entry)
+	}
+
+	return files, nil
+}
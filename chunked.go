@@ -0,0 +1,267 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Parallel chunked uploads: each chunk is sent as "PUT <file>?chunk=<n>[&sha256=<hex>]",
+// and then "POST <file>?commit[&chunks=<count>][&sha256=<hex>]" assembles the file. Instead of
+// sha256, the checksums can be given as blake3 or xxh3.
+
+// maximum number of chunks in one upload
+const maxChunks = 10000
+
+// chunked upload errors
+var (
+	errChunkIndex   = errors.New("invalid chunk number")
+	errChunkMissing = errors.New("missing chunks")
+	errChecksum     = errors.New("checksum mismatch")
+)
+
+// isCommit checks for the request to assemble uploaded chunks
+func isCommit(req *http.Request) bool {
+	_, ok := req.URL.Query()["commit"]
+
+	return ok && req.Method == http.MethodPost
+}
+
+func serveChunk(resp http.ResponseWriter, req *http.Request, target string, policy conflictPolicy) {
+	query := req.URL.Query()
+	index, err := strconv.Atoi(query.Get("chunk"))
+
+	if err != nil || index < 0 || index >= maxChunks {
+		err = errChunkIndex
+	} else {
+		var n int64
+
+		algo, sum := querySum(query)
+
+		if n, err = receiveChunk(resolvePath(target), index, algo, sum, policy, req.Body); err == nil {
+			log.Println(logTag(req), "Received chunk", index, "of", shortenURI(target), sizeString(n))
+			resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			resp.WriteHeader(http.StatusAccepted)
+			fmt.Fprintln(resp, target, "chunk", index, sizeString(n))
+			return
+		}
+	}
+
+	uploadFailed(resp, req, err)
+}
+
+func serveCommit(resp http.ResponseWriter, req *http.Request, target string, policy conflictPolicy) {
+	query := req.URL.Query()
+	count := 0
+
+	if s := query.Get("chunks"); len(s) > 0 {
+		var err error
+
+		if count, err = strconv.Atoi(s); err != nil || count <= 0 || count > maxChunks {
+			uploadFailed(resp, req, errChunkIndex)
+			return
+		}
+	}
+
+	algo, sum := querySum(query)
+	name, created, n, err := commitChunks(resolvePath(target), count, algo, sum, policy)
+
+	if !uploadFailed(resp, req, err) {
+		uploaded(resp, req, placedTarget(target, name), created, n)
+	}
+}
+
+// chunkDir returns the name of the directory for the chunks of the upload target
+func chunkDir(name string) string {
+	return filepath.Join(filepath.Dir(name), uploadTempPrefix+"chunks."+filepath.Base(name))
+}
+
+// receiveChunk stores one chunk of the upload target, verifying its checksum if given.
+func receiveChunk(name string, index int, algo *hashAlgo, sum string, policy conflictPolicy, src io.Reader) (n int64, err error) {
+	if _, err = prepareUpload(name, policy); err != nil {
+		return
+	}
+
+	dir := chunkDir(name)
+
+	if err = store.MkdirAll(dir); err != nil {
+		return
+	}
+
+	tmp, err := store.TempFile(dir, uploadTempPrefix)
+
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			store.Remove(tmp.Name())
+		}
+	}()
+
+	hash := algo.new()
+
+	if n, err = io.Copy(io.MultiWriter(tmp, hash), limitUpload(src)); err != nil {
+		return
+	}
+
+	if len(sum) > 0 && !strings.EqualFold(sum, hex.EncodeToString(hash.Sum(nil))) {
+		err = errChecksum
+		return
+	}
+
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	err = store.Rename(tmp.Name(), filepath.Join(dir, strconv.Itoa(index)))
+	return
+}
+
+// commitChunks assembles the upload target from its chunks, verifying the checksum if given.
+// With zero count all the chunks present are assembled. The target is placed as the conflict policy permits.
+func commitChunks(name string, count int, algo *hashAlgo, sum string, policy conflictPolicy) (placed string, created bool, n int64, err error) {
+	if created, err = prepareUpload(name, policy); err != nil {
+		return
+	}
+
+	dir := chunkDir(name)
+
+	partialUploads.Lock()
+
+	if partialUploads.names[dir] {
+		partialUploads.Unlock()
+		err = errUploadBusy
+		return
+	}
+
+	partialUploads.names[dir] = true
+	partialUploads.Unlock()
+
+	defer func() {
+		partialUploads.Lock()
+		delete(partialUploads.names, dir)
+		partialUploads.Unlock()
+	}()
+
+	if count == 0 {
+		list, _ := ioutil.ReadDir(dir)
+
+		for _, info := range list {
+			if !strings.HasPrefix(info.Name(), uploadTempPrefix) {
+				count++
+			}
+		}
+	}
+
+	if count == 0 {
+		err = errChunkMissing
+		return
+	}
+
+	for i := 0; i < count; i++ {
+		if _, err = store.Stat(filepath.Join(dir, strconv.Itoa(i))); err != nil {
+			if os.IsNotExist(err) {
+				err = errChunkMissing
+			}
+
+			return
+		}
+	}
+
+	// assemble
+	tmp, err := store.TempFile(filepath.Dir(name), uploadTempPrefix)
+
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			store.Remove(tmp.Name())
+		}
+	}()
+
+	hash := algo.new()
+	dst := io.MultiWriter(tmp, hash)
+
+	for i := 0; i < count; i++ {
+		var m int64
+
+		if m, err = appendFile(dst, filepath.Join(dir, strconv.Itoa(i))); err != nil {
+			return
+		}
+
+		if n += m; uploadTooLarge(n) {
+			err = errUploadTooLarge
+			return
+		}
+	}
+
+	if len(sum) > 0 && !strings.EqualFold(sum, hex.EncodeToString(hash.Sum(nil))) {
+		err = errChecksum
+		return
+	}
+
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	if placed, err = placeUpload(tmp.Name(), name, policy); err == nil {
+		created = created || placed != name
+		store.RemoveAll(dir)
+	}
+
+	return
+}
+
+func appendFile(dst io.Writer, name string) (int64, error) {
+	file, err := store.Open(name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	return io.Copy(dst, file)
+}
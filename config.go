@@ -0,0 +1,214 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// configuration file, and the named share from it
+var configFile, shareName string
+
+// options set from the configuration file
+var configured []gnuflag.Value
+
+func init() {
+	gnuflag.StringVar(&configFile, "config", "",
+		"Configuration file with \"option = value\" lines (default: web-share/config in the user configuration directory, if present).")
+	gnuflag.StringVar(&shareName, "share", "",
+		"Named share from the configuration file to run, with the options from its [name] section.")
+}
+
+// loadConfig sets the options from the configuration file, except those given on the command line.
+// The options of the named share, if any, are applied after the common ones.
+func loadConfig() {
+	name := configFile
+
+	if len(name) == 0 {
+		dir, err := os.UserConfigDir()
+
+		if name = filepath.Join(dir, "web-share", "config"); err != nil || !isFile(name) {
+			if len(shareName) > 0 {
+				die("Share "+strconv.Quote(shareName)+" requires a configuration file", nil)
+			}
+
+			return
+		}
+	}
+
+	options, err := readConfig(name)
+
+	if err != nil {
+		die("Invalid configuration file "+name, err)
+	}
+
+	// options given on the command line, including their aliases, like -i for --interface
+	var given []gnuflag.Value
+
+	gnuflag.Visit(func(f *gnuflag.Flag) { given = append(given, f.Value) })
+
+	if len(shareName) > 0 && !hasShare(options, shareName) {
+		die("Unknown share "+strconv.Quote(shareName)+", the shares in "+name+" are: "+
+			strings.Join(shareNames(options), ", "), nil)
+	}
+
+	// common options first, then those of the share
+	var selected []configOption
+
+	for _, share := range []string{"", shareName} {
+		for _, opt := range options {
+			if opt.share == share && len(opt.name) > 0 {
+				selected = append(selected, opt)
+			}
+		}
+
+		if len(shareName) == 0 {
+			break
+		}
+	}
+
+	for _, opt := range selected {
+		f := gnuflag.Lookup(opt.name)
+
+		if f == nil || opt.name == "config" || opt.name == "share" {
+			die("Invalid configuration file "+name, errors.New("line "+strconv.Itoa(opt.line)+
+				": unknown option "+strconv.Quote(opt.name)))
+		}
+
+		if valueGiven(given, f.Value) {
+			continue
+		}
+
+		if err = f.Value.Set(opt.value); err != nil {
+			die("Invalid configuration file "+name, errors.New("line "+strconv.Itoa(opt.line)+
+				": option "+opt.name+": "+err.Error()))
+		}
+
+		configured = append(configured, f.Value)
+	}
+}
+
+// option from the configuration file
+type configOption struct {
+	name, value string
+	share       string // empty for the common options
+	line        int
+}
+
+// readConfig parses the configuration file of "option = value" lines, where the option is the long
+// name of a command line option; boolean options may be given without a value. Blank lines and lines
+// starting with '#' are skipped. Lines after "[name]" belong to the named share, up to the next such line.
+func readConfig(name string) ([]configOption, error) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var options []configOption
+	var share string
+
+	scanner := bufio.NewScanner(file)
+
+	for n := 1; scanner.Scan(); n++ {
+		s := strings.TrimSpace(scanner.Text())
+
+		if len(s) == 0 || s[0] == '#' {
+			continue
+		}
+
+		if s[0] == '[' {
+			if share = strings.TrimSpace(strings.TrimSuffix(s[1:], "]")); len(share) == 0 || !strings.HasSuffix(s, "]") {
+				return nil, errors.New("line " + strconv.Itoa(n) + ": invalid share name")
+			}
+
+			// the share is known even if it has no options
+			options = append(options, configOption{share: share, line: n})
+			continue
+		}
+
+		opt := configOption{name: s, value: "true", share: share, line: n}
+
+		if i := strings.IndexByte(s, '='); i >= 0 {
+			opt.name, opt.value = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+		}
+
+		options = append(options, opt)
+	}
+
+	return options, scanner.Err()
+}
+
+// hasShare checks if the share is defined in the configuration
+func hasShare(options []configOption, share string) bool {
+	for _, opt := range options {
+		if opt.share == share {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shareNames returns the names of the shares defined in the configuration, in order
+func shareNames(options []configOption) (names []string) {
+	for _, opt := range options {
+		if len(opt.share) > 0 && (len(names) == 0 || names[len(names)-1] != opt.share) {
+			names = append(names, opt.share)
+		}
+	}
+
+	return
+}
+
+func isFile(name string) bool {
+	info, err := os.Stat(name)
+
+	return err == nil && info.Mode().IsRegular()
+}
+
+// valueGiven checks if the option value is among those set on the command line
+func valueGiven(given []gnuflag.Value, val gnuflag.Value) bool {
+	for _, v := range given {
+		if v == val {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// what to do with an upload to an existing file
+type conflictPolicy int
+
+const (
+	conflictOverwrite conflictPolicy = iota // replace the file
+	conflictReject                          // fail the upload
+	conflictRename                          // upload to "name (1).ext", "name (2).ext", etc.
+	conflictVersion                         // keep the old file as "name.~1~", "name.~2~", etc.
+)
+
+var conflictPolicies = map[string]conflictPolicy{
+	"overwrite": conflictOverwrite,
+	"reject":    conflictReject,
+	"rename":    conflictRename,
+	"version":   conflictVersion,
+}
+
+// request header overriding the conflict policy
+const uploadConflictHeader = "X-Upload-Conflict"
+
+// conflict policy for the share
+var uploadConflictSpec string
+
+func init() {
+	gnuflag.StringVar(&uploadConflictSpec, "upload-conflict", "overwrite",
+		"What to do with uploads to existing files: overwrite, reject, rename (to \"name (1).ext\"), or version (keeping the old file as \"name.~1~\"); clients may override it with "+
+			uploadConflictHeader+" header.")
+}
+
+var uploadConflict conflictPolicy
+
+// errUploadExists is returned when the upload target exists, and the policy does not allow replacing it
+var errUploadExists = errors.New("upload target already exists")
+
+// maximum number of alternative names tried by rename and version policies
+const maxConflictNames = 10000
+
+func setupUploadConflict() {
+	policy, ok := conflictPolicies[uploadConflictSpec]
+
+	if !ok {
+		die("Invalid upload conflict policy: "+uploadConflictSpec, nil)
+	}
+
+	if uploadConflict = policy; policy != conflictOverwrite && allowUpload {
+		log.Println("Upload conflict policy:", uploadConflictSpec)
+	}
+}
+
+// requestConflict returns the conflict policy for the upload request
+func requestConflict(req *http.Request) (conflictPolicy, bool) {
+	s := strings.ToLower(strings.TrimSpace(req.Header.Get(uploadConflictHeader)))
+
+	if len(s) == 0 {
+		return uploadConflict, true
+	}
+
+	policy, ok := conflictPolicies[s]
+	return policy, ok
+}
+
+// placeUpload gives the uploaded temporary file the target name according to the policy,
+// returning the resulting name
+func placeUpload(tmp, name string, policy conflictPolicy) (string, error) {
+	switch policy {
+	case conflictReject:
+		return name, moveNoReplace(tmp, name, false)
+
+	case conflictRename:
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		target := name
+
+		for i := 1; i <= maxConflictNames; i++ {
+			if err := moveNoReplace(tmp, target, false); err != errUploadExists {
+				return target, err
+			}
+
+			target = base + " (" + strconv.Itoa(i) + ")" + ext
+		}
+
+		return name, errUploadExists
+
+	case conflictVersion:
+		if _, err := keepVersion(name); err != nil {
+			return name, err
+		}
+	}
+
+	return name, store.Rename(tmp, name)
+}
+
+// keepVersion preserves the existing file under the first free name like "name.~1~",
+// returning the name, or an empty string if the file does not exist
+func keepVersion(name string) (string, error) {
+	if _, err := store.Lstat(name); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	for i := 1; i <= maxConflictNames; i++ {
+		version := name + ".~" + strconv.Itoa(i) + "~"
+
+		if err := moveNoReplace(name, version, true); err != errUploadExists {
+			return version, err
+		}
+	}
+
+	return "", errUploadExists
+}
+
+// moveNoReplace gives the file the new name, failing with errUploadExists if the name is taken.
+// With keep set the old name is kept as well, where the file system supports hard links.
+func moveNoReplace(src, dst string, keep bool) error {
+	err := store.Link(src, dst)
+
+	switch {
+	case err == nil:
+		if keep {
+			return nil
+		}
+
+		return store.Remove(src)
+	case os.IsExist(err):
+		return errUploadExists
+	}
+
+	// no hard links on this file system, so the check is not atomic
+	if _, err = store.Lstat(dst); err == nil {
+		return errUploadExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return store.Rename(src, dst)
+}
+
+// placedTarget returns the URL path of the upload as placed by the conflict policy
+func placedTarget(target, name string) string {
+	return path.Join(path.Dir(target), filepath.Base(name))
+}
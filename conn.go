@@ -0,0 +1,324 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// global traffic counters
+var (
+	totalSent   int64 // bytes sent to all clients
+	activeConns int64 // currently open connections
+)
+
+// listener producing connections that count transferred bytes
+type countingListener struct {
+	net.Listener
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	return &countingConn{Conn: conn, start: now, id: shortID(6), active: now.UnixNano()}, nil
+}
+
+type countingConn struct {
+	sent, received, requests int64 // must be the first fields for atomic access on 32-bit platforms
+	active                   int64 // time of the last transfer, in Unix nanoseconds
+	net.Conn
+	start time.Time
+	id    string // connection ID, the prefix of its request IDs
+
+	lock    sync.Mutex
+	request *transfer    // request in progress, if any
+	limiter *rateLimiter // per connection rate limit, if any
+}
+
+// request in progress on a connection
+type transfer struct {
+	id, method, path string
+	start            time.Time
+	sent             int64 // bytes sent in response, or the connection counter at the start of the request
+}
+
+// snapshot of an open connection
+type connInfo struct {
+	remote  string
+	start   time.Time
+	sent    int64
+	request *transfer // nil for idle connections
+	conn    *countingConn
+}
+
+// currently open connections
+var openConns = struct {
+	sync.Mutex
+	conns map[*countingConn]bool
+}{
+	conns: make(map[*countingConn]bool),
+}
+
+// context key for the connection of a request
+type connKey struct{}
+
+func trackConn(conn net.Conn, open bool) {
+	c, ok := conn.(*countingConn)
+
+	if !ok {
+		return
+	}
+
+	openConns.Lock()
+	defer openConns.Unlock()
+
+	if open {
+		openConns.conns[c] = true
+	} else {
+		delete(openConns.conns, c)
+	}
+}
+
+// connContext attaches the connection to the request context
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, conn)
+}
+
+// withTransfers keeps track of the requests in progress on each connection
+func withTransfers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		c, ok := req.Context().Value(connKey{}).(*countingConn)
+
+		if !ok {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		t := &transfer{
+			id:     requestID(req),
+			method: req.Method,
+			path:   req.URL.Path,
+			start:  time.Now(),
+			sent:   atomic.LoadInt64(&c.sent),
+		}
+
+		c.lock.Lock()
+		c.request = t
+		c.lock.Unlock()
+
+		defer func() {
+			c.lock.Lock()
+			c.request = nil
+			c.lock.Unlock()
+		}()
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// connections returns a snapshot of the currently open connections, oldest first
+func connections() []connInfo {
+	openConns.Lock()
+	defer openConns.Unlock()
+
+	res := make([]connInfo, 0, len(openConns.conns))
+
+	for c := range openConns.conns {
+		info := connInfo{
+			remote: c.RemoteAddr().String(),
+			start:  c.start,
+			sent:   atomic.LoadInt64(&c.sent),
+			conn:   c,
+		}
+
+		c.lock.Lock()
+
+		if c.request != nil {
+			t := *c.request
+			t.sent = info.sent - t.sent
+			info.request = &t
+		}
+
+		c.lock.Unlock()
+		res = append(res, info)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].start.Before(res[j].start) })
+	return res
+}
+
+func (c *countingConn) Read(data []byte) (int, error) {
+	n, err := c.Conn.Read(data)
+
+	if n > 0 {
+		atomic.AddInt64(&c.received, int64(n))
+		atomic.StoreInt64(&c.active, time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+func (c *countingConn) Write(data []byte) (int, error) {
+	n, err := c.Conn.Write(data)
+
+	c.countSent(int64(n))
+	return n, err
+}
+
+// size of the part of a file sent at once by sendfile(2), between updates of the counters
+const sendfileChunk = 4 << 20
+
+// ReadFrom keeps sendfile(2) working for the wrapped TCP connection, sending the file in chunks,
+// so that the progress of long transfers is visible
+func (c *countingConn) ReadFrom(src io.Reader) (n int64, err error) {
+	rf, ok := c.Conn.(io.ReaderFrom)
+
+	if !ok {
+		n, err = io.Copy(c.Conn, src)
+		c.countSent(n)
+		return
+	}
+
+	lr, ok := src.(*io.LimitedReader)
+
+	if !ok {
+		lr = &io.LimitedReader{R: src, N: math.MaxInt64}
+	}
+
+	for lr.N > 0 {
+		chunk := &io.LimitedReader{R: lr.R, N: sendfileChunk}
+
+		if chunk.N > lr.N {
+			chunk.N = lr.N
+		}
+
+		var m int64
+
+		m, err = rf.ReadFrom(chunk)
+		n += m
+		lr.N -= m
+		c.countSent(m)
+
+		// a short chunk means the end of the source
+		if err != nil || chunk.N > 0 {
+			break
+		}
+	}
+
+	return
+}
+
+func (c *countingConn) countSent(n int64) {
+	if n > 0 {
+		atomic.AddInt64(&c.sent, n)
+		atomic.AddInt64(&totalSent, n)
+		atomic.StoreInt64(&c.active, time.Now().UnixNano())
+	}
+}
+
+// stats returns a summary of the traffic on the connection
+func (c *countingConn) stats() string {
+	sent, received := atomic.LoadInt64(&c.sent), atomic.LoadInt64(&c.received)
+	elapsed := time.Since(c.start)
+
+	return "sent " + sizeString(sent) + ", received " + sizeString(received) +
+		" in " + elapsed.Round(time.Millisecond).String() +
+		" (" + sizeString(int64(float64(sent)/elapsed.Seconds())) + "/s)"
+}
+
+// response writer keeping track of the status code and the number of bytes sent
+type trackingWriter struct {
+	http.ResponseWriter
+	status int
+	sent   int64
+}
+
+func (w *trackingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *trackingWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+
+	w.sent += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the sendfile(2) optimisation of the underlying writer
+func (w *trackingWriter) ReadFrom(src io.Reader) (n int64, err error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, src)
+	}
+
+	w.sent += n
+	return
+}
+
+func (w *trackingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Status returns the response status code, defaulting to 200
+func (w *trackingWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+func init() {
+	adminMux.HandleFunc("/admin/connections", serveConnections)
+}
+
+// GET /admin/connections: the open connections with their requests in progress, oldest first
+func serveConnections(resp http.ResponseWriter, req *http.Request) {
+	type requestInfo struct {
+		ID     string    `json:"id"`
+		Method string    `json:"method"`
+		Path   string    `json:"path"`
+		Start  time.Time `json:"start"`
+		Sent   int64     `json:"sent"`
+	}
+
+	type connectionInfo struct {
+		ID      string       `json:"id"`
+		Remote  string       `json:"remote"`
+		Start   time.Time    `json:"start"`
+		Sent    int64        `json:"sent"`
+		Request *requestInfo `json:"request,omitempty"`
+	}
+
+	conns := connections()
+	res := make([]connectionInfo, 0, len(conns))
+
+	for _, c := range conns {
+		info := connectionInfo{ID: c.conn.id, Remote: c.remote, Start: c.start, Sent: c.sent}
+
+		if t := c.request; t != nil {
+			info.Request = &requestInfo{ID: t.id, Method: t.method, Path: t.path, Start: t.start, Sent: t.sent}
+		}
+
+		res = append(res, info)
+	}
+
+	writeJSON(resp, res)
+}
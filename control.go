@@ -0,0 +1,261 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/juju/gnuflag"
+	"github.com/maxim2266/mvr"
+)
+
+// control socket path
+var controlSocket string
+
+func init() {
+	gnuflag.StringVar(&controlSocket, "control-socket", "",
+		"Unix socket for \"ctl\" subcommand, \"none\" to disable (default: <port>.sock in private directory web-share in $XDG_RUNTIME_DIR, or web-share-<uid> in temporary directory).")
+}
+
+// handlers for the control socket requests, registered by the corresponding modules
+var controlMux = http.NewServeMux()
+
+// address the server is listening on, like "192.168.0.10:8080", the first of all the addresses
+var (
+	serverAddr  string
+	serverAddrs []string
+)
+
+// controlDir returns the directory of the default control sockets: web-share in $XDG_RUNTIME_DIR,
+// or web-share-<uid> in the temporary directory
+func controlDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); len(dir) > 0 {
+		return filepath.Join(dir, "web-share")
+	}
+
+	return filepath.Join(os.TempDir(), "web-share-"+strconv.Itoa(os.Getuid()))
+}
+
+// defaultControlSocket returns the default control socket path for the port
+func defaultControlSocket(port uint) string {
+	return filepath.Join(controlDir(), uintToString(port)+".sock")
+}
+
+// makeControlDir creates the directory of the default control sockets, refusing to use it
+// if it belongs to another user, or is open to the others
+func makeControlDir() error {
+	dir := controlDir()
+
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	fi, err := os.Lstat(dir)
+
+	if err != nil {
+		return err
+	}
+
+	if !privateDir(fi) {
+		return errors.New("not a private directory: " + dir)
+	}
+
+	return nil
+}
+
+// startControl starts serving the control socket
+func startControl(port uint) {
+	if controlSocket == "none" {
+		return
+	}
+
+	name := controlSocket
+
+	if len(name) == 0 {
+		if err := makeControlDir(); err != nil {
+			die("Cannot create control socket directory", err)
+		}
+
+		name = defaultControlSocket(port)
+	}
+
+	// remove stale socket, but only our own
+	if conn, err := net.Dial("unix", name); err == nil {
+		conn.Close()
+		die("Control socket is in use: "+name, nil)
+	}
+
+	if fi, err := os.Lstat(name); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 || !ownedByUser(fi) {
+			die("Control socket path is taken by another file: "+name, nil)
+		}
+
+		os.Remove(name)
+	}
+
+	ln, err := listenUnix(name)
+
+	if err != nil {
+		die("Cannot create control socket", err)
+	}
+
+	if err = os.Chmod(name, 0600); err != nil {
+		ln.Close()
+		die("Cannot set control socket permissions", err)
+	}
+
+	log.Println("Control socket:", name)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			log.Println("ctl", req.Method, req.URL.Path)
+			controlMux.ServeHTTP(resp, req)
+		}),
+	}
+
+	mvr.OnCancel(0, func(ctx context.Context) {
+		srv.Shutdown(ctx)
+	})
+
+	go srv.Serve(ln)
+}
+
+// ctl subcommands
+var ctlCommands = map[string]func(client *ctlClient, args []string) int{
+	"link": ctlLinkCommand,
+}
+
+// "ctl" subcommand: send a command to the running server via its control socket
+func ctlCommand(args []string) int {
+	var socket string
+	var port uint
+
+	flags := gnuflag.NewFlagSet("ctl", gnuflag.ExitOnError)
+
+	flags.StringVar(&socket, "socket", "", "Control socket of the server (default: derived from the port).")
+	flags.UintVar(&port, "port", defaultPort, "Port number of the server.")
+	flags.UintVar(&port, "p", defaultPort, "Port number of the server.")
+
+	flags.Parse(false, args)
+
+	if flags.NArg() == 0 {
+		die("Usage: "+os.Args[0]+" ctl [options] <command> [command options]", nil)
+	}
+
+	cmd, ok := ctlCommands[flags.Arg(0)]
+
+	if !ok {
+		die("Unknown ctl command: "+flags.Arg(0), nil)
+	}
+
+	if len(socket) == 0 {
+		socket = defaultControlSocket(port)
+	}
+
+	return cmd(newCtlClient(socket), flags.Args()[1:])
+}
+
+// HTTP client talking to the control socket
+type ctlClient struct {
+	http.Client
+}
+
+func newCtlClient(socket string) *ctlClient {
+	return &ctlClient{
+		http.Client{
+			Timeout: time.Minute,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// call posts the request as JSON to the control endpoint, and decodes the JSON result
+func (c *ctlClient) call(endpoint string, request, result interface{}) error {
+	body, err := json.Marshal(request)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Post("http://ctl"+endpoint, "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+
+		return errors.New(strconv.Itoa(resp.StatusCode) + ": " + string(bytes.TrimSpace(msg)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// readControlRequest decodes JSON request body
+func readControlRequest(resp http.ResponseWriter, req *http.Request, val interface{}) bool {
+	if req.Method != http.MethodPost {
+		resp.Header().Set("Allow", "POST")
+		http.Error(resp, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(resp, req.Body, 1<<20)).Decode(val); err != nil {
+		http.Error(resp, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// printCtlError reports a failed control request
+func printCtlError(err error) int {
+	fmt.Fprintln(os.Stderr, "ERROR:", err)
+	return 1
+}
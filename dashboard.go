@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+// administrative dashboard at /admin/
+func init() {
+	adminMux.HandleFunc("/admin/", serveDashboard)
+}
+
+var startTime = time.Now()
+
+func serveDashboard(resp http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/admin/" {
+		apiError(resp, req, http.StatusNotFound, "Not found")
+		return
+	}
+
+	files, _ := topFiles(20, "downloads")
+	clients, agents := clientStatsSnapshot()
+	disk, diskKnown := checkDiskSpace()
+
+	data := struct {
+		Uptime   time.Duration
+		Files    []fileStats
+		Clients  []clientStats
+		Agents   []agentStats
+		Sessions []session
+		Login    bool
+		Disk     *diskUsage
+	}{
+		Uptime:   time.Since(startTime).Round(time.Second),
+		Files:    files,
+		Clients:  clients,
+		Agents:   agents,
+		Sessions: sessionList(),
+		Login:    loginForm,
+	}
+
+	if diskKnown {
+		data.Disk = &disk
+	}
+
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := dashboardTemplate.Execute(resp, &data); err != nil {
+		log.Println("Template error:", err)
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"size": sizeString,
+	"time": func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Dashboard</title>
+<style>
+body{font-family:sans-serif;margin:2em;color:#333}
+table{border-collapse:collapse;margin-bottom:2em}
+th,td{padding:.3em .8em;border-bottom:1px solid #ddd;text-align:left}
+td.n{text-align:right}
+.warning{color:#b00;font-weight:bold}
+</style>
+</head>
+<body>
+<h1>Dashboard</h1>
+<p>Up for {{.Uptime}}.</p>
+{{with .Disk}}<p{{if .Low}} class="warning"{{end}}>{{if .Low}}Low disk space: {{end}}{{size .Free}} free of {{size .Total}}.</p>
+{{end}}
+<h2>Top files</h2>
+<table>
+<tr><th>Path</th><th>Downloads</th><th>Sent</th><th>Clients</th><th>Last</th></tr>
+{{range .Files}}<tr><td>{{.Path}}</td><td class="n">{{.Downloads}}</td><td class="n">{{size .Bytes}}</td><td class="n">{{.Clients}}</td><td>{{time .Last}}</td></tr>
+{{else}}<tr><td colspan="5">No downloads yet.</td></tr>
+{{end}}</table>
+
+<h2>Clients</h2>
+<table>
+<tr><th>Address</th><th>Requests</th><th>Sent</th><th>User agents</th><th>Last</th></tr>
+{{range .Clients}}<tr><td>{{.Address}}</td><td class="n">{{.Requests}}</td><td class="n">{{size .Bytes}}</td><td>{{range .UserAgents}}{{.}}<br>{{end}}</td><td>{{time .Last}}</td></tr>
+{{else}}<tr><td colspan="5">No clients yet.</td></tr>
+{{end}}</table>
+
+<h2>User agents</h2>
+<table>
+<tr><th>User agent</th><th>Kind</th><th>Requests</th><th>Sent</th><th>Clients</th></tr>
+{{range .Agents}}<tr><td>{{or .UserAgent "(none)"}}</td><td>{{.Kind}}</td><td class="n">{{.Requests}}</td><td class="n">{{size .Bytes}}</td><td class="n">{{.Clients}}</td></tr>
+{{else}}<tr><td colspan="5">No clients yet.</td></tr>
+{{end}}</table>
+{{if .Login}}
+<h2>Sessions</h2>
+<table>
+<tr><th>User</th><th>Device</th><th>Address</th><th>Started</th><th>Last active</th><th></th></tr>
+{{range .Sessions}}<tr><td>{{.User}}</td><td title="{{.UserAgent}}">{{.Device}}</td><td>{{.IP}}</td><td>{{time .Started}}</td><td>{{time .LastSeen}}</td>
+<td><form method="post" action="/admin/sessions/{{.ID}}"><input type="submit" value="Revoke"></form></td></tr>
+{{else}}<tr><td colspan="6">No sessions.</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,276 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// trash directory for the deleted files
+var trashDir string
+
+func init() {
+	gnuflag.StringVar(&trashDir, "trash", "",
+		"Directory to move the files deleted via the API to, \"none\" to delete them for good (default: "+trashDirName+" in the root directory).")
+
+	apiMux.HandleFunc("/api/delete/", serveDelete)
+}
+
+// name of the default trash directory under the root, never served
+const trashDirName = ".webshare-trash"
+
+// lifetime of a delete confirmation token
+const deleteTokenTTL = 5 * time.Minute
+
+// maximum number of the files listed by a dry run
+const maxDeleteListing = 1000
+
+// pending deletions, by confirmation token
+var deletions = struct {
+	sync.Mutex
+	tokens map[string]pendingDelete
+}{
+	tokens: make(map[string]pendingDelete),
+}
+
+type pendingDelete struct {
+	name    string // file system path
+	state   string // fingerprint of the subtree at the dry run
+	expires time.Time
+}
+
+// summary of the subtree to be deleted
+type deleteTree struct {
+	Files  []listEntry `json:"files"` // visible files, with the paths relative to the target
+	More   int         `json:"more,omitempty"`
+	Dirs   int         `json:"dirs"`
+	Hidden int         `json:"hidden,omitempty"` // ignored and special entries
+	Size   int64       `json:"size"`
+	state  string
+}
+
+// GET /api/delete/<path>: dry run, listing what would be removed, with a confirmation token;
+// DELETE /api/delete/<path>?token=<token>: moves the file or directory to the trash, provided
+// the subtree has not changed since the dry run.
+func serveDelete(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodDelete {
+		resp.Header().Set("Allow", "GET, DELETE")
+		apiError(resp, req, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if !allowManage {
+		apiError(resp, req, http.StatusForbidden, "Deletion is not allowed")
+		return
+	}
+
+	name := apiTarget(req, "/api/delete")
+
+	info, err := store.Lstat(name)
+
+	if err != nil || name == rootDir || isIgnored(name, info.IsDir()) {
+		apiError(resp, req, http.StatusNotFound, "Not found")
+		return
+	}
+
+	if err = checkInsideRoot(filepath.Dir(name)); err != nil {
+		apiError(resp, req, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	tree, err := scanDeleteTree(name, info)
+
+	if err != nil {
+		log.Println(logTag(req), "Delete error:", err)
+		apiError(resp, req, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		token := randomToken()
+		expires := time.Now().Add(deleteTokenTTL)
+
+		deletions.Lock()
+
+		for t, d := range deletions.tokens {
+			if time.Now().After(d.expires) {
+				delete(deletions.tokens, t)
+			}
+		}
+
+		deletions.tokens[token] = pendingDelete{name: name, state: tree.state, expires: expires}
+		deletions.Unlock()
+
+		writeJSON(resp, struct {
+			*deleteTree
+			Token   string    `json:"token"`
+			Expires time.Time `json:"expires"`
+		}{&tree, token, expires})
+
+		return
+	}
+
+	// confirmed deletion
+	token := req.URL.Query().Get("token")
+
+	deletions.Lock()
+	pending, ok := deletions.tokens[token]
+	delete(deletions.tokens, token)
+	deletions.Unlock()
+
+	if !ok || pending.name != name || time.Now().After(pending.expires) {
+		apiError(resp, req, http.StatusForbidden, "Missing or invalid confirmation token, see the dry run with GET")
+		return
+	}
+
+	if pending.state != tree.state {
+		apiError(resp, req, http.StatusConflict, "The content has changed since the dry run")
+		return
+	}
+
+	// the path may have been replaced since the dry run
+	if err = checkInsideRoot(filepath.Dir(name)); err != nil {
+		apiError(resp, req, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	trashed, err := removeTree(name)
+
+	if err != nil {
+		log.Println(logTag(req), "Delete error:", err)
+		apiError(resp, req, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	log.Println(logTag(req), "Deleted", shortenURI(strings.TrimPrefix(req.URL.Path, "/api/delete")), "("+strconv.Itoa(len(tree.Files)+tree.More)+" files, "+
+		sizeString(tree.Size)+")")
+
+	writeJSON(resp, struct {
+		Files   int   `json:"files"`
+		Size    int64 `json:"size"`
+		Trashed bool  `json:"trashed"`
+	}{len(tree.Files) + tree.More, tree.Size, trashed})
+}
+
+// scanDeleteTree lists the subtree, without following symlinks, computing its fingerprint
+func scanDeleteTree(name string, info os.FileInfo) (deleteTree, error) {
+	tree := deleteTree{Files: []listEntry{}}
+	hash := sha256.New()
+
+	var scan func(name, rel string, info os.FileInfo) error
+
+	scan = func(name, rel string, info os.FileInfo) error {
+		hash.Write([]byte(rel + "\x00" + strconv.FormatInt(info.Size(), 10) + "\x00" +
+			strconv.FormatInt(info.ModTime().UnixNano(), 10) + "\x00" + info.Mode().String() + "\n"))
+
+		switch {
+		case info.IsDir():
+			tree.Dirs++
+
+			list, err := store.ReadDir(name)
+
+			if err != nil {
+				return err
+			}
+
+			sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+
+			for _, entry := range list {
+				if err = scan(filepath.Join(name, entry.Name()), path.Join(rel, entry.Name()), entry); err != nil {
+					return err
+				}
+			}
+		case isSpecial(info) || isIgnored(name, false):
+			tree.Hidden++
+		default:
+			tree.Size += info.Size()
+
+			if len(tree.Files) < maxDeleteListing {
+				tree.Files = append(tree.Files, listEntry{Name: rel, Size: info.Size(), Mtime: info.ModTime()})
+			} else {
+				tree.More++
+			}
+		}
+
+		return nil
+	}
+
+	// a single file is listed by its name
+	rel := ""
+
+	if !info.IsDir() {
+		rel = info.Name()
+	}
+
+	err := scan(name, rel, info)
+
+	tree.state = hex.EncodeToString(hash.Sum(nil))
+	return tree, err
+}
+
+// removeTree moves the file or directory to the trash, or deletes it if there is no trash,
+// returning true if the file has been moved
+func removeTree(name string) (bool, error) {
+	if trashDir == "none" {
+		return false, store.RemoveAll(name)
+	}
+
+	dir := trashDir
+
+	if len(dir) == 0 {
+		dir = filepath.Join(rootDir, trashDirName)
+	}
+
+	rel, err := filepath.Rel(rootDir, name)
+
+	if err != nil {
+		return false, err
+	}
+
+	dst := filepath.Join(dir, time.Now().Format("2006-01-02T15-04-05.000"), rel)
+
+	if err = store.MkdirAll(filepath.Dir(dst)); err != nil {
+		return false, err
+	}
+
+	return true, store.Rename(name, dst)
+}
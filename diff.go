@@ -0,0 +1,192 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifest entry, as sent by the client and returned by the server
+type manifestEntry struct {
+	Path   string    `json:"path"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256,omitempty"`
+	BLAKE3 string    `json:"blake3,omitempty"`
+	XXH3   string    `json:"xxh3,omitempty"`
+	Mtime  time.Time `json:"mtime,omitempty"`
+}
+
+// sum returns the field for the hash of the given algorithm
+func (e *manifestEntry) sum(algo *hashAlgo) *string {
+	switch algo {
+	case blake3Hash:
+		return &e.BLAKE3
+	case xxh3Hash:
+		return &e.XXH3
+	default:
+		return &e.SHA256
+	}
+}
+
+// hashAlgo returns the algorithm of the hash given in the entry, or nil
+func (e *manifestEntry) hashAlgo() *hashAlgo {
+	for _, algo := range hashList {
+		if len(*e.sum(algo)) > 0 {
+			return algo
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	apiMux.HandleFunc("/api/diff", serveDiff)
+	apiMux.HandleFunc("/api/diff/", serveDiff)
+}
+
+// maximum size of the client manifest
+const maxManifestSize = 64 << 20
+
+// GET /api/diff[/<path>]?manifest=<json>, or POST /api/diff[/<path>] with the manifest in the body.
+// The manifest is a JSON array of entries with "path", "size", and optional "sha256", "blake3", or "xxh3" fields;
+// the response lists entries added, changed, or removed on the server relative to the manifest.
+func serveDiff(resp http.ResponseWriter, req *http.Request) {
+	var data []byte
+
+	switch req.Method {
+	case http.MethodGet:
+		data = []byte(req.URL.Query().Get("manifest"))
+	case http.MethodPost:
+		var err error
+
+		if data, err = ioutil.ReadAll(http.MaxBytesReader(resp, req.Body, maxManifestSize)); err != nil {
+			apiError(resp, req, http.StatusBadRequest, "Cannot read manifest")
+			return
+		}
+	default:
+		apiError(resp, req, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var manifest []manifestEntry
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			apiError(resp, req, http.StatusBadRequest, "Invalid manifest: "+err.Error())
+			return
+		}
+	}
+
+	client := make(map[string]*manifestEntry, len(manifest))
+
+	for i := range manifest {
+		client[strings.TrimPrefix(manifest[i].Path, "/")] = &manifest[i]
+	}
+
+	// compare
+	var result struct {
+		Added   []manifestEntry `json:"added"`
+		Changed []manifestEntry `json:"changed"`
+		Removed []string        `json:"removed"`
+	}
+
+	result.Added, result.Changed, result.Removed = []manifestEntry{}, []manifestEntry{}, []string{}
+
+	dir := apiTarget(req, "/api/diff")
+
+	var lock sync.Mutex
+
+	err := walkFiles(req.Context(), dir, func(name, rel string, info os.FileInfo) error {
+		entry := manifestEntry{Path: rel, Size: info.Size(), Mtime: info.ModTime()}
+
+		lock.Lock()
+		ce := client[rel]
+		delete(client, rel)
+		lock.Unlock()
+
+		if ce == nil {
+			lock.Lock()
+			result.Added = append(result.Added, entry)
+			lock.Unlock()
+			return nil
+		}
+
+		algo := ce.hashAlgo()
+
+		if ce.Size == entry.Size && algo == nil {
+			return nil
+		}
+
+		if ce.Size == entry.Size {
+			sum, err := fileHash(algo, name, info)
+
+			if err != nil {
+				return err
+			}
+
+			if *entry.sum(algo) = sum; strings.EqualFold(sum, *ce.sum(algo)) {
+				return nil
+			}
+		}
+
+		lock.Lock()
+		result.Changed = append(result.Changed, entry)
+		lock.Unlock()
+		return nil
+	})
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			apiError(resp, req, http.StatusNotFound, "Not found")
+		} else {
+			log.Println(logTag(req), "Diff error:", err)
+			apiError(resp, req, http.StatusInternalServerError, "Internal server error")
+		}
+
+		return
+	}
+
+	for rel := range client {
+		result.Removed = append(result.Removed, rel)
+	}
+
+	sort.Slice(result.Added, func(i, j int) bool { return result.Added[i].Path < result.Added[j].Path })
+	sort.Slice(result.Changed, func(i, j int) bool { return result.Changed[i].Path < result.Changed[j].Path })
+	sort.Strings(result.Removed)
+
+	writeJSON(resp, &result)
+}
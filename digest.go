@@ -0,0 +1,305 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// htdigest(1) password file
+var digestFile string
+
+func init() {
+	gnuflag.StringVar(&digestFile, "digest-auth", "",
+		"Require HTTP Digest authentication with the users from the given htdigest(1) file, for the realm given by --realm.")
+}
+
+// lifetime of a nonce; after that the client is asked to retry with a fresh one
+const digestNonceTTL = 10 * time.Minute
+
+// Digest authentication state
+var digest struct {
+	users  map[string]string // user name -> hex MD5 of "user:realm:password"
+	key    []byte            // nonce signing key
+	opaque string
+
+	lock sync.Mutex
+	seen map[string]map[string]bool // nonce -> nonce counts already used
+}
+
+// setupDigest enables Digest authentication (RFC 7616, MD5 with "auth" protection, or RFC 2069
+// for the older clients)
+func setupDigest() {
+	if len(digestFile) == 0 {
+		return
+	}
+
+	users, err := readDigestFile(digestFile, authRealm)
+
+	if err != nil {
+		die("Cannot read digest password file", err)
+	}
+
+	if len(users) == 0 {
+		die("No users for realm \""+authRealm+"\" in "+digestFile, nil)
+	}
+
+	digest.users = users
+	digest.key = make([]byte, 32)
+	digest.seen = make(map[string]map[string]bool)
+
+	if _, err = rand.Read(digest.key); err != nil {
+		die("Cannot generate nonce key", err)
+	}
+
+	digest.opaque = randomToken()
+
+	authSchemes = append(authSchemes, authScheme{
+		name:      "Digest",
+		verify:    verifyDigest,
+		challenge: digestChallenge,
+	})
+
+	passwordCheckers = append(passwordCheckers, checkDigestPassword)
+
+	log.Println("Digest authentication enabled for", len(users), "user(s)")
+}
+
+// readDigestFile reads "user:realm:hash" lines for the given realm
+func readDigestFile(name, realm string) (map[string]string, error) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	users := make(map[string]string)
+	src := bufio.NewScanner(file)
+
+	for src.Scan() {
+		line := strings.TrimSpace(src.Text())
+
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+
+		if len(fields) != 3 || len(fields[2]) != 32 {
+			return nil, &os.PathError{Op: "parse", Path: name, Err: errAuthInvalid}
+		}
+
+		if fields[1] == realm {
+			users[fields[0]] = strings.ToLower(fields[2])
+		}
+	}
+
+	return users, src.Err()
+}
+
+func digestChallenge(err error) string {
+	s := "Digest realm=" + quoteAuth(authRealm) + `, qop="auth", algorithm=MD5, nonce="` +
+		newNonce() + `", opaque="` + digest.opaque + `"`
+
+	if err == errAuthStale {
+		s += ", stale=true"
+	}
+
+	return s
+}
+
+func verifyDigest(req *http.Request, credentials string) (string, error) {
+	params, err := parseAuthParams(credentials)
+
+	if err != nil {
+		return "", err
+	}
+
+	user := params["username"]
+	ha1, ok := digest.users[user]
+
+	if !ok {
+		return "", errAuthUser
+	}
+
+	// the one-time code can only be given in the login form
+	if hasTOTP(user) {
+		return "", errTOTPRequired
+	}
+
+	if params["realm"] != authRealm || params["uri"] != req.RequestURI ||
+		(len(params["algorithm"]) > 0 && !strings.EqualFold(params["algorithm"], "MD5")) {
+		return "", errAuthInvalid
+	}
+
+	nonce := params["nonce"]
+
+	if err = checkNonce(nonce); err != nil {
+		return "", err
+	}
+
+	ha2 := md5Hex(req.Method + ":" + params["uri"])
+
+	var expected string
+
+	switch qop := params["qop"]; qop {
+	case "auth":
+		nc, cnonce := params["nc"], params["cnonce"]
+
+		if len(nc) != 8 || len(cnonce) == 0 {
+			return "", errAuthInvalid
+		}
+
+		expected = md5Hex(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(params["response"]))) != 1 {
+			return "", errAuthPassword
+		}
+
+		// replayed request
+		if !useNonceCount(nonce, nc) {
+			return "", errAuthStale
+		}
+
+		return user, nil
+	case "":
+		expected = md5Hex(ha1 + ":" + nonce + ":" + ha2)
+	default:
+		return "", errAuthInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(params["response"]))) != 1 {
+		return "", errAuthPassword
+	}
+
+	return user, nil
+}
+
+// checkDigestPassword verifies the password from the login form
+func checkDigestPassword(user, password string) error {
+	ha1, ok := digest.users[user]
+
+	if !ok {
+		return errAuthUser
+	}
+
+	if subtle.ConstantTimeCompare([]byte(md5Hex(user+":"+authRealm+":"+password)), []byte(ha1)) != 1 {
+		return errAuthPassword
+	}
+
+	return nil
+}
+
+// newNonce returns issue time of the nonce with its signature
+func newNonce() string {
+	buf := make([]byte, 8, 8+sha256.Size)
+
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().UnixNano()))
+
+	mac := hmac.New(sha256.New, digest.key)
+	mac.Write(buf)
+
+	// forget the expired nonces
+	digest.lock.Lock()
+
+	for nonce := range digest.seen {
+		if checkNonce(nonce) != nil {
+			delete(digest.seen, nonce)
+		}
+	}
+
+	digest.lock.Unlock()
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(buf))
+}
+
+// checkNonce verifies the nonce was issued by this server, and has not expired
+func checkNonce(nonce string) error {
+	buf, err := base64.RawURLEncoding.DecodeString(nonce)
+
+	if err != nil || len(buf) != 8+sha256.Size {
+		return errAuthInvalid
+	}
+
+	mac := hmac.New(sha256.New, digest.key)
+	mac.Write(buf[:8])
+
+	if !hmac.Equal(mac.Sum(nil), buf[8:]) {
+		return errAuthInvalid
+	}
+
+	if time.Since(time.Unix(0, int64(binary.BigEndian.Uint64(buf)))) > digestNonceTTL {
+		return errAuthStale
+	}
+
+	return nil
+}
+
+// useNonceCount records the nonce count, returning false if it has already been used
+func useNonceCount(nonce, nc string) bool {
+	digest.lock.Lock()
+	defer digest.lock.Unlock()
+
+	counts := digest.seen[nonce]
+
+	if counts == nil {
+		counts = make(map[string]bool)
+		digest.seen[nonce] = counts
+	}
+
+	if counts[nc] {
+		return false
+	}
+
+	counts[nc] = true
+	return true
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
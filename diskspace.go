@@ -0,0 +1,146 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"syscall"
+
+	"github.com/juju/gnuflag"
+)
+
+// low disk space threshold
+var minFreeSpec string
+
+func init() {
+	gnuflag.StringVar(&minFreeSpec, "min-free-space", "1G",
+		"Warn on the dashboard and in the log when the free space on the disk of the shared directory drops below the given size (e.g. 500M); 0 disables the warning.")
+}
+
+var minFreeSpace int64
+
+// setupDiskSpace validates the low disk space threshold
+func setupDiskSpace() {
+	var err error
+
+	if minFreeSpace, err = parseByteSize(minFreeSpec); err != nil {
+		die("Invalid minimum free space: "+minFreeSpec, err)
+	}
+}
+
+// disk space of the shared directory, as shown on the dashboard
+type diskUsage struct {
+	Free, Total int64
+	Low         bool
+}
+
+// whether the low disk space warning has been logged
+var diskWarning struct {
+	sync.Mutex
+	low bool
+}
+
+// checkDiskSpace returns the disk space of the shared directory, logging a warning when the free space
+// drops below the threshold, and a note when it recovers. The second value is false where the disk
+// space cannot be determined.
+func checkDiskSpace() (diskUsage, bool) {
+	free, total, err := diskSpace(rootDir)
+
+	if err != nil {
+		return diskUsage{}, false
+	}
+
+	usage := diskUsage{Free: free, Total: total, Low: free < minFreeSpace}
+
+	diskWarning.Lock()
+	defer diskWarning.Unlock()
+
+	if usage.Low != diskWarning.low {
+		if diskWarning.low = usage.Low; usage.Low {
+			log.Println("Warning: low disk space:", sizeString(free), "free of", sizeString(total))
+		} else {
+			log.Println("Disk space recovered:", sizeString(free), "free of", sizeString(total))
+		}
+	}
+
+	return usage, true
+}
+
+// isNoSpace returns true if the error is caused by a full disk or an exceeded quota
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// insufficientStorage responds with 507 and a JSON body describing the error
+func insufficientStorage(resp http.ResponseWriter, req *http.Request, err error) {
+	if err != nil {
+		log.Println(logTag(req), "Upload failed:", err)
+	}
+
+	body := struct {
+		Error     string `json:"error"`
+		Free      *int64 `json:"free,omitempty"`
+		RequestID string `json:"request_id,omitempty"`
+	}{
+		Error:     "Insufficient storage",
+		RequestID: requestID(req),
+	}
+
+	if usage, ok := checkDiskSpace(); ok {
+		body.Free = &usage.Free
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	resp.WriteHeader(http.StatusInsufficientStorage)
+
+	if err := json.NewEncoder(resp).Encode(&body); err != nil {
+		log.Println("JSON encoding error:", err)
+	}
+}
+
+// uploadFits returns false if the request body is known to be larger than the free disk space
+func uploadFits(req *http.Request) bool {
+	if req.ContentLength <= 0 {
+		return true
+	}
+
+	free, _, err := diskSpace(resolvePath(req.URL.Path))
+
+	if err != nil {
+		// the target directory may not exist yet
+		free, _, err = diskSpace(rootDir)
+	}
+
+	return err != nil || req.ContentLength <= free
+}
@@ -0,0 +1,277 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// cache of file hashes, invalidated by file size and modification time
+var hashCache = struct {
+	sync.Mutex
+	entries map[hashKey]hashEntry
+}{
+	entries: make(map[hashKey]hashEntry),
+}
+
+type hashKey struct {
+	name string
+	algo *hashAlgo
+}
+
+type hashEntry struct {
+	size  int64
+	mtime time.Time
+	sum   string
+}
+
+// fileHash returns hex-encoded hash of the given file
+func fileHash(algo *hashAlgo, name string, info os.FileInfo) (string, error) {
+	key := hashKey{name, algo}
+
+	hashCache.Lock()
+	entry, ok := hashCache.entries[key]
+	hashCache.Unlock()
+
+	if ok && entry.size == info.Size() && entry.mtime.Equal(info.ModTime()) {
+		return entry.sum, nil
+	}
+
+	file, err := store.Open(name)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	h := algo.new()
+
+	if _, err = io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	entry = hashEntry{size: info.Size(), mtime: info.ModTime(), sum: hex.EncodeToString(h.Sum(nil))}
+
+	hashCache.Lock()
+	hashCache.entries[key] = entry
+	hashCache.Unlock()
+
+	return entry.sum, nil
+}
+
+// number of workers for recursive operations
+var walkWorkers uint
+
+func init() {
+	gnuflag.UintVar(&walkWorkers, "workers", uint(runtime.NumCPU()),
+		"Number of parallel workers for recursive operations like manifest generation.")
+}
+
+// walkFiles calls the function for every regular file under the directory, including symlinked ones,
+// with the file path relative to the directory, using forward slashes. Ignored files are skipped,
+// and symlinked directories are not followed. Directories are read, and the function is called,
+// by a bounded pool of workers, so the function must be safe for concurrent use. The walk stops
+// at the first error, or when the context is cancelled.
+func walkFiles(ctx context.Context, dir string, fn func(name, rel string, info os.FileInfo) error) error {
+	info, err := store.Stat(dir)
+
+	if err != nil {
+		return err
+	}
+
+	if isIgnored(dir, info.IsDir()) {
+		return &os.PathError{Op: "walk", Path: dir, Err: os.ErrNotExist}
+	}
+
+	// the walk started from a file
+	if !info.IsDir() {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		return fn(dir, filepath.Base(dir), info)
+	}
+
+	w := &walker{root: dir, fn: fn, queue: []string{dir}, pending: 1}
+	w.cond.L = &w.lock
+
+	// cancellation
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.fail(ctx.Err())
+		case <-stop:
+		}
+	}()
+
+	// workers
+	n := int(walkWorkers)
+
+	if n < 1 {
+		n = 1
+	}
+
+	var wg sync.WaitGroup
+
+	wg.Add(n)
+
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			w.run()
+		}()
+	}
+
+	wg.Wait()
+	return w.err
+}
+
+// state of a parallel walk
+type walker struct {
+	root string
+	fn   func(name, rel string, info os.FileInfo) error
+
+	lock    sync.Mutex
+	cond    sync.Cond
+	queue   []string // directories to read
+	pending int      // directories queued or being read
+	err     error    // first error
+}
+
+func (w *walker) run() {
+	for {
+		w.lock.Lock()
+
+		for len(w.queue) == 0 && w.pending > 0 && w.err == nil {
+			w.cond.Wait()
+		}
+
+		if w.pending == 0 || w.err != nil {
+			w.lock.Unlock()
+			return
+		}
+
+		dir := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+		w.lock.Unlock()
+
+		if err := w.readDir(dir); err != nil {
+			w.fail(err)
+		}
+
+		w.lock.Lock()
+
+		if w.pending--; w.pending == 0 {
+			w.cond.Broadcast()
+		}
+
+		w.lock.Unlock()
+	}
+}
+
+func (w *walker) fail(err error) {
+	w.lock.Lock()
+
+	if w.err == nil {
+		w.err = err
+	}
+
+	w.cond.Broadcast()
+	w.lock.Unlock()
+}
+
+func (w *walker) stopped() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.err != nil
+}
+
+func (w *walker) readDir(dir string) error {
+	list, err := store.ReadDir(dir)
+
+	if err != nil && !os.IsPermission(err) {
+		return err // unreadable directories are skipped
+	}
+
+	for _, info := range list {
+		if w.stopped() {
+			return nil
+		}
+
+		name := filepath.Join(dir, info.Name())
+
+		if info.IsDir() {
+			if !isIgnored(name, true) {
+				w.lock.Lock()
+				w.queue = append(w.queue, name)
+				w.pending++
+				w.cond.Signal()
+				w.lock.Unlock()
+			}
+
+			continue
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = store.Stat(name); err != nil {
+				continue // dangling link
+			}
+		}
+
+		if !info.Mode().IsRegular() || isIgnored(name, false) {
+			continue
+		}
+
+		rel, err := filepath.Rel(w.root, name)
+
+		if err != nil {
+			return err
+		}
+
+		if err = w.fn(name, filepath.ToSlash(rel), info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// file system of the share, hiding everything that must not be served: special files, and the files
+// excluded by .webshare-ignore files
+type shareFS struct {
+	root string
+}
+
+func (fs shareFS) Open(name string) (http.File, error) {
+	full := filepath.Join(fs.root, filepath.FromSlash(path.Clean("/"+name)))
+
+	// check before opening, because opening a FIFO blocks
+	info, err := store.Stat(full)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if isSpecial(info) {
+		return nil, os.ErrPermission
+	}
+
+	if isIgnored(full, info.IsDir()) {
+		return nil, os.ErrNotExist
+	}
+
+	file, err := store.Open(full)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// regular files are returned as they are, to keep sendfile(2) working
+	if !info.IsDir() {
+		return file, nil
+	}
+
+	return &shareDir{file: file, dir: full}, nil
+}
+
+// directory with the hidden entries removed from the listing; the file is not embedded
+// because http.FileServer would use ReadDir method of os.File instead of Readdir
+type shareDir struct {
+	file http.File
+	dir  string
+}
+
+func (d *shareDir) Close() error                                 { return d.file.Close() }
+func (d *shareDir) Read(p []byte) (int, error)                   { return d.file.Read(p) }
+func (d *shareDir) Seek(offset int64, whence int) (int64, error) { return d.file.Seek(offset, whence) }
+func (d *shareDir) Stat() (os.FileInfo, error)                   { return d.file.Stat() }
+
+func (d *shareDir) Readdir(count int) ([]os.FileInfo, error) {
+	list, err := d.file.Readdir(count)
+	res := list[:0]
+
+	for _, info := range list {
+		if !hiddenEntry(d.dir, info) {
+			res = append(res, info)
+		}
+	}
+
+	return res, err
+}
+
+// hiddenEntry checks if the directory entry must be excluded from the listing
+func hiddenEntry(dir string, info os.FileInfo) bool {
+	full := filepath.Join(dir, info.Name())
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := store.Stat(full)
+
+		if err != nil {
+			return false
+		}
+
+		info = target
+	}
+
+	return isSpecial(info) || isIgnored(full, info.IsDir())
+}
+
+// isSpecial checks for sockets, FIFOs, and devices
+func isSpecial(info os.FileInfo) bool {
+	return info.Mode()&(os.ModeSocket|os.ModeNamedPipe|os.ModeDevice|os.ModeCharDevice|os.ModeIrregular) != 0
+}
@@ -0,0 +1,214 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// size of the thumbnails in the gallery grid
+const galleryThumbSize = 240
+
+// withGallery serves "?view=gallery" requests for the directories with the images as a grid
+// of thumbnails, opening the images in a viewer with previous and next navigation. Without
+// --thumbnails the grid shows the images themselves, scaled down by the browser.
+func withGallery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if (req.Method != http.MethodGet && req.Method != http.MethodHead) ||
+			!strings.HasSuffix(req.URL.Path, "/") || req.URL.Query().Get("view") != "gallery" {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		dir := resolvePath(req.URL.Path)
+
+		if info, err := store.Stat(dir); err != nil || !info.IsDir() || isIgnored(dir, true) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		entries, err := listDir(dir)
+
+		if err != nil {
+			log.Println(logTag(req), "Listing error:", err)
+			http.Error(resp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			Path   string
+			Images []galleryImage
+		}{
+			Path: path.Clean(req.URL.Path),
+		}
+
+		for _, e := range entries {
+			if e.Dir || !hasThumbnail(e.Name) {
+				continue
+			}
+
+			img := galleryImage{
+				Name: e.Name,
+				Href: (&url.URL{Path: e.Name}).String(),
+				Size: sizeString(e.Size),
+			}
+
+			if img.Thumb = thumbnailURL(path.Join(data.Path, e.Name), galleryThumbSize); len(img.Thumb) == 0 {
+				img.Thumb = img.Href
+			}
+
+			data.Images = append(data.Images, img)
+		}
+
+		resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		if req.Method == http.MethodHead {
+			return
+		}
+
+		if err = galleryTemplate.Execute(resp, &data); err != nil {
+			log.Println("Template error:", err)
+		}
+	})
+}
+
+type galleryImage struct {
+	Name, Href, Thumb, Size string
+}
+
+// hasImages checks if any of the entries is an image shown in the gallery
+func hasImages(entries []listEntry) bool {
+	for _, e := range entries {
+		if !e.Dir && hasThumbnail(e.Name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var galleryTemplate = template.Must(template.New("gallery").Parse(`<!doctype html>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>{{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+nav { font-size: 1.2em; margin-bottom: 1em; }
+#grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(160px, 1fr)); gap: 8px; }
+#grid a { display: block; aspect-ratio: 1; background: #eee; }
+#grid img { width: 100%; height: 100%; object-fit: cover; display: block; }
+#viewer { display: none; position: fixed; inset: 0; background: rgba(0, 0, 0, 0.92); z-index: 10; }
+#viewer.open { display: flex; align-items: center; justify-content: center; }
+#viewer img { max-width: 100%; max-height: calc(100% - 3em); }
+#viewer button { position: absolute; background: none; border: none; color: #fff; font-size: 2.5em; cursor: pointer; padding: 0.3em 0.5em; }
+#viewer .prev { left: 0; top: 45%; }
+#viewer .next { right: 0; top: 45%; }
+#viewer .close { right: 0; top: 0; }
+#viewer .caption { position: absolute; bottom: 0.5em; left: 0; right: 0; color: #ddd; text-align: center; }
+#viewer .caption a { color: #ddd; }
+</style>
+<nav><a href="./">{{.Path}}</a> &middot; {{len .Images}} image(s)</nav>
+<div id="grid">{{range $i, $img := .Images}}
+<a href="{{$img.Href}}" data-index="{{$i}}" title="{{$img.Name}}"><img src="{{$img.Thumb}}" alt="{{$img.Name}}" loading="lazy"></a>{{end}}
+</div>
+<div id="viewer"><img alt=""><button class="prev" title="Previous">&lsaquo;</button><button class="next" title="Next">&rsaquo;</button><button class="close" title="Close">&times;</button><div class="caption"></div></div>
+<script>
+(function() {
+	var links = document.querySelectorAll("#grid a"), viewer = document.getElementById("viewer");
+	var img = viewer.querySelector("img"), caption = viewer.querySelector(".caption"), current = -1;
+
+	function show(i) {
+		current = (i + links.length) % links.length;
+
+		var a = links[current];
+
+		img.src = a.getAttribute("href");
+		caption.innerHTML = "";
+
+		var dl = document.createElement("a");
+
+		dl.href = a.getAttribute("href");
+		dl.textContent = a.title;
+		dl.setAttribute("download", "");
+		caption.appendChild(dl);
+		caption.appendChild(document.createTextNode(" (" + (current + 1) + " of " + links.length + ")"));
+
+		// preload the next image
+		new Image().src = links[(current + 1) % links.length].getAttribute("href");
+
+		viewer.className = "open";
+	}
+
+	function close() {
+		viewer.className = "";
+		img.removeAttribute("src");
+		current = -1;
+	}
+
+	Array.prototype.forEach.call(links, function(a) {
+		a.addEventListener("click", function(e) {
+			e.preventDefault();
+			show(+a.getAttribute("data-index"));
+		});
+	});
+
+	viewer.querySelector(".prev").addEventListener("click", function() { show(current - 1); });
+	viewer.querySelector(".next").addEventListener("click", function() { show(current + 1); });
+	viewer.querySelector(".close").addEventListener("click", close);
+	viewer.addEventListener("click", function(e) { if (e.target === viewer) close(); });
+
+	document.addEventListener("keydown", function(e) {
+		if (current < 0) return;
+
+		if (e.key === "ArrowLeft") show(current - 1);
+		else if (e.key === "ArrowRight") show(current + 1);
+		else if (e.key === "Escape") close();
+	});
+
+	// swipes on touch screens
+	var startX = null;
+
+	viewer.addEventListener("touchstart", function(e) { startX = e.touches[0].clientX; });
+	viewer.addEventListener("touchend", function(e) {
+		if (startX === null) return;
+
+		var dx = e.changedTouches[0].clientX - startX;
+
+		startX = null;
+
+		if (Math.abs(dx) > 50) show(dx > 0 ? current - 1 : current + 1);
+	});
+})();
+</script>
+`))
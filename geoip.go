@@ -0,0 +1,413 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// GeoIP access restriction parameters
+var (
+	geoipFile    string
+	allowCountry string
+	denyCountry  string
+)
+
+func init() {
+	gnuflag.StringVar(&geoipFile, "geoip", "", "MaxMind country database (.mmdb) for the country restrictions.")
+	gnuflag.StringVar(&allowCountry, "allow-country", "", "Comma-separated list of country codes allowed to access the server.")
+	gnuflag.StringVar(&denyCountry, "deny-country", "", "Comma-separated list of country codes denied access to the server.")
+}
+
+func withGeoIP(next http.Handler) http.Handler {
+	if len(allowCountry) == 0 && len(denyCountry) == 0 {
+		return next
+	}
+
+	if len(geoipFile) == 0 {
+		die("Country restrictions require a GeoIP database (--geoip option)", nil)
+	}
+
+	db, err := openGeoDB(geoipFile)
+
+	if err != nil {
+		die("Cannot open GeoIP database "+geoipFile, err)
+	}
+
+	allow, deny := countrySet(allowCountry), countrySet(denyCountry)
+
+	log.Println("Using GeoIP database", geoipFile)
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		ip := remoteIP(req)
+
+		// local clients are not in the database
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || isPrivateIPv4(ip) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		country, err := db.country(ip)
+
+		if err != nil {
+			log.Println(logTag(req), "GeoIP lookup error:", err)
+		}
+
+		if deny[country] || (len(allow) > 0 && !allow[country]) {
+			if len(country) == 0 {
+				country = "unknown"
+			}
+
+			log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: country", country)
+			http.Error(resp, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
+func countrySet(list string) map[string]bool {
+	set := make(map[string]bool)
+
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.ToUpper(strings.TrimSpace(c)); len(c) > 0 {
+			set[c] = true
+		}
+	}
+
+	return set
+}
+
+// minimal reader for MaxMind DB files, see https://maxmind.github.io/MaxMind-DB/
+type geoDB struct {
+	buff       []byte
+	data       []byte // data section
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint // node to start IPv4 lookups from
+}
+
+var errGeoDB = errors.New("invalid MaxMind database")
+
+func openGeoDB(name string) (*geoDB, error) {
+	buff, err := ioutil.ReadFile(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// metadata
+	marker := []byte("\xAB\xCD\xEFMaxMind.com")
+	pos := bytes.LastIndex(buff, marker)
+
+	if pos < 0 {
+		return nil, errGeoDB
+	}
+
+	meta, _, err := decodeMMDB(buff[pos+len(marker):], 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := meta.(map[string]interface{})
+
+	if !ok {
+		return nil, errGeoDB
+	}
+
+	nodeCount, ok1 := m["node_count"].(uint64)
+	recordSize, ok2 := m["record_size"].(uint64)
+	ipVersion, ok3 := m["ip_version"].(uint64)
+
+	if !ok1 || !ok2 || !ok3 || (recordSize != 24 && recordSize != 28 && recordSize != 32) || nodeCount > uint64(pos) {
+		return nil, errGeoDB
+	}
+
+	treeSize := int(nodeCount * recordSize / 4)
+
+	if treeSize+16 > pos {
+		return nil, errGeoDB
+	}
+
+	db := &geoDB{
+		buff:       buff[:treeSize],
+		data:       buff[treeSize+16 : pos],
+		nodeCount:  uint(nodeCount),
+		recordSize: uint(recordSize),
+		ipVersion:  uint(ipVersion),
+	}
+
+	// IPv4 addresses live under ::/96 in IPv6 databases
+	if ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+
+	return db, nil
+}
+
+func (db *geoDB) record(node, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		b := db.buff[node*6+bit*3:]
+
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.buff[node*7:]
+
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(db.buff[node*8+bit*4:]))
+	}
+}
+
+// country returns ISO country code of the given address, or an empty string if not found
+func (db *geoDB) country(ip net.IP) (string, error) {
+	node := uint(0)
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, node = ip4, db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return "", nil
+	}
+
+	for i := uint(0); i < uint(len(ip))*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint(ip[i/8]>>(7-i%8))&1)
+	}
+
+	if node <= db.nodeCount {
+		return "", nil // not found
+	}
+
+	rec, _, err := decodeMMDB(db.data, int(node-db.nodeCount-16))
+
+	if err != nil {
+		return "", err
+	}
+
+	m, _ := rec.(map[string]interface{})
+
+	for _, key := range [...]string{"country", "registered_country"} {
+		if c, ok := m[key].(map[string]interface{}); ok {
+			if code, ok := c["iso_code"].(string); ok {
+				return code, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// nesting limit of the values, so that a pointer loop in a broken file cannot run out of stack
+const mmdbMaxDepth = 32
+
+// decodeMMDB decodes the value at the given offset of the data section, returning the value
+// and the offset of the next one. Integers are returned as uint64 or int32.
+func decodeMMDB(data []byte, off int) (interface{}, int, error) {
+	return decodeMMDBValue(data, off, 0)
+}
+
+func decodeMMDBValue(data []byte, off, depth int) (interface{}, int, error) {
+	if off < 0 || off >= len(data) || depth > mmdbMaxDepth {
+		return nil, 0, errGeoDB
+	}
+
+	ctrl := data[off]
+	off++
+	kind := int(ctrl >> 5)
+
+	// pointer
+	if kind == 1 {
+		n := int(ctrl>>3)&3 + 1
+
+		if off+n > len(data) {
+			return nil, 0, errGeoDB
+		}
+
+		var ptr int
+
+		if n < 4 {
+			ptr = int(ctrl & 7)
+		}
+
+		for _, b := range data[off : off+n] {
+			ptr = ptr<<8 | int(b)
+		}
+
+		ptr += [...]int{0, 2048, 526336, 0}[n-1]
+
+		val, _, err := decodeMMDBValue(data, ptr, depth+1)
+
+		return val, off + n, err
+	}
+
+	// extended type
+	if kind == 0 {
+		if off >= len(data) {
+			return nil, 0, errGeoDB
+		}
+
+		kind = 7 + int(data[off])
+		off++
+	}
+
+	// size
+	size := int(ctrl & 0x1F)
+
+	if size >= 29 {
+		n := size - 28
+
+		if off+n > len(data) {
+			return nil, 0, errGeoDB
+		}
+
+		ext := 0
+
+		for _, b := range data[off : off+n] {
+			ext = ext<<8 | int(b)
+		}
+
+		size = [...]int{29, 285, 65821}[n-1] + ext
+		off += n
+	}
+
+	// payload
+	switch kind {
+	case 7, 11: // map, array
+		var m map[string]interface{}
+		var a []interface{}
+
+		// each item takes at least one byte
+		if size > len(data)-off {
+			return nil, 0, errGeoDB
+		}
+
+		if kind == 7 {
+			m = make(map[string]interface{}, size)
+		}
+
+		for i := 0; i < size; i++ {
+			var key, val interface{}
+			var err error
+
+			if kind == 7 {
+				if key, off, err = decodeMMDBValue(data, off, depth+1); err != nil {
+					return nil, 0, err
+				}
+			}
+
+			if val, off, err = decodeMMDBValue(data, off, depth+1); err != nil {
+				return nil, 0, err
+			}
+
+			if kind == 7 {
+				k, ok := key.(string)
+
+				if !ok {
+					return nil, 0, errGeoDB
+				}
+
+				m[k] = val
+			} else {
+				a = append(a, val)
+			}
+		}
+
+		if kind == 7 {
+			return m, off, nil
+		}
+
+		return a, off, nil
+
+	case 14: // boolean
+		return size != 0, off, nil
+	}
+
+	if off+size > len(data) {
+		return nil, 0, errGeoDB
+	}
+
+	b := data[off : off+size]
+	off += size
+
+	switch kind {
+	case 2: // string
+		return string(b), off, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errGeoDB
+		}
+
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errGeoDB
+		}
+
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	case 4, 10: // bytes, uint128
+		return b, off, nil
+	case 5, 6, 9: // unsigned integers
+		var val uint64
+
+		for _, c := range b {
+			val = val<<8 | uint64(c)
+		}
+
+		return val, off, nil
+	case 8: // int32
+		var val uint32
+
+		for _, c := range b {
+			val = val<<8 | uint32(c)
+		}
+
+		return int32(val), off, nil
+	default:
+		return nil, 0, errGeoDB
+	}
+}
@@ -0,0 +1,357 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// MaxMind DB encoding of the test values
+func mmdbValue(kind int, payload []byte) []byte {
+	var b []byte
+
+	switch n := len(payload); {
+	case n < 29:
+		b = []byte{byte(n)}
+	case n < 285:
+		b = []byte{29, byte(n - 29)}
+	default:
+		n -= 285
+		b = []byte{30, byte(n >> 8), byte(n)}
+	}
+
+	if kind < 8 {
+		b[0] |= byte(kind << 5)
+	} else {
+		b = append([]byte{b[0]}, append([]byte{byte(kind - 7)}, b[1:]...)...)
+	}
+
+	return append(b, payload...)
+}
+
+func mmdbString(s string) []byte { return mmdbValue(2, []byte(s)) }
+
+func mmdbUint(kind int, val uint64) []byte {
+	var b [8]byte
+
+	binary.BigEndian.PutUint64(b[:], val)
+
+	return mmdbValue(kind, bytes.TrimLeft(b[:], "\x00"))
+}
+
+func mmdbMap(items ...[]byte) []byte {
+	return append([]byte{byte(7<<5 | len(items)/2)}, bytes.Join(items, nil)...)
+}
+
+func mmdbArray(items ...[]byte) []byte {
+	return append([]byte{byte(len(items)), 4}, bytes.Join(items, nil)...)
+}
+
+func mmdbPointer(ptr int) []byte {
+	return []byte{byte(1<<5 | ptr>>8&7), byte(ptr)}
+}
+
+func TestDecodeMMDB(t *testing.T) {
+	long := strings.Repeat("x", 300)
+
+	cases := []struct {
+		src []byte
+		val interface{}
+	}{
+		{mmdbString("DE"), "DE"},
+		{mmdbString(""), ""},
+		{mmdbString(long[:30]), long[:30]},
+		{mmdbString(long), long},
+		{mmdbUint(5, 443), uint64(443)},
+		{mmdbUint(6, 0), uint64(0)},
+		{mmdbUint(6, 1<<32-1), uint64(1<<32 - 1)},
+		{mmdbUint(9, 1<<40), uint64(1 << 40)},
+		{mmdbValue(8, []byte{0xff, 0xff, 0xff, 0xfe}), int32(-2)},
+		{mmdbValue(3, []byte{0x40, 0x09, 0x21, 0xfb, 0x54, 0x44, 0x2d, 0x18}), math.Pi},
+		{mmdbValue(15, []byte{0x3f, 0xc0, 0, 0}), 1.5},
+		{[]byte{1, 7}, true},
+		{[]byte{0, 7}, false},
+		{mmdbArray(mmdbString("a"), mmdbUint(5, 1)), []interface{}{"a", uint64(1)}},
+		{mmdbMap(mmdbString("iso_code"), mmdbString("FR")), map[string]interface{}{"iso_code": "FR"}},
+
+		// broken
+		{nil, nil},
+		{mmdbString("DE")[:2], nil},                          // truncated
+		{mmdbValue(3, []byte{1, 2, 3}), nil},                 // double of wrong size
+		{mmdbMap(mmdbUint(5, 1), mmdbString("x")), nil},      // key is not a string
+		{append([]byte{7<<5 | 28}, mmdbString("k")...), nil}, // too many items
+		{mmdbPointer(100), nil},                              // out of range
+		{mmdbPointer(0), nil},                                // to itself
+		{[]byte{0}, nil},                                     // extended type is missing
+		{mmdbValue(13, nil), nil},                            // end marker
+	}
+
+	for i, c := range cases {
+		val, _, err := decodeMMDB(c.src, 0)
+
+		if c.val == nil {
+			if err == nil {
+				t.Errorf("case %d: no error", i)
+			}
+		} else if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+		} else if !reflect.DeepEqual(val, c.val) {
+			t.Errorf("case %d: got %#v", i, val)
+		}
+	}
+
+	// the next value follows the pointer, not the value it points to
+	data := append(mmdbString("first"), mmdbPointer(0)...)
+	data = append(data, mmdbString("last")...)
+
+	if val, off, err := decodeMMDB(data, 6); err != nil || val != "first" || off != 8 {
+		t.Errorf("pointer: got %v, %d, %v", val, off, err)
+	} else if val, _, err = decodeMMDB(data, off); err != nil || val != "last" {
+		t.Errorf("after the pointer: got %v, %v", val, err)
+	}
+
+	// a loop via a map
+	loop := mmdbMap(mmdbString("k"), mmdbPointer(0))
+
+	if _, _, err := decodeMMDB(loop, 0); err == nil {
+		t.Error("loop: no error")
+	}
+}
+
+// buildMMDB makes a database of the networks with their data at the given offsets in the data section
+func buildMMDB(ipVersion, recordSize int, nets map[string]int, data []byte) []byte {
+	const empty = -1
+
+	type node [2]int // child node, or empty, or -2 - data offset
+
+	nodes := []node{{empty, empty}}
+
+	for cidr, off := range nets {
+		ip, n, err := net.ParseCIDR(cidr)
+
+		if err != nil {
+			panic(err)
+		}
+
+		ones, _ := n.Mask.Size()
+
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+
+			if ipVersion == 6 {
+				ip, ones = append(make(net.IP, 12), ip4...), ones+96
+			}
+		}
+
+		i := 0
+
+		for bit := 0; bit < ones; bit++ {
+			b := int(ip[bit/8]>>(7-bit%8)) & 1
+
+			if bit == ones-1 {
+				nodes[i][b] = -2 - off
+			} else {
+				if nodes[i][b] == empty {
+					nodes = append(nodes, node{empty, empty})
+					nodes[i][b] = len(nodes) - 1
+				}
+
+				i = nodes[i][b]
+			}
+		}
+	}
+
+	var tree []byte
+
+	for _, n := range nodes {
+		var rec [2]uint32
+
+		for i, r := range n {
+			switch {
+			case r == empty:
+				rec[i] = uint32(len(nodes))
+			case r < 0:
+				rec[i] = uint32(len(nodes) + 16 - 2 - r)
+			default:
+				rec[i] = uint32(r)
+			}
+		}
+
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(rec[0]>>16), byte(rec[0]>>8), byte(rec[0]),
+				byte(rec[1]>>16), byte(rec[1]>>8), byte(rec[1]))
+		case 28:
+			tree = append(tree, byte(rec[0]>>16), byte(rec[0]>>8), byte(rec[0]),
+				byte(rec[0]>>24<<4|rec[1]>>24),
+				byte(rec[1]>>16), byte(rec[1]>>8), byte(rec[1]))
+		default:
+			tree = append(tree, byte(rec[0]>>24), byte(rec[0]>>16), byte(rec[0]>>8), byte(rec[0]),
+				byte(rec[1]>>24), byte(rec[1]>>16), byte(rec[1]>>8), byte(rec[1]))
+		}
+	}
+
+	meta := mmdbMap(
+		mmdbString("node_count"), mmdbUint(6, uint64(len(nodes))),
+		mmdbString("record_size"), mmdbUint(5, uint64(recordSize)),
+		mmdbString("ip_version"), mmdbUint(5, uint64(ipVersion)),
+		mmdbString("database_type"), mmdbString("Test-Country"),
+	)
+
+	return bytes.Join([][]byte{tree, make([]byte, 16), data, []byte("\xAB\xCD\xEFMaxMind.com"), meta}, nil)
+}
+
+func writeMMDB(t *testing.T, content []byte) string {
+	name := filepath.Join(t.TempDir(), "test.mmdb")
+
+	if err := ioutil.WriteFile(name, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+func TestGeoDB(t *testing.T) {
+	var data []byte
+
+	country := func(key, code string) int {
+		off := len(data)
+		data = append(data, mmdbMap(mmdbString(key), mmdbMap(mmdbString("iso_code"), mmdbString(code)))...)
+		return off
+	}
+
+	au, us, de, fr := country("country", "AU"), country("country", "US"), country("country", "DE"), country("registered_country", "FR")
+
+	// a record pointing to another one
+	alias := len(data)
+	data = append(data, mmdbPointer(au)...)
+
+	nets := map[string]int{
+		"1.2.3.0/24":      au,
+		"8.0.0.0/8":       us,
+		"9.9.9.9/32":      alias,
+		"100.64.0.0/10":   fr,
+		"2001:db8::/32":   de,
+		"2001:db9::1/128": us,
+	}
+
+	cases := []struct {
+		ip     string
+		v4, v6 string
+	}{
+		{"1.2.3.4", "AU", "AU"},
+		{"1.2.3.255", "AU", "AU"},
+		{"1.2.4.1", "", ""},
+		{"8.8.8.8", "US", "US"},
+		{"9.9.9.9", "AU", "AU"},
+		{"9.9.9.8", "", ""},
+		{"100.100.1.1", "FR", "FR"},
+		{"::ffff:8.8.4.4", "US", "US"},
+		{"2001:db8::1", "", "DE"},
+		{"2001:db9::1", "", "US"},
+		{"2001:db9::2", "", ""},
+		{"::1", "", ""},
+	}
+
+	for _, ipVersion := range []int{4, 6} {
+		v4nets := make(map[string]int)
+
+		for cidr, off := range nets {
+			if ipVersion == 6 || !strings.Contains(cidr, ":") {
+				v4nets[cidr] = off
+			}
+		}
+
+		for _, recordSize := range []int{24, 28, 32} {
+			db, err := openGeoDB(writeMMDB(t, buildMMDB(ipVersion, recordSize, v4nets, data)))
+
+			if err != nil {
+				t.Fatalf("IPv%d, %d bit records: %s", ipVersion, recordSize, err)
+			}
+
+			for _, c := range cases {
+				expected := c.v4
+
+				if ipVersion == 6 {
+					expected = c.v6
+				}
+
+				if code, err := db.country(net.ParseIP(c.ip)); err != nil || code != expected {
+					t.Errorf("IPv%d, %d bit records, %s: got %q, %v", ipVersion, recordSize, c.ip, code, err)
+				}
+			}
+		}
+	}
+}
+
+func TestGeoDBBroken(t *testing.T) {
+	good := buildMMDB(4, 24, map[string]int{"1.2.3.0/24": 0}, mmdbMap(mmdbString("country"), mmdbPointer(0)))
+	marker := bytes.LastIndex(good, []byte("\xAB\xCD\xEFMaxMind.com"))
+
+	meta := func(nodeCount, recordSize uint64) []byte {
+		return append(append([]byte{}, good[:marker+14]...), mmdbMap(
+			mmdbString("node_count"), mmdbUint(6, nodeCount),
+			mmdbString("record_size"), mmdbUint(5, recordSize),
+			mmdbString("ip_version"), mmdbUint(5, 4),
+		)...)
+	}
+
+	for i, content := range [][]byte{
+		nil,
+		good[:marker],    // no metadata
+		good[:marker+15], // truncated metadata
+		meta(1, 20),      // unsupported record size
+		meta(1<<40, 24),  // too many nodes
+		meta(1<<62, 32),  // overflowing tree size
+		append(good[:marker+14:marker+14], mmdbString("x")...), // not a map
+	} {
+		if _, err := openGeoDB(writeMMDB(t, content)); err == nil {
+			t.Errorf("case %d: no error", i)
+		}
+	}
+
+	// the lookup of a record with a pointer loop fails, but does not crash
+	db, err := openGeoDB(writeMMDB(t, good))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.country(net.ParseIP("1.2.3.4")); err == nil {
+		t.Error("pointer loop: no error")
+	}
+}
@@ -0,0 +1,460 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// "get" subcommand: download a file from the server, verifying the SHA-256 digest of the content,
+// which the server sends as a trailer of the streamed responses, like archives. Large files are
+// downloaded over several connections in parallel, by ranges, each retried on failure.
+func getCommand(args []string) int {
+	var output, chunkSpec, knownHosts, fingerprint string
+	var insecure bool
+	var conns, retries uint
+
+	flags := gnuflag.NewFlagSet("get", gnuflag.ExitOnError)
+
+	flags.StringVar(&output, "output", "", "Output file, \"-\" for stdout (default: the file name from the server, or the last element of the URL path).")
+	flags.StringVar(&output, "o", "", "Output file, \"-\" for stdout (default: the file name from the server, or the last element of the URL path).")
+	flags.BoolVar(&insecure, "insecure", false, "Do not verify the server's TLS certificate at all.")
+	flags.StringVar(&fingerprint, "fingerprint", "", "Expected SHA-256 fingerprint of a self-signed server certificate, to trust it on the first use without asking.")
+	flags.StringVar(&knownHosts, "known-hosts", knownHostsFile(), "File of the server certificates trusted on the first use.")
+	flags.UintVar(&conns, "connections", 4, "Number of parallel connections for large files; 1 to download in one stream.")
+	flags.UintVar(&conns, "c", 4, "Number of parallel connections for large files; 1 to download in one stream.")
+	flags.StringVar(&chunkSpec, "chunk", "8M", "Size of the range requested by each connection at a time.")
+	flags.UintVar(&retries, "retries", 5, "Number of retries of a failed range request.")
+
+	flags.Parse(true, args)
+
+	if flags.NArg() != 1 {
+		die("Usage: "+os.Args[0]+" get [options] <url>", nil)
+	}
+
+	u, err := url.Parse(flags.Arg(0))
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		die("Invalid URL: "+flags.Arg(0), err)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+
+	if !insecure && u.Scheme == "https" {
+		host := u.Host
+
+		if len(u.Port()) == 0 {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+
+		if tlsConfig, err = trustConfig(host, knownHosts, fingerprint); err != nil {
+			die("Cannot read "+knownHosts, err)
+		}
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:              http.ProxyFromEnvironment,
+			DisableCompression: true,
+			TLSClientConfig:    tlsConfig,
+		},
+	}
+
+	chunk, err := parseByteSize(chunkSpec)
+
+	if err != nil || chunk <= 0 {
+		die("Invalid chunk size: "+chunkSpec, err)
+	}
+
+	// ranges of a large file
+	if conns > 1 && output != "-" {
+		g := &rangeGet{client: client, url: u.String(), conns: int(conns), chunk: chunk, retries: int(retries)}
+
+		if code, ok := g.download(u, output); ok {
+			return code
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+
+	if err != nil {
+		die("", err)
+	}
+
+	req.Header.Set("Want-Digest", "sha-256")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		die("", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		die("Server response: "+resp.Status, nil)
+	}
+
+	if len(output) == 0 {
+		if output = responseFileName(resp, u); len(output) == 0 {
+			die("Cannot tell the file name from the URL, use --output option", nil)
+		}
+	}
+
+	// receive
+	var dst io.Writer = os.Stdout
+	var tmp *os.File
+
+	if output != "-" {
+		if tmp, err = ioutil.TempFile(filepath.Dir(output), "."+filepath.Base(output)+".part-"); err != nil {
+			die("", err)
+		}
+
+		defer func() {
+			if tmp != nil {
+				tmp.Close()
+				os.Remove(tmp.Name())
+			}
+		}()
+
+		dst = tmp
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, h), resp.Body)
+
+	if err != nil {
+		log.Println("Download failed:", err)
+		return 1
+	}
+
+	// verify
+	want, ok := sha256Digest(resp.Trailer.Get("Digest"))
+
+	if !ok {
+		want, ok = sha256Digest(resp.Header.Get("Digest"))
+	}
+
+	if ok && !bytes.Equal(want, h.Sum(nil)) {
+		log.Println("Download failed: SHA-256 digest mismatch, the content is damaged")
+		return 1
+	}
+
+	if tmp != nil {
+		if err = tmp.Chmod(0644); err == nil {
+			err = tmp.Close()
+		}
+
+		if err == nil {
+			err = os.Rename(tmp.Name(), output)
+		}
+
+		if err != nil {
+			log.Println("Cannot save the file:", err)
+			return 1
+		}
+
+		tmp = nil
+	}
+
+	if ok {
+		log.Println("Received", output, sizeString(n)+", SHA-256 verified")
+	} else {
+		log.Println("Received", output, sizeString(n)+", not verified (no digest from the server)")
+	}
+
+	return 0
+}
+
+// responseFileName returns the base name of the file from Content-Disposition header, if any,
+// or from the URL path
+func responseFileName(resp *http.Response, u *url.URL) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := filepath.Base(params["filename"]); len(params["filename"]) > 0 && name != "." && name != ".." {
+			return name
+		}
+	}
+
+	if name := path.Base(u.Path); name != "/" && name != "." && name != ".." {
+		return name
+	}
+
+	return ""
+}
+
+// sha256Digest extracts the SHA-256 hash from Digest header, like "sha-256=<base64>,md5=<base64>"
+func sha256Digest(s string) ([]byte, bool) {
+	for _, d := range strings.Split(s, ",") {
+		i := strings.IndexByte(d, '=')
+
+		if i < 0 || !strings.EqualFold(strings.TrimSpace(d[:i]), "sha-256") {
+			continue
+		}
+
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(d[i+1:]))
+
+		return sum, err == nil && len(sum) == sha256.Size
+	}
+
+	return nil, false
+}
+
+// parallel download of a file by ranges
+type rangeGet struct {
+	client  *http.Client
+	url     string
+	conns   int
+	chunk   int64
+	retries int
+	ifRange string // validator of the file, so that all the ranges come from the same version
+	file    *os.File
+}
+
+// download gets the file by ranges, if it is large enough and the server supports ranges;
+// otherwise returns false
+func (g *rangeGet) download(u *url.URL, output string) (int, bool) {
+	resp, err := g.client.Head(g.url)
+
+	if err != nil {
+		die("", err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" || resp.ContentLength <= g.chunk {
+		return 0, false
+	}
+
+	size := resp.ContentLength
+
+	if etag := resp.Header.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+		g.ifRange = etag
+	} else {
+		g.ifRange = resp.Header.Get("Last-Modified")
+	}
+
+	if len(output) == 0 {
+		if output = responseFileName(resp, u); len(output) == 0 {
+			die("Cannot tell the file name from the URL, use --output option", nil)
+		}
+	}
+
+	if g.file, err = ioutil.TempFile(filepath.Dir(output), "."+filepath.Base(output)+".part-"); err != nil {
+		die("", err)
+	}
+
+	defer func() {
+		if g.file != nil {
+			g.file.Close()
+			os.Remove(g.file.Name())
+		}
+	}()
+
+	if err = g.file.Truncate(size); err != nil {
+		die("", err)
+	}
+
+	ts := time.Now()
+
+	if err = g.run(size); err != nil {
+		log.Println("Download failed:", err)
+		return 1, true
+	}
+
+	if err = g.file.Chmod(0644); err == nil {
+		err = g.file.Close()
+	}
+
+	if err == nil {
+		err = os.Rename(g.file.Name(), output)
+	}
+
+	if err != nil {
+		log.Println("Cannot save the file:", err)
+		return 1, true
+	}
+
+	g.file = nil
+
+	log.Println("Received", output, sizeString(size), "in", time.Since(ts).Round(time.Millisecond),
+		"over", g.conns, "connections")
+	return 0, true
+}
+
+// run downloads all the ranges of the file
+func (g *rangeGet) run(size int64) error {
+	jobs := make(chan int64)
+	errs := make(chan error, g.conns)
+
+	var wg sync.WaitGroup
+
+	wg.Add(g.conns)
+
+	for i := 0; i < g.conns; i++ {
+		go func() {
+			defer wg.Done()
+
+			for start := range jobs {
+				end := start + g.chunk - 1
+
+				if end >= size {
+					end = size - 1
+				}
+
+				if err := g.fetch(start, end); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+
+feed:
+	for start := int64(0); start < size; start += g.chunk {
+		select {
+		case jobs <- start:
+		case err = <-errs:
+			break feed
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+
+	return err
+}
+
+// the file has changed on the server during the download
+var errRemoteChanged = errors.New("the file has changed on the server")
+
+// fetch downloads the range of the file, retrying from where it stopped on failure
+func (g *rangeGet) fetch(start, end int64) (err error) {
+	for i := 0; i <= g.retries; i++ {
+		if i > 0 {
+			log.Println("Range "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10)+" failed, retrying:", err)
+			time.Sleep(time.Duration(i) * time.Second)
+		}
+
+		var n int64
+
+		n, err = g.fetchOnce(start, end)
+
+		if start += n; err == nil || err == errRemoteChanged {
+			return
+		}
+	}
+
+	return
+}
+
+func (g *rangeGet) fetchOnce(start, end int64) (int64, error) {
+	req, err := http.NewRequest(http.MethodGet, g.url, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10))
+
+	if len(g.ifRange) > 0 {
+		req.Header.Set("If-Range", g.ifRange)
+	}
+
+	resp, err := g.client.Do(req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// ok
+	case http.StatusOK:
+		return 0, errRemoteChanged // If-Range validator did not match
+	default:
+		return 0, errors.New("server response: " + resp.Status)
+	}
+
+	if s, e, _, err := parseContentRange(resp.Header.Get("Content-Range")); err != nil || s != start || e != end {
+		return 0, errors.New("unexpected Content-Range: " + resp.Header.Get("Content-Range"))
+	}
+
+	n, err := io.Copy(&offsetWriter{g.file, start}, io.LimitReader(resp.Body, end-start+1))
+
+	if err == nil && n < end-start+1 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// writer to the file at the given offset
+type offsetWriter struct {
+	file *os.File
+	off  int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.file.WriteAt(p, w.off)
+	w.off += int64(n)
+
+	return n, err
+}
@@ -1,8 +1,14 @@
 module github.com/maxim2266/web-share
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/juju/gnuflag v0.0.0-20171113085948-2ce1bb71843d
+	github.com/klauspost/cpuid/v2 v2.0.11 // indirect
 	github.com/maxim2266/mvr v0.5.1-0.20191024173830-b6033cc789f1
+	github.com/zeebo/xxh3 v1.0.1
+	golang.org/x/crypto v0.0.0-20191206172530-e9b2fee46413
+	golang.org/x/net v0.0.0-20191209160850-c0dbc17a3553
+	lukechampine.com/blake3 v1.1.7
 )
 
-go 1.13
+go 1.16
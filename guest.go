@@ -0,0 +1,300 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// upload-only mode
+var (
+	uploadOnly         bool
+	uploadInstructions string
+)
+
+func init() {
+	gnuflag.BoolVar(&uploadOnly, "upload-only", false,
+		"Accept uploads only: directories show an upload page instead of the listings, and nothing can be downloaded; implies --allow-upload.")
+	gnuflag.StringVar(&uploadInstructions, "upload-instructions", "",
+		"Markdown file with the instructions for the upload page; translations are looked for next to it, like \"instructions.de.md\".")
+}
+
+// withUploadOnly replaces the listings with the upload page, and rejects everything but the uploads,
+// in upload-only mode
+func withUploadOnly(next http.Handler) http.Handler {
+	if !uploadOnly {
+		return next
+	}
+
+	if len(uploadInstructions) > 0 {
+		if _, err := os.Stat(uploadInstructions); err != nil {
+			die("", err)
+		}
+	}
+
+	log.Println("Upload-only mode")
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if uploadOnlyAllowed(req) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if (req.Method == http.MethodGet || req.Method == http.MethodHead) && strings.HasSuffix(req.URL.Path, "/") &&
+			!isAPIPath(req.URL.Path) {
+			dir := resolvePath(req.URL.Path)
+
+			if info, err := store.Stat(dir); err == nil && info.IsDir() && !isIgnored(dir, true) {
+				serveUploadPage(resp, req)
+				return
+			}
+		}
+
+		log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: upload-only mode")
+		http.Error(resp, "Forbidden", http.StatusForbidden)
+	})
+}
+
+// uploadOnlyAllowed checks if the request is an upload, or is otherwise allowed in upload-only mode:
+// PUT requests (including chunks), chunk commits, multipart form posts, resumable uploads, and the icon.
+func uploadOnlyAllowed(req *http.Request) bool {
+	p := req.URL.Path
+
+	switch {
+	case strings.HasPrefix(p, tusPrefix):
+		return true
+	case isAPIPath(p):
+		return false
+	case p == "/favicon.ico":
+		return req.Method == http.MethodGet || req.Method == http.MethodHead
+	case req.Method == http.MethodPut:
+		return !strings.HasSuffix(p, "/")
+	case req.Method == http.MethodPost:
+		if isCommit(req) {
+			return true
+		}
+
+		t, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+		return t == "multipart/form-data" && strings.HasSuffix(p, "/")
+	default:
+		return false
+	}
+}
+
+// text of the upload page
+type uploadPageText struct {
+	Title, Drop, Choose, Upload, Types, MaxSize, Done, Failed string
+}
+
+// translations of the upload page, by language
+var uploadPageTexts = map[string]*uploadPageText{
+	"en": {"Upload files", "Drop files here", "or click to choose them", "Upload",
+		"Accepted file types:", "Maximum file size:", "uploaded", "failed"},
+	"de": {"Dateien hochladen", "Dateien hierher ziehen", "oder klicken, um sie auszuwählen", "Hochladen",
+		"Erlaubte Dateitypen:", "Maximale Dateigröße:", "hochgeladen", "fehlgeschlagen"},
+	"es": {"Subir archivos", "Suelte los archivos aquí", "o haga clic para elegirlos", "Subir",
+		"Tipos de archivo aceptados:", "Tamaño máximo de archivo:", "subido", "error"},
+	"fr": {"Envoyer des fichiers", "Déposez les fichiers ici", "ou cliquez pour les choisir", "Envoyer",
+		"Types de fichiers acceptés :", "Taille maximale d'un fichier :", "envoyé", "échec"},
+	"ru": {"Загрузка файлов", "Перетащите файлы сюда", "или нажмите, чтобы выбрать их", "Загрузить",
+		"Допустимые типы файлов:", "Максимальный размер файла:", "загружен", "ошибка"},
+}
+
+// pageLang returns the most preferred language of the client the upload page is translated to
+func pageLang(req *http.Request) string {
+	for _, s := range strings.Split(req.Header.Get("Accept-Language"), ",") {
+		if i := strings.IndexAny(s, ";-_"); i >= 0 {
+			s = s[:i]
+		}
+
+		if s = strings.ToLower(strings.TrimSpace(s)); uploadPageTexts[s] != nil {
+			return s
+		}
+	}
+
+	return "en"
+}
+
+// instructionsHTML returns the rendered instructions in the given language, or in the default one
+func instructionsHTML(lang string) template.HTML {
+	if len(uploadInstructions) == 0 {
+		return ""
+	}
+
+	ext := filepath.Ext(uploadInstructions)
+	names := []string{strings.TrimSuffix(uploadInstructions, ext) + "." + lang + ext, uploadInstructions}
+
+	for _, name := range names {
+		file, err := os.Open(name)
+
+		if err != nil {
+			continue
+		}
+
+		var buf bytes.Buffer
+
+		_, err = io.Copy(&buf, io.LimitReader(file, maxReadmeSize))
+		file.Close()
+
+		if err != nil {
+			continue
+		}
+
+		var res bytes.Buffer
+
+		renderMarkdown(&res, buf.String())
+		return template.HTML(res.String())
+	}
+
+	return ""
+}
+
+// serveUploadPage serves the upload page for the directory
+func serveUploadPage(resp http.ResponseWriter, req *http.Request) {
+	lang := pageLang(req)
+
+	data := struct {
+		Lang                   string
+		Text                   *uploadPageText
+		Instructions           template.HTML
+		Types, Accept, MaxSize string
+	}{
+		Lang:         lang,
+		Text:         uploadPageTexts[lang],
+		Instructions: instructionsHTML(lang),
+		Types:        strings.Join(uploadTypes, ", "),
+		Accept:       strings.Join(uploadTypes, ","),
+	}
+
+	if maxFileSize > 0 {
+		data.MaxSize = sizeString(maxFileSize)
+	}
+
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	resp.Header().Set("Content-Language", lang)
+	resp.Header().Set("Vary", "Accept-Language")
+
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	if err := uploadPageTemplate.Execute(resp, &data); err != nil {
+		log.Println("Template error:", err)
+	}
+}
+
+var uploadPageTemplate = template.Must(template.New("upload").Parse(`<!doctype html>
+<html lang="{{.Lang}}">
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>{{.Text.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+#drop { display: block; border: 3px dashed #888; border-radius: 1em; padding: 4em 1em; text-align: center; font-size: 1.5em; cursor: pointer; }
+#drop.over { border-color: #2d6cdf; background: #eef3fd; }
+#drop input { display: block; margin: 1em auto 0; font-size: 0.6em; }
+#files { list-style: none; padding: 0; }
+</style>
+<h1>{{.Text.Title}}</h1>
+{{.Instructions}}
+{{with .Types}}<p>{{$.Text.Types}} {{.}}</p>{{end}}
+{{with .MaxSize}}<p>{{$.Text.MaxSize}} {{.}}</p>{{end}}
+<form method="post" enctype="multipart/form-data">
+<label id="drop">{{.Text.Drop}}<br><small>{{.Text.Choose}}</small>
+<input type="file" name="file" multiple{{with .Accept}} accept="{{.}}"{{end}}></label>
+<p><button type="submit">{{.Text.Upload}}</button></p>
+</form>
+<ul id="files"></ul>
+<script>
+(function() {
+	var form = document.querySelector("form"), drop = document.getElementById("drop"),
+		input = form.querySelector("input"), list = document.getElementById("files");
+
+	form.querySelector("button").style.display = "none";
+	input.style.display = "none";
+
+	function send(file) {
+		var li = document.createElement("li"), data = new FormData(), xhr = new XMLHttpRequest();
+
+		li.textContent = file.name + " 0%";
+		list.appendChild(li);
+		data.append("file", file);
+
+		xhr.upload.onprogress = function(e) {
+			if (e.lengthComputable) li.textContent = file.name + " " + Math.floor(e.loaded * 100 / e.total) + "%";
+		};
+
+		xhr.onload = function() {
+			li.textContent = file.name + " — " +
+				(xhr.status < 300 ? {{.Text.Done}} : {{.Text.Failed}} + ": " + xhr.responseText.trim());
+		};
+
+		xhr.onerror = function() { li.textContent = file.name + " — " + {{.Text.Failed}}; };
+		xhr.open("POST", location.pathname);
+		xhr.send(data);
+	}
+
+	function upload(files) {
+		for (var i = 0; i < files.length; i++) send(files[i]);
+	}
+
+	input.addEventListener("change", function() {
+		upload(input.files);
+		input.value = "";
+	});
+
+	["dragenter", "dragover"].forEach(function(t) {
+		drop.addEventListener(t, function(e) {
+			e.preventDefault();
+			drop.className = "over";
+		});
+	});
+
+	["dragleave", "drop"].forEach(function(t) {
+		drop.addEventListener(t, function(e) {
+			e.preventDefault();
+			drop.className = "";
+		});
+	});
+
+	drop.addEventListener("drop", function(e) { upload(e.dataTransfer.files); });
+})();
+</script>
+`))
@@ -0,0 +1,87 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"hash"
+	"net/url"
+
+	"github.com/zeebo/xxh3"
+	"lukechampine.com/blake3"
+)
+
+// hash algorithm for the checksums, the manifests, and the upload verification. SHA-256 is
+// the default, BLAKE3 is several times faster on large files, and XXH3 is faster still, but not
+// cryptographic, so it only guards against accidental damage.
+type hashAlgo struct {
+	name string // in the query parameters and JSON
+	sums string // checksum file name, also the query parameter for it in lower case
+	new  func() hash.Hash
+}
+
+var (
+	sha256Hash = &hashAlgo{"sha256", "SHA256SUMS", sha256.New}
+	blake3Hash = &hashAlgo{"blake3", "B3SUMS", func() hash.Hash { return blake3.New(32, nil) }}
+	xxh3Hash   = &hashAlgo{"xxh3", "XXH3SUMS", func() hash.Hash { return xxh3.New() }}
+)
+
+// supported hash algorithms
+var hashList = []*hashAlgo{sha256Hash, blake3Hash, xxh3Hash}
+
+// supported hash algorithms, by name
+var hashAlgos = map[string]*hashAlgo{
+	sha256Hash.name: sha256Hash,
+	blake3Hash.name: blake3Hash,
+	xxh3Hash.name:   xxh3Hash,
+}
+
+// queryHash returns the algorithm from "hash" query parameter, SHA-256 if not given
+func queryHash(query url.Values) (*hashAlgo, bool) {
+	s := query.Get("hash")
+
+	if len(s) == 0 {
+		return sha256Hash, true
+	}
+
+	algo, ok := hashAlgos[s]
+
+	return algo, ok
+}
+
+// querySum returns the expected hash of an upload from "<algo>=<hex>" query parameter, if any
+func querySum(query url.Values) (*hashAlgo, string) {
+	for _, algo := range hashList {
+		if s := query.Get(algo.name); len(s) > 0 {
+			return algo, s
+		}
+	}
+
+	return sha256Hash, ""
+}
@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net"
+
+	"github.com/juju/gnuflag"
+)
+
+// host name to show in the server URLs
+var displayHost string
+
+func init() {
+	gnuflag.StringVar(&displayHost, "hostname", "",
+		"Host name to use in the printed URLs and minted links, like \"nas.local\" (default: the IP address).")
+}
+
+// baseURL returns the URL of the share root, without the trailing slash, like "http://nas.local:8080"
+func baseURL() string {
+	if len(displayHost) == 0 {
+		return urlScheme() + "://" + serverAddr
+	}
+
+	_, port, err := net.SplitHostPort(serverAddr)
+
+	if err != nil {
+		return urlScheme() + "://" + serverAddr
+	}
+
+	return urlScheme() + "://" + net.JoinHostPort(displayHost, port)
+}
+
+// printURL logs the URLs of the share, one per listening address, or the one with the display host name,
+// checking the name resolves
+func printURL() {
+	if len(displayHost) > 0 {
+		if _, err := net.LookupHost(displayHost); err != nil {
+			log.Println("Warning: host name", displayHost, "does not resolve:", err)
+		}
+
+		log.Println("Share URL:", baseURL()+"/")
+		return
+	}
+
+	for _, addr := range serverAddrs {
+		log.Println("Share URL:", urlScheme()+"://"+addr+"/")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/gnuflag"
+	"github.com/maxim2266/mvr"
+)
+
+// idle period after which the server stops
+var idleTimeout time.Duration
+
+func init() {
+	gnuflag.DurationVar(&idleTimeout, "idle-timeout", 0,
+		"Stop the server after no requests and no open connections for the given period (e.g. 30m).")
+}
+
+// time of the last connection activity, in Unix nanoseconds
+var lastActivity int64
+
+// touchActivity records connection activity, postponing the idle shutdown
+func touchActivity() {
+	atomic.StoreInt64(&lastActivity, time.Now().UnixNano())
+}
+
+// startIdleTimer stops the server once it has been idle for the idle timeout
+func startIdleTimer() {
+	if idleTimeout <= 0 {
+		return
+	}
+
+	log.Println("Idle timeout:", idleTimeout)
+	touchActivity()
+
+	// check often enough for the shutdown to be reasonably punctual
+	period := idleTimeout / 10
+
+	if period > time.Minute {
+		period = time.Minute
+	} else if period < time.Second {
+		period = time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				idle := time.Since(time.Unix(0, atomic.LoadInt64(&lastActivity)))
+
+				if atomic.LoadInt64(&activeConns) == 0 && idle >= idleTimeout {
+					log.Println("Idle for", idle.Round(time.Second).String()+", shutting down")
+					mvr.Cancel()
+					return
+				}
+			case <-mvr.Done():
+				return
+			}
+		}
+	}()
+}
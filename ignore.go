@@ -0,0 +1,221 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// name of the per-directory ignore file
+const ignoreFileName = ".webshare-ignore"
+
+// gitignore-style rule
+type ignoreRule struct {
+	pattern  []string // path segments, "**" matches any number of segments
+	negate   bool     // "!pattern"
+	dirOnly  bool     // "pattern/"
+	anchored bool     // pattern with a slash is relative to the directory of the ignore file
+}
+
+// cached content of an ignore file
+type ignoreFile struct {
+	checked time.Time // last time the file has been checked for changes
+	mtime   time.Time
+	rules   []ignoreRule
+}
+
+var ignoreCache = struct {
+	sync.Mutex
+	files map[string]*ignoreFile
+}{
+	files: make(map[string]*ignoreFile),
+}
+
+// isIgnored checks if the path under the root directory is excluded by any of the ignore files
+func isIgnored(full string, isDir bool) bool {
+	rel, err := filepath.Rel(rootDir, full)
+
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+
+	// the default trash directory is never served
+	if parts[0] == trashDirName {
+		return true
+	}
+
+	// everything under an ignored directory is ignored as well; the ignore files themselves
+	// and temporary upload files are always ignored
+	for i := range parts {
+		if parts[i] == ignoreFileName || strings.HasPrefix(parts[i], uploadTempPrefix) ||
+			matchIgnore(parts, i, isDir || i < len(parts)-1) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchIgnore applies the rules from the root directory down to the parent of parts[i]
+// to the path parts[:i+1]; the last matching rule wins.
+func matchIgnore(parts []string, i int, isDir bool) (ignored bool) {
+	dir := rootDir
+
+	for j := 0; j <= i; j++ {
+		if j > 0 {
+			dir = filepath.Join(dir, parts[j-1])
+		}
+
+		for _, r := range ignoreRules(dir) {
+			if r.match(parts[j:i+1], isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+
+	return
+}
+
+func (r *ignoreRule) match(name []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	if r.anchored {
+		return matchSegments(r.pattern, name)
+	}
+
+	ok, _ := path.Match(r.pattern[0], name[len(name)-1])
+	return ok
+}
+
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		for k := 0; k <= len(name); k++ {
+			if matchSegments(pattern[1:], name[k:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+
+	ok, _ := path.Match(pattern[0], name[0])
+	return ok && matchSegments(pattern[1:], name[1:])
+}
+
+// ignoreRules returns the rules from the ignore file in the given directory, if any
+func ignoreRules(dir string) []ignoreRule {
+	ignoreCache.Lock()
+	defer ignoreCache.Unlock()
+
+	now := time.Now()
+	f := ignoreCache.files[dir]
+
+	if f != nil && now.Sub(f.checked) < time.Second {
+		return f.rules
+	}
+
+	name := filepath.Join(dir, ignoreFileName)
+	info, err := os.Stat(name)
+
+	if err != nil {
+		delete(ignoreCache.files, dir)
+		return nil
+	}
+
+	if f != nil && f.mtime.Equal(info.ModTime()) {
+		f.checked = now
+		return f.rules
+	}
+
+	f = &ignoreFile{checked: now, mtime: info.ModTime(), rules: readIgnoreFile(name)}
+	ignoreCache.files[dir] = f
+
+	return f.rules
+}
+
+func readIgnoreFile(name string) (rules []ignoreRule) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		return
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var r ignoreRule
+
+		if line[0] == '!' {
+			r.negate, line = true, line[1:]
+		} else if line[0] == '\\' {
+			line = line[1:] // escaped '#' or '!'
+		}
+
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+
+		if strings.Contains(line, "/") {
+			r.anchored, line = true, strings.TrimPrefix(line, "/")
+		}
+
+		if len(line) > 0 {
+			r.pattern = strings.Split(line, "/")
+			rules = append(rules, r)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,168 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// directories whose content never changes
+var immutableSpec string
+
+func init() {
+	gnuflag.StringVar(&immutableSpec, "immutable", "",
+		"Comma-separated list of directories (URL paths, like \"/releases\") whose files never change, and can be cached by the clients.")
+}
+
+// cache lifetime of the immutable files
+const immutableMaxAge = 365 * 24 * 60 * 60
+
+// withImmutable lets the clients cache the files in the immutable directories for a long time,
+// and revalidate the listings there with ETag, instead of the no-store policy everywhere else
+func withImmutable(next http.Handler) http.Handler {
+	var dirs []string
+
+	for _, s := range strings.Split(immutableSpec, ",") {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			dirs = append(dirs, path.Clean("/"+s))
+		}
+	}
+
+	if len(dirs) == 0 {
+		return next
+	}
+
+	log.Println("Immutable directories:", strings.Join(dirs, ", "))
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if (req.Method != http.MethodGet && req.Method != http.MethodHead) || len(req.URL.RawQuery) > 0 ||
+			!underAny(req.URL.Path, dirs) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		name := resolvePath(req.URL.Path)
+		info, err := os.Stat(name)
+
+		if err != nil || isIgnored(name, info.IsDir()) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		h := resp.Header()
+
+		h.Del("Pragma")
+		h.Del("Expires")
+
+		if !info.IsDir() {
+			// the file server answers conditional requests given the ETag
+			scope := "public"
+
+			// shared caches must not serve the files to other users
+			if len(authUser(req)) > 0 {
+				scope = "private"
+			}
+
+			h.Set("Cache-Control", scope+", max-age="+strconv.Itoa(immutableMaxAge)+", immutable")
+			h.Set("ETag", fileETag(info))
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		// new entries may appear in the listing, so it is revalidated every time
+		entries, err := listDir(name)
+
+		if err != nil {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		etag := listingETag(entries)
+
+		h.Set("Cache-Control", "no-cache")
+		h.Set("ETag", etag)
+
+		if etagMatch(req.Header.Get("If-None-Match"), etag) {
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// underAny checks if the URL path is one of the directories, or is under any of them
+func underAny(p string, dirs []string) bool {
+	p = path.Clean("/" + p)
+
+	for _, dir := range dirs {
+		if dir == "/" || p == dir || strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fileETag returns the entity tag derived from the size and the modification time of the file
+func fileETag(info os.FileInfo) string {
+	return `"` + strconv.FormatInt(info.Size(), 36) + "-" + strconv.FormatInt(info.ModTime().UnixNano(), 36) + `"`
+}
+
+// listingETag returns the entity tag of the directory listing, derived from its entries
+// with the fast non-cryptographic hash
+func listingETag(entries []listEntry) string {
+	h := xxh3Hash.new()
+
+	for _, e := range entries {
+		h.Write([]byte(e.Name + "\x00" + strconv.FormatInt(e.Size, 10) + "\x00" +
+			strconv.FormatInt(e.Mtime.UnixNano(), 10) + "\n"))
+	}
+
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// etagMatch checks the If-None-Match header value against the entity tag
+func etagMatch(header, etag string) bool {
+	for _, s := range strings.Split(header, ",") {
+		if s = strings.TrimPrefix(strings.TrimSpace(s), "W/"); s == etag || s == "*" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,360 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stops the archive scan
+var errScanDone = errors.New("scan done")
+
+// withArchiveBrowse serves the paths inside .zip, .tar, and .tar.gz files, like
+// "/backups/logs.zip/2023/app.log", without extracting the archive: directories inside the archive
+// are listed, and the members are streamed. ZIP files are read by random access, while tarballs
+// are read from the start up to the member.
+func withArchiveBrowse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		name, kind, member, ok := findArchive(req.URL.Path)
+
+		if !ok {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if len(member) == 0 || strings.HasSuffix(member, "/") {
+			serveArchiveListing(resp, req, name, kind, member)
+		} else {
+			serveArchiveMember(resp, req, name, kind, member)
+		}
+	})
+}
+
+// archiveKind returns the format of the archive by the file name, or an empty string
+func archiveKind(name string) string {
+	switch name = strings.ToLower(name); {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	default:
+		return ""
+	}
+}
+
+// findArchive splits the URL path pointing into an archive into the file name of the archive,
+// its format, and the path inside the archive
+func findArchive(urlPath string) (name, kind, member string, ok bool) {
+	for i := 1; i < len(urlPath); i++ {
+		if urlPath[i] != '/' {
+			continue
+		}
+
+		if kind = archiveKind(urlPath[:i]); len(kind) == 0 {
+			continue
+		}
+
+		name = resolvePath(urlPath[:i])
+		info, err := store.Stat(name)
+
+		if err != nil || !info.Mode().IsRegular() || isIgnored(name, false) {
+			return "", "", "", false
+		}
+
+		return name, kind, urlPath[i+1:], true
+	}
+
+	return "", "", "", false
+}
+
+// member of an archive
+type archiveMember struct {
+	Name  string // with trailing slash for directories
+	Size  int64
+	Mtime time.Time
+	Dir   bool
+}
+
+// scanArchive calls the function for every member of the archive, with the function to open
+// the content of the member; the scan stops when the function returns an error
+func scanArchive(name, kind string, fn func(m archiveMember, open func() (io.ReadCloser, error)) error) error {
+	file, err := store.Open(name)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if kind == "zip" {
+		info, err := file.Stat()
+
+		if err != nil {
+			return err
+		}
+
+		ra, ok := file.(io.ReaderAt)
+
+		if !ok {
+			return errors.New("random access is not supported by the storage")
+		}
+
+		zr, err := zip.NewReader(ra, info.Size())
+
+		if err != nil {
+			return err
+		}
+
+		for _, f := range zr.File {
+			m := archiveMember{
+				Name:  f.Name,
+				Size:  int64(f.UncompressedSize64),
+				Mtime: f.Modified,
+				Dir:   f.FileInfo().IsDir(),
+			}
+
+			if err = fn(m, f.Open); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var src io.Reader = file
+
+	if kind == "tar.gz" {
+		gz, err := gzip.NewReader(file)
+
+		if err != nil {
+			return err
+		}
+
+		defer gz.Close()
+
+		src = gz
+	}
+
+	tr := tar.NewReader(src)
+
+	for {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+
+		m := archiveMember{
+			Name:  hdr.Name,
+			Size:  hdr.Size,
+			Mtime: hdr.ModTime,
+			Dir:   hdr.Typeflag == tar.TypeDir,
+		}
+
+		err = fn(m, func() (io.ReadCloser, error) { return ioutil.NopCloser(tr), nil })
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// memberPath cleans up the name of the archive member
+func memberPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// serveArchiveListing lists the directory inside the archive
+func serveArchiveListing(resp http.ResponseWriter, req *http.Request, name, kind, dir string) {
+	if dir = memberPath(dir); len(dir) > 0 {
+		dir += "/"
+	}
+
+	seen := make(map[string]bool)
+	found := len(dir) == 0
+
+	var entries []archiveMember
+
+	err := scanArchive(name, kind, func(m archiveMember, _ func() (io.ReadCloser, error)) error {
+		p := memberPath(m.Name)
+
+		if !strings.HasPrefix(p+"/", dir) {
+			return nil
+		}
+
+		if found = true; len(p) < len(dir) {
+			return nil // the directory itself
+		}
+
+		// sub-directories, explicit or implied by the member paths
+		rest := p[len(dir):]
+
+		if i := strings.IndexByte(rest, '/'); i >= 0 || m.Dir {
+			if i >= 0 {
+				rest = rest[:i]
+			}
+
+			if !seen[rest] {
+				seen[rest] = true
+				entries = append(entries, archiveMember{Name: rest + "/", Dir: true})
+			}
+
+			return nil
+		}
+
+		m.Name = rest
+		entries = append(entries, m)
+		return nil
+	})
+
+	if err != nil {
+		log.Println(logTag(req), "Archive error:", err)
+		http.Error(resp, "Cannot read archive", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !found {
+		http.NotFound(resp, req)
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	if err = archiveTemplate.Execute(resp, entries); err != nil {
+		log.Println("Template error:", err)
+	}
+}
+
+var archiveTemplate = template.Must(template.New("archive").Funcs(template.FuncMap{
+	"href": func(name string) string { return (&url.URL{Path: name}).String() },
+}).Parse(`<!doctype html>
+<meta name="viewport" content="width=device-width">
+<pre>
+<a href="../">../</a>
+{{range .}}<a href="{{href .Name}}">{{.Name}}</a>
+{{end}}</pre>
+`))
+
+// serveArchiveMember streams the member of the archive
+func serveArchiveMember(resp http.ResponseWriter, req *http.Request, name, kind, member string) {
+	member = memberPath(member)
+	isDir := false
+
+	err := scanArchive(name, kind, func(m archiveMember, open func() (io.ReadCloser, error)) error {
+		p := memberPath(m.Name)
+
+		if strings.HasPrefix(p, member+"/") {
+			isDir = true
+			return errScanDone
+		}
+
+		if p != member || m.Dir {
+			return nil
+		}
+
+		src, err := open()
+
+		if err != nil {
+			return err
+		}
+
+		defer src.Close()
+
+		h := resp.Header()
+
+		if ctype := mime.TypeByExtension(path.Ext(p)); len(ctype) > 0 {
+			h.Set("Content-Type", ctype)
+		} else {
+			h.Set("Content-Type", "application/octet-stream")
+		}
+
+		h.Set("Content-Length", strconv.FormatInt(m.Size, 10))
+		h.Set("Accept-Ranges", "none")
+
+		if !m.Mtime.IsZero() {
+			h.Set("Last-Modified", m.Mtime.UTC().Format(http.TimeFormat))
+		}
+
+		if req.Method != http.MethodHead {
+			if _, err = io.Copy(resp, src); err != nil {
+				log.Println(logTag(req), "Archive transfer error:", err)
+			}
+		}
+
+		return errScanDone
+	})
+
+	switch {
+	case err == errScanDone && isDir:
+		http.Redirect(resp, req, path.Base(member)+"/", http.StatusMovedPermanently)
+	case err == errScanDone:
+		// served
+	case err != nil:
+		log.Println(logTag(req), "Archive error:", err)
+		http.Error(resp, "Cannot read archive", http.StatusUnprocessableEntity)
+	default:
+		http.NotFound(resp, req)
+	}
+}
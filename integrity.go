@@ -0,0 +1,130 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// withIntegrity adds SHA-256 digest of the body (RFC 3230) as a trailer to the generated responses,
+// like archives and listings, when the client asks for it with "Want-Digest: sha-256" and "TE: trailers"
+// headers. Such responses are sent chunked, since the trailer needs it. Plain files are not affected,
+// their hashes are available from the manifest API.
+func withIntegrity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet || !wantsDigest(req) || plainFile(req) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		w := &digestWriter{ResponseWriter: resp, hash: sha256.New()}
+
+		next.ServeHTTP(w, req)
+
+		if w.declared {
+			resp.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(w.hash.Sum(nil)))
+		}
+	})
+}
+
+// wantsDigest checks if the client asks for the SHA-256 trailer
+func wantsDigest(req *http.Request) bool {
+	if !strings.Contains(strings.ToLower(req.Header.Get("TE")), "trailers") {
+		return false
+	}
+
+	for _, s := range strings.Split(req.Header.Get("Want-Digest"), ",") {
+		if i := strings.IndexByte(s, ';'); i >= 0 {
+			s = s[:i]
+		}
+
+		if strings.EqualFold(strings.TrimSpace(s), "sha-256") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// plainFile checks if the request is for a regular file, without any query
+func plainFile(req *http.Request) bool {
+	if len(req.URL.RawQuery) > 0 {
+		return false
+	}
+
+	info, err := store.Stat(resolvePath(req.URL.Path))
+
+	return err == nil && info.Mode().IsRegular()
+}
+
+// response writer hashing the body of the streamed response
+type digestWriter struct {
+	http.ResponseWriter
+	hash     hash.Hash
+	wrote    bool // header written
+	declared bool // trailer declared
+}
+
+func (w *digestWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.wrote = true
+
+		if status == http.StatusOK {
+			w.Header().Del("Content-Length")
+			w.Header().Add("Trailer", "Digest")
+			w.declared = true
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *digestWriter) Write(data []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+
+	if w.declared {
+		w.hash.Write(data[:n])
+	}
+
+	return n, err
+}
+
+func (w *digestWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
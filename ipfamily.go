@@ -0,0 +1,94 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"net"
+	"sort"
+
+	"github.com/juju/gnuflag"
+)
+
+// address family selection
+var (
+	ipFamily   string
+	preferIPv6 bool
+)
+
+const (
+	ipFamilyUsage   = "Address family to listen on when there are both: \"4\", \"6\", or \"any\"."
+	preferIPv6Usage = "Put IPv6 addresses first, so that the advertised URL uses IPv6."
+)
+
+func init() {
+	gnuflag.StringVar(&ipFamily, "ip-family", "any", ipFamilyUsage)
+	gnuflag.BoolVar(&preferIPv6, "prefer-ipv6", false, preferIPv6Usage)
+}
+
+// orderIPs returns the addresses of the selected family, the preferred family first. The order
+// of IPv4 addresses is kept, so the primary one comes before the aliases.
+func orderIPs(v4, v6 []string) []string {
+	sortIPv6(v6)
+
+	switch ipFamily {
+	case "4":
+		return v4
+	case "6":
+		return v6
+	case "any", "":
+		if preferIPv6 {
+			return append(v6, v4...)
+		}
+
+		return append(v4, v6...)
+	default:
+		die("Invalid address family: "+ipFamily+" (expected 4, 6, or any)", nil)
+		return nil // not reached
+	}
+}
+
+// sortIPv6 puts global IPv6 addresses before the unique local ones (fc00::/7)
+func sortIPv6(ips []string) {
+	sort.SliceStable(ips, func(i, j int) bool {
+		return !isULA(ips[i]) && isULA(ips[j])
+	})
+}
+
+// isULA checks if the address is IPv6 unique local one
+func isULA(s string) bool {
+	ip := net.ParseIP(s)
+
+	return ip != nil && ip.To4() == nil && ip[0]&0xfe == 0xfc
+}
+
+// ipFamilyFlags adds address family options to the subcommand flags
+func ipFamilyFlags(flags *gnuflag.FlagSet) {
+	flags.StringVar(&ipFamily, "ip-family", "any", ipFamilyUsage)
+	flags.BoolVar(&preferIPv6, "prefer-ipv6", false, preferIPv6Usage)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// connection reuse settings
+var (
+	keepAlive    time.Duration
+	maxIdleConns uint
+	tcpNoDelay   bool
+)
+
+func init() {
+	gnuflag.DurationVar(&keepAlive, "keep-alive", 2*time.Minute,
+		"How long to keep an idle client connection open for the next request; 0 disables keep-alive.")
+	gnuflag.UintVar(&maxIdleConns, "max-idle-conns", 0,
+		"Maximum number of idle keep-alive connections, the longest idle ones are closed beyond it; 0 for no limit.")
+	gnuflag.BoolVar(&tcpNoDelay, "tcp-nodelay", true,
+		"Send small responses immediately (TCP_NODELAY); with false, small writes are coalesced into fewer packets.")
+}
+
+// tuneServer applies the keep-alive settings to the server
+func tuneServer(srv *http.Server) {
+	if keepAlive <= 0 {
+		srv.SetKeepAlivesEnabled(false)
+		log.Println("Keep-alive disabled")
+		return
+	}
+
+	srv.IdleTimeout = keepAlive
+}
+
+// listener applying the socket options to the accepted connections
+type tunedListener struct {
+	net.Listener
+}
+
+func (l tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+
+	if tc, ok := conn.(*net.TCPConn); ok && !tcpNoDelay {
+		tc.SetNoDelay(false)
+	}
+
+	return conn, err
+}
+
+// idle keep-alive connections, with the time each has become idle
+var idleConns = struct {
+	sync.Mutex
+	since map[net.Conn]time.Time
+}{
+	since: make(map[net.Conn]time.Time),
+}
+
+// trackIdle keeps the number of idle connections within the limit, closing the longest idle ones
+func trackIdle(conn net.Conn, state http.ConnState) {
+	if maxIdleConns == 0 {
+		return
+	}
+
+	idleConns.Lock()
+	defer idleConns.Unlock()
+
+	if state != http.StateIdle {
+		delete(idleConns.since, conn)
+		return
+	}
+
+	idleConns.since[conn] = time.Now()
+
+	for uint(len(idleConns.since)) > maxIdleConns {
+		var oldest net.Conn
+		var ts time.Time
+
+		for c, t := range idleConns.since {
+			if oldest == nil || t.Before(ts) {
+				oldest, ts = c, t
+			}
+		}
+
+		delete(idleConns.since, oldest)
+		oldest.Close()
+	}
+}
@@ -0,0 +1,484 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// short link, like "/s/abc123"
+type shortLink struct {
+	Path    string    `json:"path"`              // URL path of the target
+	Expires time.Time `json:"expires,omitempty"` // zero for no expiry
+	Left    int       `json:"left,omitempty"`    // remaining number of downloads, 0 for unlimited
+	limited bool
+	pending int         // downloads in progress, each holding one of the remaining
+	size    int64       // size of the file the ranges below are of
+	got     []byteRange // parts of the file delivered so far, sorted and merged
+}
+
+var shortLinks = struct {
+	sync.Mutex
+	links map[string]*shortLink
+}{
+	links: make(map[string]*shortLink),
+}
+
+// short link path prefix
+const shortLinkPrefix = "/s/"
+
+func init() {
+	controlMux.HandleFunc("/link", serveMintLink)
+	apiMux.HandleFunc("/_api/links", serveLinksAPI)
+}
+
+// withShortLinks serves short links as their targets
+func withShortLinks(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, shortLinkPrefix) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		id := req.URL.Path[len(shortLinkPrefix):]
+		target, reserved, ok := useShortLink(id, req.Method == http.MethodGet || req.Method == http.MethodHead)
+
+		if !ok {
+			log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: no such link")
+			servePage(resp, http.StatusNotFound, "Link not found",
+				"This link does not exist, has expired, or has been used up.", time.Time{})
+			return
+		}
+
+		// directories are not served under the short link, because of the relative links in the listing
+		if info, err := os.Stat(resolvePath(target)); err == nil && info.IsDir() {
+			if reserved {
+				releaseShortLink(id, false, byteRange{}, 0)
+			}
+
+			http.Redirect(resp, req, (&url.URL{Path: target + "/"}).EscapedPath(), http.StatusFound)
+			return
+		}
+
+		req.URL.Path = target
+		req.URL.RawPath = ""
+
+		if !reserved {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		// a download via a limited link counts when the parts of the file delivered via the link
+		// cover the whole file, so that neither a probe for a few bytes, nor an interrupted download
+		// uses up the link, and the download can be resumed with a range request; multiple ranges
+		// are served as the whole file, and the ranges are not advertised, so that the download
+		// clients do not split the download into several requests
+		if strings.Contains(req.Header.Get("Range"), ",") {
+			req.Header.Del("Range")
+		}
+
+		tw := &trackingWriter{ResponseWriter: resp}
+
+		defer func() {
+			r, size, ok := deliveredRange(tw)
+
+			releaseShortLink(id, req.Method == http.MethodGet && ok, r, size)
+		}()
+
+		next.ServeHTTP(&noRangesWriter{tw}, req)
+	})
+}
+
+// deliveredRange returns the part of the file sent in the response, with the size of the file
+func deliveredRange(tw *trackingWriter) (byteRange, int64, bool) {
+	header := tw.Header()
+
+	switch tw.Status() {
+	case http.StatusOK:
+		size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+
+		return byteRange{0, tw.sent - 1}, size, err == nil
+	case http.StatusPartialContent:
+		var first, last, size int64
+
+		if _, err := fmt.Sscanf(header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &size); err != nil {
+			return byteRange{}, 0, false
+		}
+
+		return byteRange{first, first + tw.sent - 1}, size, true
+	default:
+		return byteRange{}, 0, false
+	}
+}
+
+// noRangesWriter tells the client the ranges are not supported
+type noRangesWriter struct {
+	*trackingWriter
+}
+
+func (w *noRangesWriter) WriteHeader(status int) {
+	w.Header().Set("Accept-Ranges", "none")
+	w.trackingWriter.WriteHeader(status)
+}
+
+func (w *noRangesWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.trackingWriter.Write(data)
+}
+
+// ReadFrom keeps the sendfile(2) optimisation of the underlying writer
+func (w *noRangesWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.trackingWriter.ReadFrom(src)
+}
+
+// useShortLink returns the target path of the link; with reserve set, one of the remaining downloads
+// of a limited link is reserved, and must be released with releaseShortLink
+func useShortLink(id string, reserve bool) (target string, reserved, ok bool) {
+	shortLinks.Lock()
+	defer shortLinks.Unlock()
+
+	link := shortLinks.links[id]
+
+	if link == nil {
+		return
+	}
+
+	if !link.Expires.IsZero() && time.Now().After(link.Expires) {
+		delete(shortLinks.links, id)
+		return
+	}
+
+	if reserve && link.limited {
+		if link.pending >= link.Left {
+			return
+		}
+
+		link.pending++
+		reserved = true
+	}
+
+	return link.Path, reserved, true
+}
+
+// releaseShortLink releases the reserved download, adding the delivered range of the file, if any;
+// a download is counted when the delivered ranges cover the whole file, removing the link when
+// it is used up
+func releaseShortLink(id string, delivered bool, r byteRange, size int64) {
+	shortLinks.Lock()
+	defer shortLinks.Unlock()
+
+	link := shortLinks.links[id]
+
+	if link == nil {
+		return
+	}
+
+	link.pending--
+
+	if !delivered || !link.deliver(r, size) {
+		return
+	}
+
+	if link.Left--; link.Left == 0 {
+		delete(shortLinks.links, id)
+		log.Println("Short link", shortLinkPrefix+id, "used up")
+	}
+}
+
+// deliver adds the range to the delivered parts of the file, returning true when they cover
+// the whole file, and starting over for the next download
+func (link *shortLink) deliver(r byteRange, size int64) bool {
+	// the file has changed
+	if size != link.size {
+		link.size, link.got = size, nil
+	}
+
+	if r.end >= r.start {
+		got := append(link.got, r)
+
+		sort.Slice(got, func(i, j int) bool { return got[i].start < got[j].start })
+
+		link.got = got[:1]
+
+		for _, r := range got[1:] {
+			last := &link.got[len(link.got)-1]
+
+			if r.start > last.end+1 {
+				link.got = append(link.got, r)
+			} else if r.end > last.end {
+				last.end = r.end
+			}
+		}
+	}
+
+	if size > 0 && (len(link.got) != 1 || link.got[0].start > 0 || link.got[0].end < size-1) {
+		return false
+	}
+
+	link.got = nil
+	return true
+}
+
+// mintShortLink creates a new short link to the path
+func mintShortLink(target string, ttl time.Duration, max int) (string, *shortLink) {
+	link := &shortLink{Path: target, Left: max, limited: max > 0}
+
+	if ttl > 0 {
+		link.Expires = time.Now().Add(ttl)
+	}
+
+	shortLinks.Lock()
+	defer shortLinks.Unlock()
+
+	for {
+		id := shortID(8)
+
+		if shortLinks.links[id] == nil {
+			shortLinks.links[id] = link
+			return id, link
+		}
+	}
+}
+
+// shortID returns a random string of the given length made of letters and digits
+func shortID(n int) string {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	id := make([]byte, n)
+	limit := big.NewInt(int64(len(chars)))
+
+	for i := range id {
+		k, err := rand.Int(rand.Reader, limit)
+
+		if err != nil {
+			die("Cannot generate random ID", err)
+		}
+
+		id[i] = chars[k.Int64()]
+	}
+
+	return string(id)
+}
+
+// control request to mint a short link
+type linkRequest struct {
+	Path string        `json:"path"` // URL path, or absolute file system path under the root directory
+	TTL  time.Duration `json:"ttl"`
+	Max  int           `json:"max"`
+}
+
+// control response with the new short link
+type linkResponse struct {
+	URL string `json:"url"`
+	shortLink
+}
+
+// "/link" control endpoint
+func serveMintLink(resp http.ResponseWriter, req *http.Request) {
+	var lr linkRequest
+
+	if !readControlRequest(resp, req, &lr) {
+		return
+	}
+
+	if lr.TTL < 0 || lr.Max < 0 {
+		http.Error(resp, "Invalid link constraints", http.StatusBadRequest)
+		return
+	}
+
+	target, info := controlTarget(lr.Path)
+
+	if info == nil {
+		http.Error(resp, "Not found: "+target, http.StatusNotFound)
+		return
+	}
+
+	id, link := mintShortLink(target, lr.TTL, lr.Max)
+
+	log.Println("Short link", shortLinkPrefix+id, "->", target)
+
+	res := linkResponse{
+		URL:       baseURL() + shortLinkPrefix + id,
+		shortLink: *link,
+	}
+
+	writeJSON(resp, res)
+}
+
+// POST /_api/links with {"path": "/docs/report.pdf", "ttl": "24h", "max": 1}: mints a short link
+// to the file or directory; by default the link is good for one complete download, and never expires
+func serveLinksAPI(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		resp.Header().Set("Allow", "POST")
+		apiError(resp, req, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if !jsonRequest(resp, req) {
+		return
+	}
+
+	var lr struct {
+		Path string `json:"path"`
+		TTL  string `json:"ttl"`
+		Max  *int   `json:"max"`
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(resp, req.Body, 1<<16)).Decode(&lr); err != nil {
+		apiError(resp, req, http.StatusBadRequest, "Invalid request: "+err.Error())
+		return
+	}
+
+	var ttl time.Duration
+
+	if len(lr.TTL) > 0 {
+		var err error
+
+		if ttl, err = time.ParseDuration(lr.TTL); err != nil {
+			apiError(resp, req, http.StatusBadRequest, "Invalid link lifetime: "+lr.TTL)
+			return
+		}
+	}
+
+	max := 1
+
+	if lr.Max != nil {
+		max = *lr.Max
+	}
+
+	if ttl < 0 || max < 0 {
+		apiError(resp, req, http.StatusBadRequest, "Invalid link constraints")
+		return
+	}
+
+	target, info := urlTarget(lr.Path)
+
+	if info == nil {
+		apiError(resp, req, http.StatusNotFound, "Not found: "+target)
+		return
+	}
+
+	id, link := mintShortLink(target, ttl, max)
+
+	log.Println(logTag(req), "Short link", shortLinkPrefix+id, "->", target)
+
+	writeJSON(resp, &linkResponse{
+		URL:       baseURL() + shortLinkPrefix + id,
+		shortLink: *link,
+	})
+}
+
+// controlTarget converts the path from a control request, either URL path or absolute file system
+// path under the root directory, to URL path, returning nil info if there is nothing to serve
+func controlTarget(p string) (string, os.FileInfo) {
+	if rel, err := filepath.Rel(rootDir, p); err == nil && filepath.IsAbs(p) && !strings.HasPrefix(rel, "..") {
+		p = filepath.ToSlash(rel)
+	}
+
+	return urlTarget(p)
+}
+
+// urlTarget cleans the URL path, returning nil info if there is nothing to serve
+func urlTarget(p string) (string, os.FileInfo) {
+	target := path.Clean("/" + p)
+	name := resolvePath(target)
+	info, err := os.Stat(name)
+
+	if err != nil || isSpecial(info) || isIgnored(name, info.IsDir()) {
+		return target, nil
+	}
+
+	return target, info
+}
+
+// "ctl link" command
+func ctlLinkCommand(client *ctlClient, args []string) int {
+	var lr linkRequest
+
+	flags := gnuflag.NewFlagSet("ctl link", gnuflag.ExitOnError)
+
+	flags.DurationVar(&lr.TTL, "ttl", 0, "Link lifetime, 0 for unlimited.")
+	flags.IntVar(&lr.Max, "max", 0, "Maximum number of downloads, 0 for unlimited.")
+
+	flags.Parse(true, args)
+
+	if flags.NArg() != 1 {
+		die("Usage: "+os.Args[0]+" ctl link <path> [--ttl 1h] [--max 1]", nil)
+	}
+
+	lr.Path = controlPath(flags.Arg(0))
+
+	var res linkResponse
+
+	if err := client.call("/link", &lr, &res); err != nil {
+		return printCtlError(err)
+	}
+
+	fmt.Println(res.URL)
+	return 0
+}
+
+// controlPath returns the path argument for a control request; local paths are sent as absolute
+func controlPath(p string) string {
+	if strings.HasPrefix(p, "/") {
+		return p
+	}
+
+	p, err := filepath.Abs(p)
+
+	if err != nil {
+		die("Invalid path", err)
+	}
+
+	return p
+}
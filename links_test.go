@@ -0,0 +1,138 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import "testing"
+
+func TestShortLinkDeliver(t *testing.T) {
+	type step struct {
+		r    byteRange
+		size int64
+		done bool
+	}
+
+	cases := []struct {
+		name  string
+		steps []step
+	}{
+		{"whole file", []step{
+			{byteRange{0, 99}, 100, true},
+			{byteRange{0, 99}, 100, true},
+		}},
+		{"empty file", []step{
+			{byteRange{0, -1}, 0, true},
+		}},
+		{"pieces in order", []step{
+			{byteRange{0, 49}, 100, false},
+			{byteRange{50, 99}, 100, true},
+		}},
+		{"pieces out of order, overlapping", []step{
+			{byteRange{60, 99}, 100, false},
+			{byteRange{0, 29}, 100, false},
+			{byteRange{20, 70}, 100, true},
+		}},
+		{"probes of the same part", []step{
+			{byteRange{0, 0}, 100, false},
+			{byteRange{0, 0}, 100, false},
+			{byteRange{99, 99}, 100, false},
+		}},
+		{"gap", []step{
+			{byteRange{0, 49}, 100, false},
+			{byteRange{51, 99}, 100, false},
+			{byteRange{50, 50}, 100, true},
+		}},
+		{"nothing delivered", []step{
+			{byteRange{0, 49}, 100, false},
+			{byteRange{0, -1}, 100, false},
+		}},
+		{"starts over after a download", []step{
+			{byteRange{0, 99}, 100, true},
+			{byteRange{0, 49}, 100, false},
+			{byteRange{50, 99}, 100, true},
+		}},
+		{"file changed", []step{
+			{byteRange{0, 49}, 100, false},
+			{byteRange{50, 119}, 120, false},
+			{byteRange{0, 49}, 120, true},
+		}},
+	}
+
+	for _, c := range cases {
+		var link shortLink
+
+		for i, s := range c.steps {
+			if done := link.deliver(s.r, s.size); done != s.done {
+				t.Errorf("%s, step %d: got %v", c.name, i, done)
+			}
+		}
+	}
+}
+
+func TestShortLinkCount(t *testing.T) {
+	const id = "test"
+
+	shortLinks.links[id] = &shortLink{Path: "/a.txt", Left: 2, limited: true}
+
+	defer delete(shortLinks.links, id)
+
+	use := func() bool {
+		_, reserved, ok := useShortLink(id, true)
+		return ok && reserved
+	}
+
+	// two downloads at a time at most, as only two are left
+	if !use() || !use() || use() {
+		t.Fatal("wrong number of reservations")
+	}
+
+	// interrupted download, resumed later
+	releaseShortLink(id, true, byteRange{0, 49}, 100)
+	releaseShortLink(id, false, byteRange{}, 0)
+
+	if !use() {
+		t.Fatal("no reservation")
+	}
+
+	releaseShortLink(id, true, byteRange{50, 99}, 100)
+
+	if left := shortLinks.links[id].Left; left != 1 {
+		t.Fatalf("got %d downloads left", left)
+	}
+
+	// the last download removes the link
+	if !use() {
+		t.Fatal("no reservation")
+	}
+
+	releaseShortLink(id, true, byteRange{0, 99}, 100)
+
+	if _, _, ok := useShortLink(id, true); ok {
+		t.Fatal("the link is not removed")
+	}
+}
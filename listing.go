@@ -0,0 +1,252 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// directories with more entries than this are listed page by page
+const listingPageSize = 1000
+
+// maximum page size for the listing API
+const maxListingLimit = 10000
+
+func init() {
+	apiMux.HandleFunc("/api/list", serveList)
+	apiMux.HandleFunc("/api/list/", serveList)
+}
+
+// directory entry, as returned by the listing API
+type listEntry struct {
+	Name  string    `json:"name"` // with trailing slash for directories
+	Size  int64     `json:"size"`
+	Mtime time.Time `json:"mtime"`
+	Dir   bool      `json:"dir,omitempty"`
+	Type  string    `json:"type,omitempty"` // MIME type by the file name extension
+}
+
+// listDir returns the visible entries of the directory, sorted by name
+func listDir(dir string) ([]listEntry, error) {
+	list, err := store.ReadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]listEntry, 0, len(list))
+
+	for _, info := range list {
+		if hiddenEntry(dir, info) {
+			continue
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := store.Stat(filepath.Join(dir, info.Name())); err == nil {
+				info = target
+			}
+		}
+
+		e := listEntry{Name: info.Name(), Mtime: info.ModTime(), Dir: info.IsDir()}
+
+		if e.Dir {
+			e.Name += "/"
+		} else {
+			e.Size = info.Size()
+			e.Type = mime.TypeByExtension(filepath.Ext(e.Name))
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// filterSince removes the files modified before the given time; directories are kept
+func filterSince(entries []listEntry, since time.Time) []listEntry {
+	if since.IsZero() {
+		return entries
+	}
+
+	res := entries[:0]
+
+	for _, e := range entries {
+		if e.Dir || modifiedSince(e.Mtime, since) {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+// listPage parses offset and limit query parameters, and returns the page of the entries
+func listPage(entries []listEntry, query url.Values) ([]listEntry, int, bool) {
+	offset, limit := 0, listingPageSize
+
+	if s := query.Get("offset"); len(s) > 0 {
+		var err error
+
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			return nil, 0, false
+		}
+	}
+
+	if s := query.Get("limit"); len(s) > 0 {
+		var err error
+
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > maxListingLimit {
+			return nil, 0, false
+		}
+	}
+
+	if offset > len(entries) {
+		offset = len(entries)
+	}
+
+	if end := offset + limit; end < len(entries) {
+		return entries[offset:end], offset, true
+	}
+
+	return entries[offset:], offset, true
+}
+
+// GET /api/list[/<path>]?offset=<n>&limit=<n>[&since=<time>]: page of the directory entries, sorted by name
+func serveList(resp http.ResponseWriter, req *http.Request) {
+	dir := apiTarget(req, "/api/list")
+
+	if info, err := store.Stat(dir); err != nil || !info.IsDir() || isIgnored(dir, true) {
+		apiError(resp, req, http.StatusNotFound, "Not found")
+		return
+	}
+
+	since, err := parseSince(req.URL.Query())
+
+	if err != nil {
+		apiError(resp, req, http.StatusBadRequest, "Invalid since parameter")
+		return
+	}
+
+	entries, err := listDir(dir)
+
+	if err != nil {
+		log.Println(logTag(req), "Listing error:", err)
+		apiError(resp, req, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	entries = filterSince(entries, since)
+	page, offset, ok := listPage(entries, req.URL.Query())
+
+	if !ok {
+		apiError(resp, req, http.StatusBadRequest, "Invalid offset or limit")
+		return
+	}
+
+	writeJSON(resp, struct {
+		Total   int         `json:"total"`
+		Offset  int         `json:"offset"`
+		Entries []listEntry `json:"entries"`
+	}{len(entries), offset, page})
+}
+
+// withPaging lists huge directories page by page, with the following pages loaded by the browser
+// as the listing is scrolled down; without JavaScript the pages are linked. Listings with "since"
+// parameter only show the files modified since the given time.
+func withPaging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+
+		if req.Method != http.MethodGet || !strings.HasSuffix(req.URL.Path, "/") ||
+			(len(query) > 0 && len(query["offset"]) == 0 && len(query["since"]) == 0) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		since, err := parseSince(query)
+
+		if err != nil {
+			http.Error(resp, "Invalid since parameter", http.StatusBadRequest)
+			return
+		}
+
+		dir := resolvePath(req.URL.Path)
+
+		if info, err := store.Stat(dir); err != nil || !info.IsDir() || isIgnored(dir, true) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if hasIndex(dir) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		entries, err := listDir(dir)
+
+		if err != nil || (len(entries) <= listingPageSize && len(query) == 0) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		entries = filterSince(entries, since)
+		page, offset, ok := listPage(entries, query)
+
+		if !ok {
+			http.Error(resp, "Invalid offset or limit", http.StatusBadRequest)
+			return
+		}
+
+		data := newListingData(req.URL.Path, page)
+
+		data.Gallery = hasImages(page)
+		data.Paging = &listingPaging{
+			API:     (&url.URL{Path: "/api/list" + path.Clean(req.URL.Path)}).EscapedPath(),
+			Since:   sinceString(since),
+			Total:   len(entries),
+			Next:    offset + len(page),
+			Limit:   len(page),
+			Width:   listingNameWidth,
+			HasNext: offset+len(page) < len(entries),
+			Partial: offset > 0,
+		}
+
+		serveListing(resp, req, data)
+	})
+}
@@ -0,0 +1,277 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"embed"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// hasIndex checks if the directory has index.html, which replaces the listing
+func hasIndex(dir string) bool {
+	_, err := store.Stat(filepath.Join(dir, "index.html"))
+	return err == nil
+}
+
+// longest file name shown in full in the listing
+const listingNameWidth = 48
+
+// withListing renders the directory listings with the breadcrumbs, file type icons, sizes,
+// and modification times, sortable in the browser by any column. The entries are kept as links
+// in a <pre> block, one per line, as in the listings of http.FileServer, so the scripts decorating
+// the listing keep working. With "search" parameter the listing shows the files matching
+// the pattern in the whole subtree instead.
+func withListing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if (req.Method != http.MethodGet && req.Method != http.MethodHead) || !strings.HasSuffix(req.URL.Path, "/") {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		dir := resolvePath(req.URL.Path)
+
+		if info, err := store.Stat(dir); err != nil || !info.IsDir() || isIgnored(dir, true) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if hasIndex(dir) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		var entries []listEntry
+		var search string
+		var truncated bool
+		var err error
+
+		if query := req.URL.Query(); len(query["search"]) > 0 {
+			query.Set("q", query.Get("search"))
+			limit, ok := searchQuery(query)
+
+			if !ok {
+				http.Error(resp, "Invalid search pattern", http.StatusBadRequest)
+				return
+			}
+
+			search = query.Get("q")
+			entries, truncated, err = searchEntries(req.Context(), dir, search, limit)
+
+			if err != nil {
+				log.Println(logTag(req), "Search error:", err)
+				http.Error(resp, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		} else if entries, err = listDir(dir); err != nil {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		data := newListingData(req.URL.Path, entries)
+
+		data.Search, data.Truncated = search, truncated
+		data.Gallery = len(search) == 0 && hasImages(entries)
+
+		serveListing(resp, req, data)
+	})
+}
+
+// newListingData prepares the listing of the entries of the directory at the URL path
+func newListingData(urlPath string, entries []listEntry) *listingData {
+	data := &listingData{
+		Path:    path.Clean(urlPath),
+		Server:  filepath.Base(os.Args[0]),
+		HeadPad: strings.Repeat(" ", listingNameWidth-len("Name")+1+8-len("Size")),
+	}
+
+	// breadcrumbs
+	data.Crumbs = append(data.Crumbs, crumb{Name: "share", Href: "/"})
+
+	if data.Path != "/" {
+		parts := strings.Split(strings.TrimPrefix(data.Path, "/"), "/")
+
+		for i, p := range parts {
+			data.Crumbs = append(data.Crumbs, crumb{
+				Name: p,
+				Href: (&url.URL{Path: "/" + strings.Join(parts[:i+1], "/") + "/"}).EscapedPath(),
+			})
+		}
+	}
+
+	data.Crumbs[len(data.Crumbs)-1].Href = ""
+
+	// rows, directories first
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Dir && !entries[j].Dir })
+
+	for _, e := range entries {
+		r := listingRow{listEntry: e, Icon: fileIcon(e), Label: e.Name}
+
+		if !e.Dir {
+			r.Thumb = thumbnailURL(path.Join(data.Path, e.Name), thumbSize)
+		}
+
+		if n := utf8.RuneCountInString(r.Label); n > listingNameWidth {
+			r.Label = string([]rune(r.Label)[:listingNameWidth-3]) + "..>"
+			n = listingNameWidth
+		} else {
+			r.Pad = strings.Repeat(" ", listingNameWidth-n)
+		}
+
+		r.Mod = e.Mtime.Local().Format("2006-01-02 15:04")
+
+		if e.Dir {
+			data.Dirs++
+			r.SizeText = "-"
+		} else {
+			data.Files++
+			data.Total += e.Size
+			r.SizeText = sizeString(e.Size)
+		}
+
+		r.SizeText = strings.Repeat(" ", 8-len(r.SizeText)) + r.SizeText
+		data.Rows = append(data.Rows, r)
+	}
+
+	data.TotalText = sizeString(data.Total)
+	return data
+}
+
+// serveListing renders the listing page
+func serveListing(resp http.ResponseWriter, req *http.Request, data *listingData) {
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	if err := listingTemplate.Execute(resp, data); err != nil {
+		log.Println("Template error:", err)
+	}
+}
+
+type crumb struct {
+	Name, Href string
+}
+
+type listingRow struct {
+	listEntry
+	Icon, Label, Pad, SizeText, Mod string
+	Thumb                           string // thumbnail URL, if any
+}
+
+type listingData struct {
+	Path, Server, HeadPad string
+	Crumbs                []crumb
+	Rows                  []listingRow
+	Dirs, Files           int
+	Total                 int64
+	TotalText             string
+	Search                string // search pattern, if any
+	Truncated             bool   // the search stopped at the limit
+	Gallery               bool   // the directory has images to show in the gallery
+	Paging                *listingPaging
+}
+
+// page of a huge directory listing
+type listingPaging struct {
+	API, Since       string // URL path of the listing API, and "since" parameter, if any
+	Total, Next      int    // number of entries, and the offset of the next page
+	Limit            int    // page size
+	Width            int    // longest file name shown in full
+	HasNext, Partial bool   // there are more pages, and the listing does not start with the first one
+}
+
+// searchEntries returns the files matching the pattern, with the paths relative to the directory as the names
+func searchEntries(ctx context.Context, dir, pattern string, limit int) ([]listEntry, bool, error) {
+	files, truncated, err := searchFiles(ctx, dir, pattern, limit)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	entries := make([]listEntry, len(files))
+
+	for i, f := range files {
+		entries[i] = listEntry{Name: f.Path, Size: f.Size, Mtime: f.Mtime, Type: mime.TypeByExtension(path.Ext(f.Path))}
+	}
+
+	return entries, truncated, nil
+}
+
+// fileIcon returns the icon of the file type
+func fileIcon(e listEntry) string {
+	if e.Dir {
+		return "\U0001F4C1" // folder
+	}
+
+	ext := strings.ToLower(filepath.Ext(e.Name))
+
+	switch ext {
+	case ".zip", ".tar", ".gz", ".tgz", ".bz2", ".xz", ".zst", ".7z", ".rar":
+		return "\U0001F4E6" // package
+	case ".pdf":
+		return "\U0001F4D5" // book
+	case ".iso", ".img", ".dmg":
+		return "\U0001F4BF" // disc
+	}
+
+	t, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+
+	switch {
+	case strings.HasPrefix(t, "image/"):
+		return "\U0001F5BC" // picture
+	case strings.HasPrefix(t, "video/"):
+		return "\U0001F39E" // film
+	case strings.HasPrefix(t, "audio/"):
+		return "\U0001F3B5" // note
+	case strings.HasPrefix(t, "text/"):
+		return "\U0001F4DD" // memo
+	default:
+		return "\U0001F4C4" // page
+	}
+}
+
+//go:embed templates/listing.html
+var templateFS embed.FS // the listing page template
+
+var listingTemplate = template.Must(template.New("listing.html").Funcs(template.FuncMap{
+	"href": func(name string) string { return (&url.URL{Path: name}).String() },
+	"unix": func(e listingRow) int64 { return e.Mtime.Unix() },
+}).ParseFS(templateFS, "templates/listing.html"))
@@ -0,0 +1,173 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// login form
+var (
+	loginForm  bool
+	sessionTTL time.Duration
+)
+
+func init() {
+	gnuflag.BoolVar(&loginForm, "login-form", false,
+		"Let browsers log in via a form, instead of the browser's authentication dialog (implied by --totp-secrets).")
+	gnuflag.DurationVar(&sessionTTL, "session-ttl", 7*24*time.Hour,
+		"Lifetime of a session started from the login form.")
+}
+
+const (
+	loginPath     = "/_login"
+	logoutPath    = "/_logout"
+	sessionCookie = "web-share-session"
+)
+
+// delay after a failed login attempt, to slow down guessing
+const loginFailDelay = time.Second
+
+func setupLogin() {
+	if len(totpFile) > 0 {
+		loginForm = true
+	}
+
+	if !loginForm {
+		return
+	}
+
+	if len(passwordCheckers) == 0 {
+		die("Login form requires a password file, like --htpasswd or --digest-auth", nil)
+	}
+
+	if sessionTTL <= 0 {
+		die("Invalid session lifetime: "+sessionTTL.String(), nil)
+	}
+
+	log.Println("Login form enabled at", loginPath)
+}
+
+// GET shows the login form, POST checks the credentials and starts a session
+func serveLogin(resp http.ResponseWriter, req *http.Request) {
+	data := struct {
+		User, Next, Error string
+		TOTP              bool
+	}{
+		Next: req.FormValue("next"),
+		TOTP: len(totp.secrets) > 0,
+	}
+
+	// only local redirects
+	if !strings.HasPrefix(data.Next, "/") || strings.HasPrefix(data.Next, "//") || strings.HasPrefix(data.Next, "/\\") {
+		data.Next = "/"
+	}
+
+	status := http.StatusOK
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		// the form
+	case http.MethodPost:
+		data.User = req.PostFormValue("user")
+
+		err := checkPassword(data.User, req.PostFormValue("password"))
+
+		if err == nil && hasTOTP(data.User) {
+			err = checkTOTP(data.User, req.PostFormValue("code"))
+		}
+
+		if err == nil {
+			log.Println(logTag(req), "User", data.User, "logged in")
+			startSession(resp, req, data.User)
+			http.Redirect(resp, req, data.Next, http.StatusSeeOther)
+			return
+		}
+
+		log.Println(logTag(req), "Login failed for", data.User+":", err)
+		time.Sleep(loginFailDelay)
+
+		data.Error = "Invalid user name, password, or code."
+		status = http.StatusUnauthorized
+	default:
+		http.Error(resp, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	resp.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	resp.WriteHeader(status)
+
+	if err := loginTemplate.Execute(resp, &data); err != nil {
+		log.Println("Template error:", err)
+	}
+}
+
+// ends the session and returns to the login form
+func serveLogout(resp http.ResponseWriter, req *http.Request) {
+	if cookie, err := req.Cookie(sessionCookie); err == nil {
+		endSession(cookie.Value)
+	}
+
+	http.SetCookie(resp, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(resp, req, loginPath, http.StatusSeeOther)
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Log in</title>
+<style>
+body{font-family:sans-serif;color:#333;margin-top:10%}
+form{width:16em;margin:0 auto}
+input{display:block;width:100%;box-sizing:border-box;margin:.3em 0 1em;padding:.4em;font-size:1em}
+p{color:#c00}
+</style>
+</head>
+<body>
+<form method="post" action="/_login">
+<h1>Log in</h1>
+{{with .Error}}<p>{{.}}</p>{{end}}
+<input type="hidden" name="next" value="{{.Next}}">
+<label>User<input name="user" value="{{.User}}" autocomplete="username" required autofocus></label>
+<label>Password<input name="password" type="password" autocomplete="current-password" required></label>
+{{if .TOTP}}<label>One-time code (if enabled)<input name="code" inputmode="numeric" autocomplete="one-time-code" pattern="[0-9]*"></label>{{end}}
+<input type="submit" value="Log in">
+</form>
+</body>
+</html>
+`))
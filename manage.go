@@ -0,0 +1,265 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// allow file management
+var allowManage bool
+
+func init() {
+	gnuflag.BoolVar(&allowManage, "allow-manage", false,
+		"Allow deleting files with HTTP DELETE requests, and creating and renaming them via the API.")
+
+	apiMux.HandleFunc("/_api/mkdir", serveMkdir)
+	apiMux.HandleFunc("/_api/rename", serveRename)
+}
+
+// withManage handles DELETE requests, moving the file or the empty directory at the request path
+// to the trash; non-empty directories are deleted via the API, after a dry run
+func withManage(next http.Handler) http.Handler {
+	if allowManage {
+		log.Println("File management enabled")
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodDelete || !allowManage {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		target := path.Clean("/" + req.URL.Path)
+		name := resolvePath(target)
+		info, err := store.Lstat(name)
+
+		if err != nil || name == rootDir || isIgnored(name, info.IsDir()) {
+			http.NotFound(resp, req)
+			return
+		}
+
+		if err = checkInsideRoot(filepath.Dir(name)); err != nil {
+			http.Error(resp, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if info.IsDir() {
+			if list, err := store.ReadDir(name); err != nil || len(list) > 0 {
+				http.Error(resp, "Directory is not empty, see /api/delete"+target, http.StatusConflict)
+				return
+			}
+		}
+
+		trashed, err := removeTree(name)
+
+		if err != nil {
+			log.Println(logTag(req), "Delete error:", err)
+			http.Error(resp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if trashed {
+			log.Println(logTag(req), "Deleted", shortenURI(target), "(moved to the trash)")
+		} else {
+			log.Println(logTag(req), "Deleted", shortenURI(target))
+		}
+
+		resp.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// POST /_api/mkdir with {"path": "/dir/subdir"}: creates the directory with its parents
+func serveMkdir(resp http.ResponseWriter, req *http.Request) {
+	var mr struct {
+		Path string `json:"path"`
+	}
+
+	if !manageRequest(resp, req, &mr) {
+		return
+	}
+
+	target := path.Clean("/" + mr.Path)
+	name := resolvePath(target)
+
+	if name == rootDir || isIgnored(name, true) || strings.HasPrefix(path.Base(target), uploadTempPrefix) {
+		apiError(resp, req, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	info, err := store.Stat(name)
+
+	switch {
+	case err == nil && !info.IsDir():
+		apiError(resp, req, http.StatusConflict, "Not a directory: "+target)
+		return
+	case err != nil && !os.IsNotExist(err):
+		log.Println(logTag(req), "Mkdir error:", err)
+		apiError(resp, req, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	created := err != nil
+
+	if created {
+		if err = insideRoot(name); err != nil {
+			apiError(resp, req, http.StatusForbidden, "Forbidden")
+			return
+		}
+
+		if err = store.MkdirAll(name); err != nil {
+			log.Println(logTag(req), "Mkdir error:", err)
+			apiError(resp, req, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		log.Println(logTag(req), "Created directory", shortenURI(target))
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(http.StatusCreated)
+	}
+
+	writeJSON(resp, struct {
+		Path    string `json:"path"`
+		Created bool   `json:"created"`
+	}{target, created})
+}
+
+// POST /_api/rename with {"from": "/dir/name", "to": "/dir/new-name"}: renames or moves the file
+// or directory, without replacing an existing one; a "to" without leading slash is a new name
+// in the same directory
+func serveRename(resp http.ResponseWriter, req *http.Request) {
+	var rr struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+
+	if !manageRequest(resp, req, &rr) {
+		return
+	}
+
+	from := path.Clean("/" + rr.From)
+	to := rr.To
+
+	if !strings.HasPrefix(to, "/") {
+		to = path.Join(path.Dir(from), to)
+	}
+
+	to = path.Clean("/" + to)
+
+	src, dst := resolvePath(from), resolvePath(to)
+	info, err := store.Lstat(src)
+
+	if err != nil || src == rootDir || isIgnored(src, info.IsDir()) {
+		apiError(resp, req, http.StatusNotFound, "Not found: "+from)
+		return
+	}
+
+	if dst == rootDir || dst == src || strings.HasPrefix(dst, src+string(filepath.Separator)) ||
+		isIgnored(dst, info.IsDir()) || strings.HasPrefix(path.Base(to), uploadTempPrefix) {
+		apiError(resp, req, http.StatusBadRequest, "Invalid target: "+to)
+		return
+	}
+
+	if err = checkInsideRoot(filepath.Dir(src)); err == nil {
+		err = insideRoot(filepath.Dir(dst))
+	}
+
+	if err != nil {
+		apiError(resp, req, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	if err = store.MkdirAll(filepath.Dir(dst)); err == nil {
+		err = moveNoReplace(src, dst, false)
+	}
+
+	switch {
+	case err == errUploadExists:
+		apiError(resp, req, http.StatusConflict, "Already exists: "+to)
+		return
+	case err != nil:
+		log.Println(logTag(req), "Rename error:", err)
+		apiError(resp, req, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	log.Println(logTag(req), "Renamed", shortenURI(from), "to", shortenURI(to))
+
+	writeJSON(resp, struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}{from, to})
+}
+
+// manageRequest checks the method, the permission, and the origin, and decodes the JSON request body
+func manageRequest(resp http.ResponseWriter, req *http.Request, body interface{}) bool {
+	if req.Method != http.MethodPost {
+		resp.Header().Set("Allow", "POST")
+		apiError(resp, req, http.StatusMethodNotAllowed, "Method not allowed")
+		return false
+	}
+
+	if !allowManage {
+		apiError(resp, req, http.StatusForbidden, "File management is not allowed")
+		return false
+	}
+
+	if !jsonRequest(resp, req) {
+		return false
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(resp, req.Body, 1<<16)).Decode(body); err != nil {
+		apiError(resp, req, http.StatusBadRequest, "Invalid request: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+// insideRoot checks that the nearest existing ancestor of the path (or the path itself)
+// does not lead outside of the root directory via symlinks
+func insideRoot(name string) error {
+	for name != rootDir {
+		if _, err := store.Lstat(name); err == nil {
+			break
+		}
+
+		name = filepath.Dir(name)
+	}
+
+	return checkInsideRoot(name)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInsideRoot(t *testing.T) {
+	defer func(dir string) { rootDir = dir }(rootDir)
+
+	base := t.TempDir()
+	rootDir = filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+
+	for _, dir := range []string{filepath.Join(rootDir, "dir"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		"in":  filepath.Join(rootDir, "dir"),
+		"out": outside,
+		"up":  "..",
+	}
+
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(rootDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name string
+		ok   bool
+	}{
+		{"", true},
+		{"dir", true},
+		{"dir/new.txt", true},
+		{"new/sub/file.txt", true},
+		{"in/new.txt", true},
+		{"out", false},
+		{"out/new.txt", false},
+		{"out/new/sub/file.txt", false},
+		{"up/new.txt", false},
+		{"../outside/new.txt", false},
+		{"../new.txt", false},
+	}
+
+	for _, c := range cases {
+		if err := insideRoot(filepath.Join(rootDir, c.name)); (err == nil) != c.ok {
+			t.Errorf("%q: got %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+)
+
+func init() {
+	apiMux.HandleFunc("/api/manifest", serveManifest)
+	apiMux.HandleFunc("/api/manifest/", serveManifest)
+}
+
+// GET /api/manifest[/<path>][?hash=sha256|blake3|xxh3]: hash of every file in the subtree, sorted by path,
+// plus the root hash, which is the hash of the manifest in sha256sum(1) format, or the like.
+func serveManifest(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		apiError(resp, req, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	algo, ok := queryHash(req.URL.Query())
+
+	if !ok {
+		apiError(resp, req, http.StatusBadRequest, "Unsupported hash algorithm")
+		return
+	}
+
+	files, err := buildManifest(req.Context(), apiTarget(req, "/api/manifest"), algo)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			apiError(resp, req, http.StatusNotFound, "Not found")
+		} else {
+			log.Println(logTag(req), "Manifest error:", err)
+			apiError(resp, req, http.StatusInternalServerError, "Internal server error")
+		}
+
+		return
+	}
+
+	result := struct {
+		Hash      string          `json:"hash"`
+		Root      string          `json:"root"`
+		Files     []manifestEntry `json:"files"`
+		Signature string          `json:"signature,omitempty"`
+	}{
+		Hash:  algo.name,
+		Root:  manifestRoot(files, algo),
+		Files: files,
+	}
+
+	// the signature is of the manifest in checksum file format
+	if signer != nil {
+		sig, err := signer.sign(checksumFile(files, algo))
+
+		if err != nil {
+			log.Println(logTag(req), "Signing error:", err)
+			apiError(resp, req, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		result.Signature = string(sig)
+	}
+
+	writeJSON(resp, &result)
+}
+
+// buildManifest returns hashed entries for all files under the directory, sorted by path
+func buildManifest(ctx context.Context, dir string, algo *hashAlgo) ([]manifestEntry, error) {
+	files := []manifestEntry{}
+
+	var lock sync.Mutex
+
+	err := walkFiles(ctx, dir, func(name, rel string, info os.FileInfo) error {
+		sum, err := fileHash(algo, name, info)
+
+		if err != nil {
+			return err
+		}
+
+		entry := manifestEntry{Path: rel, Size: info.Size(), Mtime: info.ModTime()}
+		*entry.sum(algo) = sum
+
+		lock.Lock()
+		files = append(files, entry)
+		lock.Unlock()
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// checksumFile returns the manifest in the format of sha256sum(1), or b3sum(1)
+func checksumFile(files []manifestEntry, algo *hashAlgo) []byte {
+	var buff []byte
+
+	for i := range files {
+		buff = append(buff, *files[i].sum(algo)+"  "+files[i].Path+"\n"...)
+	}
+
+	return buff
+}
+
+func manifestRoot(files []manifestEntry, algo *hashAlgo) string {
+	h := algo.new()
+	h.Write(checksumFile(files, algo))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
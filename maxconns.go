@@ -0,0 +1,185 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/gnuflag"
+	"github.com/maxim2266/mvr"
+)
+
+// connection limit
+var (
+	maxConns  uint
+	connQueue time.Duration
+)
+
+func init() {
+	gnuflag.UintVar(&maxConns, "max-conns", 0,
+		"Maximum number of open connections, 0 for unlimited; the excess connections wait for --conn-queue, and then get 503 Service Unavailable.")
+	gnuflag.DurationVar(&connQueue, "conn-queue", 2*time.Second,
+		"How long a new connection waits for a free slot under --max-conns.")
+}
+
+// the highest number of open connections so far
+var peakConns int64
+
+// connOpened updates the counters of the open connections
+func connOpened() {
+	n := atomic.AddInt64(&activeConns, 1)
+
+	for {
+		peak := atomic.LoadInt64(&peakConns)
+
+		if n <= peak || atomic.CompareAndSwapInt64(&peakConns, peak, n) {
+			return
+		}
+	}
+}
+
+func init() {
+	adminMux.HandleFunc("/admin/stats/connections", serveConnStats)
+}
+
+// GET /admin/stats/connections: the number of open connections, the peak, and the limit
+func serveConnStats(resp http.ResponseWriter, req *http.Request) {
+	writeJSON(resp, struct {
+		Open     int64 `json:"open"`
+		Peak     int64 `json:"peak"`
+		Max      uint  `json:"max,omitempty"`
+		Rejected int64 `json:"rejected"`
+	}{
+		atomic.LoadInt64(&activeConns),
+		atomic.LoadInt64(&peakConns),
+		maxConns,
+		atomic.LoadInt64(&rejectedConns),
+	})
+}
+
+// connections rejected under --max-conns
+var rejectedConns int64
+
+// newConnSlots returns the semaphore shared by all the listeners, or nil if the connections are not limited;
+// the peak number of connections is logged at exit
+func newConnSlots() chan struct{} {
+	mvr.OnCancel(0, func(context.Context) {
+		if peak := atomic.LoadInt64(&peakConns); peak > 0 {
+			log.Println("Peak connections:", peak)
+		}
+	})
+
+	if maxConns == 0 {
+		return nil
+	}
+
+	if connQueue < 0 {
+		die("Invalid connection queue timeout: "+connQueue.String(), nil)
+	}
+
+	log.Println("Connection limit:", maxConns)
+
+	return make(chan struct{}, maxConns)
+}
+
+// listener keeping the number of open connections within the limit
+type limitListener struct {
+	net.Listener
+	slots chan struct{}
+}
+
+func (l limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case l.slots <- struct{}{}:
+			return &limitConn{Conn: conn, slots: l.slots}, nil
+		default:
+		}
+
+		timer := time.NewTimer(connQueue)
+
+		select {
+		case l.slots <- struct{}{}:
+			timer.Stop()
+			return &limitConn{Conn: conn, slots: l.slots}, nil
+		case <-timer.C:
+			atomic.AddInt64(&rejectedConns, 1)
+			log.Println(conn.RemoteAddr(), "rejected: too many connections ("+
+				strconv.FormatInt(atomic.LoadInt64(&activeConns), 10)+" open)")
+
+			go rejectConn(conn)
+		}
+	}
+}
+
+// rejectConn reads the request, if any, and responds 503 Service Unavailable
+func rejectConn(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+		return
+	}
+
+	const msg = "Too many connections, please try again later.\n"
+
+	conn.Write([]byte("HTTP/1.1 503 Service Unavailable\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Length: " + strconv.Itoa(len(msg)) + "\r\n" +
+		"Retry-After: 10\r\n" +
+		"Connection: close\r\n\r\n" + msg))
+}
+
+// connection holding a slot until closed
+type limitConn struct {
+	net.Conn
+	slots chan struct{}
+	once  sync.Once
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(func() { <-c.slots })
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,165 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File metadata headers: downloads report the file mode and the modification time with sub-second
+// precision, and uploads may supply them to be set on the received file.
+const (
+	fileModeHeader  = "X-File-Mode"  // octal permission bits, like "0644"
+	fileMtimeHeader = "X-File-Mtime" // seconds since the Unix epoch, like "1700000000.123456789"
+)
+
+// withFileMeta adds the file metadata headers to downloads.
+func withFileMeta(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			name := resolvePath(req.URL.Path)
+
+			if info, err := os.Stat(name); err == nil && info.Mode().IsRegular() && !isIgnored(name, false) {
+				h := resp.Header()
+
+				h.Set(fileModeHeader, formatFileMode(info.Mode()))
+				h.Set(fileMtimeHeader, formatMtime(info.ModTime()))
+
+				// http.FileServer omits Last-Modified for the files dated at the Unix epoch
+				h.Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+			}
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// file metadata supplied with an upload
+type fileMeta struct {
+	mode  os.FileMode // zero if not given
+	mtime time.Time   // zero if not given
+}
+
+var (
+	errFileMeta = errors.New("invalid file metadata header")
+	errFileExec = errors.New("execute permission not allowed")
+)
+
+// uploadMeta parses the file metadata headers of the upload request; Last-Modified header is
+// also accepted for the modification time. The mode never makes the file writable by the group
+// or others, and the execute bits are only allowed with --allow-manage.
+func uploadMeta(req *http.Request) (meta fileMeta, err error) {
+	if s := req.Header.Get(fileModeHeader); len(s) > 0 {
+		mode, err := strconv.ParseUint(s, 8, 32)
+
+		if err != nil || mode == 0 || mode > 0777 {
+			return meta, errFileMeta
+		}
+
+		if mode&0111 != 0 && !allowManage {
+			return meta, errFileExec
+		}
+
+		meta.mode = os.FileMode(mode &^ 0022)
+	}
+
+	if s := req.Header.Get(fileMtimeHeader); len(s) > 0 {
+		if meta.mtime, err = parseMtime(s); err != nil {
+			return meta, errFileMeta
+		}
+	} else if s = req.Header.Get("Last-Modified"); len(s) > 0 {
+		if meta.mtime, err = http.ParseTime(s); err != nil {
+			return meta, errFileMeta
+		}
+	}
+
+	return
+}
+
+// apply sets the metadata on the file
+func (meta fileMeta) apply(name string) error {
+	if meta.mode != 0 {
+		if err := os.Chmod(name, meta.mode); err != nil {
+			return err
+		}
+	}
+
+	if !meta.mtime.IsZero() {
+		return os.Chtimes(name, meta.mtime, meta.mtime)
+	}
+
+	return nil
+}
+
+func formatFileMode(mode os.FileMode) string {
+	return "0" + strconv.FormatUint(uint64(mode.Perm()), 8)
+}
+
+func formatMtime(t time.Time) string {
+	s := strconv.FormatInt(t.Unix(), 10)
+
+	if ns := t.Nanosecond(); ns != 0 {
+		s += strings.TrimRight("."+strconv.FormatInt(int64(ns)+1e9, 10)[1:], "0")
+	}
+
+	return s
+}
+
+func parseMtime(s string) (time.Time, error) {
+	secs, frac := s, ""
+
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		secs, frac = s[:i], s[i+1:]
+	}
+
+	sec, err := strconv.ParseInt(secs, 10, 64)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var nsec int64
+
+	if len(frac) > 0 {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+
+		if nsec, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return time.Unix(sec, nsec), nil
+}
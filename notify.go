@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/juju/gnuflag"
+)
+
+// URL path patterns of the files to notify about when first downloaded
+var notifyWatch string
+
+func init() {
+	gnuflag.StringVar(&notifyWatch, "notify-watch", "",
+		"Comma-separated URL path patterns of files to notify about when first downloaded, e.g. \"/reports/*.pdf\".")
+}
+
+// notification channel
+type notifier func(subject, msg string) error
+
+// configured notification channels, by name
+var notifiers = map[string]notifier{}
+
+// serialised delivery of notifications
+var notifyLock sync.Mutex
+
+// notify sends the message to all notification channels in background
+func notify(subject, msg string) {
+	if len(notifiers) == 0 {
+		return
+	}
+
+	go func() {
+		notifyLock.Lock()
+		defer notifyLock.Unlock()
+
+		for name, send := range notifiers {
+			if err := send(subject, msg); err != nil {
+				log.Println("Notification via", name, "failed:", err)
+			}
+		}
+	}()
+}
+
+// withNotify sends notifications on the first complete download of each watched file
+func withNotify(next http.Handler) http.Handler {
+	if len(notifyWatch) == 0 {
+		return next
+	}
+
+	if len(notifiers) == 0 {
+		die("Option --notify-watch requires a notification channel", nil)
+	}
+
+	var patterns []string
+
+	for _, p := range strings.Split(notifyWatch, ",") {
+		if p = strings.TrimSpace(p); len(p) > 0 {
+			if _, err := path.Match(p, ""); err != nil {
+				die("Invalid pattern: "+p, err)
+			}
+
+			patterns = append(patterns, path.Clean("/"+p))
+		}
+	}
+
+	log.Println("Watching downloads of", strings.Join(patterns, ", "))
+
+	var lock sync.Mutex
+	seen := make(map[string]bool)
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		name := req.URL.Path
+
+		if req.Method != http.MethodGet || !matchAny(patterns, name) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		tw := &trackingWriter{ResponseWriter: resp}
+
+		next.ServeHTTP(tw, req)
+
+		// only complete downloads count
+		size, err := strconv.ParseInt(resp.Header().Get("Content-Length"), 10, 64)
+
+		if tw.Status() != http.StatusOK || err != nil || tw.sent != size {
+			return
+		}
+
+		lock.Lock()
+		first := !seen[name]
+		seen[name] = true
+		lock.Unlock()
+
+		if first {
+			notify("Downloaded "+name, name+" ("+sizeString(size)+") has been downloaded by "+
+				req.RemoteAddr+" ("+req.UserAgent()+").")
+		}
+	})
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,142 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/maxim2266/mvr"
+)
+
+// pause state; while paused new requests are rejected, but the transfers in progress continue
+var pause struct {
+	sync.Mutex
+	paused bool
+	since  time.Time
+}
+
+func init() {
+	adminMux.HandleFunc("/admin/pause", servePause)
+	adminMux.HandleFunc("/admin/resume", servePause)
+}
+
+func isPaused() bool {
+	pause.Lock()
+	defer pause.Unlock()
+
+	return pause.paused
+}
+
+// setPaused switches the pause mode on or off
+func setPaused(on bool) {
+	pause.Lock()
+	defer pause.Unlock()
+
+	if pause.paused == on {
+		return
+	}
+
+	pause.paused = on
+
+	if on {
+		pause.since = time.Now()
+		log.Println("Paused")
+	} else {
+		log.Println("Resumed after", time.Since(pause.since).Round(time.Second))
+	}
+}
+
+// withPause rejects requests while paused, and toggles the pause mode on SIGUSR1
+func withPause(next http.Handler) http.Handler {
+	sigch := make(chan os.Signal, 1)
+
+	signal.Notify(sigch, syscall.SIGUSR1)
+
+	go func() {
+		for {
+			select {
+			case <-sigch:
+				setPaused(!isPaused())
+			case <-mvr.Done():
+				signal.Stop(sigch)
+				return
+			}
+		}
+	}()
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !isPaused() {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: paused")
+
+		resp.Header().Set("Retry-After", "60")
+		servePage(resp, http.StatusServiceUnavailable, "Temporarily paused",
+			"This share is temporarily paused for maintenance.", time.Time{})
+	})
+}
+
+// "/admin/pause", "/admin/resume": POST switches the pause mode, GET reports it
+func servePause(resp http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		// report only
+	case http.MethodPost:
+		setPaused(req.URL.Path == "/admin/pause")
+	default:
+		resp.Header().Set("Allow", "GET, HEAD, POST")
+		apiError(resp, req, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	pause.Lock()
+
+	res := struct {
+		Paused bool       `json:"paused"`
+		Since  *time.Time `json:"since,omitempty"`
+	}{
+		Paused: pause.paused,
+	}
+
+	if pause.paused {
+		since := pause.since
+		res.Since = &since
+	}
+
+	pause.Unlock()
+	writeJSON(resp, res)
+}
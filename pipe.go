@@ -0,0 +1,201 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// upload pipe command
+var (
+	uploadPipe     string
+	uploadPipeSave bool
+)
+
+func init() {
+	gnuflag.StringVar(&uploadPipe, "upload-pipe", "",
+		"Command to stream the body of each upload to, instead of writing it to the file, like \"tar -x -C /srv/incoming\".")
+	gnuflag.BoolVar(&uploadPipeSave, "upload-pipe-save", false,
+		"Write the output of the --upload-pipe command to the target file, like with \"gunzip\".")
+}
+
+// upload pipe command and its arguments
+var uploadPipeArgs []string
+
+// errPipeResumable is returned for resumable or chunked uploads when the uploads are piped
+var errPipeResumable = errors.New("resumable uploads cannot be piped")
+
+func setupUploadPipe() {
+	if len(uploadPipe) == 0 {
+		if uploadPipeSave {
+			die("Option --upload-pipe-save requires --upload-pipe", nil)
+		}
+
+		return
+	}
+
+	if !allowUpload {
+		die("Option --upload-pipe requires --allow-upload", nil)
+	}
+
+	if uploadPipeArgs = strings.Fields(uploadPipe); len(uploadPipeArgs) == 0 {
+		die("Empty upload pipe command", nil)
+	}
+
+	if _, err := exec.LookPath(uploadPipeArgs[0]); err != nil {
+		die("Invalid upload pipe command", err)
+	}
+
+	if uploadPipeSave {
+		log.Println("Piping uploads through:", uploadPipe)
+	} else {
+		log.Println("Piping uploads to:", uploadPipe)
+	}
+}
+
+// pipeUpload streams the upload to the command's stdin, with the details of the upload
+// in the environment variables. With --upload-pipe-save the output of the command atomically
+// creates or replaces the target file, as the conflict policy permits, otherwise it is discarded.
+// The number of bytes received is returned, or, with --upload-pipe-save, the name and the size
+// of the file written.
+func pipeUpload(req *http.Request, target string, policy conflictPolicy, src io.Reader) (placed string, created bool, n int64, err error) {
+	name := resolvePath(target)
+
+	if !uploadTypeAllowed(name) {
+		err = errUploadType
+		return
+	}
+
+	var stdout io.Writer = ioutil.Discard
+	var tmp *os.File
+
+	if uploadPipeSave {
+		if created, err = prepareUpload(name, policy); err != nil {
+			return
+		}
+
+		if tmp, err = ioutil.TempFile(filepath.Dir(name), uploadTempPrefix); err != nil {
+			return
+		}
+
+		defer func() {
+			if err != nil {
+				tmp.Close()
+				os.Remove(tmp.Name())
+			}
+		}()
+
+		stdout = tmp
+	}
+
+	var stderr bytes.Buffer
+
+	counter := &countingReader{src: limitUpload(src)}
+
+	cmd := exec.CommandContext(req.Context(), uploadPipeArgs[0], uploadPipeArgs[1:]...)
+	cmd.Stdin = counter
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(),
+		"UPLOAD_PATH="+target,
+		"UPLOAD_FILE="+name,
+		"UPLOAD_USER="+authUser(req),
+		"REMOTE_ADDR="+req.RemoteAddr,
+		"CONTENT_TYPE="+req.Header.Get("Content-Type"),
+	)
+
+	if err = cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			err = fmt.Errorf("%s: %s (%s)", uploadPipeArgs[0], msg, err)
+		} else {
+			err = fmt.Errorf("%s: %s", uploadPipeArgs[0], err)
+		}
+
+		return
+	}
+
+	if !uploadPipeSave {
+		n = counter.n
+		return
+	}
+
+	var info os.FileInfo
+
+	if info, err = tmp.Stat(); err != nil {
+		return
+	}
+
+	n = info.Size()
+
+	if err = tmp.Chmod(0644); err != nil {
+		return
+	}
+
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	placed, err = placeUpload(tmp.Name(), name, policy)
+	created = created || placed != name
+	return
+}
+
+// piped reports the upload consumed by the pipe command
+func piped(resp http.ResponseWriter, req *http.Request, target string, n int64) {
+	log.Println(logTag(req), "Piped", shortenURI(target), sizeString(n), "to", uploadPipeArgs[0])
+	notify("Uploaded "+target, target+" ("+sizeString(n)+") has been uploaded by "+req.RemoteAddr+
+		" and passed to "+uploadPipeArgs[0]+".")
+
+	resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(resp, target, sizeString(n), "processed")
+}
+
+// countingReader counts the bytes read
+type countingReader struct {
+	src io.Reader
+	n   int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	r.n += int64(n)
+	return n, err
+}
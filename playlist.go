@@ -0,0 +1,172 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// file extensions recognised as audio
+var audioExts = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".ogg":  true,
+	".oga":  true,
+	".opus": true,
+	".m4a":  true,
+	".aac":  true,
+	".wav":  true,
+	".wma":  true,
+	".aif":  true,
+	".aiff": true,
+	".ape":  true,
+	".mka":  true,
+}
+
+func isAudio(name string) bool {
+	return audioExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// withPlaylist serves "<dir>/?m3u[&recursive=1]" requests with an M3U playlist of direct URLs
+// to the audio files in the directory, sorted by path.
+func withPlaylist(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+
+		if _, ok := query["m3u"]; !ok || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		dir := resolvePath(req.URL.Path)
+
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() || isIgnored(dir, true) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		var files []manifestEntry
+		var err error
+
+		if query.Get("recursive") == "1" {
+			files, err = audioFiles(req, dir)
+		} else {
+			files, err = listAudio(dir)
+		}
+
+		if err != nil {
+			log.Println(logTag(req), "Playlist error:", err)
+			http.Error(resp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if len(files) == 0 {
+			http.Error(resp, "No audio files", http.StatusNotFound)
+			return
+		}
+
+		base, prefix := requestBase(req), strings.TrimSuffix(path.Clean("/"+req.URL.Path), "/")+"/"
+
+		var buf strings.Builder
+
+		buf.WriteString("#EXTM3U\n")
+
+		for _, f := range files {
+			title := strings.TrimSuffix(path.Base(f.Path), path.Ext(f.Path))
+
+			buf.WriteString("#EXTINF:-1," + title + "\n")
+			buf.WriteString(base + (&url.URL{Path: prefix + f.Path}).EscapedPath() + "\n")
+		}
+
+		name := filepath.Base(dir)
+
+		if name == string(filepath.Separator) || name == "." {
+			name = "playlist"
+		}
+
+		resp.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+		resp.Header().Set("Content-Disposition", `inline; filename="`+strings.Replace(name, `"`, "'", -1)+`.m3u"`)
+		resp.Write([]byte(buf.String()))
+	})
+}
+
+// listAudio returns the audio files directly in the directory, sorted by name
+func listAudio(dir string) ([]manifestEntry, error) {
+	entries, err := listDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var files []manifestEntry
+
+	for _, e := range entries {
+		if !e.Dir && isAudio(e.Name) {
+			files = append(files, manifestEntry{Path: e.Name, Size: e.Size, Mtime: e.Mtime})
+		}
+	}
+
+	return files, nil
+}
+
+// audioFiles returns the audio files in the whole subtree, sorted by path
+func audioFiles(req *http.Request, dir string) ([]manifestEntry, error) {
+	var files []manifestEntry
+	var lock sync.Mutex
+
+	err := walkFiles(req.Context(), dir, func(name, rel string, info os.FileInfo) error {
+		if isAudio(name) {
+			lock.Lock()
+			files = append(files, manifestEntry{Path: rel, Size: info.Size(), Mtime: info.ModTime()})
+			lock.Unlock()
+		}
+
+		return nil
+	})
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, err
+}
+
+// requestBase returns the scheme and host the client used to reach the server
+func requestBase(req *http.Request) string {
+	if req.TLS != nil {
+		return "https://" + req.Host
+	}
+
+	return "http://" + req.Host
+}
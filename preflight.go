@@ -0,0 +1,178 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// startup checks of the root directory
+var skipPreflight bool
+
+func init() {
+	gnuflag.BoolVar(&skipPreflight, "skip-preflight", false,
+		"Do not scan the root directory at startup for unreadable directories and symlinks leading outside of it.")
+}
+
+// time limit for the scan of the tree, after which the report is partial
+const preflightTime = 3 * time.Second
+
+// number of reported problems of each kind, the rest are only counted
+const preflightMaxWarnings = 5
+
+var errPreflightTime = errors.New("preflight time limit")
+
+// preflight makes sure the root directory is readable, and then scans the tree, reporting its size
+// and the problems the recipients would otherwise see as confusing 403 or 404 responses
+func preflight(root string) {
+	file, err := os.Open(root)
+
+	if err == nil {
+		_, err = file.Readdirnames(1)
+		file.Close()
+	}
+
+	if err != nil && err != io.EOF {
+		die("Cannot read the root directory", err)
+	}
+
+	if skipPreflight {
+		return
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+
+	if err != nil {
+		die("Cannot resolve the root directory", err)
+	}
+
+	var (
+		files, dirs, size       int64
+		unreadable, escaping    []string
+		numUnreadable, numLinks int
+	)
+
+	deadline := time.Now().Add(preflightTime)
+
+	err = filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if time.Now().After(deadline) {
+			return errPreflightTime
+		}
+
+		if err != nil {
+			if info != nil && info.IsDir() {
+				if numUnreadable++; numUnreadable <= preflightMaxWarnings {
+					unreadable = append(unreadable, relName(root, name))
+				}
+			}
+
+			return nil
+		}
+
+		if name != root && isIgnored(name, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			dirs++
+		case mode.IsRegular():
+			files++
+			size += info.Size()
+		case mode&os.ModeSymlink != 0:
+			if target, err := filepath.EvalSymlinks(name); err == nil && !insideDir(target, realRoot) {
+				if numLinks++; numLinks <= preflightMaxWarnings {
+					escaping = append(escaping, relName(root, name)+" -> "+target)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil && err != errPreflightTime {
+		log.Println("Preflight scan failed:", err)
+		return
+	}
+
+	// report
+	msg := strconv.FormatInt(files, 10) + " files in " + strconv.FormatInt(dirs, 10) +
+		" directories, " + sizeString(size)
+
+	if err == errPreflightTime {
+		msg = "at least " + msg + " (scan stopped after " + preflightTime.String() + ")"
+	}
+
+	log.Println("Root directory:", msg)
+
+	for _, name := range unreadable {
+		log.Println("Warning: unreadable directory", name)
+	}
+
+	if numUnreadable > len(unreadable) {
+		log.Println("Warning: ...and", numUnreadable-len(unreadable), "more unreadable directories")
+	}
+
+	for _, link := range escaping {
+		log.Println("Warning: symlink leads outside of the root directory:", link)
+	}
+
+	if numLinks > len(escaping) {
+		log.Println("Warning: ...and", numLinks-len(escaping), "more symlinks leading outside of the root directory")
+	}
+}
+
+// insideDir checks if the path is the directory itself, or under it
+func insideDir(name, dir string) bool {
+	rel, err := filepath.Rel(dir, name)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// relName returns the path relative to the root, for the log
+func relName(root, name string) string {
+	if rel, err := filepath.Rel(root, name); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+
+	return name
+}
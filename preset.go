@@ -0,0 +1,162 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// preset of options for a common scenario
+type preset struct {
+	name      string
+	options   [][2]string // option name and value
+	needsAuth bool        // the preset is unsafe without authentication
+}
+
+// private address ranges, for the presets limited to the local network
+const privateNets = "127.0.0.0/8,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,169.254.0.0/16,::1/128,fc00::/7,fe80::/10"
+
+var presets = []preset{
+	{
+		name: "public-readonly",
+		options: [][2]string{
+			{"rate", "10/s"},
+			{"burst", "50"},
+			{"limit-conn", "5MB/s"},
+		},
+	},
+	{
+		name: "dropbox",
+		options: [][2]string{
+			{"allow-upload", "true"},
+			{"upload-conflict", "rename"},
+			{"rate", "10/s"},
+			{"burst", "50"},
+		},
+	},
+	{
+		name: "trusted-lan-readwrite",
+		options: [][2]string{
+			{"allow", privateNets},
+			{"allow-upload", "true"},
+			{"upload-conflict", "version"},
+		},
+	},
+	{
+		name: "internet-exposed",
+		options: [][2]string{
+			{"tls", "true"},
+			{"rate", "5/s"},
+			{"burst", "20"},
+			{"limit-conn", "2MB/s"},
+			{"stall-timeout", "30s"},
+			{"session-ttl", "12h"},
+		},
+		needsAuth: true,
+	},
+}
+
+// preset name
+var presetName string
+
+func init() {
+	gnuflag.StringVar(&presetName, "preset", "",
+		"Defaults for a common scenario: "+strings.Join(presetNames(), ", ")+
+			"; options given on the command line or in the configuration file take precedence.")
+}
+
+// applyPreset sets the options of the preset, except those given on the command line
+// or in the configuration file, so the preset is the lowest layer of the configuration
+func applyPreset() {
+	if len(presetName) == 0 {
+		return
+	}
+
+	p := findPreset(presetName)
+
+	if p == nil {
+		die("Unknown preset "+strconv.Quote(presetName)+", the presets are: "+strings.Join(presetNames(), ", "), nil)
+	}
+
+	var given []gnuflag.Value
+
+	gnuflag.Visit(func(f *gnuflag.Flag) { given = append(given, f.Value) })
+	given = append(given, configured...)
+
+	var applied []string
+
+	for _, opt := range p.options {
+		f := gnuflag.Lookup(opt[0])
+
+		if f == nil {
+			panic("unknown option in preset " + p.name + ": " + opt[0])
+		}
+
+		if valueGiven(given, f.Value) {
+			continue
+		}
+
+		if err := f.Value.Set(opt[1]); err != nil {
+			die("Invalid preset "+p.name, errors.New("option "+opt[0]+": "+err.Error()))
+		}
+
+		applied = append(applied, opt[0]+"="+opt[1])
+	}
+
+	log.Println("Preset "+p.name+":", strings.Join(applied, " "))
+
+	if p.needsAuth && len(basicUser) == 0 && len(htpasswdFile) == 0 && len(digestFile) == 0 && len(totpFile) == 0 {
+		die("Preset "+p.name+" requires authentication with --auth, --htpasswd, --digest-auth, or --totp-secrets", nil)
+	}
+}
+
+func findPreset(name string) *preset {
+	for i := range presets {
+		if presets[i].name == name {
+			return &presets[i]
+		}
+	}
+
+	return nil
+}
+
+func presetNames() []string {
+	names := make([]string, len(presets))
+
+	for i, p := range presets {
+		names[i] = p.name
+	}
+
+	return names
+}
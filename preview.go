@@ -0,0 +1,347 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// preview converters, like "application/msword,.docx,.odt=pdf:libreoffice --headless --convert-to pdf --outdir {outdir} {in}"
+var (
+	previewSpec    string
+	previewCache   string
+	previewTimeout time.Duration
+)
+
+func init() {
+	gnuflag.StringVar(&previewSpec, "preview", "",
+		"Converters for \"?preview\" by file extension or MIME type, like \"application/msword,.docx=pdf:libreoffice --headless --convert-to pdf --outdir {outdir} {in}\".")
+	gnuflag.StringVar(&previewCache, "preview-cache", "",
+		"Directory to cache the converted previews (default: web-share/previews in the user cache directory).")
+	gnuflag.DurationVar(&previewTimeout, "preview-timeout", 2*time.Minute,
+		"Maximum time for a preview conversion.")
+}
+
+// preview converter
+type converter struct {
+	patterns []string // extensions like ".docx", or MIME types like "application/msword"
+	ext      string   // extension of the output, like ".pdf"
+	args     []string // command and its arguments, with placeholders
+}
+
+var converters []converter
+
+// parseConverters parses "<patterns>=<ext>:<command>" rules, separated by semicolons
+func parseConverters(spec string) (res []converter, err error) {
+	for _, rule := range strings.Split(spec, ";") {
+		if rule = strings.TrimSpace(rule); len(rule) == 0 {
+			continue
+		}
+
+		i := strings.IndexByte(rule, '=')
+		j := strings.IndexByte(rule, ':')
+
+		if i < 0 || j < i {
+			return nil, errors.New("expected \"<patterns>=<ext>:<command>\" in " + rule)
+		}
+
+		c := converter{
+			ext:  "." + strings.TrimPrefix(strings.ToLower(strings.TrimSpace(rule[i+1:j])), "."),
+			args: strings.Fields(rule[j+1:]),
+		}
+
+		if len(c.ext) < 2 || strings.ContainsAny(c.ext, `/\`) {
+			return nil, errors.New("invalid output extension in " + rule)
+		}
+
+		if len(c.args) == 0 {
+			return nil, errors.New("missing command in " + rule)
+		}
+
+		for _, p := range strings.Split(rule[:i], ",") {
+			if p = strings.ToLower(strings.TrimSpace(p)); len(p) == 0 {
+				continue
+			}
+
+			if !strings.HasPrefix(p, ".") && !strings.Contains(p, "/") {
+				return nil, errors.New("invalid pattern: " + p)
+			}
+
+			c.patterns = append(c.patterns, p)
+		}
+
+		if len(c.patterns) == 0 {
+			return nil, errors.New("no patterns in " + rule)
+		}
+
+		res = append(res, c)
+	}
+
+	return
+}
+
+// withPreview serves "<file>?preview" requests with the file converted by the external command
+// configured for its type; the results are cached until the file changes.
+func withPreview(next http.Handler) http.Handler {
+	if len(previewSpec) == 0 {
+		return next
+	}
+
+	var err error
+
+	if converters, err = parseConverters(previewSpec); err != nil {
+		die("Invalid preview converters", err)
+	}
+
+	if len(previewCache) == 0 {
+		if previewCache, err = os.UserCacheDir(); err != nil {
+			die("Cannot locate cache directory", err)
+		}
+
+		previewCache = filepath.Join(previewCache, "web-share", "previews")
+	}
+
+	if previewCache, err = filepath.Abs(previewCache); err != nil {
+		die("Invalid preview cache directory", err)
+	}
+
+	if err = os.MkdirAll(previewCache, 0700); err != nil {
+		die("Cannot create preview cache directory", err)
+	}
+
+	if strings.HasPrefix(previewCache+string(filepath.Separator), rootDir+string(filepath.Separator)) {
+		die("Preview cache directory must not be inside the shared directory", nil)
+	}
+
+	log.Println("Previews enabled, cached in", previewCache)
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if _, ok := req.URL.Query()["preview"]; !ok || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		name := resolvePath(req.URL.Path)
+		info, err := os.Stat(name)
+
+		if err != nil || !info.Mode().IsRegular() || isIgnored(name, false) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		c := findConverter(name)
+
+		if c == nil {
+			http.Error(resp, "No preview available", http.StatusNotFound)
+			return
+		}
+
+		preview, err := c.convert(name, info)
+
+		if err != nil {
+			log.Println(logTag(req), "Preview error:", err)
+			http.Error(resp, "Preview conversion failed", http.StatusInternalServerError)
+			return
+		}
+
+		file, err := os.Open(preview)
+
+		if err != nil {
+			log.Println(logTag(req), "Preview error:", err)
+			http.Error(resp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		defer file.Close()
+
+		base := strings.TrimSuffix(path.Base(req.URL.Path), path.Ext(req.URL.Path)) + c.ext
+
+		if ctype := mime.TypeByExtension(c.ext); len(ctype) > 0 {
+			resp.Header().Set("Content-Type", ctype)
+		}
+
+		resp.Header().Set("Content-Disposition", `inline; filename="`+strings.Replace(base, `"`, "'", -1)+`"`)
+		http.ServeContent(resp, req, base, info.ModTime(), file)
+	})
+}
+
+// findConverter returns the first converter matching the file, or nil
+func findConverter(name string) *converter {
+	name = strings.ToLower(filepath.Base(name))
+	mimeType := mime.TypeByExtension(filepath.Ext(name))
+
+	if t, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = t
+	}
+
+	for i := range converters {
+		if matchType(name, mimeType, converters[i].patterns) {
+			return &converters[i]
+		}
+	}
+
+	return nil
+}
+
+// conversions in progress, by the cache file name
+var converting = struct {
+	sync.Mutex
+	files map[string]*sync.Mutex
+}{
+	files: make(map[string]*sync.Mutex),
+}
+
+// convert returns the name of the cached conversion result, running the converter if needed
+func (c *converter) convert(name string, info os.FileInfo) (string, error) {
+	h := sha256.New()
+
+	h.Write([]byte(name + "\x00" + strconv.FormatInt(info.Size(), 10) + "\x00" + formatMtime(info.ModTime()) +
+		"\x00" + strings.Join(c.args, "\x00")))
+
+	cached := filepath.Join(previewCache, hex.EncodeToString(h.Sum(nil))+c.ext)
+
+	// one conversion per file at a time
+	converting.Lock()
+	lock, ok := converting.files[cached]
+
+	if !ok {
+		lock = new(sync.Mutex)
+		converting.files[cached] = lock
+	}
+
+	converting.Unlock()
+
+	lock.Lock()
+
+	defer func() {
+		converting.Lock()
+		delete(converting.files, cached)
+		converting.Unlock()
+		lock.Unlock()
+	}()
+
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+
+	tmp, err := ioutil.TempDir(previewCache, "tmp.")
+
+	if err != nil {
+		return "", err
+	}
+
+	defer os.RemoveAll(tmp)
+
+	out := filepath.Join(tmp, strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))+c.ext)
+
+	if err = c.run(name, out, tmp); err != nil {
+		return "", err
+	}
+
+	if err = os.Rename(out, cached); err != nil {
+		return "", err
+	}
+
+	log.Println("Converted", name, "for preview")
+	return cached, nil
+}
+
+// run runs the converter with "{in}" replaced by the input file, "{out}" by the output file,
+// and "{outdir}" by the output directory; without "{out}" or "{outdir}" the output is read
+// from stdout of the command.
+func (c *converter) run(in, out, dir string) error {
+	var toStdout = true
+
+	args := make([]string, len(c.args))
+
+	for i, arg := range c.args {
+		if strings.Contains(arg, "{out}") || strings.Contains(arg, "{outdir}") {
+			toStdout = false
+		}
+
+		args[i] = strings.NewReplacer("{in}", in, "{outdir}", dir, "{out}", out).Replace(arg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), previewTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+
+	if toStdout {
+		file, err := os.Create(out)
+
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		cmd.Stdout = file
+	}
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return errors.New(args[0] + ": " + msg)
+		}
+
+		return err
+	}
+
+	if _, err := os.Stat(out); err != nil {
+		return errors.New(args[0] + ": no output produced")
+	}
+
+	return nil
+}
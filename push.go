@@ -0,0 +1,140 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// push notification settings
+var ntfyTopic, ntfyToken, pushoverKeys string
+
+func init() {
+	gnuflag.StringVar(&ntfyTopic, "ntfy", "",
+		"Publish notifications to the given ntfy topic URL, like \"https://ntfy.sh/my-topic\".")
+	gnuflag.StringVar(&ntfyToken, "ntfy-token", "", "Access token for the ntfy topic.")
+	gnuflag.StringVar(&pushoverKeys, "pushover", "",
+		"Send notifications via Pushover, given as \"<application token>:<user key>\".")
+}
+
+// HTTP client for push notifications
+var pushClient = &http.Client{Timeout: 30 * time.Second}
+
+// setupPush configures push notification channels
+func setupPush() {
+	if len(ntfyTopic) > 0 {
+		u, err := url.Parse(ntfyTopic)
+
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(strings.Trim(u.Path, "/")) == 0 {
+			die("Invalid ntfy topic URL: "+ntfyTopic, err)
+		}
+
+		notifiers["ntfy"] = sendNtfy
+		log.Println("Publishing notifications to", ntfyTopic)
+	} else if len(ntfyToken) > 0 {
+		die("Option --ntfy-token requires --ntfy", nil)
+	}
+
+	if len(pushoverKeys) > 0 {
+		keys := strings.Split(pushoverKeys, ":")
+
+		if len(keys) != 2 || len(keys[0]) == 0 || len(keys[1]) == 0 {
+			die("Invalid Pushover keys, expected \"<application token>:<user key>\"", nil)
+		}
+
+		notifiers["pushover"] = func(subject, msg string) error {
+			return sendPushover(keys[0], keys[1], subject, msg)
+		}
+
+		log.Println("Sending notifications via Pushover")
+	}
+}
+
+func sendNtfy(subject, msg string) error {
+	req, err := http.NewRequest(http.MethodPost, ntfyTopic, strings.NewReader(msg))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Title", "web-share: "+subject)
+
+	if len(ntfyToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+ntfyToken)
+	}
+
+	return pushRequest(req)
+}
+
+func sendPushover(token, user, subject, msg string) error {
+	form := url.Values{
+		"token":   {token},
+		"user":    {user},
+		"title":   {"web-share: " + subject},
+		"message": {msg},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.pushover.net/1/messages.json",
+		strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return pushRequest(req)
+}
+
+func pushRequest(req *http.Request) error {
+	resp, err := pushClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return errors.New(resp.Status + ": " + strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
@@ -0,0 +1,335 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+	"github.com/maxim2266/mvr"
+)
+
+// what to do with the clients requesting lots of tiny ranges
+var rangePolicy string
+
+func init() {
+	gnuflag.StringVar(&rangePolicy, "range-policy", "coalesce",
+		"Handling of clients requesting lots of tiny byte ranges of a file: coalesce (merge the ranges of multi-range requests, and summarise the requests in the log), reject (with 429 response), or off.")
+}
+
+// range request tracking
+const (
+	tinyRange      = 64 * 1024 // requests for fewer bytes are tiny
+	tinyRangeLimit = 100       // tiny requests per client and file per minute before the policy applies
+	rangeGap       = 64 * 1024 // ranges closer than this are merged
+	maxRangeParts  = 64        // ranges of a multi-range request after merging
+)
+
+// tiny range requests from a client for a file, in the current minute
+type rangeStats struct {
+	count   int
+	bytes   int64
+	flagged bool // over the limit
+	handled int  // requests coalesced or rejected silently
+}
+
+var rangeClients = struct {
+	sync.Mutex
+	stats map[string]*rangeStats // by client address and URL path
+}{
+	stats: make(map[string]*rangeStats),
+}
+
+// withRangeGuard watches range requests: the ranges of multi-range requests are merged, and
+// the clients making more than tinyRangeLimit requests for tiny ranges of the same file in a minute
+// are handled by the policy, with a summary logged once a minute instead of a line per request
+func withRangeGuard(next http.Handler) http.Handler {
+	switch rangePolicy {
+	case "off":
+		return next
+	case "coalesce", "reject":
+	default:
+		die("Invalid range policy: "+rangePolicy, nil)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				summariseRanges()
+			case <-mvr.Done():
+				return
+			}
+		}
+	}()
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		rng := req.Header.Get("Range")
+
+		if req.Method != http.MethodGet || len(rng) == 0 {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		// open-ended ranges are resolved against the size of the file
+		info, err := store.Stat(resolvePath(req.URL.Path))
+
+		if err != nil || !info.Mode().IsRegular() {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		ranges, ok := parseByteRanges(rng, info.Size())
+
+		if !ok {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if len(ranges) > 1 {
+			if ranges = mergeRanges(ranges); len(ranges) > maxRangeParts {
+				if rangePolicy == "reject" {
+					log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: too many ranges")
+					http.Error(resp, "Too many ranges", http.StatusRequestedRangeNotSatisfiable)
+					return
+				}
+
+				ranges = []byteRange{{ranges[0].start, ranges[len(ranges)-1].end}}
+			}
+
+			req.Header.Set("Range", formatRanges(ranges))
+		}
+
+		var size int64
+
+		for _, r := range ranges {
+			size += r.end - r.start + 1
+		}
+
+		if size >= tinyRange || !trackTinyRange(remoteIP(req).String()+" "+req.URL.Path, size) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if rangePolicy == "reject" {
+			resp.Header().Set("Retry-After", "60")
+			http.Error(resp, "Too many range requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), quietKey{}, true)))
+	})
+}
+
+// trackTinyRange counts the tiny range request, returning true if the client is over the limit
+func trackTinyRange(key string, size int64) bool {
+	rangeClients.Lock()
+	defer rangeClients.Unlock()
+
+	st := rangeClients.stats[key]
+
+	if st == nil {
+		st = new(rangeStats)
+		rangeClients.stats[key] = st
+	}
+
+	st.count++
+	st.bytes += size
+
+	if st.flagged {
+		st.handled++
+		return true
+	}
+
+	if st.count > tinyRangeLimit {
+		st.flagged = true
+		st.handled++
+
+		log.Println(key+":", st.count, "tiny range requests in less than a minute, applying range policy:", rangePolicy)
+		return true
+	}
+
+	return false
+}
+
+// summariseRanges logs the requests handled by the policy in the last minute, and starts a new minute
+func summariseRanges() {
+	rangeClients.Lock()
+	defer rangeClients.Unlock()
+
+	for key, st := range rangeClients.stats {
+		if st.handled > 0 {
+			verb := "coalesced"
+
+			if rangePolicy == "reject" {
+				verb = "rejected"
+			}
+
+			log.Println(key+":", st.handled, "tiny range requests", verb, "in the last minute, "+
+				sizeString(st.bytes/int64(st.count))+" each on average")
+		}
+
+		if st.count <= tinyRangeLimit {
+			delete(rangeClients.stats, key)
+			continue
+		}
+
+		// the client stays flagged while over the limit
+		*st = rangeStats{flagged: true}
+	}
+}
+
+// byte range, inclusive
+type byteRange struct {
+	start, end int64
+}
+
+// parseByteRanges parses Range header of the form "bytes=a-b,c-,-d" for the file of the given size,
+// resolving the open-ended and suffix ranges, and dropping the ranges past the end of the file,
+// like the file server does
+func parseByteRanges(s string, size int64) ([]byteRange, bool) {
+	if !strings.HasPrefix(s, "bytes=") {
+		return nil, false
+	}
+
+	var ranges []byteRange
+
+	for _, spec := range strings.Split(s[len("bytes="):], ",") {
+		spec = strings.TrimSpace(spec)
+
+		i := strings.IndexByte(spec, '-')
+
+		if i < 0 {
+			return nil, false
+		}
+
+		first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+		var r byteRange
+
+		if len(first) == 0 {
+			// suffix range, "-d"
+			n, err := strconv.ParseInt(last, 10, 64)
+
+			if err != nil || n < 0 {
+				return nil, false
+			}
+
+			if n == 0 {
+				continue
+			}
+
+			if n > size {
+				n = size
+			}
+
+			r = byteRange{size - n, size - 1}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+
+			if err != nil || start < 0 {
+				return nil, false
+			}
+
+			end := size - 1
+
+			if len(last) > 0 {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil, false
+				}
+
+				if end >= size {
+					end = size - 1
+				}
+			}
+
+			if start >= size {
+				continue
+			}
+
+			r = byteRange{start, end}
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges, len(ranges) > 0
+}
+
+// mergeRanges sorts the ranges, and merges those overlapping or separated by less than rangeGap bytes
+func mergeRanges(ranges []byteRange) []byteRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+
+	res := ranges[:1]
+
+	for _, r := range ranges[1:] {
+		last := &res[len(res)-1]
+
+		if r.start <= last.end+rangeGap {
+			if r.end > last.end {
+				last.end = r.end
+			}
+
+			continue
+		}
+
+		res = append(res, r)
+	}
+
+	return res
+}
+
+func formatRanges(ranges []byteRange) string {
+	parts := make([]string, len(ranges))
+
+	for i, r := range ranges {
+		parts[i] = strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.end, 10)
+	}
+
+	return "bytes=" + strings.Join(parts, ",")
+}
+
+// requests not to be logged individually
+type quietKey struct{}
+
+func isQuiet(req *http.Request) bool {
+	quiet, _ := req.Context().Value(quietKey{}).(bool)
+
+	return quiet
+}
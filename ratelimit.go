@@ -0,0 +1,248 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// bandwidth schedule, like "Mon-Fri 09:00-18:00 10Mbit; 22:00-07:00 unlimited; 50M"
+var rateLimitSpec string
+
+func init() {
+	gnuflag.StringVar(&rateLimitSpec, "rate-limit", "",
+		"Limit the total download rate, optionally by time of day, e.g. \"Mon-Fri 09:00-18:00 10Mbit; 5M\", where the first matching rule applies.")
+}
+
+// rate limit rule; the rule without a time window always applies
+type rateRule struct {
+	sched schedule
+	rate  int64 // bytes per second, 0 for unlimited
+}
+
+type rateSchedule []rateRule
+
+// rate returns the limit at the given time, 0 for unlimited
+func (rs rateSchedule) rate(t time.Time) int64 {
+	for _, r := range rs {
+		if r.sched == nil || r.sched.open(t) {
+			return r.rate
+		}
+	}
+
+	return 0
+}
+
+// size of the chunk written at once under the rate limit
+const rateChunk = 16 * 1024
+
+// withRateLimit limits the total rate of the responses, shared by all the clients
+func withRateLimit(next http.Handler) http.Handler {
+	if len(rateLimitSpec) == 0 {
+		return next
+	}
+
+	rs, err := parseRateSchedule(rateLimitSpec)
+
+	if err != nil {
+		die("Invalid rate limit", err)
+	}
+
+	log.Println("Rate limit:", rateLimitSpec)
+
+	limiter := &rateLimiter{rates: rs}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(&limitedWriter{ResponseWriter: resp, limiter: limiter, ctx: req.Context()}, req)
+	})
+}
+
+// parseRateSchedule parses rules like "[days] [HH:MM-HH:MM] rate" separated by semicolons
+func parseRateSchedule(spec string) (rateSchedule, error) {
+	var rs rateSchedule
+
+	for _, s := range strings.Split(spec, ";") {
+		fields := strings.Fields(s)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		var r rateRule
+		var err error
+
+		if r.rate, err = parseRate(fields[len(fields)-1]); err != nil {
+			return nil, err
+		}
+
+		if len(fields) > 1 {
+			if r.sched, err = parseSchedule(strings.Join(fields[:len(fields)-1], " ")); err != nil {
+				return nil, err
+			}
+		}
+
+		rs = append(rs, r)
+	}
+
+	if len(rs) == 0 {
+		return nil, errors.New("empty rate limit")
+	}
+
+	return rs, nil
+}
+
+// parseRate parses rates in bytes, like "5M" or "5M/s", or in bits, like "10Mbit" or "10Mbit/s",
+// where the multipliers are decimal, as usual for the network speeds; "unlimited" or 0 mean no limit
+func parseRate(s string) (int64, error) {
+	str := strings.TrimSuffix(strings.ToLower(s), "/s")
+
+	if str == "unlimited" {
+		return 0, nil
+	}
+
+	if !strings.HasSuffix(str, "bit") {
+		if rate, err := parseByteSize(str); err == nil {
+			return rate, nil
+		}
+
+		return 0, errors.New("invalid rate: " + strconv.Quote(s))
+	}
+
+	str = strings.TrimSuffix(str, "bit")
+	mul := int64(1)
+
+	if n := len(str); n > 0 {
+		switch str[n-1] {
+		case 'k':
+			mul = 1e3
+		case 'm':
+			mul = 1e6
+		case 'g':
+			mul = 1e9
+		}
+
+		if mul > 1 {
+			str = str[:n-1]
+		}
+	}
+
+	val, err := strconv.ParseInt(str, 10, 64)
+
+	if err != nil || val < 0 {
+		return 0, errors.New("invalid rate: " + strconv.Quote(s))
+	}
+
+	return val * mul / 8, nil
+}
+
+// rateLimiter spreads the writes over time so that their total rate stays within the limit
+type rateLimiter struct {
+	rates rateSchedule
+
+	lock sync.Mutex
+	next time.Time // when the next write may start
+}
+
+// wait blocks until n bytes can be written
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	now := time.Now()
+	rate := l.rates.rate(now)
+
+	if rate == 0 {
+		return nil
+	}
+
+	l.lock.Lock()
+
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(rate))
+	l.lock.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// response writer under the rate limit
+type limitedWriter struct {
+	http.ResponseWriter
+	limiter *rateLimiter
+	ctx     context.Context
+}
+
+func (w *limitedWriter) Write(data []byte) (n int, err error) {
+	for len(data) > 0 && err == nil {
+		chunk := data
+
+		if len(chunk) > rateChunk {
+			chunk = chunk[:rateChunk]
+		}
+
+		if err = w.limiter.wait(w.ctx, len(chunk)); err != nil {
+			return
+		}
+
+		var m int
+
+		m, err = w.ResponseWriter.Write(chunk)
+		n += m
+		data = data[m:]
+	}
+
+	return
+}
+
+func (w *limitedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// readahead size
+var readaheadSpec string
+
+func init() {
+	gnuflag.StringVar(&readaheadSpec, "readahead", "0",
+		"Ask the kernel to read ahead the given amount (e.g. 32M) of each file being downloaded, and the beginnings of the files in each listed directory.")
+}
+
+// directory listings prefetch at most that many files, and at most that much of each
+const (
+	readaheadListFiles = 32
+	readaheadListSize  = 1 << 20
+)
+
+// withReadahead issues readahead hints for downloads and listings
+func withReadahead(next http.Handler) http.Handler {
+	size, err := parseByteSize(readaheadSpec)
+
+	if err != nil {
+		die("Invalid readahead size: "+readaheadSpec, err)
+	}
+
+	if size == 0 {
+		return next
+	}
+
+	log.Println("Readahead:", sizeString(size))
+
+	listSize := size
+
+	if listSize > readaheadListSize {
+		listSize = readaheadListSize
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			name := resolvePath(req.URL.Path)
+
+			if strings.HasSuffix(req.URL.Path, "/") {
+				go readaheadDir(name, listSize)
+			} else {
+				readahead(name, rangeStart(req.Header.Get("Range")), size)
+			}
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// readahead asks the kernel to read the part of the file into the page cache
+func readahead(name string, offset, size int64) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		return
+	}
+
+	defer file.Close()
+
+	if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() || isIgnored(name, false) {
+		return
+	}
+
+	if err = willNeed(file, offset, size); err != nil {
+		log.Println("Readahead error:", err)
+	}
+}
+
+// readaheadDir reads ahead the beginnings of the files in the directory
+func readaheadDir(dir string, size int64) {
+	list, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		return
+	}
+
+	n := 0
+
+	for _, info := range list {
+		if info.Mode().IsRegular() && !hiddenEntry(dir, info) {
+			readahead(filepath.Join(dir, info.Name()), 0, size)
+
+			if n++; n == readaheadListFiles {
+				break
+			}
+		}
+	}
+}
+
+// rangeStart returns the offset of the first byte requested, or 0
+func rangeStart(rng string) int64 {
+	if !strings.HasPrefix(rng, "bytes=") {
+		return 0
+	}
+
+	rng = rng[6:]
+
+	if i := strings.IndexAny(rng, "-,"); i > 0 {
+		if n, err := strconv.ParseInt(strings.TrimSpace(rng[:i]), 10, 64); err == nil {
+			return n
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,305 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// README files rendered above directory listings, in order of preference
+var readmeNames = [...]string{"README.md", "README.txt"}
+
+// largest README file to render
+const maxReadmeSize = 1 << 20
+
+// withReadme renders README file from the directory above its listing.
+func withReadme(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet || !strings.HasSuffix(req.URL.Path, "/") || len(req.URL.RawQuery) > 0 {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		banner := readmeBanner(resolvePath(req.URL.Path))
+
+		if len(banner) == 0 {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		// the README may have changed without changing the directory
+		req.Header.Del("If-Modified-Since")
+
+		w := &listingWriter{ResponseWriter: resp, status: http.StatusOK}
+
+		next.ServeHTTP(w, req)
+
+		body := w.buf.Bytes()
+
+		if w.status == http.StatusOK && strings.HasPrefix(resp.Header().Get("Content-Type"), "text/html") {
+			if i := bytes.Index(body, []byte("<pre")); i >= 0 {
+				body = append(append(body[:i:i], banner...), body[i:]...)
+			}
+		}
+
+		resp.Header().Del("Content-Length")
+		resp.WriteHeader(w.status)
+		resp.Write(body)
+	})
+}
+
+// listingWriter buffers directory listing
+type listingWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *listingWriter) WriteHeader(status int)      { w.status = status }
+func (w *listingWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+// readmeBanner returns HTML rendering of the README file from the directory, if any
+func readmeBanner(dir string) []byte {
+	if hasIndex(dir) {
+		return nil
+	}
+
+	for _, name := range readmeNames {
+		full := filepath.Join(dir, name)
+		info, err := os.Stat(full)
+
+		if err != nil || !info.Mode().IsRegular() || info.Size() > maxReadmeSize || isIgnored(full, false) {
+			continue
+		}
+
+		file, err := os.Open(full)
+
+		if err != nil {
+			continue
+		}
+
+		var buf bytes.Buffer
+
+		_, err = io.Copy(&buf, io.LimitReader(file, maxReadmeSize))
+		file.Close()
+
+		if err != nil {
+			continue
+		}
+
+		var res bytes.Buffer
+
+		res.WriteString("<article class=\"readme\">\n")
+
+		if strings.HasSuffix(name, ".md") {
+			renderMarkdown(&res, buf.String())
+		} else {
+			res.WriteString("<pre>")
+			res.WriteString(html.EscapeString(buf.String()))
+			res.WriteString("</pre>\n")
+		}
+
+		res.WriteString("</article>\n<hr>\n")
+		return res.Bytes()
+	}
+
+	return nil
+}
+
+// renderMarkdown converts a reasonable subset of Markdown to HTML: headings, paragraphs,
+// lists, block quotes, code blocks, horizontal rules, and inline code, emphasis and links.
+func renderMarkdown(w *bytes.Buffer, src string) {
+	var para, item []string
+	var list string // "ul", "ol", or empty
+
+	flushItem := func() {
+		if len(item) > 0 {
+			w.WriteString("<li>" + renderInline(strings.Join(item, "\n")) + "</li>\n")
+			item = item[:0]
+		}
+	}
+
+	flush := func() {
+		flushItem()
+
+		if len(para) > 0 {
+			w.WriteString("<p>" + renderInline(strings.Join(para, "\n")) + "</p>\n")
+			para = para[:0]
+		}
+
+		if len(list) > 0 {
+			w.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+
+	lines := strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		trimmed := strings.TrimLeft(line, " ")
+
+		switch {
+		case len(trimmed) == 0:
+			flush()
+
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			w.WriteString("<pre><code>")
+
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimLeft(lines[i], " "), "```"); i++ {
+				w.WriteString(html.EscapeString(lines[i]) + "\n")
+			}
+
+			w.WriteString("</code></pre>\n")
+
+		case mdHeading.MatchString(trimmed):
+			flush()
+
+			m := mdHeading.FindStringSubmatch(trimmed)
+			tag := "h" + string('0'+rune(len(m[1])))
+
+			w.WriteString("<" + tag + ">" + renderInline(strings.TrimRight(m[2], " #")) + "</" + tag + ">\n")
+
+		case mdRule.MatchString(trimmed):
+			flush()
+			w.WriteString("<hr>\n")
+
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+
+			var quote []string
+
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimLeft(lines[i], " "), ">"); i++ {
+				quote = append(quote, strings.TrimPrefix(strings.TrimLeft(lines[i], " ")[1:], " "))
+			}
+
+			i--
+			w.WriteString("<blockquote>\n")
+			renderMarkdown(w, strings.Join(quote, "\n"))
+			w.WriteString("</blockquote>\n")
+
+		case mdBullet.MatchString(trimmed), mdNumber.MatchString(trimmed):
+			kind, re := "ul", mdBullet
+
+			if !mdBullet.MatchString(trimmed) {
+				kind, re = "ol", mdNumber
+			}
+
+			if len(para) > 0 || list != kind {
+				flush()
+				list = kind
+				w.WriteString("<" + kind + ">\n")
+			}
+
+			flushItem()
+			item = append(item, re.ReplaceAllString(trimmed, ""))
+
+		case len(item) > 0 && strings.HasPrefix(line, " "):
+			item = append(item, trimmed) // continuation of the list item
+
+		default:
+			if len(list) > 0 {
+				flush()
+			}
+
+			para = append(para, trimmed)
+		}
+	}
+
+	flush()
+}
+
+var (
+	mdHeading = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	mdRule    = regexp.MustCompile(`^([-*_])(\s*([-*_])){2,}$`)
+	mdBullet  = regexp.MustCompile(`^[-*+]\s+`)
+	mdNumber  = regexp.MustCompile(`^\d+[.)]\s+`)
+	mdStrong  = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdEmph    = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+	mdLink    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+)
+
+// renderInline converts inline Markdown to HTML, with code spans left verbatim.
+func renderInline(s string) string {
+	parts := strings.Split(s, "`")
+
+	for i, part := range parts {
+		part = html.EscapeString(part)
+
+		if i%2 == 1 && i < len(parts)-1 {
+			parts[i] = "<code>" + part + "</code>"
+			continue
+		}
+
+		part = mdLink.ReplaceAllStringFunc(part, func(m string) string {
+			sub := mdLink.FindStringSubmatch(m)
+
+			if !safeLink(html.UnescapeString(sub[2])) {
+				return sub[1]
+			}
+
+			return "<a href=\"" + sub[2] + "\">" + sub[1] + "</a>"
+		})
+
+		part = mdStrong.ReplaceAllString(part, "<strong>$1$2</strong>")
+		part = mdEmph.ReplaceAllString(part, "<em>$1$2</em>")
+
+		if i%2 == 1 { // unmatched backtick
+			part = "`" + part
+		}
+
+		parts[i] = part
+	}
+
+	return strings.Join(parts, "")
+}
+
+// safeLink accepts relative links, and absolute links with http, https, or mailto schemes only
+func safeLink(link string) bool {
+	i := strings.IndexAny(link, ":/?#")
+
+	if i < 0 || link[i] != ':' {
+		return true
+	}
+
+	switch strings.ToLower(link[:i]) {
+	case "http", "https", "mailto":
+		return true
+	default:
+		return false
+	}
+}
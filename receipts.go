@@ -0,0 +1,171 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// receipt of an uploaded file
+type uploadReceipt struct {
+	Path     string    `json:"path"` // URL path of the stored file
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256,omitempty"`
+	URL      string    `json:"url,omitempty"` // direct download link
+	Received time.Time `json:"received"`
+	Piped    bool      `json:"piped,omitempty"` // passed to --upload-pipe command, not stored
+}
+
+// complete adds the time, the hash of the stored file, and the download link
+func (r *uploadReceipt) complete() {
+	r.Received = time.Now().UTC()
+
+	if r.Piped {
+		return
+	}
+
+	name := resolvePath(r.Path)
+
+	if info, err := store.Stat(name); err == nil {
+		r.Size = info.Size()
+
+		if r.SHA256, err = fileHash(sha256Hash, name, info); err != nil {
+			log.Println("Cannot hash", name+":", err)
+		}
+	}
+
+	// nothing can be downloaded from the upload-only share
+	if !uploadOnly {
+		r.URL = baseURL() + (&url.URL{Path: r.Path}).EscapedPath()
+	}
+}
+
+// wantsReceipt returns the format of the receipt accepted by the client: "json", "html",
+// or the plain text list of the files by default
+func wantsReceipt(req *http.Request) string {
+	accept := req.Header.Get("Accept")
+
+	switch {
+	case strings.Contains(accept, "application/json"):
+		return "json"
+	case strings.Contains(accept, "text/html"):
+		return "html"
+	default:
+		return ""
+	}
+}
+
+// writeReceipts sends the receipts of the uploaded files as JSON, as HTML page, or as plain text lines
+// of the paths and the sizes, depending on the Accept header, with the given status
+func writeReceipts(resp http.ResponseWriter, req *http.Request, status int, receipts []uploadReceipt, single bool) {
+	format := wantsReceipt(req)
+
+	if len(format) > 0 {
+		for i := range receipts {
+			receipts[i].complete()
+		}
+	}
+
+	switch format {
+	case "json":
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(status)
+
+		if single && len(receipts) == 1 {
+			writeJSON(resp, &receipts[0])
+		} else {
+			writeJSON(resp, struct {
+				Files []uploadReceipt `json:"files"`
+			}{receipts})
+		}
+	case "html":
+		resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+		resp.WriteHeader(status)
+
+		dir := path.Dir(path.Clean(req.URL.Path))
+
+		if req.Method == http.MethodPost {
+			dir = path.Clean(req.URL.Path) // form uploads are posted to the directory
+		}
+
+		data := struct {
+			Files []uploadReceipt
+			Dir   string
+		}{receipts, (&url.URL{Path: strings.TrimSuffix(dir, "/") + "/"}).EscapedPath()}
+
+		if err := receiptTemplate.Execute(resp, &data); err != nil {
+			log.Println("Template error:", err)
+		}
+	default:
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		resp.WriteHeader(status)
+
+		for _, r := range receipts {
+			if r.Piped {
+				fmt.Fprintln(resp, r.Path, sizeString(r.Size), "processed")
+			} else {
+				fmt.Fprintln(resp, r.Path, sizeString(r.Size))
+			}
+		}
+	}
+}
+
+var receiptTemplate = template.Must(template.New("receipt").Funcs(template.FuncMap{
+	"size": sizeString,
+	"time": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04:05") },
+}).Parse(`<!doctype html>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>Upload receipt</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; }
+th, td { text-align: left; padding: 0.3em 1em 0.3em 0; vertical-align: top; }
+code, input { font-family: monospace; }
+input { width: 40em; max-width: 100%; }
+</style>
+<h1>Upload receipt</h1>
+{{range .Files}}<table>
+<tr><th>File</th><td>{{if .URL}}<a href="{{.URL}}">{{.Path}}</a>{{else}}{{.Path}}{{end}}</td></tr>
+<tr><th>Size</th><td>{{size .Size}} ({{.Size}} bytes)</td></tr>
+{{with .SHA256}}<tr><th>SHA-256</th><td><code>{{.}}</code></td></tr>
+{{end}}{{with .URL}}<tr><th>Link</th><td><input readonly value="{{.}}" onclick="this.select()"></td></tr>
+{{end}}{{if .Piped}}<tr><th>Status</th><td>Processed, not stored</td></tr>
+{{end}}<tr><th>Received</th><td>{{time .Received}}</td></tr>
+</table>
+<hr>
+{{end}}<p><a href="{{.Dir}}">Back to the folder</a></p>
+`))
@@ -0,0 +1,447 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/juju/gnuflag"
+	"github.com/maxim2266/mvr"
+)
+
+// period to track the recent files over
+var recentWindow time.Duration
+
+func init() {
+	gnuflag.DurationVar(&recentWindow, "recent", 0,
+		"Track the files added or modified within the given period (e.g. 1h), and show them in the listings; 0 disables the tracking.")
+
+	apiMux.HandleFunc("/api/recent", serveRecent)
+	apiMux.HandleFunc("/api/recent/", serveRecent)
+}
+
+// default and maximum number of the recent files returned by the API
+const (
+	recentLimit    = 100
+	maxRecentLimit = 1000
+)
+
+// recently added or modified file
+type recentFile struct {
+	Path    string    `json:"path"` // relative to the root directory, with forward slashes
+	Size    int64     `json:"size"`
+	Mtime   time.Time `json:"mtime"`
+	Changed time.Time `json:"changed"` // when the change was seen
+}
+
+// recent files, by the file name; maintained from the file system events
+var recent = struct {
+	sync.Mutex
+	files map[string]recentFile
+}{
+	files: make(map[string]recentFile),
+}
+
+// startRecent watches all the directories under the root for the files being added or modified,
+// starting with those modified within the tracking period
+func startRecent() {
+	if recentWindow == 0 {
+		return
+	}
+
+	if recentWindow < 0 {
+		die("Invalid recent files period: "+recentWindow.String(), nil)
+	}
+
+	w, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		die("Cannot watch the root directory", err)
+	}
+
+	n := watchTree(w, rootDir, time.Now().Add(-recentWindow))
+
+	log.Println("Tracking recent files for", recentWindow.String()+",", n, "directories watched")
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		defer w.Close()
+
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+
+				recentEvent(w, ev)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+
+				log.Println("Recent files:", err)
+			case now := <-ticker.C:
+				pruneRecent(now.Add(-recentWindow))
+			case <-mvr.Done():
+				return
+			}
+		}
+	}()
+}
+
+// watchTree adds the directory and its subdirectories to the watcher, and records the files modified
+// after the given time; with zero time all the files are recorded as just added. Ignored and symlinked
+// directories are skipped. Returns the number of the directories watched.
+func watchTree(w *fsnotify.Watcher, dir string, since time.Time) int {
+	if err := w.Add(dir); err != nil {
+		log.Println("Cannot watch", dir+":", err)
+		return 0
+	}
+
+	list, err := store.ReadDir(dir)
+
+	if err != nil {
+		return 1
+	}
+
+	n, now := 1, time.Now()
+
+	for _, info := range list {
+		name := filepath.Join(dir, info.Name())
+
+		if info.IsDir() {
+			if !isIgnored(name, true) {
+				n += watchTree(w, name, since)
+			}
+
+			continue
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = store.Stat(name); err != nil {
+				continue
+			}
+		}
+
+		if !info.Mode().IsRegular() || isIgnored(name, false) {
+			continue
+		}
+
+		switch {
+		case since.IsZero():
+			addRecent(name, info, now)
+		case info.ModTime().After(since):
+			addRecent(name, info, info.ModTime())
+		}
+	}
+
+	return n
+}
+
+// recentEvent updates the recent files from the file system event
+func recentEvent(w *fsnotify.Watcher, ev fsnotify.Event) {
+	// a directory moved within the tree reports its move under the new name, too
+	if ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		if _, err := store.Lstat(ev.Name); os.IsNotExist(err) {
+			forgetRecent(ev.Name)
+		}
+
+		return
+	}
+
+	if ev.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+		return
+	}
+
+	info, err := store.Lstat(ev.Name)
+
+	if err != nil {
+		return
+	}
+
+	if info.IsDir() {
+		// a new or moved in directory, with everything in it
+		if ev.Op&fsnotify.Create != 0 && !isIgnored(ev.Name, true) {
+			watchTree(w, ev.Name, time.Time{})
+		}
+
+		return
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		if info, err = store.Stat(ev.Name); err != nil {
+			return
+		}
+	}
+
+	if info.Mode().IsRegular() && !isIgnored(ev.Name, false) {
+		addRecent(ev.Name, info, time.Now())
+	}
+}
+
+func addRecent(name string, info os.FileInfo, changed time.Time) {
+	rel, err := filepath.Rel(rootDir, name)
+
+	if err != nil {
+		return
+	}
+
+	recent.Lock()
+	defer recent.Unlock()
+
+	recent.files[name] = recentFile{
+		Path:    filepath.ToSlash(rel),
+		Size:    info.Size(),
+		Mtime:   info.ModTime(),
+		Changed: changed,
+	}
+}
+
+// forgetRecent removes the file, or the directory with all its files
+func forgetRecent(name string) {
+	prefix := name + string(filepath.Separator)
+
+	recent.Lock()
+	defer recent.Unlock()
+
+	for k := range recent.files {
+		if k == name || strings.HasPrefix(k, prefix) {
+			delete(recent.files, k)
+		}
+	}
+}
+
+// pruneRecent removes the files changed before the given time
+func pruneRecent(since time.Time) {
+	recent.Lock()
+	defer recent.Unlock()
+
+	for k, f := range recent.files {
+		if f.Changed.Before(since) {
+			delete(recent.files, k)
+		}
+	}
+}
+
+// recentFiles returns the files under the directory changed after the given time, the latest first
+func recentFiles(dir string, since time.Time) []recentFile {
+	prefix := dir + string(filepath.Separator)
+	res := []recentFile{}
+
+	recent.Lock()
+
+	for k, f := range recent.files {
+		if (dir == rootDir || strings.HasPrefix(k, prefix)) && f.Changed.After(since) {
+			res = append(res, f)
+		}
+	}
+
+	recent.Unlock()
+
+	// the ignore rules may have changed since
+	files := res[:0]
+
+	for _, f := range res {
+		if !isIgnored(resolvePath(f.Path), false) {
+			files = append(files, f)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Changed.Equal(files[j].Changed) {
+			return files[i].Path < files[j].Path
+		}
+
+		return files[i].Changed.After(files[j].Changed)
+	})
+
+	return files
+}
+
+// GET /api/recent[/<path>][?minutes=<n>&limit=<n>]: the files under the directory added or modified
+// in the last n minutes (by default, the whole --recent period), the latest first
+func serveRecent(resp http.ResponseWriter, req *http.Request) {
+	if recentWindow == 0 {
+		apiError(resp, req, http.StatusNotFound, "Recent files are not tracked")
+		return
+	}
+
+	dir := apiTarget(req, "/api/recent")
+
+	if info, err := store.Stat(dir); err != nil || !info.IsDir() || isIgnored(dir, true) {
+		apiError(resp, req, http.StatusNotFound, "Not found")
+		return
+	}
+
+	query := req.URL.Query()
+	window, limit := recentWindow, recentLimit
+
+	if s := query.Get("minutes"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+
+		if err != nil || n <= 0 {
+			apiError(resp, req, http.StatusBadRequest, "Invalid minutes parameter")
+			return
+		}
+
+		if d := time.Duration(n) * time.Minute; d < window {
+			window = d
+		}
+	}
+
+	if s := query.Get("limit"); len(s) > 0 {
+		var err error
+
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > maxRecentLimit {
+			apiError(resp, req, http.StatusBadRequest, "Invalid limit parameter")
+			return
+		}
+	}
+
+	files := recentFiles(dir, time.Now().Add(-window))
+	total := len(files)
+
+	if len(files) > limit {
+		files = files[:limit]
+	}
+
+	writeJSON(resp, struct {
+		Minutes int          `json:"minutes"`
+		Total   int          `json:"total"`
+		Files   []recentFile `json:"files"`
+	}{int(window / time.Minute), total, files})
+}
+
+// withRecent shows the files recently added to the directory and its subdirectories above the listing
+func withRecent(next http.Handler) http.Handler {
+	if recentWindow == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet || !strings.HasSuffix(req.URL.Path, "/") {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if hasIndex(resolvePath(req.URL.Path)) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		w := &listingWriter{ResponseWriter: resp, status: http.StatusOK}
+
+		next.ServeHTTP(w, req)
+
+		body := w.buf.Bytes()
+
+		if w.status == http.StatusOK && strings.HasPrefix(resp.Header().Get("Content-Type"), "text/html") {
+			if i := bytes.LastIndex(body, []byte("<pre")); i >= 0 {
+				var section bytes.Buffer
+
+				dir := path.Clean(req.URL.Path)
+				data := struct{ API, Base string }{
+					API:  (&url.URL{Path: "/api/recent" + dir}).EscapedPath(),
+					Base: strings.TrimPrefix(strings.TrimSuffix(dir, "/")+"/", "/"),
+				}
+
+				if err := recentTemplate.Execute(&section, &data); err != nil {
+					log.Println("Template error:", err)
+				} else {
+					body = append(append(body[:i:i], section.Bytes()...), body[i:]...)
+					resp.Header().Del("Content-Length")
+				}
+			}
+		}
+
+		resp.WriteHeader(w.status)
+
+		if _, err := resp.Write(body); err != nil {
+			log.Println(logTag(req), "Listing error:", err)
+		}
+	})
+}
+
+// "Recently added" section, filled in by the browser from the API
+var recentTemplate = template.Must(template.New("recent").Parse(`<div id="recent" hidden>
+<h3>Recently added</h3>
+<ul></ul>
+</div>
+<script>
+(function() {
+	var section = document.getElementById("recent"), base = {{.Base}};
+
+	if (!window.fetch) return;
+
+	function ago(t) {
+		var m = Math.round((Date.now() - new Date(t).getTime()) / 60000);
+
+		if (m < 1) return "just now";
+		if (m < 60) return m + " min ago";
+
+		return Math.floor(m / 60) + " h " + (m % 60) + " min ago";
+	}
+
+	fetch({{.API}} + "?limit=20")
+		.then(function(r) { return r.json(); })
+		.then(function(res) {
+			var list = section.getElementsByTagName("ul")[0];
+
+			if (!res.files || res.files.length === 0) return;
+
+			res.files.forEach(function(f) {
+				var li = document.createElement("li"), a = document.createElement("a");
+
+				a.href = "/" + f.path.split("/").map(encodeURIComponent).join("/");
+				a.textContent = f.path.substring(base.length);
+				li.appendChild(a);
+				li.appendChild(document.createTextNode(" (" + ago(f.changed) + ")"));
+				list.appendChild(li);
+			});
+
+			section.hidden = false;
+		});
+})();
+</script>
+`))
@@ -0,0 +1,780 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+var (
+	relayMode                                         bool
+	relayVia, relayName, relayToken, relayFingerprint string
+	relayDomain                                       string
+)
+
+func init() {
+	gnuflag.BoolVar(&relayMode, "relay", false,
+		"Run as a relay instead of sharing a directory: shares behind NAT connect out to the relay with --relay-via, and the relay passes the requests to them.")
+	gnuflag.StringVar(&relayDomain, "relay-domain", "",
+		"Serve each share at its own host name <name>.<domain> instead of <relay>/r/<name>/, so that the browsers keep the shares apart; needs wildcard DNS record and certificate.")
+	gnuflag.StringVar(&relayToken, "relay-token", "",
+		"Secret required by the relay from the shares, or given by the share to the relay.")
+	gnuflag.StringVar(&relayVia, "relay-via", "",
+		"URL of a relay (another web-share with --relay) to connect out to, so that the share is reachable through the relay without port forwarding, like \"https://relay.example.com\".")
+	gnuflag.StringVar(&relayName, "relay-name", "",
+		"Name of the share on the relay, as in the share URL <relay>/r/<name>/ (default: random).")
+	gnuflag.StringVar(&relayFingerprint, "relay-fingerprint", "",
+		"SHA-256 fingerprint of the relay's self-signed certificate, to trust it.")
+}
+
+const (
+	relayPrefix      = "/_relay/"         // path of the tunnel requests from the shares
+	relaySharePrefix = "/r/"              // path prefix of the relayed shares
+	relayCookie      = "web-share-relay"  // the share the browser has been directed to
+	relayProtocol    = "web-share-relay"  // Upgrade protocol of the tunnels
+	relayIdle        = 4                  // tunnels kept waiting on the relay by each share
+	relayPing        = 30 * time.Second   // interval of the pings over the idle tunnels
+	relayWait        = 30 * time.Second   // maximum time a request waits for a tunnel
+	relayOwnerTTL    = 2 * time.Minute    // the name of a share is kept for that long after it is gone
+	relayInstance    = "X-Relay-Instance" // header with the random ID of the share instance
+	relayURLHeader   = "X-Relay-URL"      // header with the URL of the share on the relay
+
+	// the shares served from the same origin are kept apart: no credentials are passed,
+	// and the pages are sandboxed, so that the scripts of one share cannot read another share
+	relaySandbox = "sandbox allow-downloads allow-forms allow-modals allow-popups allow-scripts"
+)
+
+var errRelayOffline = errors.New("the share is offline")
+
+// relay side: shares by name
+var relayShares = struct {
+	sync.Mutex
+	byName map[string]*relayShare
+}{
+	byName: make(map[string]*relayShare),
+}
+
+type relayShare struct {
+	owner string // instance ID of the share
+	idle  chan *relayTunnel
+	last  time.Time // last tunnel from the share
+}
+
+// tunnel from a share, idle until given to a request
+type relayTunnel struct {
+	conn net.Conn
+
+	lock        sync.Mutex
+	taken, dead bool
+}
+
+// runRelay runs the relay on the given addresses
+func runRelay(ips []string, port uint) int {
+	if len(relayVia) > 0 {
+		die("Options --relay and --relay-via are mutually exclusive", nil)
+	}
+
+	addrs := make([]string, len(ips))
+
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, uintToString(port))
+		log.Println("Relay listening on", addrs[i])
+	}
+
+	serverAddr, serverAddrs = addrs[0], addrs
+	setupTLS()
+
+	if len(relayToken) == 0 {
+		log.Println("Warning: without --relay-token any share can connect to the relay")
+	}
+
+	if err := serve(addrs, relayHandler()); err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	return 0
+}
+
+// target of a relayed request
+type relayKey struct{}
+
+type relayTarget struct {
+	name, client string
+	shared       bool // served from the origin shared with the other shares
+}
+
+// relayHandler accepts the tunnels from the shares, and passes the requests to the shares over them.
+// With --relay-domain the share is selected by the host name. Otherwise, it is selected by "/r/<name>/"
+// path prefix, which is stripped, or by the cookie set on such a request, so that the absolute links
+// of the share work, or else the only share connected; such shares all have the origin of the relay,
+// and are kept apart by the sandbox.
+func relayHandler() http.Handler {
+	relayDomain = strings.ToLower(strings.Trim(relayDomain, "."))
+
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = "share"
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				t := ctx.Value(relayKey{}).(relayTarget)
+				return takeTunnel(ctx, t.name, t.client)
+			},
+			DisableKeepAlives:  true, // a tunnel per request, each with its client address
+			DisableCompression: true,
+		},
+		FlushInterval: 100 * time.Millisecond,
+		ModifyResponse: func(resp *http.Response) error {
+			if resp.Request.Context().Value(relayKey{}).(relayTarget).shared {
+				resp.Header.Del("Set-Cookie")
+				resp.Header.Set("Content-Security-Policy", relaySandbox)
+			}
+
+			return nil
+		},
+		ErrorHandler: func(resp http.ResponseWriter, req *http.Request, err error) {
+			t := req.Context().Value(relayKey{}).(relayTarget)
+
+			if err == errRelayOffline {
+				http.Error(resp, "The share is offline", http.StatusBadGateway)
+				return
+			}
+
+			log.Println(req.RemoteAddr, req.Method, shortenURI(req.URL.Path), "->", t.name, "failed:", err)
+			http.Error(resp, "Bad gateway", http.StatusBadGateway)
+		},
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, relayPrefix) {
+			acceptTunnel(resp, req, req.URL.Path[len(relayPrefix):])
+			return
+		}
+
+		if len(relayDomain) > 0 {
+			relayByHost(resp, req, proxy)
+			return
+		}
+
+		var name string
+
+		if strings.HasPrefix(req.URL.Path, relaySharePrefix) {
+			rest := req.URL.Path[len(relaySharePrefix):]
+			i := strings.IndexByte(rest, '/')
+
+			if i < 0 {
+				http.Redirect(resp, req, (&url.URL{Path: req.URL.Path + "/"}).EscapedPath(), http.StatusMovedPermanently)
+				return
+			}
+
+			name, req.URL.Path, req.URL.RawPath = rest[:i], rest[i:], ""
+
+			http.SetCookie(resp, &http.Cookie{
+				Name:     relayCookie,
+				Value:    name,
+				Path:     "/",
+				Secure:   req.TLS != nil,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		} else if c, err := req.Cookie(relayCookie); err == nil {
+			name = c.Value
+		} else {
+			name = onlyRelayShare()
+		}
+
+		if !validRelayName(name) || liveRelayShare(name) == nil {
+			http.Error(resp, "No such share", http.StatusNotFound)
+			return
+		}
+
+		log.Println(req.RemoteAddr, req.Method, shortenURI(req.URL.Path), "->", name)
+
+		req.Header.Del("Authorization")
+		req.Header.Del("Cookie")
+
+		ctx := context.WithValue(req.Context(), relayKey{}, relayTarget{name, req.RemoteAddr, true})
+
+		proxy.ServeHTTP(resp, req.WithContext(ctx))
+	})
+}
+
+// relayByHost passes the request to the share named by the host name, "<name>.<relay domain>";
+// the links with "/r/<name>/" prefix are redirected there
+func relayByHost(resp http.ResponseWriter, req *http.Request, proxy http.Handler) {
+	host, port, err := net.SplitHostPort(req.Host)
+
+	if err != nil {
+		host, port = req.Host, ""
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if host == relayDomain && strings.HasPrefix(req.URL.Path, relaySharePrefix) {
+		rest := req.URL.Path[len(relaySharePrefix):]
+		name, rest := rest, "/"
+
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			name, rest = name[:i], name[i:]
+		}
+
+		u := url.URL{Scheme: "http", Host: name + "." + relayDomain, Path: rest, RawQuery: req.URL.RawQuery}
+
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+
+		if len(port) > 0 {
+			u.Host = net.JoinHostPort(u.Host, port)
+		}
+
+		http.Redirect(resp, req, u.String(), http.StatusMovedPermanently)
+		return
+	}
+
+	name := strings.TrimSuffix(host, "."+relayDomain)
+
+	if name == host || !validRelayName(name) || liveRelayShare(name) == nil {
+		http.Error(resp, "No such share", http.StatusNotFound)
+		return
+	}
+
+	log.Println(req.RemoteAddr, req.Method, shortenURI(req.URL.Path), "->", name)
+
+	ctx := context.WithValue(req.Context(), relayKey{}, relayTarget{name, req.RemoteAddr, false})
+
+	proxy.ServeHTTP(resp, req.WithContext(ctx))
+}
+
+// relayShareURL returns the URL of the share on the relay
+func relayShareURL(req *http.Request, name string) string {
+	u := url.URL{Scheme: "http", Host: req.Host, Path: relaySharePrefix + name + "/"}
+
+	if req.TLS != nil {
+		u.Scheme = "https"
+	}
+
+	if len(relayDomain) > 0 {
+		u.Host, u.Path = name+"."+relayDomain, "/"
+
+		if _, port, err := net.SplitHostPort(req.Host); err == nil {
+			u.Host = net.JoinHostPort(u.Host, port)
+		}
+	}
+
+	return u.String()
+}
+
+// acceptTunnel takes over the connection of the tunnel request from the share, keeping it until
+// a request for the share comes
+func acceptTunnel(resp http.ResponseWriter, req *http.Request, name string) {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), relayProtocol) || !validRelayName(name) {
+		http.Error(resp, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	// the name is a part of the host name
+	if len(relayDomain) > 0 && (name != strings.ToLower(name) || strings.ContainsRune(name, '_')) {
+		http.Error(resp, "Invalid share name, must be lowercase letters, digits, and \"-\"", http.StatusBadRequest)
+		return
+	}
+
+	if len(relayToken) > 0 &&
+		subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+relayToken)) != 1 {
+		log.Println(req.RemoteAddr, "Share", name, "rejected: invalid token")
+		http.Error(resp, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	owner := req.Header.Get(relayInstance)
+
+	if len(owner) == 0 {
+		http.Error(resp, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+
+	relayShares.Lock()
+
+	s := relayShares.byName[name]
+
+	if s != nil && s.owner != owner && now.Sub(s.last) < relayOwnerTTL {
+		relayShares.Unlock()
+		log.Println(req.RemoteAddr, "Share", name, "rejected: the name is taken")
+		http.Error(resp, "The name is taken", http.StatusConflict)
+		return
+	}
+
+	if s == nil || s.owner != owner {
+		s = &relayShare{owner: owner, idle: make(chan *relayTunnel, 4*relayIdle)}
+		relayShares.byName[name] = s
+		log.Println(req.RemoteAddr, "Share", name, "connected")
+	}
+
+	s.last = now
+	relayShares.Unlock()
+
+	hj, ok := resp.(http.Hijacker)
+
+	if !ok {
+		http.Error(resp, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	conn, rw, err := hj.Hijack()
+
+	if err != nil {
+		log.Println(req.RemoteAddr, "Tunnel error:", err)
+		return
+	}
+
+	conn.SetDeadline(time.Time{})
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: " + relayProtocol + "\r\nConnection: Upgrade\r\n" +
+		relayURLHeader + ": " + relayShareURL(req, name) + "\r\n\r\n")
+
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	t := &relayTunnel{conn: conn}
+
+	select {
+	case s.idle <- t:
+		go t.ping()
+	default:
+		conn.Close() // too many idle tunnels
+	}
+}
+
+// ping keeps the idle tunnel open through NATs and firewalls, until it is taken
+func (t *relayTunnel) ping() {
+	ticker := time.NewTicker(relayPing)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.lock.Lock()
+
+		if t.taken || t.dead {
+			t.lock.Unlock()
+			return
+		}
+
+		t.conn.SetWriteDeadline(time.Now().Add(relayPing))
+
+		if _, err := t.conn.Write([]byte("\n")); err != nil {
+			t.dead = true
+			t.conn.Close()
+		}
+
+		t.lock.Unlock()
+	}
+}
+
+// takeTunnel returns an idle tunnel of the share, telling the share the client address
+func takeTunnel(ctx context.Context, name, client string) (net.Conn, error) {
+	s := liveRelayShare(name)
+
+	if s == nil {
+		return nil, errRelayOffline
+	}
+
+	timer := time.NewTimer(relayWait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case t := <-s.idle:
+			t.lock.Lock()
+
+			if t.dead {
+				t.lock.Unlock()
+				continue
+			}
+
+			t.taken = true
+			t.conn.SetWriteDeadline(time.Now().Add(relayPing))
+			_, err := t.conn.Write([]byte("C " + client + "\n"))
+			t.conn.SetWriteDeadline(time.Time{})
+			t.lock.Unlock()
+
+			if err != nil {
+				t.conn.Close()
+				continue
+			}
+
+			return t.conn, nil
+		case <-timer.C:
+			return nil, errRelayOffline
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// liveRelayShare returns the share, unless it has been gone for a while
+func liveRelayShare(name string) *relayShare {
+	relayShares.Lock()
+	defer relayShares.Unlock()
+
+	s := relayShares.byName[name]
+
+	if s != nil && len(s.idle) == 0 && time.Since(s.last) > relayOwnerTTL {
+		delete(relayShares.byName, name)
+		log.Println("Share", name, "gone")
+		return nil
+	}
+
+	return s
+}
+
+// onlyRelayShare returns the name of the share, if only one share is connected
+func onlyRelayShare() string {
+	relayShares.Lock()
+
+	names := make([]string, 0, len(relayShares.byName))
+
+	for name := range relayShares.byName {
+		names = append(names, name)
+	}
+
+	relayShares.Unlock()
+
+	var only string
+
+	for _, name := range names {
+		if liveRelayShare(name) != nil {
+			if len(only) > 0 {
+				return ""
+			}
+
+			only = name
+		}
+	}
+
+	return only
+}
+
+// validRelayName checks the share name is made of letters, digits, "-" and "_"
+func validRelayName(name string) bool {
+	if len(name) == 0 || len(name) > 64 {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// share side: listener of the requests coming over the tunnels to the relay
+type relayListener struct {
+	base      *url.URL // relay URL
+	tlsConfig *tls.Config
+	instance  string
+
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+
+	lock sync.Mutex
+	up   bool   // connected to the relay
+	url  string // of the share on the relay
+	err  string
+}
+
+var errRelayClosed = errors.New("relay listener closed")
+
+// startRelayListener connects the share to the relay, if configured; nil otherwise
+func startRelayListener() *relayListener {
+	if len(relayVia) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(relayVia)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		die("Invalid relay URL: "+relayVia, err)
+	}
+
+	if len(relayName) == 0 {
+		relayName = strings.ToLower(shortID(10))
+	}
+
+	if !validRelayName(relayName) {
+		die("Invalid relay share name: "+relayName, nil)
+	}
+
+	l := &relayListener{
+		base:     u,
+		instance: randomToken(),
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+
+	if u.Scheme == "https" {
+		host := u.Host
+
+		if len(u.Port()) == 0 {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+
+		if len(relayFingerprint) > 0 {
+			if l.tlsConfig, err = trustConfig(host, "", relayFingerprint); err != nil {
+				die("", err)
+			}
+		} else {
+			l.tlsConfig = &tls.Config{ServerName: u.Hostname()}
+		}
+	}
+
+	for i := 0; i < relayIdle; i++ {
+		go l.keep()
+	}
+
+	return l
+}
+
+func (l *relayListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errRelayClosed
+	}
+}
+
+func (l *relayListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *relayListener) Addr() net.Addr {
+	return relayAddr(l.base.String())
+}
+
+type relayAddr string
+
+func (a relayAddr) Network() string { return "relay" }
+func (a relayAddr) String() string  { return string(a) }
+
+// keep keeps a tunnel waiting on the relay, opening another one when a request comes over it
+func (l *relayListener) keep() {
+	delay := time.Second
+
+	for {
+		conn, err := l.tunnel()
+
+		select {
+		case <-l.done:
+			if conn != nil {
+				conn.Close()
+			}
+
+			return
+		default:
+		}
+
+		if err != nil {
+			l.status(err)
+
+			select {
+			case <-time.After(delay):
+			case <-l.done:
+				return
+			}
+
+			if delay *= 2; delay > time.Minute {
+				delay = time.Minute
+			}
+
+			continue
+		}
+
+		delay = time.Second
+
+		select {
+		case l.conns <- conn:
+		case <-l.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// status logs the changes of the relay connection state
+func (l *relayListener) status(err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if err == nil {
+		if !l.up {
+			l.up, l.err = true, ""
+			log.Println("Connected to relay, share URL:", l.url)
+		}
+
+		return
+	}
+
+	if l.up || l.err != err.Error() {
+		l.up, l.err = false, err.Error()
+		log.Println("Relay connection failed:", err)
+	}
+}
+
+// tunnel opens a tunnel to the relay, and waits for a request to come over it
+func (l *relayListener) tunnel() (net.Conn, error) {
+	addr := l.base.Host
+
+	if len(l.base.Port()) == 0 {
+		if l.tlsConfig != nil {
+			addr = net.JoinHostPort(l.base.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(l.base.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: relayPing}
+
+	var conn net.Conn
+	var err error
+
+	if l.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, l.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(l.base.String(), "/")+relayPrefix+relayName, nil)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", relayProtocol)
+	req.Header.Set(relayInstance, l.instance)
+
+	if len(relayToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+relayToken)
+	}
+
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, errors.New("relay response: " + resp.Status)
+	}
+
+	l.lock.Lock()
+
+	if l.url = resp.Header.Get(relayURLHeader); len(l.url) == 0 {
+		l.url = strings.TrimSuffix(l.base.String(), "/") + relaySharePrefix + relayName + "/" // older relays
+	}
+
+	l.lock.Unlock()
+	l.status(nil)
+
+	// wait for a client, skipping the pings
+	for {
+		conn.SetReadDeadline(time.Now().Add(3 * relayPing))
+
+		line, err := br.ReadString('\n')
+
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "C ") {
+			conn.SetDeadline(time.Time{})
+
+			remote, err := net.ResolveTCPAddr("tcp", line[2:])
+
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+
+			return &relayConn{Conn: conn, r: br, local: relayAddr(l.base.String()), remote: remote}, nil
+		}
+	}
+}
+
+// connection from a client over the relay
+type relayConn struct {
+	net.Conn
+	r      *bufio.Reader
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *relayConn) Read(data []byte) (int, error) { return c.r.Read(data) }
+func (c *relayConn) LocalAddr() net.Addr           { return c.local }
+func (c *relayConn) RemoteAddr() net.Addr          { return c.remote }
@@ -0,0 +1,168 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// request rate limit per client address
+var (
+	requestRateSpec string
+	requestBurst    uint
+)
+
+func init() {
+	gnuflag.StringVar(&requestRateSpec, "rate", "",
+		"Limit the rate of requests from each client address, like \"10/s\" or \"600/m\"; excess requests get 429 response.")
+	gnuflag.UintVar(&requestBurst, "burst", 0,
+		"Number of requests a client may make at once under --rate (default: the number of requests in --rate).")
+}
+
+// token bucket of a client
+type requestBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// withRequestRate limits the rate of requests from each client address
+func withRequestRate(next http.Handler) http.Handler {
+	if len(requestRateSpec) == 0 {
+		return next
+	}
+
+	count, per, err := parseRequestRate(requestRateSpec)
+
+	if err != nil {
+		die("Invalid request rate: "+requestRateSpec, err)
+	}
+
+	rate := count / per.Seconds() // requests per second
+	burst := float64(requestBurst)
+
+	if burst == 0 {
+		burst = math.Max(1, math.Floor(count))
+	}
+
+	log.Println("Request rate limit:", requestRateSpec, "per client, burst", burst)
+
+	var lock sync.Mutex
+
+	buckets := make(map[string]*requestBucket)
+	cleaned := time.Now()
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		key := req.RemoteAddr
+
+		if ip := remoteIP(req); ip != nil {
+			key = ip.String()
+		}
+
+		now := time.Now()
+
+		lock.Lock()
+
+		// forget the clients whose buckets have refilled
+		if now.Sub(cleaned) > time.Minute {
+			for k, b := range buckets {
+				if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+					delete(buckets, k)
+				}
+			}
+
+			cleaned = now
+		}
+
+		b := buckets[key]
+
+		if b == nil {
+			b = &requestBucket{tokens: burst, last: now}
+			buckets[key] = b
+		}
+
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+
+		ok := b.tokens >= 1
+
+		if ok {
+			b.tokens--
+		}
+
+		wait := (1 - b.tokens) / rate
+		lock.Unlock()
+
+		if !ok {
+			log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: too many requests")
+			resp.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait))))
+			http.Error(resp, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// parseRequestRate parses the rate like "10/s", "600/m", "1000/h", or just "10" per second
+func parseRequestRate(s string) (float64, time.Duration, error) {
+	per := time.Second
+	i := strings.IndexByte(s, '/')
+
+	if i >= 0 {
+		switch strings.TrimSpace(s[i+1:]) {
+		case "s", "sec":
+			per = time.Second
+		case "m", "min":
+			per = time.Minute
+		case "h", "hour":
+			per = time.Hour
+		default:
+			return 0, 0, errors.New("expected the rate per s, m, or h")
+		}
+
+		s = s[:i]
+	}
+
+	count, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+
+	if err == nil && (count <= 0 || math.IsInf(count, 0) || math.IsNaN(count)) {
+		err = errors.New("the rate must be positive")
+	}
+
+	return count, per, err
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// allow search engines to index the share
+var allowIndexing bool
+
+func init() {
+	gnuflag.BoolVar(&allowIndexing, "allow-indexing", false,
+		"Do not serve the built-in robots.txt, and do not send \"X-Robots-Tag: noindex\" header.")
+}
+
+// built-in robots.txt
+const robotsTxt = "User-agent: *\nDisallow: /\n"
+
+var robotsTS = time.Now()
+
+func withRobots(next http.Handler) http.Handler {
+	if allowIndexing {
+		return next
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("X-Robots-Tag", "noindex, nofollow, noarchive")
+
+		if req.URL.Path == "/robots.txt" {
+			log.Println(logTag(req), req.Method, req.URL.Path)
+			resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			http.ServeContent(resp, req, req.URL.Path, robotsTS, strings.NewReader(robotsTxt))
+			return
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/maxim2266/mvr"
+)
+
+// root directory availability checks
+const (
+	rootCheckPeriod  = 2 * time.Second
+	rootCheckTimeout = 5 * time.Second // a dropped network mount may block the check
+)
+
+// non-zero while the root directory is unavailable
+var rootLost int32
+
+func rootUnavailable() bool {
+	return atomic.LoadInt32(&rootLost) != 0
+}
+
+// withRootCheck watches the root directory, and rejects requests while it is unavailable,
+// like when the USB drive is pulled out or the network mount is dropped, resuming automatically
+// once the directory is back
+func withRootCheck(next http.Handler) http.Handler {
+	go watchRoot(rootDir, isMountPoint(rootDir))
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !rootUnavailable() {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: root directory unavailable")
+
+		resp.Header().Set("Retry-After", "30")
+		servePage(resp, http.StatusServiceUnavailable, "Temporarily unavailable",
+			"The shared directory is not available at the moment, probably because its drive or network mount has been disconnected. Please try again later.", time.Time{})
+	})
+}
+
+// watchRoot periodically checks the root directory, logging the changes of its availability
+func watchRoot(root string, mounted bool) {
+	ticker := time.NewTicker(rootCheckPeriod)
+	defer ticker.Stop()
+
+	var since time.Time    // when the directory became unavailable
+	var pending chan error // check in progress, if any
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-mvr.Done():
+			return
+		}
+
+		if pending == nil {
+			pending = make(chan error, 1)
+
+			go func(ch chan<- error) { ch <- checkRoot(root, mounted) }(pending)
+		}
+
+		var err error
+
+		select {
+		case err = <-pending:
+			pending = nil
+		case <-time.After(rootCheckTimeout):
+			err = errors.New("not responding")
+		case <-mvr.Done():
+			return
+		}
+
+		switch {
+		case err != nil && !rootUnavailable():
+			since = time.Now()
+			atomic.StoreInt32(&rootLost, 1)
+			log.Println("Root directory is unavailable:", err)
+		case err == nil && rootUnavailable():
+			atomic.StoreInt32(&rootLost, 0)
+			log.Println("Root directory is available again after", time.Since(since).Round(time.Second))
+		}
+	}
+}
+
+// checkRoot verifies that the root directory exists, can be read, and is still mounted,
+// if it was a mount point at startup
+func checkRoot(root string, mounted bool) error {
+	info, err := store.Stat(root)
+
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return errors.New("not a directory")
+	}
+
+	if mounted && !isMountPoint(root) {
+		return errors.New("not mounted")
+	}
+
+	dir, err := store.Open(root)
+
+	if err != nil {
+		return err
+	}
+
+	defer dir.Close()
+
+	if _, err = dir.Readdir(1); err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
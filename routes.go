@@ -0,0 +1,182 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// upload routing rules, like "image/*=/photos; .zip,.tar.gz=/incoming/archives"
+var uploadRoutesSpec string
+
+func init() {
+	gnuflag.StringVar(&uploadRoutesSpec, "upload-routes", "",
+		"Route uploads to directories by file extension or MIME type, e.g. \"image/*=/photos; .zip,.tar.gz=/incoming/archives\".")
+}
+
+// upload routing rule
+type uploadRoute struct {
+	patterns []string // extensions like ".tar.gz", or MIME types like "image/*"
+	dir      string   // URL path of the target directory
+}
+
+var uploadRoutes []uploadRoute
+
+func setupUploadRoutes() {
+	if len(uploadRoutesSpec) == 0 {
+		return
+	}
+
+	if !allowUpload {
+		die("Option --upload-routes requires --allow-upload", nil)
+	}
+
+	var err error
+
+	if uploadRoutes, err = parseUploadRoutes(uploadRoutesSpec); err != nil {
+		die("Invalid upload routes", err)
+	}
+
+	log.Println("Routing uploads:", uploadRoutesSpec)
+}
+
+func parseUploadRoutes(spec string) (routes []uploadRoute, err error) {
+	for _, rule := range strings.Split(spec, ";") {
+		if rule = strings.TrimSpace(rule); len(rule) == 0 {
+			continue
+		}
+
+		i := strings.LastIndexByte(rule, '=')
+
+		if i < 0 {
+			return nil, errors.New("missing target directory in " + rule)
+		}
+
+		r := uploadRoute{dir: path.Clean("/" + strings.TrimSpace(rule[i+1:]))}
+
+		for _, p := range strings.Split(rule[:i], ",") {
+			if p = strings.ToLower(strings.TrimSpace(p)); len(p) == 0 {
+				continue
+			}
+
+			if !strings.HasPrefix(p, ".") && !strings.Contains(p, "/") {
+				return nil, errors.New("invalid pattern: " + p)
+			}
+
+			r.patterns = append(r.patterns, p)
+		}
+
+		if len(r.patterns) == 0 {
+			return nil, errors.New("no patterns in " + rule)
+		}
+
+		routes = append(routes, r)
+	}
+
+	return
+}
+
+// routeUpload returns the target path for the upload, given its URL path and MIME type;
+// with empty type it is guessed from the file extension.
+func routeUpload(target, mimeType string) string {
+	name := strings.ToLower(path.Base(target))
+
+	if len(mimeType) == 0 {
+		mimeType = mime.TypeByExtension(path.Ext(name))
+	}
+
+	if t, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = t
+	}
+
+	for _, r := range uploadRoutes {
+		if matchType(name, mimeType, r.patterns) {
+			return path.Join(r.dir, path.Base(target))
+		}
+	}
+
+	return target
+}
+
+// matchType checks the lower-case file name and its MIME type against the patterns,
+// which are file extensions like ".tar.gz", or MIME types like "image/*"
+func matchType(name, mimeType string, patterns []string) bool {
+	for _, p := range patterns {
+		switch {
+		case strings.HasPrefix(p, "."):
+			if strings.HasSuffix(name, p) {
+				return true
+			}
+		case strings.HasSuffix(p, "/*"):
+			if strings.HasPrefix(mimeType, p[:len(p)-1]) {
+				return true
+			}
+		default:
+			if mimeType == p {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// routeByContent routes the upload by its name, then by its declared content type, and finally by
+// the type detected from the content, returning the target path and the request body to read.
+func routeByContent(target string, req *http.Request) (string, io.Reader) {
+	if len(uploadRoutes) == 0 {
+		return target, req.Body
+	}
+
+	if routed := routeUpload(target, ""); routed != target {
+		return routed, req.Body
+	}
+
+	// curl sends form content type with --data-binary
+	switch t := req.Header.Get("Content-Type"); t {
+	case "", "application/octet-stream", "application/x-www-form-urlencoded":
+		// unknown
+	default:
+		return routeUpload(target, t), req.Body
+	}
+
+	body := bufio.NewReaderSize(req.Body, 512)
+	head, _ := body.Peek(512)
+
+	return routeUpload(target, http.DetectContentType(head)), body
+}
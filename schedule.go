@@ -0,0 +1,268 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// availability schedule, like "Mon-Fri 09:00-18:00; Sat 10:00-14:00"
+var scheduleSpec string
+
+func init() {
+	gnuflag.StringVar(&scheduleSpec, "schedule", "",
+		"Only serve within the given time windows, e.g. \"Mon-Fri 09:00-18:00; Sat 10:00-14:00\".")
+}
+
+// time window within a week
+type window struct {
+	days       [7]bool // indexed by time.Weekday
+	start, end int     // minutes since midnight; end < start means the window ends on the next day
+}
+
+type schedule []window
+
+func withSchedule(next http.Handler) http.Handler {
+	if len(scheduleSpec) == 0 {
+		return next
+	}
+
+	sched, err := parseSchedule(scheduleSpec)
+
+	if err != nil {
+		die("Invalid schedule", err)
+	}
+
+	log.Println("Serving only within", scheduleSpec)
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		now := time.Now()
+
+		if sched.open(now) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: outside of schedule")
+
+		opens := sched.next(now)
+
+		if !opens.IsZero() {
+			resp.Header().Set("Retry-After", strconv.Itoa(int(opens.Sub(now).Seconds())+1))
+		}
+
+		servePage(resp, http.StatusServiceUnavailable, "Closed",
+			"This share is only available "+scheduleSpec+".", opens)
+	})
+}
+
+func parseSchedule(spec string) (schedule, error) {
+	var sched schedule
+
+	for _, s := range strings.Split(spec, ";") {
+		if s = strings.TrimSpace(s); len(s) == 0 {
+			continue
+		}
+
+		var w window
+		var err error
+
+		fields := strings.Fields(s)
+
+		switch len(fields) {
+		case 1:
+			for i := range w.days {
+				w.days[i] = true
+			}
+		case 2:
+			if w.days, err = parseDays(fields[0]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("invalid time window: " + strconv.Quote(s))
+		}
+
+		span := strings.Split(fields[len(fields)-1], "-")
+
+		if len(span) != 2 {
+			return nil, errors.New("invalid time span: " + strconv.Quote(fields[len(fields)-1]))
+		}
+
+		if w.start, err = parseClock(span[0]); err != nil {
+			return nil, err
+		}
+
+		if w.end, err = parseClock(span[1]); err != nil {
+			return nil, err
+		}
+
+		sched = append(sched, w)
+	}
+
+	if len(sched) == 0 {
+		return nil, errors.New("empty schedule")
+	}
+
+	return sched, nil
+}
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseDays parses day lists like "Mon-Fri", "Sat,Sun", "weekdays", "weekends" or "daily"
+func parseDays(s string) (days [7]bool, err error) {
+	for _, item := range strings.Split(strings.ToLower(s), ",") {
+		switch item {
+		case "daily":
+			item = "sun-sat"
+		case "weekdays":
+			item = "mon-fri"
+		case "weekends":
+			days[time.Saturday], days[time.Sunday] = true, true
+			continue
+		}
+
+		span := strings.Split(item, "-")
+		first, ok1 := dayNames[span[0]]
+		last, ok2 := first, ok1
+
+		if len(span) == 2 {
+			last, ok2 = dayNames[span[1]]
+		}
+
+		if !ok1 || !ok2 || len(span) > 2 {
+			return days, errors.New("invalid day specification: " + strconv.Quote(item))
+		}
+
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+
+			if d == last {
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// parseClock converts "HH:MM" to minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+
+	if err != nil {
+		return 0, errors.New("invalid time: " + strconv.Quote(s))
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (sched schedule) open(t time.Time) bool {
+	mins := t.Hour()*60 + t.Minute()
+	today, yesterday := t.Weekday(), (t.Weekday()+6)%7
+
+	for _, w := range sched {
+		if w.start <= w.end {
+			if w.days[today] && mins >= w.start && mins < w.end {
+				return true
+			}
+		} else if (w.days[today] && mins >= w.start) || (w.days[yesterday] && mins < w.end) {
+			// overnight window
+			return true
+		}
+	}
+
+	return false
+}
+
+// next returns the time when the schedule opens next, or zero time if it never does
+func (sched schedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute)
+
+	for i := 0; i < 8*24*60; i++ {
+		if t = t.Add(time.Minute); sched.open(t) {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// simple page for the server's own responses
+func servePage(resp http.ResponseWriter, status int, title, msg string, until time.Time) {
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	resp.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	resp.WriteHeader(status)
+
+	var when string
+
+	if !until.IsZero() {
+		when = until.Format("Mon, 02 Jan 2006 15:04 MST")
+	}
+
+	err := pageTemplate.Execute(resp, struct{ Title, Message, When, ID string }{
+		title, msg, when, resp.Header().Get(requestIDHeader),
+	})
+
+	if err != nil {
+		log.Println("Template error:", err)
+	}
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>body{font-family:sans-serif;text-align:center;margin-top:15%;color:#333}</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{if .When}}<p>Please come back at {{.When}}.</p>{{end}}
+{{if .ID}}<p><small>Request ID: {{.ID}}</small></p>{{end}}
+</body>
+</html>
+`))
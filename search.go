@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// default number of search results
+const searchLimit = 200
+
+func init() {
+	apiMux.HandleFunc("/_api/search", serveSearch)
+	apiMux.HandleFunc("/_api/search/", serveSearch)
+}
+
+// GET /_api/search[/<path>]?q=<pattern>[&limit=<n>]: files under the path with the names matching the pattern,
+// sorted by path. The pattern is either a part of the name, or a shell pattern like "*.jpg", case-insensitive;
+// a pattern with a slash is matched against the path relative to the directory.
+func serveSearch(resp http.ResponseWriter, req *http.Request) {
+	dir := apiTarget(req, "/_api/search")
+
+	if info, err := store.Stat(dir); err != nil || !info.IsDir() || isIgnored(dir, true) {
+		apiError(resp, req, http.StatusNotFound, "Not found")
+		return
+	}
+
+	query := req.URL.Query()
+	limit, ok := searchQuery(query)
+
+	if !ok {
+		apiError(resp, req, http.StatusBadRequest, "Invalid search pattern or limit")
+		return
+	}
+
+	files, truncated, err := searchFiles(req.Context(), dir, query.Get("q"), limit)
+
+	if err != nil {
+		log.Println(logTag(req), "Search error:", err)
+		apiError(resp, req, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	log.Println(logTag(req), "Search for", strconv.Quote(query.Get("q"))+":", len(files), "file(s)")
+
+	writeJSON(resp, struct {
+		Query     string          `json:"query"`
+		Files     []manifestEntry `json:"files"`
+		Truncated bool            `json:"truncated,omitempty"`
+	}{query.Get("q"), files, truncated})
+}
+
+// searchQuery validates the pattern, and returns the limit of the search results
+func searchQuery(query url.Values) (int, bool) {
+	q := query.Get("q")
+
+	if len(strings.TrimSpace(q)) == 0 {
+		return 0, false
+	}
+
+	if _, err := path.Match(q, ""); err != nil {
+		return 0, false
+	}
+
+	limit := searchLimit
+
+	if s := query.Get("limit"); len(s) > 0 {
+		var err error
+
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > maxListingLimit {
+			return 0, false
+		}
+	}
+
+	return limit, true
+}
+
+// the walk is stopped when this many files are found
+var errSearchLimit = errors.New("search limit reached")
+
+// searchFiles returns up to the limit of files matching the pattern under the directory, sorted by path,
+// and whether the search stopped at the limit
+func searchFiles(ctx context.Context, dir, pattern string, limit int) ([]manifestEntry, bool, error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	glob := strings.ContainsAny(pattern, "*?[")
+	files := []manifestEntry{}
+
+	var lock sync.Mutex
+
+	err := walkFiles(ctx, dir, func(name, rel string, info os.FileInfo) error {
+		s := rel
+
+		if !strings.Contains(pattern, "/") {
+			s = path.Base(rel)
+		}
+
+		s = strings.ToLower(s)
+
+		if glob {
+			if ok, _ := path.Match(pattern, s); !ok {
+				return nil
+			}
+		} else if !strings.Contains(s, pattern) {
+			return nil
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		if len(files) == limit {
+			return errSearchLimit
+		}
+
+		files = append(files, manifestEntry{Path: rel, Size: info.Size(), Mtime: info.ModTime()})
+		return nil
+	})
+
+	if err != nil && err != errSearchLimit {
+		return nil, false, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, err == errSearchLimit, nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/gnuflag"
+	"github.com/maxim2266/mvr"
+)
+
+// "send" subcommand: serve a single file over HTTP, and optionally over bare TCP connections,
+// so that the file can be received with nothing but netcat
+func sendCommand(args []string) int {
+	var itf string
+	var port, rawPort uint
+	var raw bool
+
+	flags := gnuflag.NewFlagSet("send", gnuflag.ExitOnError)
+
+	flags.StringVar(&itf, "interface", "", "(required) Network interface, IP address, or host name to send from.")
+	flags.StringVar(&itf, "i", "", "(required) Network interface, IP address, or host name to send from.")
+
+	flags.UintVar(&port, "port", defaultPort, "HTTP port number to listen on.")
+	flags.UintVar(&port, "p", defaultPort, "HTTP port number to listen on.")
+	ipFamilyFlags(flags)
+
+	flags.BoolVar(&raw, "raw", false, "Also send the file to every TCP connection on --raw-port, as with \"nc host port > file\".")
+	flags.UintVar(&rawPort, "raw-port", 0, "TCP port number for --raw (default: the HTTP port plus one).")
+
+	flags.Parse(true, args)
+
+	if flags.NArg() != 1 {
+		die("Usage: "+os.Args[0]+" send [options] <file>", nil)
+	}
+
+	if rawPort == 0 {
+		rawPort = port + 1
+	}
+
+	if port == 0 || port > 0xFFFF || rawPort > 0xFFFF || (raw && rawPort == port) {
+		die("Invalid port number", nil)
+	}
+
+	if len(itf) == 0 {
+		die("Network interface is not specified", nil)
+	}
+
+	name, err := filepath.Abs(flags.Arg(0))
+
+	if err != nil {
+		die("", err)
+	}
+
+	info, err := os.Stat(name)
+
+	if err != nil {
+		die("", err)
+	}
+
+	if !info.Mode().IsRegular() {
+		die("Not a regular file: "+name, nil)
+	}
+
+	ips := bindAddresses(itf)
+
+	if len(ips) == 0 {
+		die("Cannot find IP address of "+itf, nil)
+	}
+
+	ip := ips[0]
+
+	mvr.Run(func() int {
+		if raw {
+			addr := net.JoinHostPort(ip, uintToString(rawPort))
+			ln, err := net.Listen("tcp", addr)
+
+			if err != nil {
+				log.Println(err)
+				return 1
+			}
+
+			mvr.OnCancel(0, func(context.Context) { ln.Close() })
+
+			log.Println("Sending", filepath.Base(name), "over TCP on", addr+", receive with:",
+				"nc", ip, uintToString(rawPort), ">", filepath.Base(name))
+
+			go sendRaw(ln, name)
+		}
+
+		addr := net.JoinHostPort(ip, uintToString(port))
+		base := "/" + filepath.Base(name)
+
+		srv := &http.Server{
+			Addr: addr,
+			Handler: http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+				log.Println(req.RemoteAddr, req.Method, shortenURI(req.RequestURI))
+
+				switch req.URL.Path {
+				case "/":
+					http.Redirect(resp, req, base, http.StatusFound)
+				case base:
+					resp.Header().Set("Content-Disposition", `attachment; filename="`+
+						strings.Replace(base[1:], `"`, "'", -1)+`"`)
+					http.ServeFile(resp, req, name)
+				default:
+					http.NotFound(resp, req)
+				}
+			}),
+		}
+
+		mvr.OnCancel(0, func(ctx context.Context) { srv.Shutdown(ctx) })
+
+		log.Println("Sending", filepath.Base(name), "over HTTP at http://"+addr+(&url.URL{Path: base}).EscapedPath())
+
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Println(err)
+			return 1
+		}
+
+		return 0
+	})
+
+	return 0 // not reached
+}
+
+// sendRaw writes the file to every accepted connection
+func sendRaw(ln net.Listener, name string) {
+	for {
+		conn, err := ln.Accept()
+
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			start := time.Now()
+			file, err := os.Open(name)
+
+			if err != nil {
+				log.Println(conn.RemoteAddr(), "Raw transfer error:", err)
+				return
+			}
+
+			defer file.Close()
+
+			log.Println(conn.RemoteAddr(), "Raw transfer started")
+
+			n, err := io.Copy(conn, file)
+
+			if err != nil {
+				log.Println(conn.RemoteAddr(), "Raw transfer error after", sizeString(n)+":", err)
+				return
+			}
+
+			log.Println(conn.RemoteAddr(), "Raw transfer complete:", sizeString(n), "in",
+				time.Since(start).Round(time.Millisecond))
+		}()
+	}
+}
@@ -0,0 +1,278 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// "install-service" subcommand: generate and install a service definition running the server
+// with the given options.
+func installServiceCommand(args []string) int {
+	var name, runAs string
+	var userService, printOnly bool
+
+	flags := gnuflag.NewFlagSet("install-service", gnuflag.ExitOnError)
+
+	flags.StringVar(&name, "name", "web-share", "Service name.")
+	flags.StringVar(&runAs, "run-as", os.Getenv("SUDO_USER"), "Run system service as the given user.")
+	flags.BoolVar(&userService, "user", false, "Install per-user service instead of system-wide one.")
+	flags.BoolVar(&printOnly, "print", false, "Print the service definition without installing it.")
+
+	flags.Parse(true, args)
+
+	if len(name) == 0 || strings.ContainsAny(name, "/\\ ") {
+		die("Invalid service name: "+name, nil)
+	}
+
+	// validate server options
+	serverArgs := flags.Args()
+
+	gnuflag.CommandLine.Parse(true, serverArgs)
+
+	if gnuflag.NArg() > 0 {
+		die("Unexpected argument: "+gnuflag.Arg(0), nil)
+	}
+
+	exe, err := os.Executable()
+
+	if err != nil {
+		die("Cannot find the executable", err)
+	}
+
+	cwd, err := os.Getwd()
+
+	if err != nil {
+		die("Cannot get the current directory", err)
+	}
+
+	svc := service{
+		name:  name,
+		exe:   exe,
+		args:  serverArgs,
+		dir:   cwd,
+		runAs: runAs,
+		user:  userService,
+	}
+
+	var def []byte
+	var path string
+	var commands [][]string
+
+	switch runtime.GOOS {
+	case "linux":
+		def, path, commands = svc.systemd()
+	case "darwin":
+		def, path, commands = svc.launchd()
+	default:
+		die("Services are not supported on "+runtime.GOOS, nil)
+	}
+
+	if printOnly {
+		os.Stdout.Write(def)
+		return 0
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		die("Cannot create directory for the service definition", err)
+	}
+
+	if err = ioutil.WriteFile(path, def, 0644); err != nil {
+		die("Cannot write service definition", err)
+	}
+
+	fmt.Println("Written", path)
+
+	for _, cmd := range commands {
+		fmt.Println("Running", strings.Join(cmd, " "))
+
+		c := exec.Command(cmd[0], cmd[1:]...)
+
+		c.Stdout, c.Stderr = os.Stdout, os.Stderr
+
+		if err = c.Run(); err != nil {
+			die("Command failed: "+cmd[0], err)
+		}
+	}
+
+	return 0
+}
+
+// service definition parameters
+type service struct {
+	name, exe, dir, runAs string
+	args                  []string
+	user                  bool // per-user service
+}
+
+// systemd unit
+func (s *service) systemd() (def []byte, path string, commands [][]string) {
+	var buf bytes.Buffer
+
+	buf.WriteString("[Unit]\n")
+	fmt.Fprintf(&buf, "Description=web-share: %s\n", s.name)
+	buf.WriteString("Wants=network-online.target\nAfter=network-online.target\n\n")
+
+	buf.WriteString("[Service]\n")
+	fmt.Fprintf(&buf, "ExecStart=%s\n", systemdQuote(append([]string{s.exe}, s.args...)))
+	fmt.Fprintf(&buf, "WorkingDirectory=%s\n", s.dir)
+
+	if !s.user && len(s.runAs) > 0 {
+		fmt.Fprintf(&buf, "User=%s\n", s.runAs)
+	}
+
+	buf.WriteString("Restart=on-failure\n\n")
+
+	buf.WriteString("[Install]\n")
+
+	if s.user {
+		buf.WriteString("WantedBy=default.target\n")
+	} else {
+		buf.WriteString("WantedBy=multi-user.target\n")
+	}
+
+	unit := s.name + ".service"
+
+	if s.user {
+		path = filepath.Join(userHome(), ".config", "systemd", "user", unit)
+		commands = [][]string{
+			{"systemctl", "--user", "daemon-reload"},
+			{"systemctl", "--user", "enable", "--now", unit},
+		}
+	} else {
+		path = filepath.Join("/etc/systemd/system", unit)
+		commands = [][]string{
+			{"systemctl", "daemon-reload"},
+			{"systemctl", "enable", "--now", unit},
+		}
+	}
+
+	return buf.Bytes(), path, commands
+}
+
+// systemdQuote joins the arguments, quoting where necessary
+func systemdQuote(args []string) string {
+	res := make([]string, len(args))
+
+	for i, arg := range args {
+		arg = strings.Replace(arg, "%", "%%", -1)
+
+		if len(arg) > 0 && !strings.ContainsAny(arg, " \t\"'\\;$") {
+			res[i] = arg
+			continue
+		}
+
+		arg = strings.Replace(arg, "\\", "\\\\", -1)
+		arg = strings.Replace(arg, "\"", "\\\"", -1)
+		arg = strings.Replace(arg, "$", "$$", -1)
+		res[i] = "\"" + arg + "\""
+	}
+
+	return strings.Join(res, " ")
+}
+
+// launchd property list
+func (s *service) launchd() (def []byte, path string, commands [][]string) {
+	label := s.name
+
+	if !strings.Contains(label, ".") {
+		label = "com.github.maxim2266." + label
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	buf.WriteString("<plist version=\"1.0\">\n<dict>\n")
+
+	plistString(&buf, "Label", label)
+	buf.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+
+	for _, arg := range append([]string{s.exe}, s.args...) {
+		buf.WriteString("\t\t<string>" + xmlEscape(arg) + "</string>\n")
+	}
+
+	buf.WriteString("\t</array>\n")
+	plistString(&buf, "WorkingDirectory", s.dir)
+
+	if !s.user && len(s.runAs) > 0 {
+		plistString(&buf, "UserName", s.runAs)
+	}
+
+	buf.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	buf.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	buf.WriteString("</dict>\n</plist>\n")
+
+	if s.user {
+		path = filepath.Join(userHome(), "Library", "LaunchAgents", label+".plist")
+	} else {
+		path = filepath.Join("/Library/LaunchDaemons", label+".plist")
+	}
+
+	commands = [][]string{{"launchctl", "load", "-w", path}}
+
+	return buf.Bytes(), path, commands
+}
+
+func plistString(buf *bytes.Buffer, key, value string) {
+	buf.WriteString("\t<key>" + key + "</key>\n\t<string>" + xmlEscape(value) + "</string>\n")
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func userHome() string {
+	u, err := user.Current()
+
+	if err != nil {
+		die("Cannot get the current user", err)
+	}
+
+	if len(u.HomeDir) == 0 {
+		die("Cannot get the home directory", errors.New("empty home directory for "+u.Username))
+	}
+
+	return u.HomeDir
+}
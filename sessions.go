@@ -0,0 +1,248 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessions started from the login form
+var sessions = struct {
+	sync.Mutex
+	byToken map[string]*session
+}{
+	byToken: make(map[string]*session),
+}
+
+type session struct {
+	ID        string    `json:"id"` // public identifier, unlike the token
+	User      string    `json:"user"`
+	Device    string    `json:"device"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	Started   time.Time `json:"started"`
+	LastSeen  time.Time `json:"last_seen"`
+	Expires   time.Time `json:"expires"`
+}
+
+func init() {
+	adminMux.HandleFunc("/admin/sessions", serveSessions)
+	adminMux.HandleFunc("/admin/sessions/", serveSessions)
+}
+
+// sessionUser returns the user of the request's session, if any, recording the activity
+func sessionUser(req *http.Request) string {
+	cookie, err := req.Cookie(sessionCookie)
+
+	if err != nil {
+		return ""
+	}
+
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	s, ok := sessions.byToken[cookie.Value]
+
+	if !ok {
+		return ""
+	}
+
+	now := time.Now()
+
+	if now.After(s.Expires) {
+		delete(sessions.byToken, cookie.Value)
+		return ""
+	}
+
+	s.LastSeen = now
+	s.IP = remoteIP(req).String()
+
+	return s.User
+}
+
+// startSession issues a session cookie for the user
+func startSession(resp http.ResponseWriter, req *http.Request, user string) {
+	token, now := randomToken(), time.Now()
+
+	s := &session{
+		ID:        shortID(8),
+		User:      user,
+		Device:    deviceName(req.UserAgent()),
+		UserAgent: req.UserAgent(),
+		IP:        remoteIP(req).String(),
+		Started:   now,
+		LastSeen:  now,
+		Expires:   now.Add(sessionTTL),
+	}
+
+	sessions.Lock()
+
+	// forget the expired sessions
+	for t, s := range sessions.byToken {
+		if now.After(s.Expires) {
+			delete(sessions.byToken, t)
+		}
+	}
+
+	sessions.byToken[token] = s
+	sessions.Unlock()
+
+	http.SetCookie(resp, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  s.Expires,
+		Secure:   req.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// endSession forgets the session with the given token
+func endSession(token string) {
+	sessions.Lock()
+	delete(sessions.byToken, token)
+	sessions.Unlock()
+}
+
+// revokeSession ends the session with the given ID, returning false if there is no such session
+func revokeSession(id string) bool {
+	sessions.Lock()
+	defer sessions.Unlock()
+
+	for token, s := range sessions.byToken {
+		if s.ID == id {
+			delete(sessions.byToken, token)
+			log.Println("Revoked session", id, "of", s.User, "on", s.Device)
+			return true
+		}
+	}
+
+	return false
+}
+
+// sessionList returns a snapshot of the active sessions, the most recently used first
+func sessionList() []session {
+	now := time.Now()
+
+	sessions.Lock()
+
+	list := make([]session, 0, len(sessions.byToken))
+
+	for _, s := range sessions.byToken {
+		if now.Before(s.Expires) {
+			list = append(list, *s)
+		}
+	}
+
+	sessions.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen.After(list[j].LastSeen) })
+	return list
+}
+
+// "/admin/sessions": GET lists the active sessions;
+// "/admin/sessions/<id>": DELETE or POST revokes the session
+func serveSessions(resp http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/admin/sessions"), "/")
+
+	if len(id) == 0 {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			resp.Header().Set("Allow", "GET, HEAD")
+			apiError(resp, req, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		writeJSON(resp, sessionList())
+		return
+	}
+
+	if req.Method != http.MethodDelete && req.Method != http.MethodPost {
+		resp.Header().Set("Allow", "DELETE, POST")
+		apiError(resp, req, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if !revokeSession(id) {
+		apiError(resp, req, http.StatusNotFound, "Not found")
+		return
+	}
+
+	// form on the dashboard
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		http.Redirect(resp, req, "/admin/", http.StatusSeeOther)
+		return
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// deviceName makes a guess about the device and browser from the user agent string
+func deviceName(ua string) string {
+	s := strings.ToLower(ua)
+
+	var device string
+
+	switch {
+	case strings.Contains(s, "iphone"):
+		device = "iPhone"
+	case strings.Contains(s, "ipad"):
+		device = "iPad"
+	case strings.Contains(s, "android"):
+		device = "Android"
+	case strings.Contains(s, "windows"):
+		device = "Windows"
+	case strings.Contains(s, "cros"):
+		device = "Chromebook"
+	case strings.Contains(s, "mac os"):
+		device = "Mac"
+	case strings.Contains(s, "linux"):
+		device = "Linux"
+	default:
+		return clientKind(ua)
+	}
+
+	switch {
+	case strings.Contains(s, "edg/"):
+		return device + ", Edge"
+	case strings.Contains(s, "firefox/"), strings.Contains(s, "fxios/"):
+		return device + ", Firefox"
+	case strings.Contains(s, "chrome/"), strings.Contains(s, "crios/"):
+		return device + ", Chrome"
+	case strings.Contains(s, "safari/"):
+		return device + ", Safari"
+	default:
+		return device
+	}
+}
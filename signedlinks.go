@@ -0,0 +1,344 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// file with the key for signed links
+var linkKeyFile string
+
+func init() {
+	gnuflag.StringVar(&linkKeyFile, "link-key", "",
+		"Require every URL to carry an expiry time and a signature made with the key from the given file (created if missing); links are made with \"sign\" subcommand.")
+
+	controlMux.HandleFunc("/sign", serveSignLink)
+}
+
+// key for signed links
+var linkKey []byte
+
+// cookie extending a signed link to a directory to everything under it, for browsing; the cookie
+// is sent with every request, so that the listing page can use the API and the thumbnails, and
+// is named after the directory, so that the links to several directories can be used at once
+const signedLinkCookie = "web-share-link"
+
+// setupLinkKey reads the key for signed links, or creates the key file
+func setupLinkKey() {
+	if len(linkKeyFile) == 0 {
+		return
+	}
+
+	data, err := ioutil.ReadFile(linkKeyFile)
+
+	if os.IsNotExist(err) {
+		key := make([]byte, 32)
+
+		if _, err = rand.Read(key); err != nil {
+			die("Cannot generate link key", err)
+		}
+
+		data = []byte(base64.StdEncoding.EncodeToString(key) + "\n")
+
+		if err = writeNewFile(linkKeyFile, data); err != nil {
+			die("Cannot create link key file", err)
+		}
+
+		log.Println("Created link key file", linkKeyFile)
+	} else if err != nil {
+		die("Cannot read link key file", err)
+	}
+
+	if linkKey, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err != nil || len(linkKey) < 16 {
+		die("Invalid link key in "+linkKeyFile, nil)
+	}
+
+	log.Println("Signed links required")
+}
+
+// writeNewFile creates the file readable by the owner only, failing if it exists
+func writeNewFile(name string, data []byte) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		os.Remove(name)
+		return err
+	}
+
+	return file.Close()
+}
+
+// linkSignature returns the signature of the URL path (the scope of the link) and the expiry time
+func linkSignature(scope string, exp int64) string {
+	mac := hmac.New(sha256.New, linkKey)
+	mac.Write([]byte(scope + "\n" + strconv.FormatInt(exp, 10)))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkLinkSignature verifies the signature of the scope, returning the expiry time
+func checkLinkSignature(scope, exp, sig string) (time.Time, bool) {
+	t, err := strconv.ParseInt(exp, 10, 64)
+
+	if err != nil || !hmac.Equal([]byte(sig), []byte(linkSignature(scope, t))) {
+		return time.Time{}, false
+	}
+
+	return time.Unix(t, 0), true
+}
+
+// inScope checks if the URL path is covered by the link to the given path; a link to a directory
+// covers everything under it. The path is cleaned first, because it may not be canonical yet.
+func inScope(p, scope string) bool {
+	if p = path.Clean("/" + p); p == scope {
+		return true
+	}
+
+	if !strings.HasSuffix(scope, "/") {
+		return false
+	}
+
+	return p+"/" == scope || strings.HasPrefix(p, scope)
+}
+
+// scopePath maps the URL paths of the requests the listing page makes on its own, to the API
+// and for the thumbnails, to the path of the file or directory they are about
+func scopePath(p string) string {
+	switch {
+	case strings.HasPrefix(p, thumbPrefix):
+		return p[len(thumbPrefix)-1:]
+	case isAPIPath(p):
+		// "/api/list/dir/" -> "/dir/"
+		rest := p[strings.IndexByte(p[1:], '/')+2:]
+
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			return rest[i:]
+		}
+
+		return "/"
+	default:
+		return p
+	}
+}
+
+// withSignedLinks only lets through the requests carrying a valid signature and expiry time
+// in "exp" and "sig" parameters, or in the cookie set by a signed link to a directory
+func withSignedLinks(next http.Handler) http.Handler {
+	setupLinkKey()
+
+	if linkKey == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		exp, sig := query.Get("exp"), query.Get("sig")
+
+		if len(exp) > 0 || len(sig) > 0 {
+			until, ok := checkLinkSignature(req.URL.Path, exp, sig)
+
+			if !ok || time.Now().After(until) {
+				rejectSignedLink(resp, req, ok)
+				return
+			}
+
+			// the following requests from the listing do not carry the signature
+			if strings.HasSuffix(req.URL.Path, "/") {
+				scope := sha256.Sum256([]byte(req.URL.Path))
+
+				http.SetCookie(resp, &http.Cookie{
+					Name:     signedLinkCookie + "-" + hex.EncodeToString(scope[:6]),
+					Value:    base64.RawURLEncoding.EncodeToString([]byte(req.URL.Path)) + "." + exp + "." + sig,
+					Path:     "/",
+					Expires:  until,
+					Secure:   req.TLS != nil,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+
+			query.Del("exp")
+			query.Del("sig")
+			req.URL.RawQuery = query.Encode()
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		expired := false
+		target := scopePath(req.URL.Path)
+
+		for _, c := range req.Cookies() {
+			if c.Name != signedLinkCookie && !strings.HasPrefix(c.Name, signedLinkCookie+"-") {
+				continue
+			}
+
+			parts := strings.Split(c.Value, ".")
+
+			if len(parts) != 3 {
+				continue
+			}
+
+			scope, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+			if err != nil || !inScope(target, string(scope)) {
+				continue
+			}
+
+			if until, ok := checkLinkSignature(string(scope), parts[1], parts[2]); ok {
+				if time.Now().Before(until) {
+					next.ServeHTTP(resp, req)
+					return
+				}
+
+				expired = true
+			}
+		}
+
+		rejectSignedLink(resp, req, expired)
+	})
+}
+
+func rejectSignedLink(resp http.ResponseWriter, req *http.Request, expired bool) {
+	if expired {
+		log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: link expired")
+		servePage(resp, http.StatusForbidden, "Link expired", "This link has expired.", time.Time{})
+		return
+	}
+
+	log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: invalid signature")
+	servePage(resp, http.StatusForbidden, "Invalid link", "This link is not valid.", time.Time{})
+}
+
+// control request to sign a link
+type signRequest struct {
+	Path string        `json:"path"` // URL path, or absolute file system path under the root directory
+	TTL  time.Duration `json:"ttl"`
+}
+
+// control response with the signed link
+type signResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// "/sign" control endpoint
+func serveSignLink(resp http.ResponseWriter, req *http.Request) {
+	var sr signRequest
+
+	if !readControlRequest(resp, req, &sr) {
+		return
+	}
+
+	if linkKey == nil {
+		http.Error(resp, "Signed links are not enabled, see --link-key option", http.StatusBadRequest)
+		return
+	}
+
+	if sr.TTL <= 0 {
+		http.Error(resp, "Invalid link lifetime", http.StatusBadRequest)
+		return
+	}
+
+	target, info := controlTarget(sr.Path)
+
+	if info == nil {
+		http.Error(resp, "Not found: "+target, http.StatusNotFound)
+		return
+	}
+
+	if info.IsDir() && target != "/" {
+		target += "/"
+	}
+
+	res := signResponse{Expires: time.Now().Add(sr.TTL).Truncate(time.Second)}
+	exp := strconv.FormatInt(res.Expires.Unix(), 10)
+
+	res.URL = baseURL() + (&url.URL{Path: target}).EscapedPath() +
+		"?exp=" + exp + "&sig=" + linkSignature(target, res.Expires.Unix())
+
+	log.Println("Signed link to", target, "until", res.Expires.Format(time.RFC3339))
+	writeJSON(resp, &res)
+}
+
+// "sign" subcommand: get a signed link to the path from the running server
+func signCommand(args []string) int {
+	var socket string
+	var port uint
+	var sr signRequest
+
+	flags := gnuflag.NewFlagSet("sign", gnuflag.ExitOnError)
+
+	flags.DurationVar(&sr.TTL, "ttl", 24*time.Hour, "Link lifetime.")
+	flags.StringVar(&socket, "socket", "", "Control socket of the server (default: derived from the port).")
+	flags.UintVar(&port, "port", defaultPort, "Port number of the server.")
+	flags.UintVar(&port, "p", defaultPort, "Port number of the server.")
+
+	flags.Parse(true, args)
+
+	if flags.NArg() != 1 {
+		die("Usage: "+os.Args[0]+" sign <path> [--ttl 1h] [--port 8080]", nil)
+	}
+
+	if len(socket) == 0 {
+		socket = defaultControlSocket(port)
+	}
+
+	sr.Path = controlPath(flags.Arg(0))
+
+	var res signResponse
+
+	if err := newCtlClient(socket).call("/sign", &sr, &res); err != nil {
+		return printCtlError(err)
+	}
+
+	fmt.Println(res.URL)
+	return 0
+}
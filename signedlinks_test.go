@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestInScope(t *testing.T) {
+	cases := []struct {
+		p, scope string
+		ok       bool
+	}{
+		// directory
+		{"/docs/", "/docs/", true},
+		{"/docs", "/docs/", true},
+		{"/docs/a/b.txt", "/docs/", true},
+		{"/docs//a/./b.txt", "/docs/", true},
+		{"/docs2", "/docs/", false},
+		{"/docs2/a.txt", "/docs/", false},
+		{"/docs/../etc/passwd", "/docs/", false},
+		{"/", "/docs/", false},
+		{"", "/docs/", false},
+
+		// file
+		{"/a.txt", "/a.txt", true},
+		{"/a.txt/", "/a.txt", true},
+		{"/a.txt.bak", "/a.txt", false},
+		{"/a.txt/x", "/a.txt", false},
+		{"/x/../a.txt", "/a.txt", true},
+
+		// the whole share
+		{"/", "/", true},
+		{"/a/b", "/", true},
+	}
+
+	for _, c := range cases {
+		if ok := inScope(c.p, c.scope); ok != c.ok {
+			t.Errorf("%q in %q: got %v", c.p, c.scope, ok)
+		}
+	}
+}
+
+func TestScopePath(t *testing.T) {
+	cases := []struct {
+		p, res string
+	}{
+		{"/docs/a.txt", "/docs/a.txt"},
+		{"/_thumb/docs/a.jpg", "/docs/a.jpg"},
+		{"/_thumb/", "/"},
+		{"/api/list/docs/", "/docs/"},
+		{"/api/list", "/"},
+		{"/api/list/", "/"},
+		{"/_api/search/docs/sub", "/docs/sub"},
+		{"/_api/mkdir", "/"},
+		{"/apiary/x", "/apiary/x"},
+	}
+
+	for _, c := range cases {
+		if res := scopePath(c.p); res != c.res {
+			t.Errorf("%q: got %q, expected %q", c.p, res, c.res)
+		}
+	}
+
+	// the requests of a listing under a signed link to a directory
+	for _, p := range []string{"/_thumb/docs/a.jpg", "/api/list/docs/", "/_api/search/docs/"} {
+		if !inScope(scopePath(p), "/docs/") {
+			t.Errorf("%q is not in scope", p)
+		}
+	}
+
+	for _, p := range []string{"/_thumb/other/a.jpg", "/api/list/", "/_api/search/", "/api/list/docs/../other/"} {
+		if inScope(scopePath(p), "/docs/") {
+			t.Errorf("%q is in scope", p)
+		}
+	}
+}
+
+func TestLinkSignature(t *testing.T) {
+	defer func(key []byte) { linkKey = key }(linkKey)
+
+	linkKey = []byte("0123456789abcdef0123456789abcdef")
+
+	exp := time.Now().Add(time.Hour).Unix()
+	sig := linkSignature("/docs/", exp)
+
+	cases := []struct {
+		scope, exp, sig string
+		ok              bool
+	}{
+		{"/docs/", strconv.FormatInt(exp, 10), sig, true},
+		{"/docs", strconv.FormatInt(exp, 10), sig, false},
+		{"/docs/a/", strconv.FormatInt(exp, 10), sig, false},
+		{"/docs/", strconv.FormatInt(exp+1, 10), sig, false},
+		{"/docs/", "x", sig, false},
+		{"/docs/", strconv.FormatInt(exp, 10), sig[1:], false},
+		{"/docs/", strconv.FormatInt(exp, 10), "", false},
+	}
+
+	for i, c := range cases {
+		if when, ok := checkLinkSignature(c.scope, c.exp, c.sig); ok != c.ok {
+			t.Errorf("case %d: got %v", i, ok)
+		} else if ok && when.Unix() != exp {
+			t.Errorf("case %d: got expiry time %v", i, when)
+		}
+	}
+}
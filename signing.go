@@ -0,0 +1,156 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"mime"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// key for signing generated content
+var signKey string
+
+func init() {
+	gnuflag.StringVar(&signKey, "sign-key", "",
+		"Private ssh or minisign key to sign generated checksum files and manifests with.")
+
+	// detached signatures
+	mime.AddExtensionType(".asc", "application/pgp-signature")
+	mime.AddExtensionType(".sig", "application/pgp-signature")
+	mime.AddExtensionType(".minisig", "text/plain; charset=utf-8")
+}
+
+// signer of generated content, nil if not configured
+var signer *signingKey
+
+type signingKey struct {
+	file     string
+	minisign bool // ssh key otherwise
+}
+
+func setupSigning() {
+	if len(signKey) == 0 {
+		return
+	}
+
+	file, err := filepath.Abs(signKey)
+
+	if err != nil {
+		die("Invalid key file name", err)
+	}
+
+	key, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		die("Cannot read signing key", err)
+	}
+
+	signer = &signingKey{
+		file:     file,
+		minisign: bytes.HasPrefix(key, []byte("untrusted comment:")),
+	}
+
+	// make sure it works
+	if _, err = signer.sign([]byte("test")); err != nil {
+		die("Cannot sign with "+signKey, err)
+	}
+
+	log.Println("Signing generated content with", signKey)
+}
+
+// extension of the signature files
+func (key *signingKey) ext() string {
+	if key.minisign {
+		return ".minisig"
+	}
+
+	return ".sig"
+}
+
+// sign returns a detached signature of the data; ssh signatures use "web-share" namespace.
+func (key *signingKey) sign(data []byte) ([]byte, error) {
+	if !key.minisign {
+		return runSigner(data, "ssh-keygen", "-q", "-Y", "sign", "-f", key.file, "-n", "web-share")
+	}
+
+	// minisign only signs files
+	tmp, err := ioutil.TempFile("", "web-share-")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(tmp.Name() + ".minisig")
+
+	if _, err = runSigner(nil, "minisign", "-S", "-s", key.file, "-m", tmp.Name()); err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadFile(tmp.Name() + ".minisig")
+}
+
+func runSigner(data []byte, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return nil, errors.New(name + ": " + msg)
+		}
+
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,189 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// network condition simulation, like "latency=200ms,rate=1M,loss=1%"
+var simulateSpec string
+
+func init() {
+	gnuflag.StringVar(&simulateSpec, "simulate", "",
+		"Simulate a slow network on responses, e.g. \"latency=200ms,rate=1M,loss=1%\".")
+}
+
+type simulation struct {
+	latency time.Duration // delay before each response
+	rate    int64         // bytes per second, 0 for unlimited
+	loss    float64       // probability of a lost packet per written chunk
+}
+
+// size of a simulated packet burst
+const simChunk = 16 * 1024
+
+func withSimulation(next http.Handler) http.Handler {
+	if len(simulateSpec) == 0 {
+		return next
+	}
+
+	sim, err := parseSimulation(simulateSpec)
+
+	if err != nil {
+		die("Invalid network simulation parameters", err)
+	}
+
+	log.Printf("Simulating network: latency %s, rate %s, loss %g%%",
+		sim.latency, rateString(sim.rate), sim.loss*100)
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !sim.sleep(req, sim.latency) {
+			return
+		}
+
+		next.ServeHTTP(&simWriter{ResponseWriter: resp, sim: sim, req: req}, req)
+	})
+}
+
+func parseSimulation(spec string) (*simulation, error) {
+	sim := new(simulation)
+
+	for _, param := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+
+		if len(kv) != 2 {
+			return nil, errors.New("invalid parameter: " + strconv.Quote(param))
+		}
+
+		var err error
+
+		switch kv[0] {
+		case "latency":
+			if sim.latency, err = time.ParseDuration(kv[1]); err == nil && sim.latency < 0 {
+				err = errors.New("negative latency")
+			}
+		case "rate":
+			sim.rate, err = parseByteSize(strings.TrimSuffix(kv[1], "/s"))
+		case "loss":
+			var val float64
+
+			if val, err = strconv.ParseFloat(strings.TrimSuffix(kv[1], "%"), 64); err == nil {
+				if val < 0 || val >= 100 {
+					err = errors.New("loss must be in the range [0, 100)")
+				}
+
+				sim.loss = val / 100
+			}
+		default:
+			err = errors.New("unknown parameter: " + strconv.Quote(kv[0]))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sim, nil
+}
+
+// sleep pauses for the given duration, returning false if the request has been cancelled meanwhile
+func (sim *simulation) sleep(req *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-req.Context().Done():
+		return false
+	}
+}
+
+// response writer with simulated bandwidth and packet loss
+type simWriter struct {
+	http.ResponseWriter
+	sim *simulation
+	req *http.Request
+}
+
+func (w *simWriter) Write(data []byte) (n int, err error) {
+	for len(data) > 0 && err == nil {
+		chunk := data
+
+		if len(chunk) > simChunk {
+			chunk = chunk[:simChunk]
+		}
+
+		// lost packets cost a retransmission timeout
+		if w.sim.loss > 0 && rand.Float64() < w.sim.loss {
+			if !w.sim.sleep(w.req, 2*w.sim.latency+200*time.Millisecond) {
+				return n, w.req.Context().Err()
+			}
+		}
+
+		var m int
+
+		m, err = w.ResponseWriter.Write(chunk)
+		n += m
+		data = data[m:]
+
+		if w.sim.rate > 0 && !w.sim.sleep(w.req, time.Duration(m)*time.Second/time.Duration(w.sim.rate)) {
+			return n, w.req.Context().Err()
+		}
+	}
+
+	return
+}
+
+func (w *simWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func rateString(rate int64) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+
+	return sizeString(rate) + "/s"
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errSince = errors.New("invalid since parameter")
+
+// parseSince parses "since" query parameter, given as RFC 3339 timestamp ("2024-05-01T12:00:00Z"),
+// date ("2024-05-01", local time), seconds since the Unix epoch, or duration back from now ("24h");
+// zero time is returned if the parameter is not present.
+func parseSince(query url.Values) (time.Time, error) {
+	s := strings.TrimSpace(query.Get("since"))
+
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+
+	if t, err := parseMtime(s); err == nil && !strings.HasPrefix(s, "-") {
+		return t, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return time.Now().Add(-d), nil
+	}
+
+	// spaces in the timestamp may come from unescaped '+' in the time zone offset
+	if strings.Contains(s, " ") {
+		return parseSince(url.Values{"since": {strings.Replace(s, " ", "+", -1)}})
+	}
+
+	return time.Time{}, errSince
+}
+
+// modifiedSince checks the modification time against the "since" time; zero time accepts everything.
+func modifiedSince(mtime, since time.Time) bool {
+	return since.IsZero() || !mtime.Before(since)
+}
+
+// sinceString returns "since" parameter for the links to the following pages
+func sinceString(since time.Time) string {
+	if since.IsZero() {
+		return ""
+	}
+
+	return formatMtime(since)
+}
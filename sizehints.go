@@ -0,0 +1,168 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// large file warning threshold
+var largeFileSpec string
+
+func init() {
+	gnuflag.StringVar(&largeFileSpec, "large-file", "1G",
+		"Ask for confirmation before downloading files bigger than the given size (e.g. 500M) from the listing; 0 disables the warning.")
+}
+
+// withSizeHints shows the sizes and types of the files in the directory listings, taken from
+// the listing API, and makes the browser ask for confirmation before downloading a large file,
+// for the guests on metered connections
+func withSizeHints(next http.Handler) http.Handler {
+	threshold, err := parseByteSize(largeFileSpec)
+
+	if err != nil {
+		die("Invalid large file size: "+largeFileSpec, err)
+	}
+
+	var script bytes.Buffer
+
+	if err = sizeHintsScript.Execute(&script, threshold); err != nil {
+		die("Template error", err)
+	}
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet || !strings.HasSuffix(req.URL.Path, "/") {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if hasIndex(resolvePath(req.URL.Path)) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		w := &listingWriter{ResponseWriter: resp, status: http.StatusOK}
+
+		next.ServeHTTP(w, req)
+
+		body := w.buf.Bytes()
+
+		if w.status == http.StatusOK && strings.HasPrefix(resp.Header().Get("Content-Type"), "text/html") &&
+			bytes.Contains(body, []byte("<pre")) {
+			body = append(body, script.Bytes()...)
+			resp.Header().Del("Content-Length")
+		}
+
+		resp.WriteHeader(w.status)
+
+		if _, err := resp.Write(body); err != nil {
+			log.Println(logTag(req), "Listing error:", err)
+		}
+	})
+}
+
+// annotates the links in the listing, including the entries loaded later
+var sizeHintsScript = template.Must(template.New("size-hints").Parse(`<style>
+.size{color:#888;font-size:smaller}
+.size.large{color:#c60;font-weight:bold}
+</style>
+<script>
+(function() {
+	var lists = document.getElementsByTagName("pre"), threshold = {{.}}, entries = {};
+
+	if (lists.length === 0 || !window.fetch) return;
+
+	var listing = lists[lists.length - 1];
+	var api = "/api/list" + location.pathname;
+
+	function size(n) {
+		if (n < 1024) return n + "B";
+
+		var units = "KMGTPE", u = -1;
+
+		do { n /= 1024; u++; } while (n >= 1024 && u < units.length - 1);
+
+		return n.toFixed(1) + units[u];
+	}
+
+	function decorate() {
+		var links = listing.getElementsByTagName("a");
+
+		for (var i = 0; i < links.length; i++) {
+			var a = links[i], e = entries[decodeURIComponent(a.getAttribute("href")).replace(/^\.\//, "")];
+
+			if (!e || e.dir || a.hasAttribute("data-hinted")) continue;
+
+			// the size may be in the listing already
+			var s = document.createElement("span"), shown = a.hasAttribute("data-size");
+
+			s.className = "size";
+			s.textContent = shown ? "" : " " + size(e.size) + (e.type ? ", " + e.type.replace(/;.*/, "") : "");
+			a.setAttribute("data-size", e.size);
+			a.setAttribute("data-hinted", "");
+
+			if (threshold > 0 && e.size > threshold) {
+				s.className += " large";
+				a.title = "Large file: " + size(e.size);
+				a.addEventListener("click", function(ev) {
+					var n = +this.getAttribute("data-size");
+
+					if (!confirm(decodeURIComponent(this.getAttribute("href")) + " is " + size(n) + ". Download anyway?")) ev.preventDefault();
+				});
+			}
+
+			a.parentNode.insertBefore(s, a.nextSibling);
+		}
+	}
+
+	function load(offset) {
+		fetch(api + "?offset=" + offset + "&limit=10000", {credentials: "same-origin"})
+			.then(function(r) { return r.ok ? r.json() : null; })
+			.then(function(page) {
+				if (!page) return;
+
+				page.entries.forEach(function(e) { entries[e.name] = e; });
+				decorate();
+
+				if (page.entries.length > 0 && page.offset + page.entries.length < page.total) load(page.offset + page.entries.length);
+			});
+	}
+
+	load(0);
+
+	if (window.MutationObserver) new MutationObserver(decorate).observe(listing, {childList: true});
+})();
+</script>
+`))
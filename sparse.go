@@ -0,0 +1,177 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// files with less than this proportion of allocated space are served as sparse
+const sparseRatio = 0.75
+
+// withSparse handles sparse files: the allocated size is reported in the X-Allocated-Size header,
+// and full downloads are gzip-compressed on the fly with the holes never read from the disk.
+func withSparse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		name := resolvePath(req.URL.Path)
+		info, err := os.Stat(name)
+
+		if err != nil || !info.Mode().IsRegular() || isIgnored(name, false) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		alloc := allocatedSize(info)
+
+		if float64(alloc) >= float64(info.Size())*sparseRatio {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		resp.Header().Set("X-Allocated-Size", strconv.FormatInt(alloc, 10))
+
+		if len(req.Header.Get("Range")) > 0 || !acceptsGzip(req) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		// conditional request
+		if t, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil &&
+			!info.ModTime().Truncate(time.Second).After(t) {
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		file, err := os.Open(name)
+
+		if err != nil {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		defer file.Close()
+
+		ctype := mime.TypeByExtension(filepath.Ext(name))
+
+		if len(ctype) == 0 {
+			ctype = "application/octet-stream"
+		}
+
+		resp.Header().Set("Content-Type", ctype)
+		resp.Header().Set("Content-Encoding", "gzip")
+		resp.Header().Set("Vary", "Accept-Encoding")
+		resp.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		resp.Header().Del("Content-Length")
+
+		if req.Method == http.MethodHead {
+			return
+		}
+
+		gz, _ := gzip.NewWriterLevel(resp, gzip.BestSpeed)
+
+		if err = copySparse(gz, file, info.Size()); err == nil {
+			err = gz.Close()
+		}
+
+		if err != nil {
+			log.Println(logTag(req), "Sparse file transfer error:", err)
+		}
+	})
+}
+
+func acceptsGzip(req *http.Request) bool {
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		if fields := strings.Split(strings.TrimSpace(enc), ";"); fields[0] == "gzip" {
+			return len(fields) == 1 || strings.Replace(fields[1], " ", "", -1) != "q=0"
+		}
+	}
+
+	return false
+}
+
+// copySparse copies the file to the writer, generating zeros for the holes instead of reading them
+func copySparse(w io.Writer, file *os.File, size int64) error {
+	for off := int64(0); off < size; {
+		data, hole, err := dataRange(file, off, size)
+
+		if err != nil {
+			return err
+		}
+
+		if err = writeZeros(w, data-off); err != nil {
+			return err
+		}
+
+		if _, err = file.Seek(data, io.SeekStart); err != nil {
+			return err
+		}
+
+		if _, err = io.CopyN(w, file, hole-data); err != nil {
+			return err
+		}
+
+		off = hole
+	}
+
+	return nil
+}
+
+var zeros [64 * 1024]byte
+
+func writeZeros(w io.Writer, n int64) error {
+	for n > 0 {
+		chunk := zeros[:]
+
+		if n < int64(len(chunk)) {
+			chunk = chunk[:n]
+		}
+
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+
+		n -= int64(len(chunk))
+	}
+
+	return nil
+}
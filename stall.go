@@ -0,0 +1,82 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/gnuflag"
+	"github.com/maxim2266/mvr"
+)
+
+// time without any data transferred after which a request is aborted
+var stallTimeout time.Duration
+
+func init() {
+	gnuflag.DurationVar(&stallTimeout, "stall-timeout", 2*time.Minute,
+		"Abort requests that have not sent or received any data for the given period, however long the transfer takes overall.")
+}
+
+// startWatchdog closes the connections with requests in progress that have made no progress
+// for the stall timeout; idle connections between requests are left to the server's idle timeout
+func startWatchdog() {
+	if stallTimeout <= 0 {
+		die("Invalid stall timeout: "+stallTimeout.String(), nil)
+	}
+
+	period := stallTimeout / 4
+
+	if period < time.Second {
+		period = time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				for _, info := range connections() {
+					idle := now.Sub(time.Unix(0, atomic.LoadInt64(&info.conn.active)))
+
+					if info.request != nil && idle >= stallTimeout {
+						log.Println(info.remote, "["+info.request.id+"]", "Stalled for",
+							idle.Round(time.Second).String()+", closing the connection")
+						info.conn.Close()
+					}
+				}
+			case <-mvr.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,262 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// file of the runtime state kept across restarts
+var stateFile string
+
+func init() {
+	gnuflag.StringVar(&stateFile, "state", "",
+		"File to save the runtime state to on shutdown, and to restore it from on startup: short links, login sessions, download statistics, and banned addresses.")
+}
+
+// runtime state, as saved to the state file
+type runtimeState struct {
+	Saved    time.Time             `json:"saved"`
+	Links    map[string]*shortLink `json:"links,omitempty"`    // by ID
+	Sessions map[string]*session   `json:"sessions,omitempty"` // by token
+	Files    []fileState           `json:"files,omitempty"`
+	Clients  []*clientStats        `json:"clients,omitempty"`
+	Agents   []agentState          `json:"agents,omitempty"`
+	Banned   []string              `json:"banned,omitempty"`
+}
+
+// statistics with the addresses of the clients, so that they are not counted twice after the restart
+type fileState struct {
+	fileStats
+	Seen []string `json:"seen,omitempty"`
+}
+
+type agentState struct {
+	agentStats
+	Seen []string `json:"seen,omitempty"`
+}
+
+// restoreState loads the state saved by the previous run, if any; expired links and sessions are dropped
+func restoreState() {
+	if len(stateFile) == 0 {
+		return
+	}
+
+	data, err := ioutil.ReadFile(stateFile)
+
+	if err != nil {
+		if !os.IsNotExist(err) {
+			die("Cannot read state file", err)
+		}
+
+		return
+	}
+
+	var state runtimeState
+
+	if err = json.Unmarshal(data, &state); err != nil {
+		die("Invalid state file "+stateFile, err)
+	}
+
+	now := time.Now()
+
+	shortLinks.Lock()
+
+	for id, link := range state.Links {
+		if link.Expires.IsZero() || now.Before(link.Expires) {
+			link.limited = link.Left > 0
+			shortLinks.links[id] = link
+		}
+	}
+
+	shortLinks.Unlock()
+
+	sessions.Lock()
+
+	for token, s := range state.Sessions {
+		if now.Before(s.Expires) {
+			sessions.byToken[token] = s
+		}
+	}
+
+	sessions.Unlock()
+
+	stats.Lock()
+
+	for i := range state.Files {
+		fs := &state.Files[i].fileStats
+		fs.clients = stringSet(state.Files[i].Seen)
+		stats.files[fs.Path] = fs
+	}
+
+	for _, cs := range state.Clients {
+		stats.clients[cs.Address] = cs
+	}
+
+	for i := range state.Agents {
+		as := &state.Agents[i].agentStats
+		as.clients = stringSet(state.Agents[i].Seen)
+		stats.agents[as.UserAgent] = as
+	}
+
+	stats.Unlock()
+
+	bannedIPs.Lock()
+
+	for _, addr := range state.Banned {
+		bannedIPs.addrs[addr] = true
+	}
+
+	bannedIPs.Unlock()
+
+	log.Println("Restored state saved at", state.Saved.Format(time.RFC3339)+":",
+		len(shortLinks.links), "short link(s),", len(sessions.byToken), "session(s),", len(state.Banned), "banned address(es)")
+}
+
+// saveState writes the current state to the state file, replacing it atomically
+func saveState() {
+	if len(stateFile) == 0 {
+		return
+	}
+
+	state := runtimeState{
+		Saved:    time.Now(),
+		Links:    make(map[string]*shortLink),
+		Sessions: make(map[string]*session),
+	}
+
+	shortLinks.Lock()
+
+	for id, link := range shortLinks.links {
+		l := *link
+		state.Links[id] = &l
+	}
+
+	shortLinks.Unlock()
+
+	sessions.Lock()
+
+	for token, s := range sessions.byToken {
+		c := *s
+		state.Sessions[token] = &c
+	}
+
+	sessions.Unlock()
+
+	stats.Lock()
+
+	for _, fs := range stats.files {
+		state.Files = append(state.Files, fileState{*fs, stringList(fs.clients)})
+	}
+
+	for _, cs := range stats.clients {
+		c := *cs
+		state.Clients = append(state.Clients, &c)
+	}
+
+	for _, as := range stats.agents {
+		state.Agents = append(state.Agents, agentState{*as, stringList(as.clients)})
+	}
+
+	stats.Unlock()
+
+	bannedIPs.Lock()
+	state.Banned = stringList(bannedIPs.addrs)
+	bannedIPs.Unlock()
+
+	if err := writeState(&state); err != nil {
+		log.Println("Cannot save state:", err)
+		return
+	}
+
+	log.Println("Saved state to", stateFile)
+}
+
+func writeState(state *runtimeState) error {
+	data, err := json.MarshalIndent(state, "", "\t")
+
+	if err != nil {
+		return err
+	}
+
+	// the file holds session tokens, so it is only readable by the owner
+	tmp, err := ioutil.TempFile(filepath.Dir(stateFile), "."+filepath.Base(stateFile)+".tmp-")
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(append(data, '\n')); err == nil {
+		err = tmp.Sync()
+	}
+
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), stateFile)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+func stringSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+
+	for _, s := range list {
+		set[s] = true
+	}
+
+	return set
+}
+
+// stringList returns the keys of the set, sorted
+func stringList(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+
+	for s := range set {
+		list = append(list, s)
+	}
+
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,323 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// per-file download statistics for the current session
+type fileStats struct {
+	Path      string    `json:"path"`
+	Downloads int       `json:"downloads"`
+	Bytes     int64     `json:"bytes"`
+	Clients   int       `json:"clients"`
+	Last      time.Time `json:"last"`
+
+	clients map[string]bool
+}
+
+// per-client statistics
+type clientStats struct {
+	Address    string    `json:"address"`
+	Requests   int       `json:"requests"`
+	Bytes      int64     `json:"bytes"`
+	UserAgents []string  `json:"user_agents"`
+	Last       time.Time `json:"last"`
+}
+
+// per-user-agent statistics
+type agentStats struct {
+	UserAgent string `json:"user_agent"`
+	Kind      string `json:"kind"`
+	Requests  int    `json:"requests"`
+	Bytes     int64  `json:"bytes"`
+	Clients   int    `json:"clients"`
+
+	clients map[string]bool
+}
+
+var stats = struct {
+	sync.Mutex
+	files   map[string]*fileStats
+	clients map[string]*clientStats
+	agents  map[string]*agentStats
+}{
+	files:   make(map[string]*fileStats),
+	clients: make(map[string]*clientStats),
+	agents:  make(map[string]*agentStats),
+}
+
+func init() {
+	adminMux.HandleFunc("/admin/stats/top", serveTopStats)
+	adminMux.HandleFunc("/admin/stats/clients", serveClientStats)
+}
+
+// withStats records statistics of file downloads
+func withStats(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		tw := &trackingWriter{ResponseWriter: resp}
+
+		next.ServeHTTP(tw, req)
+
+		client := remoteIP(req).String()
+
+		stats.Lock()
+		defer stats.Unlock()
+
+		countClient(client, req.UserAgent(), tw.sent)
+
+		// only count files
+		path := req.URL.Path
+
+		if req.Method != http.MethodGet || strings.HasSuffix(path, "/") || path == "/favicon.ico" {
+			return
+		}
+
+		status := tw.Status()
+
+		if status != http.StatusOK && status != http.StatusPartialContent {
+			return
+		}
+
+		// a download is either a full request, or a range request from the beginning of the file
+		rng := req.Header.Get("Range")
+		download := status == http.StatusOK || strings.HasPrefix(rng, "bytes=0-")
+		fs := stats.files[path]
+
+		if fs == nil {
+			fs = &fileStats{Path: path, clients: make(map[string]bool)}
+			stats.files[path] = fs
+		}
+
+		if download {
+			fs.Downloads++
+		}
+
+		fs.Bytes += tw.sent
+		fs.clients[client] = true
+		fs.Clients = len(fs.clients)
+		fs.Last = time.Now()
+	})
+}
+
+// countClient updates client and user agent statistics; must be called under the lock
+func countClient(client, ua string, sent int64) {
+	now := time.Now()
+	cs := stats.clients[client]
+
+	if cs == nil {
+		cs = &clientStats{Address: client}
+		stats.clients[client] = cs
+	}
+
+	cs.Requests++
+	cs.Bytes += sent
+	cs.Last = now
+
+	if !containsString(cs.UserAgents, ua) {
+		cs.UserAgents = append(cs.UserAgents, ua)
+	}
+
+	as := stats.agents[ua]
+
+	if as == nil {
+		as = &agentStats{UserAgent: ua, Kind: clientKind(ua), clients: make(map[string]bool)}
+		stats.agents[ua] = as
+	}
+
+	as.Requests++
+	as.Bytes += sent
+	as.clients[client] = true
+	as.Clients = len(as.clients)
+}
+
+// clientKind makes a guess about the type of client from its user agent string
+func clientKind(ua string) string {
+	switch s := strings.ToLower(ua); {
+	case len(s) == 0:
+		return "unknown"
+	case strings.Contains(s, "curl"):
+		return "curl"
+	case strings.Contains(s, "wget"):
+		return "wget"
+	case strings.Contains(s, "web-share"), strings.HasPrefix(s, "go-http-client"):
+		return "script"
+	case strings.Contains(s, "smart-tv"), strings.Contains(s, "smarttv"), strings.Contains(s, "tizen"),
+		strings.Contains(s, "webos"), strings.Contains(s, "hbbtv"), strings.Contains(s, "bravia"):
+		return "tv"
+	case strings.Contains(s, "vlc"), strings.Contains(s, "kodi"), strings.Contains(s, "mpv"),
+		strings.Contains(s, "applecoremedia"), strings.Contains(s, "stagefright"),
+		strings.Contains(s, "exoplayer"), strings.Contains(s, "lavf"):
+		return "media player"
+	case strings.Contains(s, "mozilla"):
+		return "browser"
+	default:
+		return "other"
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GET /admin/stats/top?n=10&sort=downloads|bytes|clients
+func serveTopStats(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		apiError(resp, req, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	n := 10
+
+	if s := req.URL.Query().Get("n"); len(s) > 0 {
+		var err error
+
+		if n, err = strconv.Atoi(s); err != nil || n <= 0 {
+			apiError(resp, req, http.StatusBadRequest, "Invalid number of entries")
+			return
+		}
+	}
+
+	list, ok := topFiles(n, req.URL.Query().Get("sort"))
+
+	if !ok {
+		apiError(resp, req, http.StatusBadRequest, "Invalid sort order")
+		return
+	}
+
+	writeJSON(resp, list)
+}
+
+// topFiles returns up to n most downloaded files in the given order: "downloads" (default), "bytes" or "clients"
+func topFiles(n int, order string) ([]fileStats, bool) {
+	var less func(a, b *fileStats) bool
+
+	switch order {
+	case "", "downloads":
+		less = func(a, b *fileStats) bool { return a.Downloads > b.Downloads }
+	case "bytes":
+		less = func(a, b *fileStats) bool { return a.Bytes > b.Bytes }
+	case "clients":
+		less = func(a, b *fileStats) bool { return a.Clients > b.Clients }
+	default:
+		return nil, false
+	}
+
+	// copy
+	stats.Lock()
+
+	list := make([]fileStats, 0, len(stats.files))
+
+	for _, fs := range stats.files {
+		list = append(list, *fs)
+	}
+
+	stats.Unlock()
+
+	// sort
+	sort.Slice(list, func(i, j int) bool {
+		a, b := &list[i], &list[j]
+
+		if less(a, b) {
+			return true
+		}
+
+		if less(b, a) {
+			return false
+		}
+
+		return a.Path < b.Path
+	})
+
+	if len(list) > n {
+		list = list[:n]
+	}
+
+	return list, true
+}
+
+// GET /admin/stats/clients
+func serveClientStats(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		apiError(resp, req, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	clients, agents := clientStatsSnapshot()
+
+	writeJSON(resp, struct {
+		Clients []clientStats `json:"clients"`
+		Agents  []agentStats  `json:"user_agents"`
+	}{clients, agents})
+}
+
+// clientStatsSnapshot returns copies of client and user agent statistics, busiest first
+func clientStatsSnapshot() ([]clientStats, []agentStats) {
+	stats.Lock()
+
+	clients := make([]clientStats, 0, len(stats.clients))
+
+	for _, cs := range stats.clients {
+		c := *cs
+		c.UserAgents = append([]string(nil), cs.UserAgents...)
+		clients = append(clients, c)
+	}
+
+	agents := make([]agentStats, 0, len(stats.agents))
+
+	for _, as := range stats.agents {
+		agents = append(agents, *as)
+	}
+
+	stats.Unlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Requests > clients[j].Requests ||
+			(clients[i].Requests == clients[j].Requests && clients[i].Address < clients[j].Address)
+	})
+
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].Requests > agents[j].Requests ||
+			(agents[i].Requests == agents[j].Requests && agents[i].UserAgent < agents[j].UserAgent)
+	})
+
+	return clients, agents
+}
@@ -0,0 +1,119 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/gnuflag"
+	"github.com/maxim2266/mvr"
+)
+
+// status line update interval
+var statusInterval time.Duration
+
+func init() {
+	gnuflag.DurationVar(&statusInterval, "status-interval", 2*time.Second,
+		"Interval of the status line updates when running on a terminal, 0 to disable.")
+}
+
+// status line at the bottom of the terminal, with the log scrolling above it
+type statusLine struct {
+	lock sync.Mutex
+	text string
+}
+
+// startStatusLine must be called before mvr.Run(), so that the log messages go through the status line
+func startStatusLine() {
+	if statusInterval <= 0 || tuiMode || !isTerminal(os.Stderr) {
+		return
+	}
+
+	sl := new(statusLine)
+
+	log.SetOutput(sl)
+
+	mvr.OnCancel(0, func(context.Context) {
+		sl.update("")
+	})
+
+	go func() {
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+
+		prev, ts := atomic.LoadInt64(&totalSent), time.Now()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				sent := atomic.LoadInt64(&totalSent)
+				rate := float64(sent-prev) / now.Sub(ts).Seconds()
+				prev, ts = sent, now
+
+				sl.update("[" + strconv.FormatInt(atomic.LoadInt64(&activeConns), 10) + " connection(s), " +
+					sizeString(int64(rate)) + "/s, " + sizeString(sent) + " total]")
+			case <-mvr.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Write outputs a log message above the status line
+func (sl *statusLine) Write(msg []byte) (int, error) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	os.Stderr.WriteString("\r\033[K")
+
+	n, err := os.Stderr.Write(msg)
+
+	os.Stderr.WriteString(sl.text)
+	return n, err
+}
+
+func (sl *statusLine) update(text string) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	sl.text = text
+	os.Stderr.WriteString("\r\033[K" + text)
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
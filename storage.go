@@ -0,0 +1,136 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// storage backend of the share. The names are the file system paths as given by resolvePath,
+// so that ignore files, logging, and the like keep working with them; a remote backend maps them
+// to its own object names or paths. Resumable uploads, CGI scripts, and a few other features
+// still use the local file system directly.
+type storage interface {
+	// reading
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Open(name string) (http.File, error)        // file or directory
+	ReadDir(name string) ([]os.FileInfo, error) // in no particular order
+
+	// writing; the uploads are written to a temporary file first, and then renamed
+	TempFile(dir, prefix string) (storageFile, error)
+	OpenPart(name string, flag int) (storagePart, error) // partial file of a resumable upload, written in place
+	MkdirAll(name string) error
+	Rename(from, to string) error
+	Link(from, to string) error // fails with os.ErrExist if the name is taken
+	Remove(name string) error
+	RemoveAll(name string) error
+}
+
+// file being written to the storage
+type storageFile interface {
+	io.WriteCloser
+	Name() string
+}
+
+// partial file of a resumable upload
+type storagePart interface {
+	io.WriteCloser
+	io.Seeker
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+}
+
+// the storage of the share
+var store storage = localStorage{}
+
+// storage on the local file system
+type localStorage struct{}
+
+func (localStorage) Stat(name string) (os.FileInfo, error)  { return os.Stat(name) }
+func (localStorage) Lstat(name string) (os.FileInfo, error) { return os.Lstat(name) }
+func (localStorage) MkdirAll(name string) error             { return os.MkdirAll(name, 0755) }
+func (localStorage) Rename(from, to string) error           { return os.Rename(from, to) }
+func (localStorage) Link(from, to string) error             { return os.Link(from, to) }
+func (localStorage) Remove(name string) error               { return os.Remove(name) }
+func (localStorage) RemoveAll(name string) error            { return os.RemoveAll(name) }
+
+// Open returns *os.File, to keep sendfile(2) working
+func (localStorage) Open(name string) (http.File, error) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		return nil, err // not a typed nil
+	}
+
+	return file, nil
+}
+
+func (localStorage) ReadDir(name string) ([]os.FileInfo, error) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return file.Readdir(-1)
+}
+
+// OpenPart opens the file with the os.O_* flags, creating it readable by everyone
+func (localStorage) OpenPart(name string, flag int) (storagePart, error) {
+	file, err := os.OpenFile(name, flag, 0644)
+
+	if err != nil {
+		return nil, err // not a typed nil
+	}
+
+	return file, nil
+}
+
+// TempFile returns *os.File readable by everyone, as the uploaded files are
+func (localStorage) TempFile(dir, prefix string) (storageFile, error) {
+	file, err := ioutil.TempFile(dir, prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return file, nil
+}
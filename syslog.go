@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"io"
+	"log"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// syslog output
+var logSyslog bool
+
+func init() {
+	gnuflag.BoolVar(&logSyslog, "log-syslog", false,
+		"Send log messages to the local syslog (or journald) instead of stderr.")
+}
+
+// log writer sending messages to syslog with priorities depending on the message
+type syslogWriter struct {
+	sys  *syslog.Writer
+	next io.Writer // status line or terminal UI, if any
+}
+
+// startSyslog must be called before mvr.Run(), and after the status line or terminal UI set-up
+func startSyslog() {
+	if !logSyslog {
+		return
+	}
+
+	sys, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "web-share")
+
+	if err != nil {
+		die("Cannot connect to syslog", err)
+	}
+
+	w := &syslogWriter{sys: sys}
+
+	if prev := log.Writer(); prev != io.Writer(os.Stderr) {
+		w.next = prev
+	} else {
+		log.SetFlags(0) // syslog adds its own timestamps
+	}
+
+	log.SetOutput(w)
+}
+
+func (w *syslogWriter) Write(msg []byte) (int, error) {
+	line := strings.TrimSpace(string(msg))
+
+	// strip the timestamp
+	if log.Flags()&(log.Ldate|log.Ltime) == log.Ldate|log.Ltime && len(line) > 20 &&
+		line[4] == '/' && line[7] == '/' && line[13] == ':' {
+		line = line[20:]
+	}
+
+	var err error
+
+	switch syslogPriority(line) {
+	case syslog.LOG_ERR:
+		err = w.sys.Err(line)
+	case syslog.LOG_WARNING:
+		err = w.sys.Warning(line)
+	case syslog.LOG_INFO:
+		err = w.sys.Info(line)
+	default:
+		err = w.sys.Notice(line)
+	}
+
+	if w.next != nil {
+		w.next.Write(msg)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(msg), nil
+}
+
+// syslogPriority classifies the log message: errors, rejected requests, access log, and the rest
+func syslogPriority(line string) syslog.Priority {
+	switch {
+	case strings.Contains(line, "rror") || strings.Contains(line, "failed") || strings.Contains(line, "ERROR"):
+		return syslog.LOG_ERR
+	case strings.Contains(line, "rejected"):
+		return syslog.LOG_WARNING
+	}
+
+	// access log messages start with the client address
+	if i := strings.IndexByte(line, ' '); i > 0 {
+		if host, _, err := net.SplitHostPort(line[:i]); err == nil && net.ParseIP(host) != nil {
+			return syslog.LOG_INFO
+		}
+	}
+
+	return syslog.LOG_NOTICE
+}
@@ -0,0 +1,337 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+var (
+	thumbnails     bool
+	thumbnailCache string
+)
+
+func init() {
+	gnuflag.BoolVar(&thumbnails, "thumbnails", false,
+		"Show thumbnails of JPEG, PNG and GIF images in directory listings.")
+	gnuflag.StringVar(&thumbnailCache, "thumbnail-cache", "",
+		"Directory to cache the thumbnails (default: web-share-thumbnails in the temporary directory).")
+}
+
+// thumbnail URL path prefix
+const thumbPrefix = "/_thumb/"
+
+const (
+	thumbSize    = 160      // default thumbnail width and height
+	maxThumbSize = 640      // largest thumbnail
+	maxThumbArea = 50 << 20 // largest image to make a thumbnail of, in pixels
+)
+
+// thumbnails in progress
+var thumbSlots = make(chan struct{}, runtime.NumCPU())
+
+// withThumbnails serves "/_thumb/<path>?w=<size>" requests with the image scaled down to fit
+// the given size, as JPEG. Thumbnails are made on the first request, a few at a time, and cached
+// until the image changes.
+func withThumbnails(next http.Handler) http.Handler {
+	if !thumbnails {
+		return next
+	}
+
+	var err error
+
+	if len(thumbnailCache) == 0 {
+		thumbnailCache = filepath.Join(os.TempDir(), "web-share-thumbnails")
+	}
+
+	if thumbnailCache, err = filepath.Abs(thumbnailCache); err != nil {
+		die("Invalid thumbnail cache directory", err)
+	}
+
+	if err = os.MkdirAll(thumbnailCache, 0700); err != nil {
+		die("Cannot create thumbnail cache directory", err)
+	}
+
+	if strings.HasPrefix(thumbnailCache+string(filepath.Separator), rootDir+string(filepath.Separator)) {
+		die("Thumbnail cache directory must not be inside the shared directory", nil)
+	}
+
+	log.Println("Thumbnails enabled, cached in", thumbnailCache)
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, thumbPrefix) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(resp, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		size := thumbSize
+
+		if s := req.URL.Query().Get("w"); len(s) > 0 {
+			if size, err = strconv.Atoi(s); err != nil || size < 16 || size > maxThumbSize {
+				http.Error(resp, "Invalid thumbnail size", http.StatusBadRequest)
+				return
+			}
+		}
+
+		name := resolvePath(req.URL.Path[len(thumbPrefix)-1:])
+		info, err := store.Stat(name)
+
+		if err != nil || !info.Mode().IsRegular() || isIgnored(name, false) || !hasThumbnail(name) {
+			http.NotFound(resp, req)
+			return
+		}
+
+		thumb, err := makeThumbnail(name, info, size)
+
+		if err != nil {
+			log.Println(logTag(req), "Thumbnail error:", err)
+			http.Error(resp, "Cannot make thumbnail", http.StatusUnprocessableEntity)
+			return
+		}
+
+		file, err := os.Open(thumb)
+
+		if err != nil {
+			log.Println(logTag(req), "Thumbnail error:", err)
+			http.Error(resp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		defer file.Close()
+
+		resp.Header().Set("Content-Type", "image/jpeg")
+		resp.Header().Set("Cache-Control", "max-age=3600")
+		http.ServeContent(resp, req, "", info.ModTime(), file)
+	})
+}
+
+// hasThumbnail checks if a thumbnail can be made of the file, by its extension
+func hasThumbnail(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	default:
+		return false
+	}
+}
+
+// thumbnailURL returns the URL of the thumbnail of the given size of the file at the URL path, if thumbnails are enabled
+func thumbnailURL(urlPath string, size int) string {
+	if !thumbnails || !hasThumbnail(urlPath) {
+		return ""
+	}
+
+	return (&url.URL{Path: thumbPrefix[:len(thumbPrefix)-1] + urlPath}).EscapedPath() + "?w=" + strconv.Itoa(size)
+}
+
+// makeThumbnail returns the name of the cached thumbnail, making it if needed
+func makeThumbnail(name string, info os.FileInfo, size int) (string, error) {
+	h := sha256.New()
+
+	h.Write([]byte(name + "\x00" + strconv.FormatInt(info.Size(), 10) + "\x00" + formatMtime(info.ModTime()) +
+		"\x00" + strconv.Itoa(size)))
+
+	cached := filepath.Join(thumbnailCache, hex.EncodeToString(h.Sum(nil))+".jpg")
+
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+
+	thumbSlots <- struct{}{}
+	defer func() { <-thumbSlots }()
+
+	// made while waiting
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+
+	img, err := decodeImage(name)
+
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := ioutil.TempFile(thumbnailCache, "tmp.")
+
+	if err != nil {
+		return "", err
+	}
+
+	err = jpeg.Encode(tmp, scaleImage(img, size), &jpeg.Options{Quality: 80})
+
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), cached)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return cached, nil
+}
+
+// decodeImage reads the image, refusing the ones too large to decode
+func decodeImage(name string) (image.Image, error) {
+	file, err := store.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var decodeConfig func(r io.Reader) (image.Config, error)
+	var decode func(r io.Reader) (image.Image, error)
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png":
+		decodeConfig, decode = png.DecodeConfig, png.Decode
+	case ".gif":
+		decodeConfig, decode = gif.DecodeConfig, gif.Decode
+	default:
+		decodeConfig, decode = jpeg.DecodeConfig, jpeg.Decode
+	}
+
+	cfg, err := decodeConfig(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxThumbArea {
+		return nil, errors.New("image is too large: " + strconv.Itoa(cfg.Width) + "x" + strconv.Itoa(cfg.Height))
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return decode(file)
+}
+
+// scaleImage scales the image down to fit the size, averaging up to 4x4 samples per pixel,
+// on white background
+func scaleImage(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, h*size/w
+		} else {
+			w, h = w*size/h, size
+		}
+	}
+
+	if w < 1 {
+		w = 1
+	}
+
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		ys := samples(y0, y1)
+
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			xs := samples(x0, x1)
+
+			var r, g, bl, n uint32
+
+			for _, sy := range ys {
+				for _, sx := range xs {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += cr + 0xffff - ca
+					g += cg + 0xffff - ca
+					bl += cb + 0xffff - ca
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), 0xff})
+		}
+	}
+
+	return dst
+}
+
+// samples returns up to 4 evenly spaced coordinates in [from, to)
+func samples(from, to int) []int {
+	if to <= from {
+		return []int{from}
+	}
+
+	n := to - from
+
+	if n > 4 {
+		n = 4
+	}
+
+	res := make([]int, n)
+
+	for i := range res {
+		res[i] = from + (2*i+1)*(to-from)/(2*n)
+	}
+
+	return res
+}
@@ -0,0 +1,242 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// TLS options
+var (
+	useTLS            bool
+	certFile, keyFile string
+)
+
+func init() {
+	gnuflag.BoolVar(&useTLS, "tls", false,
+		"Serve over HTTPS, with an ephemeral self-signed certificate unless --cert and --key are given.")
+	gnuflag.StringVar(&certFile, "cert", "", "TLS certificate file in PEM format; implies --tls.")
+	gnuflag.StringVar(&keyFile, "key", "", "TLS private key file in PEM format; implies --tls.")
+}
+
+// TLS configuration, nil for plain HTTP
+var tlsConfig *tls.Config
+
+// setupTLS loads or generates the certificate, and logs its fingerprint for the recipients to verify
+func setupTLS() {
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		if len(certFile) == 0 || len(keyFile) == 0 {
+			die("Options --cert and --key must be given together", nil)
+		}
+
+		useTLS = true
+	}
+
+	if len(clientCA) > 0 {
+		useTLS = true
+	}
+
+	if m := setupACME(); m != nil {
+		tlsConfig = m.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		setupClientCA(tlsConfig)
+		return
+	}
+
+	if !useTLS {
+		return
+	}
+
+	var cert tls.Certificate
+	var err error
+
+	if len(certFile) > 0 {
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	} else {
+		cert, err = selfSignedCert()
+	}
+
+	if err != nil {
+		die("Cannot set up TLS", err)
+	}
+
+	tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if len(certFile) == 0 {
+		log.Println("Using an ephemeral self-signed TLS certificate")
+	}
+
+	log.Println("TLS certificate SHA-256 fingerprint:", certFingerprint(cert.Certificate[0]))
+	setupClientCA(tlsConfig)
+}
+
+// urlScheme returns the scheme of the server URLs
+func urlScheme() string {
+	if tlsConfig != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// selfSignedCert generates a certificate for the listening addresses and the display host name
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "web-share"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, addr := range serverAddrs {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			}
+		}
+	}
+
+	if len(displayHost) > 0 {
+		tmpl.DNSNames = append(tmpl.DNSNames, displayHost)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// certFingerprint returns the SHA-256 of the certificate, like "AB:CD:...", as browsers show it
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// listener of TLS connections that log their handshake failures
+type tlsListener struct {
+	net.Listener
+}
+
+func (l tlsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &handshakeConn{Conn: conn.(*tls.Conn)}, nil
+}
+
+// TLS connection completing the handshake on the first read, and logging the failure with the peer's address
+type handshakeConn struct {
+	*tls.Conn
+	once sync.Once
+	err  error
+}
+
+func (c *handshakeConn) Read(data []byte) (int, error) {
+	c.once.Do(func() {
+		if c.err = c.Handshake(); c.err != nil && c.err != io.EOF {
+			log.Println(c.RemoteAddr(), "TLS handshake failed:", c.err)
+		}
+	})
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.Conn.Read(data)
+}
+
+// withTLSState sets the TLS connection state of the requests; the server does not see the TLS
+// connections as such, because they are wrapped in the counting ones
+func withTLSState(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if c, ok := req.Context().Value(connKey{}).(*countingConn); ok && req.TLS == nil {
+			conn := c.Conn
+
+			if lc, ok := conn.(*limitConn); ok {
+				conn = lc.Conn
+			}
+
+			if tc, ok := conn.(*handshakeConn); ok {
+				state := tc.ConnectionState()
+				req.TLS = &state
+			}
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
@@ -0,0 +1,244 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/gnuflag"
+)
+
+// TOTP secrets file
+var totpFile string
+
+func init() {
+	gnuflag.StringVar(&totpFile, "totp-secrets", "",
+		"File with \"user:secret\" lines attaching TOTP secrets (base32) to accounts, so their login requires a one-time code.")
+}
+
+// TOTP parameters (RFC 6238), as supported by all authenticator apps
+const (
+	totpStep   = 30 // seconds
+	totpDigits = 6
+	totpSkew   = 1 // steps of clock difference accepted either way
+)
+
+var (
+	errTOTPRequired = errors.New("one-time code required")
+	errTOTPCode     = errors.New("invalid one-time code")
+)
+
+// TOTP state
+var totp struct {
+	secrets map[string][]byte // by user name
+
+	lock sync.Mutex
+	used map[string]int64 // last accepted time step by user, so that the codes cannot be reused
+}
+
+func setupTOTP() {
+	if len(totpFile) == 0 {
+		return
+	}
+
+	secrets, err := readTOTPFile(totpFile)
+
+	if err != nil {
+		die("Cannot read TOTP secrets", err)
+	}
+
+	if len(secrets) == 0 {
+		die("No TOTP secrets in "+totpFile, nil)
+	}
+
+	totp.secrets = secrets
+	totp.used = make(map[string]int64)
+
+	log.Println("Two-factor authentication enabled for", len(secrets), "user(s)")
+}
+
+// readTOTPFile reads "user:secret" lines, with the secrets in base32
+func readTOTPFile(name string) (map[string][]byte, error) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	secrets := make(map[string][]byte)
+	src := bufio.NewScanner(file)
+
+	for src.Scan() {
+		line := strings.TrimSpace(src.Text())
+
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		i := strings.LastIndexByte(line, ':')
+
+		if i <= 0 {
+			return nil, &os.PathError{Op: "parse", Path: name, Err: errors.New("expected \"user:secret\" line")}
+		}
+
+		secret, err := decodeTOTPSecret(line[i+1:])
+
+		if err != nil {
+			return nil, &os.PathError{Op: "parse", Path: name, Err: err}
+		}
+
+		secrets[line[:i]] = secret
+	}
+
+	return secrets, src.Err()
+}
+
+// decodeTOTPSecret decodes base32 secret, ignoring spaces, case, and padding
+func decodeTOTPSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.TrimRight(strings.Replace(s, " ", "", -1), "="))
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+
+	if err == nil && len(secret) < 10 {
+		err = errors.New("TOTP secret is too short")
+	}
+
+	return secret, err
+}
+
+// hasTOTP checks if the user has a TOTP secret attached
+func hasTOTP(user string) bool {
+	_, ok := totp.secrets[user]
+	return ok
+}
+
+// checkTOTP verifies the one-time code of the user
+func checkTOTP(user, code string) error {
+	secret, ok := totp.secrets[user]
+
+	if !ok {
+		return errAuthUser
+	}
+
+	if code = strings.Replace(code, " ", "", -1); len(code) == 0 {
+		return errTOTPRequired
+	}
+
+	now := time.Now().Unix() / totpStep
+
+	totp.lock.Lock()
+	defer totp.lock.Unlock()
+
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			if step <= totp.used[user] {
+				return errTOTPCode // replayed
+			}
+
+			totp.used[user] = step
+			return nil
+		}
+	}
+
+	return errTOTPCode
+}
+
+// totpCode computes the code for the given time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	off := sum[len(sum)-1] & 0xf
+	n := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, n%1000000)
+}
+
+// "totp" subcommand: generate a new secret for the user
+func totpCommand(args []string) int {
+	var issuer string
+
+	flags := gnuflag.NewFlagSet("totp", gnuflag.ExitOnError)
+
+	flags.StringVar(&issuer, "issuer", "web-share", "Issuer name shown by authenticator apps.")
+
+	flags.Parse(true, args)
+
+	if flags.NArg() != 1 {
+		die("Usage: "+os.Args[0]+" totp [options] <user>", nil)
+	}
+
+	user := flags.Arg(0)
+
+	if len(user) == 0 || strings.ContainsAny(user, ":\r\n") {
+		die("Invalid user name: "+user, nil)
+	}
+
+	buf := make([]byte, 20)
+
+	if _, err := rand.Read(buf); err != nil {
+		die("Cannot generate secret", err)
+	}
+
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + user,
+		RawQuery: url.Values{"secret": {secret}, "issuer": {issuer}}.Encode(),
+	}
+
+	fmt.Println("# add this line to the file given by --totp-secrets:")
+	fmt.Println(user + ":" + secret)
+	fmt.Println("# add this URI to the authenticator app (e.g. as a QR code via qrencode -t ansiutf8):")
+	fmt.Println(uri.String())
+	return 0
+}
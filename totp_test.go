@@ -0,0 +1,119 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// RFC 6238, appendix B, truncated to 6 digits
+func TestTOTPCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	cases := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, c := range cases {
+		if code := totpCode(secret, c.time/totpStep); code != c.code {
+			t.Errorf("time %d: got %q, expected %q", c.time, code, c.code)
+		}
+	}
+}
+
+func TestDecodeTOTPSecret(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	cases := []struct {
+		src string
+		ok  bool
+	}{
+		{"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", true},
+		{"gezd gnbv gy3t qojq gezd gnbv gy3t qojq", true},
+		{"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ====", true},
+		{"GEZDGNBV", false},         // too short
+		{"GEZDGNBVGY3TQOJ1", false}, // not base32
+		{"", false},
+	}
+
+	for _, c := range cases {
+		got, err := decodeTOTPSecret(c.src)
+
+		switch {
+		case c.ok && err != nil:
+			t.Errorf("%q: unexpected error: %s", c.src, err)
+		case c.ok && !bytes.Equal(got, secret):
+			t.Errorf("%q: got %q", c.src, got)
+		case !c.ok && err == nil:
+			t.Errorf("%q: no error", c.src)
+		}
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Now().Unix() / totpStep
+
+	totp.secrets = map[string][]byte{"joe": secret}
+	totp.used = make(map[string]int64)
+
+	defer func() { totp.secrets, totp.used = nil, nil }()
+
+	cases := []struct {
+		user, code string
+		err        error
+	}{
+		{"ann", totpCode(secret, now), errAuthUser},
+		{"joe", "", errTOTPRequired},
+		{"joe", "000000x", errTOTPCode},
+		{"joe", totpCode(secret, now-totpSkew-1), errTOTPCode}, // too old
+		{"joe", totpCode(secret, now-totpSkew), nil},
+		{"joe", totpCode(secret, now-totpSkew), errTOTPCode}, // replayed
+		{"joe", totpCode(secret, now), nil},
+		{"joe", totpCode(secret, now-totpSkew), errTOTPCode}, // older than the last one
+		{"joe", totpCode(secret, now+totpSkew)[:3] + " " + totpCode(secret, now+totpSkew)[3:], nil},
+		{"joe", totpCode(secret, now+totpSkew+1), errTOTPCode}, // too far ahead
+	}
+
+	for i, c := range cases {
+		if err := checkTOTP(c.user, c.code); err != c.err {
+			t.Errorf("case %d: got %v, expected %v", i, err, c.err)
+		}
+	}
+}
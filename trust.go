@@ -0,0 +1,215 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// trusted certificates of the servers, by "host:port", pinned on first use
+type trustStore struct {
+	file        string // known hosts file
+	host        string // "host:port" of the server
+	fingerprint string // expected fingerprint, if given on the command line
+
+	lock  sync.Mutex
+	hosts map[string]string
+}
+
+// knownHostsFile returns the default known hosts file, web-share/known_hosts in the user configuration directory
+func knownHostsFile() string {
+	dir, err := os.UserConfigDir()
+
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "web-share", "known_hosts")
+}
+
+// trustConfig returns TLS configuration that accepts certificates signed by a known CA, and otherwise
+// the certificate pinned for the host on the first connection, after the user confirms its fingerprint.
+func trustConfig(host, file, fingerprint string) (*tls.Config, error) {
+	t := &trustStore{
+		file:        file,
+		host:        host,
+		fingerprint: normalFingerprint(fingerprint),
+		hosts:       make(map[string]string),
+	}
+
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		InsecureSkipVerify:    true, // verified below
+		VerifyPeerCertificate: t.verify,
+	}, nil
+}
+
+// load reads the known hosts file with "host:port fingerprint" lines
+func (t *trustStore) load() error {
+	if len(t.file) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(t.file)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	defer file.Close()
+
+	src := bufio.NewScanner(file)
+
+	for src.Scan() {
+		fields := strings.Fields(src.Text())
+
+		if len(fields) == 2 && !strings.HasPrefix(fields[0], "#") {
+			t.hosts[fields[0]] = normalFingerprint(fields[1])
+		}
+	}
+
+	return src.Err()
+}
+
+func (t *trustStore) verify(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("No server certificate")
+	}
+
+	// certificates signed by a known CA are trusted as usual
+	certs := make([]*x509.Certificate, len(rawCerts))
+
+	for i, der := range rawCerts {
+		var err error
+
+		if certs[i], err = x509.ParseCertificate(der); err != nil {
+			return err
+		}
+	}
+
+	hostName, _, _ := net.SplitHostPort(t.host)
+	opts := x509.VerifyOptions{DNSName: hostName, Intermediates: x509.NewCertPool()}
+
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	if _, err := certs[0].Verify(opts); err == nil {
+		return nil
+	}
+
+	// pinned certificate
+	fp := certFingerprint(rawCerts[0])
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if pinned, ok := t.hosts[t.host]; ok {
+		if normalFingerprint(fp) != pinned {
+			return errors.New("The certificate of " + t.host + " has changed, its SHA-256 fingerprint is now " + fp +
+				"; if the change is expected, remove the host from " + t.file)
+		}
+
+		return nil
+	}
+
+	// first use
+	switch {
+	case len(t.fingerprint) > 0:
+		if normalFingerprint(fp) != t.fingerprint {
+			return errors.New("The certificate of " + t.host + " does not match the given fingerprint, its SHA-256 fingerprint is " + fp)
+		}
+	case isTerminal(os.Stdin):
+		fmt.Fprintf(os.Stderr, "The certificate of %s is not signed by a known authority.\n"+
+			"Its SHA-256 fingerprint is %s\n"+
+			"Compare it with the fingerprint the server logged on start-up. Trust the certificate? [y/N] ", t.host, fp)
+
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			return errors.New("The certificate of " + t.host + " is not trusted")
+		}
+	default:
+		return errors.New("The certificate of " + t.host + " is not signed by a known authority, its SHA-256 fingerprint is " + fp +
+			"; compare it with the fingerprint the server logged on start-up, and pass it with --fingerprint option")
+	}
+
+	t.hosts[t.host] = normalFingerprint(fp)
+
+	if err := t.save(fp); err != nil {
+		return errors.New("Cannot save the certificate fingerprint: " + err.Error())
+	}
+
+	return nil
+}
+
+// save appends the host with the fingerprint to the known hosts file
+func (t *trustStore) save(fp string) error {
+	if len(t.file) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.file), 0700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(t.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.WriteString(t.host + " " + fp + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// normalFingerprint converts "ab:cd:..." or "abcd..." fingerprint to "ABCD..."
+func normalFingerprint(s string) string {
+	return strings.ToUpper(strings.Replace(strings.TrimSpace(s), ":", "", -1))
+}
@@ -0,0 +1,451 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/gnuflag"
+	"github.com/maxim2266/mvr"
+)
+
+// terminal UI mode
+var tuiMode bool
+
+func init() {
+	gnuflag.BoolVar(&tuiMode, "tui", false,
+		"Show terminal UI with live transfers, clients and recent log messages.")
+}
+
+// number of log lines kept for the terminal UI
+const tuiLogLines = 200
+
+// terminal UI; it is also the log writer
+type tui struct {
+	lock    sync.Mutex
+	lines   []string // recent log lines
+	active  bool     // false after the terminal has been restored
+	banKey  bool     // 'b' has been pressed, waiting for the client number
+	clients []string // client addresses as numbered on the screen
+	saved   string   // terminal settings to restore
+}
+
+// startTUI must be called before mvr.Run(), so that the log messages go to the terminal UI
+func startTUI() {
+	if !tuiMode {
+		return
+	}
+
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		die("Terminal UI requires a terminal", nil)
+	}
+
+	saved, err := stty("-g")
+
+	if err != nil {
+		die("Cannot get terminal settings", err)
+	}
+
+	// no line buffering, no echo; Ctrl-C still works
+	if _, err = stty("-icanon", "-echo", "min", "1"); err != nil {
+		die("Cannot set terminal mode", err)
+	}
+
+	t := &tui{active: true, saved: strings.TrimSpace(saved)}
+
+	// alternate screen, hidden cursor
+	os.Stderr.WriteString("\033[?1049h\033[?25l")
+
+	log.SetOutput(t)
+
+	mvr.OnCancel(0, func(context.Context) {
+		t.stop()
+	})
+
+	keys := make(chan byte)
+
+	go func() {
+		var buf [1]byte
+
+		for {
+			if n, err := os.Stdin.Read(buf[:]); err != nil {
+				return
+			} else if n > 0 {
+				keys <- buf[0]
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		prev, ts := atomic.LoadInt64(&totalSent), time.Now()
+		rate := 0.0
+
+		for {
+			select {
+			case now := <-ticker.C:
+				sent := atomic.LoadInt64(&totalSent)
+				rate = float64(sent-prev) / now.Sub(ts).Seconds()
+				prev, ts = sent, now
+			case key := <-keys:
+				t.handleKey(key)
+			case <-mvr.Done():
+				return
+			}
+
+			t.render(rate)
+		}
+	}()
+}
+
+// Write stores the log message for display
+func (t *tui) Write(msg []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.active {
+		return os.Stderr.Write(msg)
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(string(msg), "\n"), "\n") {
+		t.lines = append(t.lines, line)
+	}
+
+	if len(t.lines) > tuiLogLines {
+		t.lines = append(t.lines[:0], t.lines[len(t.lines)-tuiLogLines:]...)
+	}
+
+	return len(msg), nil
+}
+
+// stop restores the terminal
+func (t *tui) stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.active {
+		return
+	}
+
+	t.active = false
+
+	os.Stderr.WriteString("\033[?25h\033[?1049l")
+	stty(t.saved)
+
+	// keep the recent messages visible
+	for _, line := range t.lines[max0(len(t.lines)-20):] {
+		os.Stderr.WriteString(line + "\n")
+	}
+}
+
+func (t *tui) handleKey(key byte) {
+	t.lock.Lock()
+
+	banKey := t.banKey
+	t.banKey = false
+
+	switch {
+	case banKey && key >= '1' && key <= '9':
+		if i := int(key - '1'); i < len(t.clients) {
+			addr := t.clients[i]
+
+			t.lock.Unlock()
+			banClient(addr)
+			return
+		}
+	case key == 'b':
+		t.banKey = true
+	case key == 'u':
+		t.lock.Unlock()
+
+		if len(cgiDir) > 0 {
+			log.Println("Uploads cannot be enabled with CGI scripts")
+			return
+		}
+
+		enableUploads(!uploadsEnabled())
+
+		if uploadsEnabled() {
+			log.Println("Uploads enabled")
+		} else {
+			log.Println("Uploads disabled")
+		}
+
+		return
+	case key == 'p':
+		t.lock.Unlock()
+		setPaused(!isPaused())
+		return
+	case key == 'q':
+		t.lock.Unlock()
+		log.Println("Stopping the server")
+		mvr.Cancel()
+		return
+	}
+
+	t.lock.Unlock()
+}
+
+// per-client summary of the open connections
+type tuiClient struct {
+	addr  string
+	conns int
+	sent  int64
+}
+
+func (t *tui) render(rate float64) {
+	rows, cols := terminalSize()
+	conns := connections()
+
+	// clients
+	byAddr := make(map[string]*tuiClient)
+	var clients []*tuiClient
+
+	for _, c := range conns {
+		addr := c.remote
+
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+
+		cl := byAddr[addr]
+
+		if cl == nil {
+			cl = &tuiClient{addr: addr}
+			byAddr[addr] = cl
+			clients = append(clients, cl)
+		}
+
+		cl.conns++
+		cl.sent += c.sent
+	}
+
+	sort.SliceStable(clients, func(i, j int) bool { return clients[i].sent > clients[j].sent })
+
+	if len(clients) > 9 {
+		clients = clients[:9]
+	}
+
+	// screen
+	var screen []string
+
+	uploads := "off"
+
+	if uploadsEnabled() {
+		uploads = "on"
+	}
+
+	screen = append(screen,
+		"web-share: "+baseURL()+"/ "+rootDir+" | "+strconv.Itoa(len(conns))+" connection(s), "+sizeString(int64(rate))+
+			"/s, "+sizeString(atomic.LoadInt64(&totalSent))+" total | uploads "+uploads+
+			" | up "+time.Since(startTime).Round(time.Second).String(),
+		"")
+
+	if isPaused() {
+		screen[0] += " | PAUSED"
+	}
+
+	// transfers
+	var transfers []string
+
+	for _, c := range conns {
+		if r := c.request; r != nil {
+			elapsed := time.Since(r.start)
+
+			transfers = append(transfers, "  "+padRight(c.remote, 22)+" "+padRight(r.method, 7)+" "+
+				padRight(sizeString(r.sent), 8)+" "+padRight(sizeString(int64(float64(r.sent)/elapsed.Seconds()))+"/s", 10)+
+				" "+padRight(elapsed.Round(time.Second).String(), 8)+" "+r.path)
+		}
+	}
+
+	maxTransfers := max0(rows/3 - 1)
+
+	screen = append(screen, "Transfers ("+strconv.Itoa(len(transfers))+")")
+
+	if len(transfers) > maxTransfers {
+		transfers = append(transfers[:max0(maxTransfers-1)], "  ...")
+	}
+
+	screen = append(screen, transfers...)
+	screen = append(screen, "", "Clients ("+strconv.Itoa(len(byAddr))+")")
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.clients = t.clients[:0]
+
+	for i, cl := range clients {
+		line := "  " + strconv.Itoa(i+1) + ". " + padRight(cl.addr, 40) + " " + strconv.Itoa(cl.conns) +
+			" connection(s), " + sizeString(cl.sent)
+
+		if isBanned(cl.addr) {
+			line += " [banned]"
+		}
+
+		screen = append(screen, line)
+		t.clients = append(t.clients, cl.addr)
+	}
+
+	// recent log messages fill the rest of the screen
+	footer := "Keys: u - toggle uploads, p - pause/resume, b<N> - ban client N, q - quit"
+
+	if t.banKey {
+		footer = "Ban client number (1-9)?"
+	}
+
+	screen = append(screen, "", "Recent")
+
+	if n := rows - len(screen) - 2; n > 0 {
+		screen = append(screen, t.lines[max0(len(t.lines)-n):]...)
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString("\033[H")
+
+	for i, line := range screen {
+		if i >= rows-1 {
+			break
+		}
+
+		if len(line) > cols {
+			line = line[:cols]
+		}
+
+		buf.WriteString(line + "\033[K\r\n")
+	}
+
+	buf.WriteString("\033[J\033[" + strconv.Itoa(rows) + ";1H\033[7m" + padRight(footer, cols)[:cols] + "\033[0m")
+
+	if t.active {
+		os.Stderr.Write(buf.Bytes())
+	}
+}
+
+func padRight(s string, n int) string {
+	if len(s) >= n {
+		return s
+	}
+
+	return s + strings.Repeat(" ", n-len(s))
+}
+
+func max0(n int) int {
+	if n < 0 {
+		return 0
+	}
+
+	return n
+}
+
+// stty runs stty(1) on the terminal
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+
+	cmd.Stdin = os.Stdin
+
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// terminalSize returns the number of rows and columns of the terminal
+func terminalSize() (rows, cols int) {
+	rows, cols = 24, 80
+
+	if out, err := stty("size"); err == nil {
+		if f := strings.Fields(out); len(f) == 2 {
+			if r, err := strconv.Atoi(f[0]); err == nil && r > 5 {
+				rows = r
+			}
+
+			if c, err := strconv.Atoi(f[1]); err == nil && c > 20 {
+				cols = c
+			}
+		}
+	}
+
+	return
+}
+
+// banned client addresses
+var bannedIPs = struct {
+	sync.Mutex
+	addrs map[string]bool
+}{
+	addrs: make(map[string]bool),
+}
+
+func isBanned(addr string) bool {
+	bannedIPs.Lock()
+	defer bannedIPs.Unlock()
+
+	return bannedIPs.addrs[addr]
+}
+
+// banClient rejects further requests from the address, and closes its open connections
+func banClient(addr string) {
+	bannedIPs.Lock()
+	bannedIPs.addrs[addr] = true
+	bannedIPs.Unlock()
+
+	log.Println("Banned", addr)
+
+	for _, c := range connections() {
+		if host, _, err := net.SplitHostPort(c.remote); err == nil && host == addr {
+			c.conn.Close()
+		}
+	}
+}
+
+// withBans rejects requests from banned clients
+func withBans(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if addr := remoteIP(req); addr != nil && isBanned(addr.String()) {
+			log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: banned")
+			http.Error(resp, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
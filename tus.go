@@ -0,0 +1,520 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maxim2266/mvr"
+)
+
+// tus resumable upload protocol (https://tus.io/protocols/resumable-upload), version 1.0.0,
+// with creation, checksum, expiration, and termination extensions
+const (
+	tusPrefix  = "/_tus/"
+	tusVersion = "1.0.0"
+	tusExpiry  = 24 * time.Hour // unfinished uploads are removed after this period of inactivity
+)
+
+// tus checksum algorithms
+var tusChecksums = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+	"sha256": sha256.New,
+	"blake3": blake3Hash.new,
+	"xxh3":   xxh3Hash.new,
+}
+
+// tus upload in progress
+type tusUpload struct {
+	lock    sync.Mutex // held while receiving data
+	target  string     // URL path of the target file
+	partial string     // file with the data received so far
+	meta    string     // Upload-Metadata header, as given
+	length  int64
+	offset  int64
+	policy  conflictPolicy
+	expires time.Time
+	done    bool // completed or terminated
+}
+
+// uploads in progress, by ID
+var tusUploads = struct {
+	sync.Mutex
+	byID map[string]*tusUpload
+}{
+	byID: make(map[string]*tusUpload),
+}
+
+// withTus handles tus uploads under /_tus/: POST /_tus/ creates an upload with Upload-Metadata holding
+// the file name (as "filename" or "name", possibly with a directory path), HEAD /_tus/<id> reports
+// the offset, PATCH /_tus/<id> appends the data, and DELETE /_tus/<id> cancels the upload.
+// The completed file is placed at its name under the root directory, like a PUT upload.
+func withTus(next http.Handler) http.Handler {
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				expireTus(now)
+			case <-mvr.Done():
+				return
+			}
+		}
+	}()
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != strings.TrimSuffix(tusPrefix, "/") && !strings.HasPrefix(req.URL.Path, tusPrefix) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		resp.Header().Set("Tus-Resumable", tusVersion)
+		resp.Header().Set("Cache-Control", "no-store")
+
+		if req.Method == http.MethodOptions {
+			resp.Header().Set("Tus-Version", tusVersion)
+			resp.Header().Set("Tus-Extension", "creation,checksum,expiration,termination")
+			resp.Header().Set("Tus-Checksum-Algorithm", "sha1,md5,sha256,blake3,xxh3")
+
+			if maxFileSize > 0 {
+				resp.Header().Set("Tus-Max-Size", strconv.FormatInt(maxFileSize, 10))
+			}
+
+			resp.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if !uploadsEnabled() {
+			http.Error(resp, "Uploads are not allowed", http.StatusForbidden)
+			return
+		}
+
+		if req.Header.Get("Tus-Resumable") != tusVersion {
+			resp.Header().Set("Tus-Version", tusVersion)
+			http.Error(resp, "Unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+
+		id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, tusPrefix), strings.TrimSuffix(tusPrefix, "/"))
+
+		if len(id) == 0 {
+			if req.Method != http.MethodPost {
+				resp.Header().Set("Allow", "OPTIONS, POST")
+				http.Error(resp, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			createTus(resp, req)
+			return
+		}
+
+		tusUploads.Lock()
+		u := tusUploads.byID[id]
+		tusUploads.Unlock()
+
+		if u == nil {
+			http.NotFound(resp, req)
+			return
+		}
+
+		switch req.Method {
+		case http.MethodHead:
+			u.lock.Lock()
+			defer u.lock.Unlock()
+
+			if u.done {
+				http.NotFound(resp, req)
+				return
+			}
+
+			resp.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+			resp.Header().Set("Upload-Length", strconv.FormatInt(u.length, 10))
+			resp.Header().Set("Upload-Expires", u.expires.UTC().Format(http.TimeFormat))
+
+			if len(u.meta) > 0 {
+				resp.Header().Set("Upload-Metadata", u.meta)
+			}
+		case http.MethodPatch:
+			patchTus(resp, req, id, u)
+		case http.MethodDelete:
+			u.lock.Lock()
+			defer u.lock.Unlock()
+
+			if !u.done {
+				u.done = true
+				removeTus(id, u)
+				log.Println(logTag(req), "Cancelled upload of", shortenURI(u.target))
+			}
+
+			resp.WriteHeader(http.StatusNoContent)
+		default:
+			resp.Header().Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+			http.Error(resp, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// POST /_tus/: creates the upload
+func createTus(resp http.ResponseWriter, req *http.Request) {
+	if len(uploadPipeArgs) > 0 {
+		uploadFailed(resp, req, errPipeResumable)
+		return
+	}
+
+	if len(req.Header.Get("Upload-Defer-Length")) > 0 {
+		http.Error(resp, "Deferred upload length is not supported", http.StatusBadRequest)
+		return
+	}
+
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+
+	if err != nil || length < 0 {
+		http.Error(resp, "Invalid Upload-Length header", http.StatusBadRequest)
+		return
+	}
+
+	if uploadTooLarge(length) {
+		uploadFailed(resp, req, errUploadTooLarge)
+		return
+	}
+
+	meta, ok := parseTusMetadata(req.Header.Get("Upload-Metadata"))
+
+	if !ok {
+		http.Error(resp, "Invalid Upload-Metadata header", http.StatusBadRequest)
+		return
+	}
+
+	name := meta["filename"]
+
+	if len(name) == 0 {
+		name = meta["name"]
+	}
+
+	// browsers on Windows may send the full path
+	name = path.Clean("/" + strings.Replace(name, `\`, "/", -1))
+
+	if name == "/" {
+		http.Error(resp, "Missing file name in Upload-Metadata header", http.StatusBadRequest)
+		return
+	}
+
+	policy, ok := requestConflict(req)
+
+	if !ok {
+		http.Error(resp, "Invalid "+uploadConflictHeader+" header", http.StatusBadRequest)
+		return
+	}
+
+	target := routeUpload(name, meta["filetype"])
+
+	if free, _, err := diskSpace(rootDir); err == nil && length > free {
+		log.Println(logTag(req), "Upload of", shortenURI(target), sizeString(length), "rejected: not enough disk space")
+		insufficientStorage(resp, req, nil)
+		return
+	}
+
+	full := resolvePath(target)
+
+	if _, err = prepareUpload(full, policy); err != nil {
+		uploadFailed(resp, req, err)
+		return
+	}
+
+	id := randomToken()
+	u := &tusUpload{
+		target:  target,
+		partial: filepath.Join(filepath.Dir(full), uploadTempPrefix+"tus."+id),
+		meta:    req.Header.Get("Upload-Metadata"),
+		length:  length,
+		policy:  policy,
+		expires: time.Now().Add(tusExpiry),
+	}
+
+	file, err := store.OpenPart(u.partial, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+
+	if err != nil {
+		uploadFailed(resp, req, err)
+		return
+	}
+
+	if err = file.Close(); err != nil {
+		store.Remove(u.partial)
+		uploadFailed(resp, req, err)
+		return
+	}
+
+	tusUploads.Lock()
+	tusUploads.byID[id] = u
+	tusUploads.Unlock()
+
+	log.Println(logTag(req), "Started upload of", shortenURI(target), sizeString(length))
+
+	resp.Header().Set("Location", tusPrefix+id)
+	resp.Header().Set("Upload-Expires", u.expires.UTC().Format(http.TimeFormat))
+
+	// an empty file is complete already
+	if length == 0 {
+		u.lock.Lock()
+		defer u.lock.Unlock()
+
+		if !completeTus(resp, req, id, u) {
+			return
+		}
+	}
+
+	resp.WriteHeader(http.StatusCreated)
+}
+
+// PATCH /_tus/<id>: appends the request body to the upload at the given offset
+func patchTus(resp http.ResponseWriter, req *http.Request, id string, u *tusUpload) {
+	if req.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(resp, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+
+	if err != nil || offset < 0 {
+		http.Error(resp, "Invalid Upload-Offset header", http.StatusBadRequest)
+		return
+	}
+
+	// checksum of this request's data, as "<algorithm> <base64 digest>"
+	var h hash.Hash
+	var sum []byte
+
+	if s := req.Header.Get("Upload-Checksum"); len(s) > 0 {
+		fields := strings.Fields(s)
+
+		if len(fields) != 2 {
+			http.Error(resp, "Invalid Upload-Checksum header", http.StatusBadRequest)
+			return
+		}
+
+		newHash, ok := tusChecksums[fields[0]]
+
+		if !ok {
+			http.Error(resp, "Unsupported checksum algorithm", http.StatusBadRequest)
+			return
+		}
+
+		if sum, err = base64.StdEncoding.DecodeString(fields[1]); err != nil {
+			http.Error(resp, "Invalid Upload-Checksum header", http.StatusBadRequest)
+			return
+		}
+
+		h = newHash()
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if u.done {
+		http.NotFound(resp, req)
+		return
+	}
+
+	if offset != u.offset {
+		resp.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+		http.Error(resp, "Upload offset mismatch", http.StatusConflict)
+		return
+	}
+
+	if req.ContentLength > u.length-u.offset {
+		http.Error(resp, "Data beyond the upload length", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	file, err := store.OpenPart(u.partial, os.O_WRONLY)
+
+	if err != nil {
+		uploadFailed(resp, req, err)
+		return
+	}
+
+	if _, err = file.Seek(u.offset, io.SeekStart); err != nil {
+		file.Close()
+		uploadFailed(resp, req, err)
+		return
+	}
+
+	var dst io.Writer = file
+
+	if h != nil {
+		dst = io.MultiWriter(file, h)
+	}
+
+	n, err := io.Copy(dst, io.LimitReader(req.Body, u.length-u.offset))
+
+	if h != nil && err == nil && !bytes.Equal(h.Sum(nil), sum) {
+		err = errChecksum
+	}
+
+	// without a checksum, the data received before the failure is kept for resumption
+	if err != nil && h != nil {
+		n = 0
+	}
+
+	if cerr := file.Truncate(u.offset + n); err == nil {
+		err = cerr
+	}
+
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+
+	u.offset += n
+	u.expires = time.Now().Add(tusExpiry)
+
+	resp.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+	resp.Header().Set("Upload-Expires", u.expires.UTC().Format(http.TimeFormat))
+
+	if err == errChecksum {
+		http.Error(resp, "Checksum mismatch", 460) // "Checksum Mismatch", as defined by the protocol
+		return
+	}
+
+	if uploadFailed(resp, req, err) {
+		return
+	}
+
+	if u.offset == u.length && !completeTus(resp, req, id, u) {
+		return
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// completeTus places the completed upload at its target; to be called with the upload locked
+func completeTus(resp http.ResponseWriter, req *http.Request, id string, u *tusUpload) bool {
+	u.done = true
+
+	placed, err := placeUpload(u.partial, resolvePath(u.target), u.policy)
+
+	if err != nil {
+		removeTus(id, u)
+		uploadFailed(resp, req, err)
+		return false
+	}
+
+	tusUploads.Lock()
+	delete(tusUploads.byID, id)
+	tusUploads.Unlock()
+
+	target := placedTarget(u.target, placed)
+
+	log.Println(logTag(req), "Uploaded", shortenURI(target), sizeString(u.length))
+	checkDiskSpace()
+	notify("Uploaded "+target, target+" ("+sizeString(u.length)+") has been uploaded by "+req.RemoteAddr+".")
+	return true
+}
+
+// removeTus forgets the upload and deletes its data
+func removeTus(id string, u *tusUpload) {
+	tusUploads.Lock()
+	delete(tusUploads.byID, id)
+	tusUploads.Unlock()
+
+	if err := store.Remove(u.partial); err != nil && !os.IsNotExist(err) {
+		log.Println("Cannot remove partial upload:", err)
+	}
+}
+
+// expireTus removes the unfinished uploads inactive for too long
+func expireTus(now time.Time) {
+	tusUploads.Lock()
+
+	expired := make(map[string]*tusUpload)
+
+	for id, u := range tusUploads.byID {
+		expired[id] = u
+	}
+
+	tusUploads.Unlock()
+
+	for id, u := range expired {
+		u.lock.Lock()
+
+		if !u.done && now.After(u.expires) {
+			u.done = true
+			removeTus(id, u)
+			log.Println("Upload of", shortenURI(u.target), "expired at", sizeString(u.offset), "of", sizeString(u.length))
+		}
+
+		u.lock.Unlock()
+	}
+}
+
+// parseTusMetadata parses Upload-Metadata header: comma-separated pairs of a key and a base64-encoded value,
+// where the value may be omitted
+func parseTusMetadata(s string) (map[string]string, bool) {
+	meta := make(map[string]string)
+
+	for _, pair := range strings.Split(s, ",") {
+		fields := strings.Fields(pair)
+
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			meta[fields[0]] = ""
+		case 2:
+			val, err := base64.StdEncoding.DecodeString(fields[1])
+
+			if err != nil {
+				return nil, false
+			}
+
+			meta[fields[0]] = string(val)
+		default:
+			return nil, false
+		}
+	}
+
+	return meta, true
+}
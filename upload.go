@@ -0,0 +1,544 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/juju/gnuflag"
+)
+
+// allow uploads
+var allowUpload bool
+
+// uploads can be switched on and off at run time
+var uploadsOn int32
+
+func uploadsEnabled() bool {
+	return atomic.LoadInt32(&uploadsOn) != 0
+}
+
+func enableUploads(on bool) {
+	var v int32
+
+	if on {
+		v = 1
+	}
+
+	atomic.StoreInt32(&uploadsOn, v)
+}
+
+func init() {
+	gnuflag.BoolVar(&allowUpload, "allow-upload", false,
+		"Allow uploading files via HTTP PUT requests, like \"curl -T file http://host:port/path/file\", or the form in the directory listings.")
+}
+
+// prefix of temporary files created during uploads; such files are never served
+const uploadTempPrefix = ".webshare-upload-"
+
+// partial files of resumable uploads are named "<uploadTempPrefix>partial.<file name>"
+const uploadPartialPrefix = uploadTempPrefix + "partial."
+
+// upload errors
+var (
+	errUploadForbidden = errors.New("upload target is not allowed")
+	errUploadConflict  = errors.New("upload target is a directory")
+	errUploadOffset    = errors.New("upload offset is beyond the received data")
+	errUploadBusy      = errors.New("upload to the same file is in progress")
+	errContentRange    = errors.New("invalid Content-Range header")
+)
+
+// withUpload handles PUT requests, writing the request body to the file at the request path.
+// Requests with Content-Range header write to a partial file, which becomes the target file
+// once all its bytes have been received.
+func withUpload(next http.Handler) http.Handler {
+	if allowUpload {
+		log.Println("Uploads enabled")
+	}
+
+	enableUploads(allowUpload || uploadOnly)
+
+	setupUploadRoutes()
+	setupUploadPipe()
+	setupDiskSpace()
+	setupUploadConflict()
+	setupUploadLimits()
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead && uploadsEnabled() {
+			// report the size of the partial upload, if any
+			if info, err := store.Stat(partialName(resolvePath(req.URL.Path))); err == nil {
+				resp.Header().Set("X-Upload-Offset", strconv.FormatInt(info.Size(), 10))
+			}
+		}
+
+		if req.Method != http.MethodPut && !isCommit(req) {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		if !uploadsEnabled() {
+			resp.Header().Set("Allow", "GET, HEAD")
+			http.Error(resp, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		target := path.Clean("/" + req.URL.Path)
+
+		if uploadTooLarge(req.ContentLength) {
+			log.Println(logTag(req), "Upload of", shortenURI(target), sizeString(req.ContentLength),
+				"rejected: file too large")
+			uploadFailed(resp, req, errUploadTooLarge)
+			return
+		}
+
+		if !uploadFits(req) {
+			log.Println(logTag(req), "Upload of", shortenURI(target), sizeString(req.ContentLength),
+				"rejected: not enough disk space")
+			insufficientStorage(resp, req, nil)
+			return
+		}
+
+		if _, err := uploadMeta(req); err == errFileExec {
+			log.Println(logTag(req), "Upload of", shortenURI(target), "rejected: executable file mode")
+			http.Error(resp, "Execute permission is not allowed in "+fileModeHeader+" header", http.StatusForbidden)
+			return
+		} else if err != nil {
+			http.Error(resp, "Invalid "+fileModeHeader+", "+fileMtimeHeader+", or Last-Modified header",
+				http.StatusBadRequest)
+			return
+		}
+
+		policy, ok := requestConflict(req)
+
+		if !ok {
+			http.Error(resp, "Invalid "+uploadConflictHeader+" header", http.StatusBadRequest)
+			return
+		}
+
+		if len(uploadPipeArgs) > 0 && (req.Method == http.MethodPost || len(req.URL.Query()["chunk"]) > 0 ||
+			len(req.Header.Get("Content-Range")) > 0) {
+			uploadFailed(resp, req, errPipeResumable)
+			return
+		}
+
+		if req.Method == http.MethodPost {
+			serveCommit(resp, req, routeUpload(target, ""), policy)
+			return
+		}
+
+		if _, ok := req.URL.Query()["chunk"]; ok {
+			serveChunk(resp, req, routeUpload(target, ""), policy)
+			return
+		}
+
+		var created, complete bool
+		var n int64
+		var name string
+		var err error
+
+		if rng := req.Header.Get("Content-Range"); len(rng) > 0 {
+			target = routeUpload(target, "")
+			name, created, complete, n, err = receivePart(resolvePath(target), rng, policy, req.Body)
+		} else {
+			var body io.Reader
+
+			target, body = routeByContent(target, req)
+
+			if len(uploadPipeArgs) == 0 {
+				name, created, n, err = receiveFile(resolvePath(target), policy, body)
+			} else if name, created, n, err = pipeUpload(req, target, policy, body); !uploadPipeSave {
+				if !uploadFailed(resp, req, err) {
+					piped(resp, req, target, n)
+				}
+
+				return
+			}
+
+			complete = true
+		}
+
+		if !complete {
+			// n is the size of the partial file
+			resp.Header().Set("X-Upload-Offset", strconv.FormatInt(n, 10))
+
+			if n > 0 {
+				resp.Header().Set("Range", "bytes=0-"+strconv.FormatInt(n-1, 10))
+			}
+		}
+
+		if uploadFailed(resp, req, err) {
+			return
+		}
+
+		if !complete {
+			log.Println(logTag(req), "Partially uploaded", shortenURI(target), sizeString(n))
+			resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			resp.WriteHeader(http.StatusPermanentRedirect) // "308 Resume Incomplete"
+			fmt.Fprintln(resp, target, sizeString(n), "received so far")
+			return
+		}
+
+		uploaded(resp, req, placedTarget(target, name), created, n)
+	})
+}
+
+// uploaded reports successful upload
+func uploaded(resp http.ResponseWriter, req *http.Request, target string, created bool, n int64) {
+	// file metadata has already been validated
+	meta, _ := uploadMeta(req)
+
+	if err := meta.apply(resolvePath(target)); err != nil {
+		uploadFailed(resp, req, err)
+		return
+	}
+
+	log.Println(logTag(req), "Uploaded", shortenURI(target), sizeString(n))
+	checkDiskSpace()
+	notify("Uploaded "+target, target+" ("+sizeString(n)+") has been uploaded by "+req.RemoteAddr+".")
+
+	status := http.StatusOK
+
+	if created {
+		resp.Header().Set("Location", (&url.URL{Path: target}).EscapedPath())
+		status = http.StatusCreated
+	}
+
+	writeReceipts(resp, req, status, []uploadReceipt{{Path: target, Size: n}}, true)
+}
+
+// uploadFailed responds with the error status, if any
+func uploadFailed(resp http.ResponseWriter, req *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case err == errUploadForbidden:
+		http.Error(resp, "Forbidden", http.StatusForbidden)
+	case err == errUploadConflict, err == errUploadBusy:
+		http.Error(resp, "Conflict", http.StatusConflict)
+	case err == errUploadExists:
+		http.Error(resp, "File already exists", http.StatusConflict)
+	case err == errUploadOffset:
+		http.Error(resp, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+	case err == errContentRange:
+		http.Error(resp, "Invalid Content-Range", http.StatusBadRequest)
+	case err == errChunkIndex:
+		http.Error(resp, "Invalid chunk number", http.StatusBadRequest)
+	case err == errChunkMissing:
+		http.Error(resp, "Missing chunks", http.StatusConflict)
+	case err == errChecksum:
+		http.Error(resp, "Checksum mismatch", http.StatusUnprocessableEntity)
+	case err == errUploadType:
+		http.Error(resp, "File type is not accepted", http.StatusUnsupportedMediaType)
+	case err == errUploadTooLarge:
+		http.Error(resp, "File is too large", http.StatusRequestEntityTooLarge)
+	case err == errPipeResumable:
+		http.Error(resp, "Resumable uploads are not supported", http.StatusBadRequest)
+	case isNoSpace(err):
+		insufficientStorage(resp, req, err)
+	default:
+		log.Println(logTag(req), "Upload failed:", err)
+		http.Error(resp, "Upload failed", http.StatusInternalServerError)
+	}
+
+	return true
+}
+
+// receiveFile atomically creates or replaces the file with the content from the reader, as the conflict
+// policy permits, returning the name of the file written, and true if the file did not exist before.
+func receiveFile(name string, policy conflictPolicy, src io.Reader) (placed string, created bool, n int64, err error) {
+	if created, err = prepareUpload(name, policy); err != nil {
+		return
+	}
+
+	// write to a temporary file
+	tmp, err := store.TempFile(filepath.Dir(name), uploadTempPrefix)
+
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			store.Remove(tmp.Name())
+		}
+	}()
+
+	if n, err = io.Copy(tmp, limitUpload(src)); err != nil {
+		return
+	}
+
+	if err = tmp.Close(); err != nil {
+		return
+	}
+
+	placed, err = placeUpload(tmp.Name(), name, policy)
+	created = created || placed != name
+	return
+}
+
+// uploads in progress, by partial file name
+var partialUploads = struct {
+	sync.Mutex
+	names map[string]bool
+}{
+	names: make(map[string]bool),
+}
+
+// receivePart writes the content from the reader to the partial file at the offset given
+// in the Content-Range header ("bytes start-end/total"), and renames the partial file to the target
+// when complete, as the conflict policy permits. Header "bytes */total" only queries the size of the partial
+// file. The size of the partial file is returned when incomplete, otherwise the size of the target file.
+func receivePart(name, rng string, policy conflictPolicy, src io.Reader) (placed string, created, complete bool, n int64, err error) {
+	start, end, total, err := parseContentRange(rng)
+
+	if err != nil {
+		return
+	}
+
+	if uploadTooLarge(total) {
+		err = errUploadTooLarge
+		return
+	}
+
+	if created, err = prepareUpload(name, policy); err != nil {
+		return
+	}
+
+	partial := partialName(name)
+
+	partialUploads.Lock()
+
+	if partialUploads.names[partial] {
+		partialUploads.Unlock()
+		err = errUploadBusy
+		return
+	}
+
+	partialUploads.names[partial] = true
+	partialUploads.Unlock()
+
+	defer func() {
+		partialUploads.Lock()
+		delete(partialUploads.names, partial)
+		partialUploads.Unlock()
+	}()
+
+	file, err := store.OpenPart(partial, os.O_WRONLY|os.O_CREATE)
+
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if file != nil {
+			if e := file.Close(); err == nil {
+				err = e
+			}
+		}
+	}()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return
+	}
+
+	n = info.Size()
+
+	// size query
+	if start < 0 {
+		return
+	}
+
+	if start > n {
+		err = errUploadOffset
+		return
+	}
+
+	if _, err = file.Seek(start, io.SeekStart); err != nil {
+		return
+	}
+
+	// whatever is received is kept, even if the connection drops
+	m, err := io.Copy(file, io.LimitReader(src, end-start+1))
+	n = start + m
+
+	if e := file.Truncate(n); err == nil {
+		err = e
+	}
+
+	if err != nil || n < total {
+		return
+	}
+
+	err, file = file.Close(), nil
+
+	if err != nil {
+		return
+	}
+
+	if placed, err = placeUpload(partial, name, policy); err == nil {
+		complete, created = true, created || placed != name
+	}
+
+	return
+}
+
+// prepareUpload checks the upload target and creates its directory, returning true
+// if the target does not exist. Existing targets are rejected early under the reject policy.
+func prepareUpload(name string, policy conflictPolicy) (created bool, err error) {
+	if name == rootDir || strings.HasPrefix(filepath.Base(name), uploadTempPrefix) || isIgnored(name, false) {
+		err = errUploadForbidden
+		return
+	}
+
+	if !uploadTypeAllowed(name) {
+		err = errUploadType
+		return
+	}
+
+	// existing target
+	info, err := store.Stat(name)
+
+	switch {
+	case err == nil:
+		if info.IsDir() {
+			err = errUploadConflict
+			return
+		}
+
+		if !info.Mode().IsRegular() {
+			err = errUploadForbidden
+			return
+		}
+
+		if policy == conflictReject {
+			err = errUploadExists
+			return
+		}
+	case os.IsNotExist(err):
+		created, err = true, nil
+	default:
+		return
+	}
+
+	// parent directory, checked before anything is created, and again after that
+	dir := filepath.Dir(name)
+
+	if err = insideRoot(dir); err != nil {
+		return
+	}
+
+	if err = store.MkdirAll(dir); err != nil {
+		return
+	}
+
+	err = checkInsideRoot(dir)
+	return
+}
+
+// partialName returns the name of the partial file for the upload target
+func partialName(name string) string {
+	return filepath.Join(filepath.Dir(name), uploadPartialPrefix+filepath.Base(name))
+}
+
+// parseContentRange parses "bytes start-end/total" or "bytes */total" headers; in the latter case
+// the returned start is -1.
+func parseContentRange(s string) (start, end, total int64, err error) {
+	err = errContentRange
+
+	if !strings.HasPrefix(s, "bytes ") {
+		return
+	}
+
+	s = strings.TrimSpace(s[6:])
+	i := strings.IndexByte(s, '/')
+
+	if i < 0 {
+		return
+	}
+
+	if total, err = strconv.ParseInt(s[i+1:], 10, 64); err != nil || total < 0 {
+		err = errContentRange
+		return
+	}
+
+	if s = s[:i]; s == "*" {
+		start = -1
+		return
+	}
+
+	if i = strings.IndexByte(s, '-'); i < 0 {
+		err = errContentRange
+		return
+	}
+
+	start, err1 := strconv.ParseInt(s[:i], 10, 64)
+	end, err2 := strconv.ParseInt(s[i+1:], 10, 64)
+
+	if err1 != nil || err2 != nil || start < 0 || end < start || end >= total {
+		err = errContentRange
+		return
+	}
+
+	return start, end, total, nil
+}
+
+// checkInsideRoot makes sure the directory does not lead outside of the root directory via symlinks
+func checkInsideRoot(dir string) error {
+	root, err := filepath.EvalSymlinks(rootDir)
+
+	if err != nil {
+		return err
+	}
+
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return err
+	}
+
+	if dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		return errUploadForbidden
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// withUploadForm adds an upload form to the directory listings when uploads are enabled, and handles
+// the multipart POST requests from the form, or from "curl -F file=@name http://host:port/dir/".
+// Each file is written to the directory like a PUT upload, atomically and under the conflict policy.
+func withUploadForm(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !uploadsEnabled() || !strings.HasSuffix(req.URL.Path, "/") {
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		switch req.Method {
+		case http.MethodPost:
+			if t, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); t == "multipart/form-data" {
+				serveFormUpload(resp, req)
+				return
+			}
+		case http.MethodGet:
+			if !hasIndex(resolvePath(req.URL.Path)) {
+				serveWithForm(next, resp, req, uploadForm)
+				return
+			}
+		}
+
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// serveWithForm inserts the form above the listing
+func serveWithForm(next http.Handler, resp http.ResponseWriter, req *http.Request, form string) {
+	w := &listingWriter{ResponseWriter: resp, status: http.StatusOK}
+
+	next.ServeHTTP(w, req)
+
+	body := w.buf.Bytes()
+
+	if w.status == http.StatusOK && strings.HasPrefix(resp.Header().Get("Content-Type"), "text/html") {
+		if i := bytes.LastIndex(body, []byte("<pre")); i >= 0 {
+			body = append(append(body[:i:i], form...), body[i:]...)
+			resp.Header().Del("Content-Length")
+		}
+	}
+
+	resp.WriteHeader(w.status)
+
+	if _, err := resp.Write(body); err != nil {
+		log.Println(logTag(req), "Listing error:", err)
+	}
+}
+
+const uploadForm = `<form method="post" enctype="multipart/form-data">
+<input type="file" name="file" multiple required> <button type="submit">Upload</button>
+</form>
+`
+
+// serveFormUpload receives the files from the multipart request into the directory at the request path
+func serveFormUpload(resp http.ResponseWriter, req *http.Request) {
+	// the form is only to be submitted from the listing of this server
+	if origin := foreignOrigin(req); len(origin) > 0 {
+		log.Println(logTag(req), req.Method, shortenURI(req.URL.Path), "rejected: cross-origin upload from", origin)
+		http.Error(resp, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	dir := path.Clean("/" + req.URL.Path)
+
+	if !uploadFits(req) {
+		log.Println(logTag(req), "Upload to", shortenURI(dir), sizeString(req.ContentLength),
+			"rejected: not enough disk space")
+		insufficientStorage(resp, req, nil)
+		return
+	}
+
+	policy, ok := requestConflict(req)
+
+	if !ok {
+		http.Error(resp, "Invalid "+uploadConflictHeader+" header", http.StatusBadRequest)
+		return
+	}
+
+	mr, err := req.MultipartReader()
+
+	if err != nil {
+		http.Error(resp, "Invalid multipart request", http.StatusBadRequest)
+		return
+	}
+
+	var receipts []uploadReceipt
+
+	for {
+		part, err := mr.NextPart()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			http.Error(resp, "Invalid multipart request", http.StatusBadRequest)
+			return
+		}
+
+		// browsers on Windows may send the full path
+		name := path.Base(strings.Replace(part.FileName(), `\`, "/", -1))
+
+		if len(part.FileName()) == 0 || name == "." || name == ".." || name == "/" {
+			part.Close()
+			continue
+		}
+
+		target := routeUpload(path.Join(dir, name), part.Header.Get("Content-Type"))
+
+		var placed string
+		var n int64
+
+		if len(uploadPipeArgs) == 0 {
+			placed, _, n, err = receiveFile(resolvePath(target), policy, part)
+		} else {
+			placed, _, n, err = pipeUpload(req, target, policy, part)
+		}
+
+		part.Close()
+
+		if uploadFailed(resp, req, err) {
+			return
+		}
+
+		if len(uploadPipeArgs) > 0 && !uploadPipeSave {
+			log.Println(logTag(req), "Piped", shortenURI(target), sizeString(n), "to", uploadPipeArgs[0])
+			notify("Uploaded "+target, target+" ("+sizeString(n)+") has been uploaded by "+req.RemoteAddr+
+				" and passed to "+uploadPipeArgs[0]+".")
+			receipts = append(receipts, uploadReceipt{Path: target, Size: n, Piped: true})
+			continue
+		}
+
+		target = placedTarget(target, placed)
+
+		log.Println(logTag(req), "Uploaded", shortenURI(target), sizeString(n))
+		notify("Uploaded "+target, target+" ("+sizeString(n)+") has been uploaded by "+req.RemoteAddr+".")
+		receipts = append(receipts, uploadReceipt{Path: target, Size: n})
+	}
+
+	checkDiskSpace()
+
+	writeReceipts(resp, req, http.StatusOK, receipts, false)
+}
@@ -0,0 +1,142 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/gnuflag"
+)
+
+// upload restrictions
+var (
+	uploadTypesSpec   string
+	maxFileSizeSpec   string
+	uploadTypes       []string // extensions with the dot, and MIME types, possibly like "image/*"
+	maxFileSize       int64    // 0 for no limit
+	errUploadType     = errors.New("file type is not accepted")
+	errUploadTooLarge = errors.New("file is too large")
+)
+
+func init() {
+	gnuflag.StringVar(&uploadTypesSpec, "upload-types", "",
+		"Comma-separated list of file types accepted for upload, as extensions (like \".pdf\") or MIME types (like \"image/*\"); all types by default.")
+	gnuflag.StringVar(&maxFileSizeSpec, "max-file-size", "0",
+		"Maximum size of an uploaded file (e.g. 2G); 0 for no limit.")
+}
+
+func setupUploadLimits() {
+	for _, s := range strings.Split(uploadTypesSpec, ",") {
+		if s = strings.ToLower(strings.TrimSpace(s)); len(s) > 0 {
+			if !strings.HasPrefix(s, ".") && !strings.Contains(s, "/") {
+				s = "." + s
+			}
+
+			uploadTypes = append(uploadTypes, s)
+		}
+	}
+
+	if len(uploadTypes) > 0 {
+		log.Println("Accepted upload types:", strings.Join(uploadTypes, ", "))
+	}
+
+	var err error
+
+	if maxFileSize, err = parseByteSize(maxFileSizeSpec); err != nil || maxFileSize < 0 {
+		die("Invalid maximum file size: "+maxFileSizeSpec, err)
+	}
+
+	if maxFileSize > 0 {
+		log.Println("Maximum upload file size:", sizeString(maxFileSize))
+	}
+}
+
+// uploadTypeAllowed checks the type of the file to upload by its name
+func uploadTypeAllowed(name string) bool {
+	if len(uploadTypes) == 0 {
+		return true
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	ctype, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+
+	for _, t := range uploadTypes {
+		switch {
+		case t == ext,
+			t == ctype && len(ctype) > 0,
+			strings.HasSuffix(t, "/*") && strings.HasPrefix(ctype, t[:len(t)-1]):
+			return true
+		}
+	}
+
+	return false
+}
+
+// uploadTooLarge checks the size of the file to upload against the limit
+func uploadTooLarge(size int64) bool {
+	return maxFileSize > 0 && size > maxFileSize
+}
+
+// limitUpload returns the reader failing with errUploadTooLarge once the size limit is exceeded
+func limitUpload(src io.Reader) io.Reader {
+	if maxFileSize == 0 {
+		return src
+	}
+
+	return &sizeLimitReader{src: src, left: maxFileSize}
+}
+
+type sizeLimitReader struct {
+	src  io.Reader
+	left int64
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	if r.left < 0 {
+		return 0, errUploadTooLarge
+	}
+
+	// one byte over the limit tells an oversized file from the one of the exact size
+	if int64(len(p)) > r.left+1 {
+		p = p[:r.left+1]
+	}
+
+	n, err := r.src.Read(p)
+
+	if r.left -= int64(n); r.left < 0 {
+		return n, errUploadTooLarge
+	}
+
+	return n, err
+}
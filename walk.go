@@ -0,0 +1,125 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+func init() {
+	apiMux.HandleFunc("/api/walk", serveWalk)
+	apiMux.HandleFunc("/api/walk/", serveWalk)
+}
+
+// streamed entries are flushed to the client at least that often
+const walkFlushInterval = 100 * time.Millisecond
+
+// GET /api/walk[/<path>][?sha256=1|blake3=1|xxh3=1][&since=<time>]: streams one JSON object per file under the path as the tree
+// is walked (NDJSON), in no particular order, optionally with the file hashes. An error during
+// the walk is reported as the last object with "error" field.
+func serveWalk(resp http.ResponseWriter, req *http.Request) {
+	dir := apiTarget(req, "/api/walk")
+
+	if _, err := os.Stat(dir); err != nil || isIgnored(dir, true) {
+		apiError(resp, req, http.StatusNotFound, "Not found")
+		return
+	}
+
+	var algo *hashAlgo
+
+	for _, a := range hashList {
+		if req.URL.Query().Get(a.name) == "1" {
+			algo = a
+		}
+	}
+	since, err := parseSince(req.URL.Query())
+
+	if err != nil {
+		apiError(resp, req, http.StatusBadRequest, "Invalid since parameter")
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/x-ndjson")
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+
+	var lock sync.Mutex
+
+	enc := json.NewEncoder(resp)
+	flusher, _ := resp.(http.Flusher)
+	flushed := time.Now()
+	count := 0
+
+	err = walkFiles(req.Context(), dir, func(name, rel string, info os.FileInfo) error {
+		if !modifiedSince(info.ModTime(), since) {
+			return nil
+		}
+
+		entry := manifestEntry{Path: rel, Size: info.Size(), Mtime: info.ModTime()}
+
+		if algo != nil {
+			sum, err := fileHash(algo, name, info)
+
+			if err != nil {
+				return err
+			}
+
+			*entry.sum(algo) = sum
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		if err := enc.Encode(&entry); err != nil {
+			return err
+		}
+
+		count++
+
+		if flusher != nil && time.Since(flushed) >= walkFlushInterval {
+			flusher.Flush()
+			flushed = time.Now()
+		}
+
+		return nil
+	})
+
+	if err != nil && req.Context().Err() == nil {
+		log.Println(logTag(req), "Walk error:", err)
+		enc.Encode(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+	}
+
+	log.Println(logTag(req), "Walked", count, "file(s)")
+}
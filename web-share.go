@@ -31,13 +31,19 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/juju/gnuflag"
@@ -46,44 +52,144 @@ import (
 
 const defaultPort = 8080
 
-func main() {
-	// command line parameters
-	var itf, dir string
-	var port uint
+// absolute path to the directory being served
+var rootDir string
+
+// subcommands
+var commands = map[string]func(args []string) int{
+	"bench":           benchCommand,
+	"check":           checkCommand,
+	"ctl":             ctlCommand,
+	"get":             getCommand,
+	"install-service": installServiceCommand,
+	"replay":          replayCommand,
+	"send":            sendCommand,
+	"sign":            signCommand,
+	"totp":            totpCommand,
+}
 
-	gnuflag.StringVar(&itf, "interface", "", "(required) Network interface to run the server on.")
-	gnuflag.StringVar(&itf, "i", "", "(required) Network interface to run the server on.")
+// main command line parameters
+var (
+	serverItf, serverDir string
+	serverPort           uint
+)
 
-	gnuflag.UintVar(&port, "port", defaultPort, "Network port number to listen on.")
-	gnuflag.UintVar(&port, "p", defaultPort, "Network port number to listen on.")
+func init() {
+	gnuflag.StringVar(&serverItf, "interface", "", "(required) Network interface, IP address, or host name to run the server on.")
+	gnuflag.StringVar(&serverItf, "i", "", "(required) Network interface, IP address, or host name to run the server on.")
 
-	gnuflag.StringVar(&dir, "directory", ".", "Root directory to serve files from.")
-	gnuflag.StringVar(&dir, "d", ".", "Root directory to serve files from.")
+	gnuflag.UintVar(&serverPort, "port", defaultPort, "Network port number to listen on.")
+	gnuflag.UintVar(&serverPort, "p", defaultPort, "Network port number to listen on.")
 
+	gnuflag.StringVar(&serverDir, "directory", ".", "Root directory to serve files from.")
+	gnuflag.StringVar(&serverDir, "d", ".", "Root directory to serve files from.")
+}
+
+func main() {
+	// subcommand
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+
+		// "serve" is the default command, spelled out for readability, as in "serve --share photos"
+		if os.Args[1] == "serve" {
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
+	// command line parameters
 	gnuflag.Parse(false)
+	loadConfig()
+	applyPreset()
+
+	itf, dir, port := serverItf, serverDir, serverPort
 
 	// validate port
 	if port == 0 || port > 0xFFFF {
 		die("Invalid port number: "+uintToString(port), nil)
 	}
 
-	// build address
-	var addr string
+	// build addresses
+	var addrs []string
 
 	if len(itf) == 0 {
 		die("Network interface is not specified", nil)
 	}
 
-	if addr = findIP(itf); len(addr) == 0 {
-		die("Cannot find IPv4 address of "+itf, nil)
+	if addrs = bindAddresses(itf); len(addrs) == 0 {
+		die("Cannot find IP address of "+itf, nil)
 	}
 
+	// status line or terminal UI, syslog
+	startTUI()
+	startStatusLine()
+	startSyslog()
+
 	mvr.Run(func() int {
-		addr += ":" + uintToString(port)
-		log.Println("Listening on", addr)
+		if relayMode {
+			return runRelay(addrs, port)
+		}
+
+		rootDir = absPath(dir)
+		preflight(rootDir)
+
+		for i, ip := range addrs {
+			addrs[i] = net.JoinHostPort(ip, uintToString(port))
+			log.Println("Listening on", addrs[i])
+		}
+
+		serverAddr, serverAddrs = addrs[0], addrs
+		setupTLS()
+		printURL()
+
+		// request handler
+		setupSigning()
+		startControl(port)
+		setupEmail()
+		setupPush()
+		setupDigest()
+		setupBasic()
+		setupTOTP()
+		startIdleTimer()
+		startWatchdog()
+		startRecent()
+		restoreState()
+
+		var handler http.Handler = serveFrom(rootDir)
+
+		handler = withNotify(handler)
+		handler = withTransfers(handler)
+		handler = withStats(handler)
+		handler = withAPI(handler)
+		handler = withUploadOnly(handler)
+		handler = withShortLinks(handler)
+		handler = withCanonical(handler)
+		handler = withPause(handler)
+		handler = withRootCheck(handler)
+		handler = withTotalCap(handler)
+		handler = withAdmin(handler)
+		handler = withRobots(handler)
+		handler = withSimulation(handler)
+		handler = withRateLimit(handler)
+		handler = withBandwidth(handler)
+		handler = withRequestRate(handler)
+		handler = withRangeGuard(handler)
+		handler = withCapture(handler)
+		handler = withSchedule(handler)
+		handler = withGeoIP(handler)
+		handler = withSignedLinks(handler)
+		handler = withAuth(handler)
+		handler = withBans(handler)
+		handler = withAccessList(handler)
+		handler = withRequestID(handler)
 
 		// start the server
-		if err := serve(addr, serveFrom(dir)); err != nil {
+		err := serve(addrs, handler)
+
+		saveState()
+
+		if err != nil {
 			log.Println(err)
 			return 1
 		}
@@ -93,7 +199,50 @@ func main() {
 
 }
 
-func findIP(itf string) string {
+// bindAddresses returns the IP addresses to listen on, given a network interface name, an IP address,
+// or a host name. Both IPv4 and IPv6 addresses are returned, in the order given by --ip-family and
+// --prefer-ipv6 options; link-local IPv6 addresses are skipped, because they are of little use in URLs.
+func bindAddresses(s string) []string {
+	if ip := net.ParseIP(s); ip != nil {
+		if ip.To4() != nil {
+			return orderIPs([]string{ip.String()}, nil)
+		}
+
+		return orderIPs(nil, []string{ip.String()})
+	}
+
+	if _, err := net.InterfaceByName(s); err == nil {
+		return interfaceIPs(s)
+	}
+
+	ips, err := net.LookupIP(s)
+
+	if err != nil {
+		die("Unknown network interface or host name: "+s, nil)
+	}
+
+	// the first address of each family
+	var v4, v6 []string
+
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			if len(v4) == 0 {
+				v4 = []string{ip4.String()}
+			}
+		} else if !ip.IsLinkLocalUnicast() {
+			v6 = append(v6, ip.String())
+		}
+	}
+
+	if sortIPv6(v6); len(v6) > 1 {
+		v6 = v6[:1]
+	}
+
+	return orderIPs(v4, v6)
+}
+
+// interfaceIPs returns the addresses of the network interface in the preferred order, skipping link-local IPv6 ones
+func interfaceIPs(itf string) []string {
 	// get interface
 	it, err := net.InterfaceByName(itf)
 
@@ -112,32 +261,58 @@ func findIP(itf string) string {
 		die("Cannot get interface address list", err)
 	}
 
-	// find IPv4 address
+	var v4, v6 []string
+
 	for _, a := range addrs {
 		if ip, ok := a.(*net.IPNet); ok {
 			if ip4 := ip.IP.To4(); ip4 != nil {
-				return ip4.String()
+				v4 = append(v4, ip4.String())
+			} else if !ip.IP.IsLinkLocalUnicast() {
+				v6 = append(v6, ip.IP.String())
 			}
 		}
 	}
 
-	return ""
+	return orderIPs(v4, v6)
 }
 
-func serve(addr string, handler http.Handler) error {
+// serve runs the server on all the given addresses, returning the first error
+func serve(addrs []string, handler http.Handler) error {
 	srv := &http.Server{
-		Addr:           addr,
-		Handler:        handler,
-		ReadTimeout:    time.Hour, // just to make sure it expires eventually
-		WriteTimeout:   time.Hour,
-		MaxHeaderBytes: 1 << 18, // we don't expect big headers
+		Handler:           handler,
+		ReadHeaderTimeout: stallTimeout, // transfers are watched for stalls instead of being limited in time
+		MaxHeaderBytes:    1 << 18,      // we don't expect big headers
+		ConnContext:       connContext,
 		ConnState: func(conn net.Conn, state http.ConnState) {
-			if state == http.StateClosed {
-				log.Println(conn.RemoteAddr(), "Closed")
+			touchActivity()
+			trackIdle(conn, state)
+
+			switch state {
+			case http.StateNew:
+				connOpened()
+				trackConn(conn, true)
+			case http.StateHijacked:
+				atomic.AddInt64(&activeConns, -1)
+				trackConn(conn, false)
+			case http.StateClosed:
+				atomic.AddInt64(&activeConns, -1)
+				trackConn(conn, false)
+
+				if c, ok := conn.(*countingConn); ok {
+					log.Println(conn.RemoteAddr(), "["+c.id+"]", "Closed:", c.stats())
+				} else {
+					log.Println(conn.RemoteAddr(), "Closed")
+				}
 			}
 		},
 	}
 
+	tuneServer(srv)
+
+	if tlsConfig != nil {
+		srv.Handler = withTLSState(handler)
+	}
+
 	// termination handler
 	mvr.OnCancel(0, func(ctx context.Context) {
 		if err := srv.Shutdown(ctx); err != nil {
@@ -145,8 +320,64 @@ func serve(addr string, handler http.Handler) error {
 		}
 	})
 
+	// bind all the addresses before serving any
+	listeners := make([]net.Listener, 0, len(addrs))
+
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr) // list all open ports: netstat -lntu
+
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+
+			return err
+		}
+
+		listeners = append(listeners, ln)
+	}
+
+	// requests over the relay
+	if rl := startRelayListener(); rl != nil {
+		listeners = append(listeners, rl)
+	}
+
 	// serve
-	return srv.ListenAndServe() // list all open ports: netstat -lntu
+	errs := make(chan error, len(listeners))
+	slots := newConnSlots()
+
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			if _, relayed := ln.(*relayListener); !relayed {
+				ln = accessListener{ln}
+			}
+
+			ln = tunedListener{ln}
+
+			// the relay terminates TLS itself
+			if _, relayed := ln.(*relayListener); tlsConfig != nil && !relayed {
+				ln = tlsListener{tls.NewListener(ln, tlsConfig)}
+			}
+
+			if slots != nil {
+				ln = limitListener{ln, slots}
+			}
+
+			errs <- srv.Serve(countingListener{ln})
+		}(ln)
+	}
+
+	err := <-errs
+
+	if err != http.ErrServerClosed {
+		srv.Close()
+	}
+
+	for i := 1; i < len(listeners); i++ {
+		<-errs
+	}
+
+	return err
 }
 
 var faviconTS = time.Now()
@@ -157,7 +388,33 @@ func serveFrom(dir string) http.HandlerFunc {
 	log.Println("Serving files from", root)
 
 	// create file server
-	server := http.FileServer(http.Dir(root))
+	var server http.Handler = http.FileServer(shareFS{root})
+
+	server = withWebDAV(server)
+	server = withListing(server)
+	server = withGallery(server)
+	server = withPaging(server)
+	server = withReadme(server)
+	server = withCast(server)
+	server = withSizeHints(server)
+	server = withUploadForm(server)
+	server = withRecent(server)
+	server = withChecksums(server)
+	server = withPlaylist(server)
+	server = withArchive(server)
+	server = withArchiveBrowse(server)
+	server = withPreview(server)
+	server = withThumbnails(server)
+	server = withSparse(server)
+	server = withFileMeta(server)
+	server = withReadahead(server)
+	server = withUpload(server)
+	server = withTus(server)
+	server = withManage(server)
+	server = withCGI(server)
+	server = withImmutable(server)
+	server = withApp(server)
+	server = withIntegrity(server)
 
 	// server name
 	serverName := filepath.Base(os.Args[0])
@@ -170,15 +427,17 @@ func serveFrom(dir string) http.HandlerFunc {
 
 		if err != nil {
 			http.Error(resp, "Invalid URI", http.StatusBadRequest)
-			log.Println(req.RemoteAddr, "Invalid URI:", err)
+			log.Println(logTag(req), "Invalid URI:", err)
 			return
 		}
 
-		// log the request
-		if rng := req.Header.Get("Range"); len(rng) > 0 && rng != "bytes=0-" {
-			log.Println(req.RemoteAddr, req.Method, shortenURI(uri), rng)
-		} else {
-			log.Println(req.RemoteAddr, req.Method, shortenURI(uri))
+		// log the request, unless summarised by the range guard
+		switch rng := req.Header.Get("Range"); {
+		case isQuiet(req):
+		case len(rng) > 0 && rng != "bytes=0-":
+			log.Println(logTag(req), req.Method, shortenURI(uri), rng)
+		default:
+			log.Println(logTag(req), req.Method, shortenURI(uri))
 		}
 
 		// serve
@@ -197,13 +456,15 @@ func serveFrom(dir string) http.HandlerFunc {
 }
 
 func absPath(dir string) string {
-	// make absolute
+	// make absolute, in the form not limited in length on Windows
 	root, err := filepath.Abs(dir)
 
 	if err != nil {
 		die("Cannot build absolute pathname", err)
 	}
 
+	root = extendedPath(root)
+
 	// check if exists
 	var info os.FileInfo
 
@@ -219,6 +480,22 @@ func absPath(dir string) string {
 	return root
 }
 
+// resolvePath maps URL path to the corresponding file system path under the root directory
+func resolvePath(urlPath string) string {
+	return filepath.Join(rootDir, filepath.FromSlash(path.Clean("/"+urlPath)))
+}
+
+// remoteIP returns the IP address of the client
+func remoteIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
 func die(msg string, err error) {
 	if len(msg) > 0 && err != nil {
 		os.Stderr.WriteString("ERROR: " + msg + ": " + err.Error() + "\n")
@@ -237,6 +514,58 @@ func uintToString(val uint) string {
 	return strconv.FormatUint(uint64(val), 10)
 }
 
+// parseByteSize parses sizes like "1500", "64K", "1M", "5MB" or "2GiB"; the multipliers are binary.
+func parseByteSize(s string) (int64, error) {
+	str := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	str = strings.TrimSuffix(str, "I")
+	mul := int64(1)
+
+	if n := len(str); n > 0 {
+		switch str[n-1] {
+		case 'K':
+			mul = 1 << 10
+		case 'M':
+			mul = 1 << 20
+		case 'G':
+			mul = 1 << 30
+		case 'T':
+			mul = 1 << 40
+		}
+
+		if mul > 1 {
+			str = str[:n-1]
+		}
+	}
+
+	val, err := strconv.ParseInt(str, 10, 64)
+
+	if err != nil || val < 0 {
+		return 0, errors.New("invalid size: " + strconv.Quote(s))
+	}
+
+	if val > math.MaxInt64/mul {
+		return 0, errors.New("size is too large: " + strconv.Quote(s))
+	}
+
+	return val * mul, nil
+}
+
+// sizeString formats a byte count using binary units, like "1.5M".
+func sizeString(n int64) string {
+	if n < 1024 {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+
+	val, unit := float64(n)/1024, 0
+
+	for val >= 1024 && unit < 5 {
+		val /= 1024
+		unit++
+	}
+
+	return strconv.FormatFloat(val, 'f', 1, 64) + "KMGTPE"[unit:unit+1]
+}
+
 func shortenURI(uri string) string {
 	const maxURI = 500
 
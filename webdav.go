@@ -0,0 +1,247 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/gnuflag"
+	"golang.org/x/net/webdav"
+)
+
+// serve WebDAV
+var webdavEnabled bool
+
+func init() {
+	gnuflag.BoolVar(&webdavEnabled, "webdav", false,
+		"Serve the share over WebDAV as well, so that it can be mounted as a network drive; the drive is read-only unless uploads or file management are allowed.")
+}
+
+// withWebDAV handles WebDAV requests, letting the clients mount the share as a network drive.
+// GET, HEAD, PUT, and DELETE requests are left to the other handlers, so the uploads and the deletes
+// follow the same rules as elsewhere; creating directories and locking need uploads or file management
+// enabled, and copying, moving, and setting properties need file management.
+func withWebDAV(next http.Handler) http.Handler {
+	if !webdavEnabled {
+		return next
+	}
+
+	dav := &webdav.Handler{
+		FileSystem: davFS{},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(req *http.Request, err error) {
+			switch {
+			case err != nil:
+				log.Println(logTag(req), "WebDAV", req.Method, shortenURI(req.URL.Path), "error:", err)
+			case req.Method == "COPY" || req.Method == "MOVE":
+				if dst, err := url.Parse(req.Header.Get("Destination")); err == nil {
+					log.Println(logTag(req), "WebDAV", req.Method, shortenURI(req.URL.Path), "to", shortenURI(dst.Path))
+				}
+			case req.Method == "MKCOL":
+				log.Println(logTag(req), "Created directory", shortenURI(path.Clean(req.URL.Path)))
+			}
+		},
+	}
+
+	log.Println("WebDAV enabled")
+
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodOptions, "PROPFIND":
+			// always allowed
+		case "MKCOL", "LOCK", "UNLOCK":
+			if !uploadsEnabled() && !allowManage {
+				davNotAllowed(resp, req)
+				return
+			}
+		case "COPY", "MOVE", "PROPPATCH":
+			if !allowManage {
+				davNotAllowed(resp, req)
+				return
+			}
+		default:
+			next.ServeHTTP(resp, req)
+			return
+		}
+
+		dav.ServeHTTP(resp, req)
+	})
+}
+
+func davNotAllowed(resp http.ResponseWriter, req *http.Request) {
+	log.Println(logTag(req), "WebDAV", req.Method, shortenURI(req.URL.Path), "rejected: read-only")
+
+	resp.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+	http.Error(resp, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// WebDAV view of the share, with the same files hidden as from the listings, and all the changes
+// kept inside the root directory. Written directly to the local file system.
+type davFS struct{}
+
+// davName maps the WebDAV name to the file system path, failing for the names not to be seen
+func davName(name string, isDir bool) (string, error) {
+	full := resolvePath(name)
+
+	if isIgnored(full, isDir) || strings.HasPrefix(filepath.Base(full), uploadTempPrefix) {
+		return "", os.ErrNotExist
+	}
+
+	return full, nil
+}
+
+// davTarget maps the name of the file or directory to be created, removed, or renamed
+func davTarget(name string) (string, error) {
+	full, err := davName(name, false)
+
+	if err != nil {
+		return "", err
+	}
+
+	if full == rootDir {
+		return "", os.ErrPermission
+	}
+
+	if err = insideRoot(filepath.Dir(full)); err != nil {
+		return "", os.ErrPermission
+	}
+
+	return full, nil
+}
+
+func (davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	full, err := davTarget(name)
+
+	if err != nil {
+		return err
+	}
+
+	return os.Mkdir(full, perm)
+}
+
+func (davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	var full string
+	var err error
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		full, err = davTarget(name)
+	} else {
+		var info os.FileInfo
+
+		if info, err = os.Stat(resolvePath(name)); err != nil {
+			return nil, err
+		}
+
+		if isSpecial(info) {
+			return nil, os.ErrPermission
+		}
+
+		full, err = davName(name, info.IsDir())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(full, flag, perm)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &davFile{File: file, dir: full}, nil
+}
+
+// RemoveAll is only called for COPY and MOVE over an existing target, which then goes to the trash
+func (davFS) RemoveAll(ctx context.Context, name string) error {
+	full, err := davTarget(name)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = removeTree(full)
+	return err
+}
+
+func (davFS) Rename(ctx context.Context, oldName, newName string) error {
+	from, err := davTarget(oldName)
+
+	if err != nil {
+		return err
+	}
+
+	to, err := davTarget(newName)
+
+	if err != nil {
+		return err
+	}
+
+	return store.Rename(from, to)
+}
+
+func (davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := os.Stat(resolvePath(name))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = davName(name, info.IsDir()); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// file or directory, with the hidden entries removed from the directory listing
+type davFile struct {
+	*os.File
+	dir string
+}
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	list, err := f.File.Readdir(count)
+	res := list[:0]
+
+	for _, info := range list {
+		if !hiddenEntry(f.dir, info) && !strings.HasPrefix(info.Name(), uploadTempPrefix) {
+			res = append(res, info)
+		}
+	}
+
+	return res, err
+}
@@ -0,0 +1,348 @@
+/*
+Copyright (c) 2016,2017,2018,2019, Maxim Konakov
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software without
+   specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY
+OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE,
+EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// streaming ZIP writer for stored (uncompressed) entries of known sizes, so that the size of the whole
+// archive can be computed in advance; zip64 extensions are used where the sizes or offsets require them
+type zipWriter struct {
+	w       io.Writer
+	off     int64 // bytes written so far
+	entries []zipEntry
+	dry     bool // only count the bytes, without reading the content
+}
+
+type zipEntry struct {
+	name   string
+	size   int64
+	mtime  time.Time
+	mode   os.FileMode
+	crc    uint32
+	offset int64
+}
+
+const (
+	zipMax32 = 0xffffffff
+	zipMax16 = 0xffff
+)
+
+var errFileChanged = errors.New("file size has changed during transfer")
+
+// zipSize returns the exact size of the archive with the given entries
+func zipSize(entries []zipEntry) int64 {
+	z := &zipWriter{w: ioutil.Discard, dry: true}
+
+	for _, e := range entries {
+		z.add(e, nil)
+	}
+
+	z.close()
+	return z.off
+}
+
+func (z *zipWriter) write(p []byte) error {
+	n, err := z.w.Write(p)
+	z.off += int64(n)
+	return err
+}
+
+// add writes the entry with exactly e.size bytes of content from the reader
+func (z *zipWriter) add(e zipEntry, src io.Reader) error {
+	e.offset = z.off
+	zip64 := e.size >= zipMax32
+
+	// local file header, with the CRC in the data descriptor
+	var b zipBuf
+
+	b.u32(0x04034b50)
+	b.u16(zipVersion(zip64))
+	b.u16(0x0808) // data descriptor, UTF-8 names
+	b.u16(0)      // stored
+	b.dosTime(e.mtime)
+	b.u32(0) // CRC
+
+	if zip64 {
+		b.u32(zipMax32)
+		b.u32(zipMax32)
+	} else {
+		b.u32(uint32(e.size))
+		b.u32(uint32(e.size))
+	}
+
+	extra := zipTimeExtra(e.mtime)
+
+	if zip64 {
+		var x zipBuf
+
+		x.u16(0x0001)
+		x.u16(16)
+		x.u64(uint64(e.size))
+		x.u64(uint64(e.size))
+		extra = append(x, extra...)
+	}
+
+	b.u16(uint16(len(e.name)))
+	b.u16(uint16(len(extra)))
+	b = append(append(b, e.name...), extra...)
+
+	if err := z.write(b); err != nil {
+		return err
+	}
+
+	// content
+	if z.dry {
+		z.off += e.size
+	} else {
+		h := crc32.NewIEEE()
+		n, err := io.Copy(io.MultiWriter(zipCounter{z}, h), io.LimitReader(src, e.size))
+
+		if err != nil {
+			return err
+		}
+
+		if n != e.size {
+			return errFileChanged
+		}
+
+		e.crc = h.Sum32()
+	}
+
+	// data descriptor
+	b = b[:0]
+	b.u32(0x08074b50)
+	b.u32(e.crc)
+
+	if zip64 {
+		b.u64(uint64(e.size))
+		b.u64(uint64(e.size))
+	} else {
+		b.u32(uint32(e.size))
+		b.u32(uint32(e.size))
+	}
+
+	z.entries = append(z.entries, e)
+	return z.write(b)
+}
+
+// close writes the central directory
+func (z *zipWriter) close() error {
+	start := z.off
+
+	for _, e := range z.entries {
+		zip64 := e.size >= zipMax32
+		bigOffset := e.offset >= zipMax32
+
+		var x zipBuf
+
+		if zip64 || bigOffset {
+			x.u16(0x0001)
+
+			n := 0
+
+			if zip64 {
+				n += 16
+			}
+
+			if bigOffset {
+				n += 8
+			}
+
+			x.u16(uint16(n))
+
+			if zip64 {
+				x.u64(uint64(e.size))
+				x.u64(uint64(e.size))
+			}
+
+			if bigOffset {
+				x.u64(uint64(e.offset))
+			}
+		}
+
+		extra := append(x, zipTimeExtra(e.mtime)...)
+
+		var b zipBuf
+
+		b.u32(0x02014b50)
+		b.u16(3<<8 | zipVersion(zip64 || bigOffset)) // made by Unix, for the file modes
+		b.u16(zipVersion(zip64 || bigOffset))
+		b.u16(0x0808)
+		b.u16(0)
+		b.dosTime(e.mtime)
+		b.u32(e.crc)
+
+		if zip64 {
+			b.u32(zipMax32)
+			b.u32(zipMax32)
+		} else {
+			b.u32(uint32(e.size))
+			b.u32(uint32(e.size))
+		}
+
+		b.u16(uint16(len(e.name)))
+		b.u16(uint16(len(extra)))
+		b.u16(0) // comment
+		b.u16(0) // disk
+		b.u16(0) // internal attributes
+		b.u32(uint32(unixMode(e.mode)) << 16)
+
+		if bigOffset {
+			b.u32(zipMax32)
+		} else {
+			b.u32(uint32(e.offset))
+		}
+
+		b = append(append(b, e.name...), extra...)
+
+		if err := z.write(b); err != nil {
+			return err
+		}
+	}
+
+	end := z.off
+	size, count := end-start, int64(len(z.entries))
+
+	var b zipBuf
+
+	if count >= zipMax16 || size >= zipMax32 || start >= zipMax32 {
+		// zip64 end of central directory record
+		b.u32(0x06064b50)
+		b.u64(44)
+		b.u16(3<<8 | 45)
+		b.u16(45)
+		b.u32(0)
+		b.u32(0)
+		b.u64(uint64(count))
+		b.u64(uint64(count))
+		b.u64(uint64(size))
+		b.u64(uint64(start))
+
+		// locator
+		b.u32(0x07064b50)
+		b.u32(0)
+		b.u64(uint64(end))
+		b.u32(1)
+
+		count, size, start = zipMax16, zipMax32, zipMax32
+	}
+
+	b.u32(0x06054b50)
+	b.u16(0)
+	b.u16(0)
+	b.u16(uint16(count))
+	b.u16(uint16(count))
+	b.u32(uint32(size))
+	b.u32(uint32(start))
+	b.u16(0) // comment
+
+	return z.write(b)
+}
+
+func zipVersion(zip64 bool) uint16 {
+	if zip64 {
+		return 45
+	}
+
+	return 20
+}
+
+// extended timestamp extra field, for the modification time with one second precision
+func zipTimeExtra(t time.Time) zipBuf {
+	var b zipBuf
+
+	b.u16(0x5455)
+	b.u16(5)
+	b = append(b, 1)
+	b.u32(uint32(t.Unix()))
+
+	return b
+}
+
+// unixMode converts the mode to Unix st_mode bits
+func unixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+
+	switch {
+	case mode.IsDir():
+		m |= 0040000
+	default:
+		m |= 0100000
+	}
+
+	return m
+}
+
+// zipCounter writes the content through the zip writer, counting the bytes
+type zipCounter struct{ z *zipWriter }
+
+func (c zipCounter) Write(p []byte) (int, error) {
+	n, err := c.z.w.Write(p)
+	c.z.off += int64(n)
+	return n, err
+}
+
+// little-endian encoding buffer
+type zipBuf []byte
+
+func (b *zipBuf) u16(v uint16) { *b = append(*b, byte(v), byte(v>>8)) }
+
+func (b *zipBuf) u32(v uint32) {
+	var x [4]byte
+	binary.LittleEndian.PutUint32(x[:], v)
+	*b = append(*b, x[:]...)
+}
+
+func (b *zipBuf) u64(v uint64) {
+	var x [8]byte
+	binary.LittleEndian.PutUint64(x[:], v)
+	*b = append(*b, x[:]...)
+}
+
+// dosTime appends MS-DOS time and date, in local time, clamped to the supported range
+func (b *zipBuf) dosTime(t time.Time) {
+	t = t.Local()
+
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.Local)
+	} else if t.Year() > 2107 {
+		t = time.Date(2107, 12, 31, 23, 59, 58, 0, time.Local)
+	}
+
+	b.u16(uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()>>1))
+	b.u16(uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day()))
+}